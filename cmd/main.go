@@ -11,8 +11,10 @@ import (
 
 	"ahs/internal/config"
 	"ahs/internal/server"
+	"ahs/internal/service/rag"
 	"ahs/internal/workflow"
 
+	"github.com/cloudwego/eino-ext/components/model/openai"
 	"go.uber.org/zap"
 )
 
@@ -51,6 +53,40 @@ func main() {
 		zap.Strings("工作流列表", workflowManager.List()),
 	)
 
+	// 初始化记忆系统：配置无效或存储无法打开时立即退出，避免运行中才发现
+	ragOpts, err := rag.OptionsFromConfig(cfg.RAG)
+	if err != nil {
+		logger.Fatal("记忆系统配置无效", zap.Error(err))
+	}
+	if err := rag.InitDefault(ragOpts, nil, nil); err != nil {
+		logger.Fatal("记忆系统初始化失败", zap.Error(err))
+	}
+	// 异步写入的重试与死信记入日志
+	rag.Default().SetLogger(logger.Named("rag"))
+	// 短期记忆整理使用的模型
+	if ragOpts.Consolidation.Enable {
+		llm, ok := cfg.LLMConfigs[cfg.RAG.Consolidation.LLM]
+		if !ok {
+			logger.Fatal("记忆整理使用的模型未配置", zap.String("llm", cfg.RAG.Consolidation.LLM))
+		}
+		cm, err := openai.NewChatModel(context.Background(), &openai.ChatModelConfig{
+			APIKey:  llm.APIKey,
+			BaseURL: llm.APIBaseURL,
+			Model:   llm.Model,
+		})
+		if err != nil {
+			logger.Fatal("记忆整理模型创建失败", zap.Error(err))
+		}
+		rag.Default().SetSummarizer(rag.NewChatSummarizer(cm))
+	}
+	logger.Info("记忆系统初始化完成",
+		zap.String("命名空间", ragOpts.Namespace),
+		zap.Bool("bbolt", ragOpts.Bolt.Enable),
+		zap.Bool("异步写入", ragOpts.Async.Enable),
+		zap.Bool("近重复合并", ragOpts.Dedupe.Enable),
+		zap.Bool("记忆整理", ragOpts.Consolidation.Enable),
+	)
+
 	// 创建HTTP服务器
 	srv := server.New(cfg, logger, workflowManager)
 
@@ -77,6 +113,17 @@ func main() {
 	} else {
 		logger.Info("服务器已优雅关闭")
 	}
+
+	// 排空记忆系统异步写入队列；截止前未完成的写入保留在预写日志中，下次启动重放
+	if mgr := rag.Default(); mgr != nil {
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer flushCancel()
+		if err := mgr.Close(flushCtx); err != nil {
+			logger.Error("记忆系统关闭失败", zap.Error(err))
+		} else {
+			logger.Info("记忆系统已关闭", zap.Any("异步写入", mgr.AsyncStats()))
+		}
+	}
 }
 
 // initLogger 初始化日志
@@ -0,0 +1,48 @@
+// ragmigrate 将 RAG 记忆的 JSONL 数据（DiskJSON）导入 bbolt 存储
+//
+// 用法：
+//
+//	go run ./cmd/ragmigrate -src data/rag -dst data/rag_bolt -ns default
+//
+// 迁移期间不要运行 ahs；完成后在配置中启用 Bolt 即可。可重复执行（按 ID 覆盖）。
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"ahs/internal/service/rag"
+)
+
+func main() {
+	var (
+		src    string
+		dst    string
+		ns     string
+		dryRun bool
+	)
+	flag.StringVar(&src, "src", "data/rag", "DiskJSON 数据根目录")
+	flag.StringVar(&dst, "dst", "data/rag_bolt", "bbolt 数据根目录")
+	flag.StringVar(&ns, "ns", "default", "命名空间")
+	flag.BoolVar(&dryRun, "dry-run", false, "只统计，不写入")
+	flag.Parse()
+
+	rep, err := rag.MigrateDiskToBolt(context.Background(), ns,
+		rag.DiskJSONOptions{Enable: true, RootPath: src},
+		rag.BoltOptions{Enable: true, RootPath: dst},
+		dryRun,
+	)
+	for _, t := range rep.Tenants {
+		fmt.Printf("%s/%s\t%d\n", t.Tenant.UserID, t.Tenant.ArchiveID, t.Items)
+	}
+	for _, e := range rep.Errors {
+		fmt.Fprintf(os.Stderr, "失败: %s\n", e)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "迁移失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("完成：%d 个租户，%d 条记忆\n", len(rep.Tenants), rep.Items())
+}
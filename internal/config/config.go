@@ -17,7 +17,10 @@ type Config struct {
 	Log        LogConfig               `mapstructure:"log"`
 	WorkerPool WorkerPoolConfig        `mapstructure:"worker_pool"`
 	Tenant     TenantConfig            `mapstructure:"tenant"`
+	Auth       AuthConfig              `mapstructure:"auth"`
 	CORS       CORSConfig              `mapstructure:"cors"`
+	Usage      UsageConfig             `mapstructure:"usage"`
+	RAG        RAGConfig               `mapstructure:"rag"`
 	LLMConfigs map[string]LLMConfig `mapstructure:"llm_configs"`
 }
 
@@ -32,10 +35,17 @@ type ServerConfig struct {
 }
 
 // RateLimitConfig 限流配置
+// QPS/Burst 在 per_tenant 模式下为每个键（用户或 IP）的额度，否则为全局额度
 type RateLimitConfig struct {
-	Enabled bool `mapstructure:"enabled"`
-	QPS     int  `mapstructure:"qps"`
-	Burst   int  `mapstructure:"burst"`
+	Enabled           bool          `mapstructure:"enabled"`
+	QPS               int           `mapstructure:"qps"`
+	Burst             int           `mapstructure:"burst"`
+	PerTenant         bool          `mapstructure:"per_tenant"`          // 按 X-User-ID 限流，无租户的请求按 IP
+	PerWorkflow       bool          `mapstructure:"per_workflow"`        // 键中追加 workflow 名称
+	MaxKeys           int           `mapstructure:"max_keys"`            // 限流器数量上限（LRU 淘汰）
+	IdleTTL           time.Duration `mapstructure:"idle_ttl"`            // 限流器空闲回收时间
+	TrustForwardedFor bool          `mapstructure:"trust_forwarded_for"` // 按 IP 限流时信任 X-Forwarded-For
+	StreamConcurrency int           `mapstructure:"stream_concurrency"`  // 每租户同时进行的流式运行上限，0 表示不限
 }
 
 // LogConfig 日志配置
@@ -58,6 +68,23 @@ type TenantConfig struct {
 	ExcludePaths []string `mapstructure:"exclude_paths"` // 排除的路径（正则表达式）
 }
 
+// AuthConfig 认证配置
+// Mode: none（信任上游网关）| api_key（静态 API Key）| hmac（共享密钥签名租户头部）
+type AuthConfig struct {
+	Mode         string         `mapstructure:"mode"`
+	APIKeys      []APIKeyConfig `mapstructure:"api_keys"`       // api_key 模式下的有效 Key
+	HMACSecret   string         `mapstructure:"hmac_secret"`    // hmac 模式共享密钥
+	MaxClockSkew time.Duration  `mapstructure:"max_clock_skew"` // hmac 模式允许的时间偏差
+	ExcludePaths []string       `mapstructure:"exclude_paths"`  // 排除的路径（正则表达式）
+}
+
+// APIKeyConfig 单个 API Key 及其允许访问的用户
+type APIKeyConfig struct {
+	Name  string   `mapstructure:"name"`  // 便于日志识别的名称
+	Key   string   `mapstructure:"key"`   // Key 明文
+	Users []string `mapstructure:"users"` // 允许的 user_id，"*" 表示任意用户
+}
+
 // CORSConfig CORS配置
 type CORSConfig struct {
 	Enabled        bool     `mapstructure:"enabled"`         // 是否启用CORS
@@ -69,6 +96,154 @@ type CORSConfig struct {
 	MaxAge         int      `mapstructure:"max_age"`         // 预检请求缓存时间(秒)
 }
 
+// UsageConfig 用量统计与额度配置
+type UsageConfig struct {
+	Enabled           bool             `mapstructure:"enabled"`             // 是否记录 token 用量
+	RootPath          string           `mapstructure:"root_path"`           // 持久化目录，为空时仅保存在内存
+	DailyTokenLimit   int64            `mapstructure:"daily_token_limit"`   // 每用户每日 token 额度（汇总全部存档），0 表示不限
+	UserLimits        map[string]int64 `mapstructure:"user_limits"`         // 按 user_id 覆盖额度
+	Mode              string           `mapstructure:"mode"`                // 超额处理：reject | degrade
+	DegradedMaxTokens int              `mapstructure:"degraded_max_tokens"` // 降级模式下单次生成上限
+}
+
+// RAGConfig 记忆系统配置，对应 rag.RAGOptions（映射与校验见 rag.OptionsFromConfig）
+type RAGConfig struct {
+	Namespace     string                 `mapstructure:"namespace"` // 命名空间，各存储按其分目录
+	InMemory      RAGMemoryConfig        `mapstructure:"in_memory"`
+	DiskJSON      RAGDiskJSONConfig      `mapstructure:"disk_json"`
+	Bolt          RAGBoltConfig          `mapstructure:"bolt"` // 启用时替代 disk_json
+	Vector        RAGVectorConfig        `mapstructure:"vector"`
+	Triple        RAGTripleConfig        `mapstructure:"triple"`
+	Retrieval     RAGRetrievalConfig     `mapstructure:"retrieval"`
+	Ranking       RAGRankingConfig       `mapstructure:"ranking"`
+	Dedupe        RAGDedupeConfig        `mapstructure:"dedupe"`
+	Async         RAGAsyncConfig         `mapstructure:"async"`
+	Retention     RAGRetentionConfig     `mapstructure:"retention"`
+	Consolidation RAGConsolidationConfig `mapstructure:"consolidation"` // LLM 取 llm_configs 中的同名配置
+}
+
+// RAGMemoryConfig 内存存储
+type RAGMemoryConfig struct {
+	Enabled    bool          `mapstructure:"enabled"`
+	MaxEntries int           `mapstructure:"max_entries"` // 每租户最大条目数
+	TTL        time.Duration `mapstructure:"ttl"`         // 查询时过滤超过该时长的条目，0 表示不过滤
+}
+
+// RAGDiskJSONConfig 磁盘 JSONL 存储
+type RAGDiskJSONConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	RootPath     string `mapstructure:"root_path"`
+	MaxFileBytes int64  `mapstructure:"max_file_bytes"` // 活动段轮转大小，0 表示不轮转
+	Sync         string `mapstructure:"sync"`           // none | always | group
+}
+
+// RAGBoltConfig bbolt 存储
+type RAGBoltConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	RootPath string        `mapstructure:"root_path"`
+	Timeout  time.Duration `mapstructure:"timeout"` // 等待数据库文件锁的超时
+}
+
+// RAGHTTPConfig 外部检索服务的 HTTP 客户端
+type RAGHTTPConfig struct {
+	Timeout      time.Duration `mapstructure:"timeout"`
+	MaxRetries   int           `mapstructure:"max_retries"`
+	RetryBackoff time.Duration `mapstructure:"retry_backoff"`
+}
+
+// RAGVectorConfig 向量检索：endpoint 为空时使用本地向量库
+type RAGVectorConfig struct {
+	Enabled           bool          `mapstructure:"enabled"`
+	RootPath          string        `mapstructure:"root_path"`
+	Endpoint          string        `mapstructure:"endpoint"`
+	APIKey            string        `mapstructure:"api_key"`
+	Index             string        `mapstructure:"index"`
+	EmbeddingProvider string        `mapstructure:"embedding_provider"` // hash | openai
+	EmbeddingModel    string        `mapstructure:"embedding_model"`
+	EmbeddingEndpoint string        `mapstructure:"embedding_endpoint"`
+	EmbeddingAPIKey   string        `mapstructure:"embedding_api_key"`
+	Dim               int           `mapstructure:"dim"`
+	HTTP              RAGHTTPConfig `mapstructure:"http"`
+}
+
+// RAGTripleConfig 三元组检索：endpoint 为空时使用本地三元组库
+type RAGTripleConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	RootPath      string        `mapstructure:"root_path"`
+	Endpoint      string        `mapstructure:"endpoint"`
+	APIKey        string        `mapstructure:"api_key"`
+	SchemaVersion string        `mapstructure:"schema_version"`
+	HTTP          RAGHTTPConfig `mapstructure:"http"`
+}
+
+// RAGRetrievalConfig 混合检索融合
+type RAGRetrievalConfig struct {
+	Fusion         string        `mapstructure:"fusion"` // rrf | weighted
+	RRFK           int           `mapstructure:"rrf_k"`
+	LocalWeight    float64       `mapstructure:"local_weight"`
+	VectorWeight   float64       `mapstructure:"vector_weight"`
+	TripleWeight   float64       `mapstructure:"triple_weight"`
+	BackendTimeout time.Duration `mapstructure:"backend_timeout"`
+}
+
+// RAGRankingConfig 重要性与时间衰减排序
+type RAGRankingConfig struct {
+	Enabled             bool                            `mapstructure:"enabled"`
+	Default             RAGRankWeightsConfig            `mapstructure:"default"`
+	Kinds               map[string]RAGRankWeightsConfig `mapstructure:"kinds"` // 按记忆类型覆盖 default
+	DefaultImportance   float64                         `mapstructure:"default_importance"`
+	Candidates          int                             `mapstructure:"candidates"`
+	AccessFlushInterval time.Duration                   `mapstructure:"access_flush_interval"`
+	AccessPath          string                          `mapstructure:"access_path"` // 检索统计快照目录，为空时不持久化
+}
+
+// RAGRankWeightsConfig 排序权重
+type RAGRankWeightsConfig struct {
+	Relevance  float64       `mapstructure:"relevance"`
+	Importance float64       `mapstructure:"importance"`
+	Recency    float64       `mapstructure:"recency"`
+	HalfLife   time.Duration `mapstructure:"half_life"` // 0 表示不衰减
+}
+
+// RAGAsyncConfig 异步写入
+type RAGAsyncConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	QueueSize    int           `mapstructure:"queue_size"`
+	Workers      int           `mapstructure:"workers"`
+	SpoolPath    string        `mapstructure:"spool_path"` // 预写日志目录，为空时不启用
+	SpoolSync    bool          `mapstructure:"spool_sync"`
+	MaxRetries   int           `mapstructure:"max_retries"`
+	RetryBackoff time.Duration `mapstructure:"retry_backoff"`
+}
+
+// RAGRetentionConfig 保留策略与后台压缩
+type RAGRetentionConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	MaxDays  int           `mapstructure:"max_days"`
+	MaxBytes int64         `mapstructure:"max_bytes"` // 每租户上限
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// RAGDedupeConfig 保存时的近重复检测
+type RAGDedupeConfig struct {
+	Enabled         bool     `mapstructure:"enabled"`
+	Threshold       float64  `mapstructure:"threshold"` // 规范化文本 shingle 的 Jaccard 系数阈值
+	Shingle         int      `mapstructure:"shingle"`
+	Candidates      int      `mapstructure:"candidates"`
+	VectorThreshold float64  `mapstructure:"vector_threshold"` // 启用向量后端时的相似度阈值，0 表示不使用
+	Kinds           []string `mapstructure:"kinds"`            // 为空表示全部类型
+}
+
+// RAGConsolidationConfig 短期记忆整理
+type RAGConsolidationConfig struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	LLM       string        `mapstructure:"llm"` // llm_configs 中的名称
+	Interval  time.Duration `mapstructure:"interval"`
+	Threshold int           `mapstructure:"threshold"` // 租户新增短期记忆达到该数量时立即整理，0 表示只按周期
+	BatchSize int           `mapstructure:"batch_size"`
+	MinAge    time.Duration `mapstructure:"min_age"`
+}
+
 // LLMConfig LLM配置结构
 type LLMConfig struct {
 	APIBaseURL string `mapstructure:"api_base_url"`
@@ -110,6 +285,12 @@ func setDefaults() {
 	viper.SetDefault("rate_limit.enabled", true)
 	viper.SetDefault("rate_limit.qps", 50)
 	viper.SetDefault("rate_limit.burst", 100)
+	viper.SetDefault("rate_limit.per_tenant", true)
+	viper.SetDefault("rate_limit.per_workflow", false)
+	viper.SetDefault("rate_limit.max_keys", 10000)
+	viper.SetDefault("rate_limit.idle_ttl", "10m")
+	viper.SetDefault("rate_limit.trust_forwarded_for", false)
+	viper.SetDefault("rate_limit.stream_concurrency", 4)
 
 	viper.SetDefault("log.level", "info")
 	viper.SetDefault("log.encoding", "json")
@@ -121,17 +302,86 @@ func setDefaults() {
 	
 	// 租户配置默认值
 	viper.SetDefault("tenant.required", true) // 默认要求租户信息（因为上游Hertz已验证）
-	viper.SetDefault("tenant.exclude_paths", []string{"^/health$", "^/api/workflows$"})
+	viper.SetDefault("tenant.exclude_paths", []string{"^/health$", "^/api/workflows$", "^/api/archives$"})
 	
+	// 认证配置默认值：默认信任上游网关，直连部署请开启 api_key 或 hmac
+	viper.SetDefault("auth.mode", "none")
+	viper.SetDefault("auth.max_clock_skew", "5m")
+	viper.SetDefault("auth.exclude_paths", []string{"^/health$"})
+
 	// CORS配置默认值
 	viper.SetDefault("cors.enabled", true)
 	viper.SetDefault("cors.allowed_origins", []string{"http://localhost:5173"}) // 默认允许前端开发端口
 	viper.SetDefault("cors.allowed_methods", []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"})
-	viper.SetDefault("cors.allowed_headers", []string{"Content-Type", "Authorization", "X-User-ID", "X-Archive-ID"})
-	viper.SetDefault("cors.exposed_headers", []string{})
+	viper.SetDefault("cors.allowed_headers", []string{"Content-Type", "Authorization", "X-User-ID", "X-Archive-ID", "X-API-Key", "X-Timestamp", "X-Signature"})
+	viper.SetDefault("cors.exposed_headers", []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"})
 	viper.SetDefault("cors.allow_credentials", false)
 	viper.SetDefault("cors.max_age", 86400) // 24小时
 	
+	// 用量与额度默认值
+	viper.SetDefault("usage.enabled", true)
+	viper.SetDefault("usage.root_path", "data/usage")
+	viper.SetDefault("usage.daily_token_limit", 0)
+	viper.SetDefault("usage.mode", "reject")
+	viper.SetDefault("usage.degraded_max_tokens", 512)
+
+	// 记忆系统默认值（与 rag.DefaultOptions 一致）
+	viper.SetDefault("rag.namespace", "default")
+	viper.SetDefault("rag.in_memory.enabled", true)
+	viper.SetDefault("rag.in_memory.max_entries", 2048)
+	viper.SetDefault("rag.disk_json.enabled", true)
+	viper.SetDefault("rag.disk_json.root_path", "data/rag")
+	viper.SetDefault("rag.bolt.enabled", false)
+	viper.SetDefault("rag.bolt.root_path", "data/rag_bolt")
+	viper.SetDefault("rag.bolt.timeout", "1s")
+	viper.SetDefault("rag.vector.enabled", false)
+	viper.SetDefault("rag.vector.root_path", "data/rag_vector")
+	viper.SetDefault("rag.vector.embedding_provider", "hash")
+	viper.SetDefault("rag.vector.dim", 256)
+	viper.SetDefault("rag.vector.http.timeout", "5s")
+	viper.SetDefault("rag.vector.http.max_retries", 2)
+	viper.SetDefault("rag.vector.http.retry_backoff", "200ms")
+	viper.SetDefault("rag.triple.enabled", false)
+	viper.SetDefault("rag.triple.root_path", "data/rag_triple")
+	viper.SetDefault("rag.triple.schema_version", "1")
+	viper.SetDefault("rag.triple.http.timeout", "5s")
+	viper.SetDefault("rag.triple.http.max_retries", 2)
+	viper.SetDefault("rag.triple.http.retry_backoff", "200ms")
+	viper.SetDefault("rag.retrieval.fusion", "rrf")
+	viper.SetDefault("rag.retrieval.rrf_k", 60)
+	viper.SetDefault("rag.retrieval.local_weight", 1)
+	viper.SetDefault("rag.retrieval.vector_weight", 1)
+	viper.SetDefault("rag.retrieval.triple_weight", 1)
+	viper.SetDefault("rag.retrieval.backend_timeout", "3s")
+	viper.SetDefault("rag.ranking.enabled", true)
+	viper.SetDefault("rag.ranking.default", map[string]any{"relevance": 1, "importance": 0.5, "recency": 0.5, "half_life": "168h"})
+	viper.SetDefault("rag.ranking.kinds.short_term", map[string]any{"relevance": 1, "importance": 0.3, "recency": 1, "half_life": "24h"})
+	viper.SetDefault("rag.ranking.kinds.long_term", map[string]any{"relevance": 1, "importance": 0.5, "recency": 0.3, "half_life": "2160h"})
+	viper.SetDefault("rag.ranking.kinds.fact", map[string]any{"relevance": 1, "importance": 0.5, "recency": 0, "half_life": 0})
+	viper.SetDefault("rag.ranking.default_importance", 0.5)
+	viper.SetDefault("rag.ranking.candidates", 50)
+	viper.SetDefault("rag.ranking.access_flush_interval", "1m")
+	viper.SetDefault("rag.ranking.access_path", "data/rag_access")
+	viper.SetDefault("rag.dedupe.enabled", false)
+	viper.SetDefault("rag.dedupe.threshold", 0.8)
+	viper.SetDefault("rag.dedupe.shingle", 2)
+	viper.SetDefault("rag.dedupe.candidates", 10)
+	viper.SetDefault("rag.dedupe.vector_threshold", 0.95)
+	viper.SetDefault("rag.consolidation.enabled", false)
+	viper.SetDefault("rag.consolidation.llm", "local")
+	viper.SetDefault("rag.consolidation.interval", "1h")
+	viper.SetDefault("rag.consolidation.threshold", 50)
+	viper.SetDefault("rag.consolidation.batch_size", 20)
+	viper.SetDefault("rag.consolidation.min_age", "30m")
+	viper.SetDefault("rag.async.enabled", true)
+	viper.SetDefault("rag.async.queue_size", 1024)
+	viper.SetDefault("rag.async.workers", 1)
+	viper.SetDefault("rag.async.spool_path", "data/rag_spool")
+	viper.SetDefault("rag.async.max_retries", 3)
+	viper.SetDefault("rag.async.retry_backoff", "200ms")
+	viper.SetDefault("rag.retention.enabled", false)
+	viper.SetDefault("rag.retention.interval", "1h")
+
 	// LLM配置默认为空map，用户可在配置文件中定义多个LLM提供商
 	viper.SetDefault("llm_configs", map[string]interface{}{})
 }
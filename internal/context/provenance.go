@@ -0,0 +1,45 @@
+package context
+
+import (
+	"context"
+)
+
+// ProvenanceKey 是来源信息在 context 中的键类型
+type ProvenanceKey struct{}
+
+// Provenance 产生当前写入的运行来源，保存记忆时记录到条目（见 rag.MetaRunID 等）
+type Provenance struct {
+	RunID      string `json:"run_id,omitempty"`       // 单次工作流运行
+	SessionID  string `json:"session_id,omitempty"`   // 客户端会话（请求体 session_id）
+	Workflow   string `json:"workflow,omitempty"`     // 工作流名称
+	ToolCallID string `json:"tool_call_id,omitempty"` // 触发写入的工具调用
+	Model      string `json:"model,omitempty"`        // 发起工具调用的模型
+}
+
+// WithProvenance 将来源信息合并到 context 中：p 的非空字段覆盖已有值，其余保留
+// 各层只需补充自己知道的字段（工作流服务：运行/会话/工作流；agent：模型；工具：调用 ID）
+func WithProvenance(ctx context.Context, p Provenance) context.Context {
+	cur, _ := GetProvenance(ctx)
+	if p.RunID != "" {
+		cur.RunID = p.RunID
+	}
+	if p.SessionID != "" {
+		cur.SessionID = p.SessionID
+	}
+	if p.Workflow != "" {
+		cur.Workflow = p.Workflow
+	}
+	if p.ToolCallID != "" {
+		cur.ToolCallID = p.ToolCallID
+	}
+	if p.Model != "" {
+		cur.Model = p.Model
+	}
+	return context.WithValue(ctx, ProvenanceKey{}, cur)
+}
+
+// GetProvenance 从 context 中获取来源信息
+func GetProvenance(ctx context.Context) (Provenance, bool) {
+	p, ok := ctx.Value(ProvenanceKey{}).(Provenance)
+	return p, ok
+}
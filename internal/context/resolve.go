@@ -0,0 +1,35 @@
+package context
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrTenantMissing 无法确定请求所属租户
+	ErrTenantMissing = errors.New("缺少租户信息(user_id, archive_id)")
+	// ErrTenantMismatch 请求体声明的租户与认证头部不一致
+	ErrTenantMismatch = errors.New("请求体中的租户与请求头不一致")
+)
+
+// ResolveTenant 统一的租户解析入口
+// - 优先使用中间件从（已认证的）请求头注入的租户
+// - claimed 为请求体等非可信来源声明的租户；若非空字段与 context 租户不一致则拒绝
+// - context 中没有租户时（租户头部非必需的部署），才退回使用 claimed
+func ResolveTenant(ctx context.Context, claimed Tenant) (Tenant, error) {
+	if t, ok := GetTenant(ctx); ok && t.UserID != "" && t.ArchiveID != "" {
+		if (claimed.UserID != "" && claimed.UserID != t.UserID) ||
+			(claimed.ArchiveID != "" && claimed.ArchiveID != t.ArchiveID) {
+			return Tenant{}, ErrTenantMismatch
+		}
+		return t, nil
+	}
+	if claimed.UserID != "" && claimed.ArchiveID != "" {
+		// 回退来源未经过中间件，需要同样的格式校验
+		if err := claimed.Validate(); err != nil {
+			return Tenant{}, err
+		}
+		return claimed, nil
+	}
+	return Tenant{}, ErrTenantMissing
+}
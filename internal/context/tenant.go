@@ -46,4 +46,24 @@ func MustGetTenant(ctx context.Context) Tenant {
 		panic("租户信息未在 context 中找到")
 	}
 	return tenant
-}
\ No newline at end of file
+}
+
+// AuthKey 是认证信息在 context 中的键类型
+type AuthKey struct{}
+
+// AuthInfo 认证中间件校验通过后的身份信息
+type AuthInfo struct {
+	Method  string // 认证方式：api_key | hmac
+	Subject string // 凭证标识（API Key 名称或签名用户）
+}
+
+// WithAuth 将认证信息注入到 context 中
+func WithAuth(ctx context.Context, info AuthInfo) context.Context {
+	return context.WithValue(ctx, AuthKey{}, info)
+}
+
+// GetAuth 从 context 中获取认证信息
+func GetAuth(ctx context.Context) (AuthInfo, bool) {
+	info, ok := ctx.Value(AuthKey{}).(AuthInfo)
+	return info, ok
+}
@@ -0,0 +1,56 @@
+package context
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxTenantIDLen 租户 ID（user_id / archive_id）最大长度（字节）
+const MaxTenantIDLen = 64
+
+// ErrInvalidTenantID 租户 ID 不符合规则
+var ErrInvalidTenantID = errors.New("租户ID不合法")
+
+// ValidateTenantID 校验单个租户 ID
+// 规则：1~64 个 ASCII 字符，仅允许字母、数字与 _ - . @，首字符必须为字母或数字，且不得包含 ".."
+// 拒绝控制字符、路径分隔符、空白与所有非 ASCII 字符（避免 unicode 同形字冒充）
+func ValidateTenantID(id string) error {
+	if id == "" {
+		return fmt.Errorf("%w: 不能为空", ErrInvalidTenantID)
+	}
+	if len(id) > MaxTenantIDLen {
+		return fmt.Errorf("%w: 长度不能超过 %d", ErrInvalidTenantID, MaxTenantIDLen)
+	}
+	if !isAlnum(id[0]) {
+		return fmt.Errorf("%w: 必须以字母或数字开头", ErrInvalidTenantID)
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if isAlnum(c) || c == '_' || c == '-' || c == '@' {
+			continue
+		}
+		if c == '.' {
+			if i+1 < len(id) && id[i+1] == '.' {
+				return fmt.Errorf("%w: 不能包含 \"..\"", ErrInvalidTenantID)
+			}
+			continue
+		}
+		return fmt.Errorf("%w: 包含非法字符 %q", ErrInvalidTenantID, c)
+	}
+	return nil
+}
+
+// Validate 校验租户的 user_id 与 archive_id
+func (t Tenant) Validate() error {
+	if err := ValidateTenantID(t.UserID); err != nil {
+		return fmt.Errorf("user_id: %w", err)
+	}
+	if err := ValidateTenantID(t.ArchiveID); err != nil {
+		return fmt.Errorf("archive_id: %w", err)
+	}
+	return nil
+}
+
+func isAlnum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
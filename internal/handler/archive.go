@@ -0,0 +1,355 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	actx "ahs/internal/context"
+	"ahs/internal/service/rag"
+	"ahs/internal/service/usage"
+	"go.uber.org/zap"
+)
+
+// maxSnapshotBytes 快照导入的请求体上限
+const maxSnapshotBytes = 256 << 20
+
+// ArchiveHandler 归档生命周期处理器
+//
+//	GET    /api/archives                   列出当前用户的归档
+//	POST   /api/archives                   创建空归档 {"archive_id"}
+//	DELETE /api/archives/{id}              清除归档的记忆与用量记录
+//	POST   /api/archives/{id}/fork         分叉归档 {"new_archive_id"}
+//	GET    /api/archives/{id}/snapshot     导出 tar.gz 快照
+//	PUT    /api/archives/{id}/snapshot     从 tar.gz 快照导入到新归档
+//	GET    /api/archives/{id}/runs/{run}   列出某次运行写入的记忆
+//	DELETE /api/archives/{id}/runs/{run}   回滚（删除）某次运行写入的记忆
+//	GET    /api/archives/{id}/memories/{mid}/versions[?as_of=&chapter=]
+//	                                       列出记忆的全部版本；带 as_of（RFC3339）或 chapter 时返回当时有效的版本
+type ArchiveHandler struct {
+	rag    *rag.Manager
+	usage  *usage.Tracker
+	logger *zap.Logger
+}
+
+// NewArchiveHandler 创建归档处理器；tracker 可为 nil
+func NewArchiveHandler(mgr *rag.Manager, tracker *usage.Tracker, logger *zap.Logger) *ArchiveHandler {
+	return &ArchiveHandler{rag: mgr, usage: tracker, logger: logger}
+}
+
+// Archives 处理 /api/archives
+func (h *ArchiveHandler) Archives(w http.ResponseWriter, r *http.Request) {
+	userID, err := requestUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ids, err := h.rag.ListArchives(r.Context(), userID)
+		if err != nil {
+			h.logger.Error("列出归档失败", zap.Error(err), zap.String("user_id", userID))
+			http.Error(w, "列出归档失败", http.StatusInternalServerError)
+			return
+		}
+		h.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"user_id":  userID,
+			"archives": ids,
+			"count":    len(ids),
+		})
+
+	case http.MethodPost:
+		var req struct {
+			ArchiveID string `json:"archive_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "请求格式错误", http.StatusBadRequest)
+			return
+		}
+		if err := actx.ValidateTenantID(req.ArchiveID); err != nil {
+			http.Error(w, "archive_id 不合法: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		t := rag.Tenant{UserID: userID, ArchiveID: req.ArchiveID}
+		if err := h.rag.CreateArchive(r.Context(), t); err != nil {
+			h.writeArchiveError(w, "创建归档失败", t, err)
+			return
+		}
+		h.writeJSON(w, http.StatusCreated, map[string]string{"user_id": userID, "archive_id": req.ArchiveID})
+
+	default:
+		http.Error(w, "仅支持 GET/POST 请求", http.StatusMethodNotAllowed)
+	}
+}
+
+// Archive 处理 /api/archives/{id}[/fork|/snapshot|/runs/{run}|/memories/{mid}/versions]
+func (h *ArchiveHandler) Archive(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/archives/")
+	archiveID, action, _ := strings.Cut(rest, "/")
+	if archiveID == "" {
+		http.Error(w, "缺少归档ID", http.StatusBadRequest)
+		return
+	}
+	if err := actx.ValidateTenantID(archiveID); err != nil {
+		http.Error(w, "archive_id 不合法: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID, err := requestUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// 路径中的归档必须与租户头部一致，防止跨归档操作
+	if ct, ok := actx.GetTenant(r.Context()); ok && ct.ArchiveID != archiveID {
+		http.Error(w, "路径中的归档与请求头不一致", http.StatusBadRequest)
+		return
+	}
+	t := rag.Tenant{UserID: userID, ArchiveID: archiveID}
+	if runID, ok := strings.CutPrefix(action, "runs/"); ok {
+		h.run(w, r, t, runID)
+		return
+	}
+	if rest, ok := strings.CutPrefix(action, "memories/"); ok {
+		memoryID, sub, _ := strings.Cut(rest, "/")
+		if sub != "versions" {
+			http.Error(w, "未知的归档操作", http.StatusNotFound)
+			return
+		}
+		h.versions(w, r, t, memoryID)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodDelete:
+		h.purge(w, r, t)
+	case action == "fork" && r.Method == http.MethodPost:
+		h.fork(w, r, t)
+	case action == "snapshot" && r.Method == http.MethodGet:
+		h.exportSnapshot(w, r, t)
+	case action == "snapshot" && r.Method == http.MethodPut:
+		h.importSnapshot(w, r, t)
+	case action == "" || action == "fork" || action == "snapshot":
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "未知的归档操作", http.StatusNotFound)
+	}
+}
+
+func (h *ArchiveHandler) purge(w http.ResponseWriter, r *http.Request, t rag.Tenant) {
+	if err := h.rag.PurgeArchive(r.Context(), t); err != nil {
+		h.writeArchiveError(w, "清除归档失败", t, err)
+		return
+	}
+	if h.usage != nil {
+		if err := h.usage.Purge(r.Context(), actx.Tenant{UserID: t.UserID, ArchiveID: t.ArchiveID}); err != nil {
+			h.writeArchiveError(w, "清除用量记录失败", t, err)
+			return
+		}
+	}
+	h.logger.Info("归档已清除", zap.String("user_id", t.UserID), zap.String("archive_id", t.ArchiveID))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// run 查询或回滚单次运行写入的记忆与三元组
+func (h *ArchiveHandler) run(w http.ResponseWriter, r *http.Request, t rag.Tenant, runID string) {
+	if runID == "" || strings.Contains(runID, "/") {
+		http.Error(w, "run_id 不合法", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		items, err := h.rag.RunItems(r.Context(), t, runID)
+		if err != nil {
+			h.writeArchiveError(w, "查询运行记忆失败", t, err)
+			return
+		}
+		if items == nil {
+			items = []rag.MemoryItem{}
+		}
+		triples, err := h.rag.RunTriples(r.Context(), t, runID)
+		if err != nil {
+			h.writeArchiveError(w, "查询运行三元组失败", t, err)
+			return
+		}
+		if triples == nil {
+			triples = []rag.Triple{}
+		}
+		h.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"run_id":  runID,
+			"items":   items,
+			"count":   len(items),
+			"triples": triples,
+		})
+	case http.MethodDelete:
+		rep, err := h.rag.RollbackRun(r.Context(), t, runID)
+		if err != nil {
+			h.writeArchiveError(w, "回滚运行记忆失败", t, err)
+			return
+		}
+		h.writeJSON(w, http.StatusOK, rep)
+	default:
+		http.Error(w, "仅支持 GET/DELETE 请求", http.StatusMethodNotAllowed)
+	}
+}
+
+// versions 列出记忆的历史版本，或返回指定时间/章节有效的版本
+func (h *ArchiveHandler) versions(w http.ResponseWriter, r *http.Request, t rag.Tenant, memoryID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "仅支持 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+	if memoryID == "" {
+		http.Error(w, "缺少记忆ID", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	var at *time.Time
+	if s := q.Get("as_of"); s != "" {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "as_of 不合法: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		at = &v
+	}
+	chapter := 0
+	if s := q.Get("chapter"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v <= 0 {
+			http.Error(w, "chapter 必须为正整数", http.StatusBadRequest)
+			return
+		}
+		chapter = v
+	}
+
+	if at != nil || chapter > 0 {
+		it, err := h.rag.GetAsOf(r.Context(), t, memoryID, at, chapter)
+		if errors.Is(err, rag.ErrNotFound) {
+			http.Error(w, "记忆不存在或当时尚未生效", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.writeArchiveError(w, "查询记忆版本失败", t, err)
+			return
+		}
+		h.writeJSON(w, http.StatusOK, map[string]interface{}{"id": memoryID, "item": it})
+		return
+	}
+	items, err := h.rag.Versions(r.Context(), t, memoryID)
+	if errors.Is(err, rag.ErrNotFound) {
+		http.Error(w, "记忆不存在", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.writeArchiveError(w, "查询记忆版本失败", t, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":       memoryID,
+		"versions": items,
+		"count":    len(items),
+	})
+}
+
+func (h *ArchiveHandler) fork(w http.ResponseWriter, r *http.Request, src rag.Tenant) {
+	var req struct {
+		NewArchiveID string `json:"new_archive_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "请求格式错误", http.StatusBadRequest)
+		return
+	}
+	if err := actx.ValidateTenantID(req.NewArchiveID); err != nil {
+		http.Error(w, "new_archive_id 不合法: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	n, err := h.rag.ForkArchive(r.Context(), src, req.NewArchiveID)
+	if err != nil {
+		h.writeArchiveError(w, "分叉归档失败", src, err)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"user_id":    src.UserID,
+		"archive_id": req.NewArchiveID,
+		"forked":     src.ArchiveID,
+		"items":      n,
+	})
+}
+
+func (h *ArchiveHandler) exportSnapshot(w http.ResponseWriter, r *http.Request, t rag.Tenant) {
+	ok, err := h.rag.ArchiveExists(r.Context(), t)
+	if err != nil {
+		h.writeArchiveError(w, "导出快照失败", t, err)
+		return
+	}
+	if !ok {
+		http.Error(w, "归档不存在", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", t.ArchiveID+".tar.gz"))
+	// 头部已写出后无法再返回错误状态，只能记录日志
+	if err := h.rag.ExportArchive(r.Context(), t, w); err != nil {
+		h.logger.Error("导出快照失败", zap.Error(err), zap.String("user_id", t.UserID), zap.String("archive_id", t.ArchiveID))
+	}
+}
+
+func (h *ArchiveHandler) importSnapshot(w http.ResponseWriter, r *http.Request, t rag.Tenant) {
+	body := http.MaxBytesReader(w, r.Body, maxSnapshotBytes)
+	n, err := h.rag.ImportArchive(r.Context(), t, body)
+	if err != nil {
+		if errors.Is(err, rag.ErrArchiveExists) {
+			h.writeArchiveError(w, "导入快照失败", t, err)
+			return
+		}
+		h.logger.Warn("导入快照失败", zap.Error(err), zap.String("archive_id", t.ArchiveID))
+		http.Error(w, "快照格式错误: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"user_id":    t.UserID,
+		"archive_id": t.ArchiveID,
+		"items":      n,
+	})
+}
+
+// writeArchiveError 按错误类型映射状态码
+func (h *ArchiveHandler) writeArchiveError(w http.ResponseWriter, msg string, t rag.Tenant, err error) {
+	switch {
+	case errors.Is(err, rag.ErrArchiveExists):
+		http.Error(w, "归档已存在", http.StatusConflict)
+	case errors.Is(err, rag.ErrArchiveNotFound):
+		http.Error(w, "归档不存在", http.StatusNotFound)
+	default:
+		h.logger.Error(msg, zap.Error(err), zap.String("user_id", t.UserID), zap.String("archive_id", t.ArchiveID))
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
+
+func (h *ArchiveHandler) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Error("编码归档响应失败", zap.Error(err))
+	}
+}
+
+// requestUser 取当前请求的用户：优先中间件注入的租户，其次 X-User-ID 头部
+func requestUser(r *http.Request) (string, error) {
+	if t, ok := actx.GetTenant(r.Context()); ok && t.UserID != "" {
+		return t.UserID, nil
+	}
+	userID := strings.TrimSpace(r.Header.Get("X-User-ID"))
+	if userID == "" {
+		return "", errors.New("缺少 X-User-ID")
+	}
+	if err := actx.ValidateTenantID(userID); err != nil {
+		return "", fmt.Errorf("X-User-ID 不合法: %w", err)
+	}
+	return userID, nil
+}
@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	actx "ahs/internal/context"
 	"ahs/internal/service"
+	"ahs/internal/service/usage"
 	"go.uber.org/zap"
 )
 
@@ -32,6 +35,24 @@ func GetRequestBody(ctx context.Context) ([]byte, bool) {
 	return body, ok
 }
 
+// ResolveTenant 解析当前请求的租户，供工具与工作流统一使用
+// 以中间件注入的租户为准，请求体中的 user_id/archive_id 仅在没有租户头部时作为回退，
+// 二者不一致时返回 actx.ErrTenantMismatch
+func ResolveTenant(ctx context.Context) (actx.Tenant, error) {
+	var claimed actx.Tenant
+	if b, ok := GetRequestBody(ctx); ok && len(b) > 0 {
+		var payload struct {
+			UserID    string `json:"user_id"`
+			ArchiveID string `json:"archive_id"`
+		}
+		if err := json.Unmarshal(b, &payload); err != nil {
+			return actx.Tenant{}, fmt.Errorf("解析请求体租户失败: %w", err)
+		}
+		claimed = actx.Tenant{UserID: payload.UserID, ArchiveID: payload.ArchiveID}
+	}
+	return actx.ResolveTenant(ctx, claimed)
+}
+
 // Handler HTTP处理器
 type Handler struct {
 	workflowService *service.WorkflowService
@@ -144,6 +165,13 @@ func (h *Handler) Execute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 请求体中的租户不得与请求头冲突
+	if _, err := actx.ResolveTenant(r.Context(), actx.Tenant{UserID: req.UserID, ArchiveID: req.ArchiveID}); err == actx.ErrTenantMismatch {
+		h.logger.Warn("请求体租户与请求头不一致", zap.String("user_id", req.UserID), zap.String("archive_id", req.ArchiveID))
+		http.Error(w, "请求体中的租户与请求头不一致", http.StatusBadRequest)
+		return
+	}
+
 	// 将原始 body 放入 context
 	ctx := WithRequestBody(r.Context(), body)
 	if req.Timeout > 0 {
@@ -159,6 +187,8 @@ func (h *Handler) Execute(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "工作流未找到", http.StatusNotFound)
 		case service.ErrInvalidRequest:
 			http.Error(w, "无效的请求", http.StatusBadRequest)
+		case usage.ErrQuotaExceeded:
+			h.writeQuotaExceeded(w)
 		default:
 			h.logger.Error("执行工作流失败", zap.Error(err), zap.String("workflow", req.Workflow))
 			http.Error(w, "执行工作流失败", http.StatusInternalServerError)
@@ -174,18 +204,14 @@ func (h *Handler) Execute(w http.ResponseWriter, r *http.Request) {
 }
 
 // ExecuteStream 流式执行工作流处理器
+// 请求校验与额度检查在发送流式响应头之前完成，失败时与 Execute 返回相同的状态码（额度用尽为 429 + Retry-After）；
+// 之后的事件：run（运行 ID）、data（分片）、done 或 error（最后一个事件，均携带本次运行的 token 用量）
 func (h *Handler) ExecuteStream(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "仅支持 POST 请求", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// 设置流式响应头
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Transfer-Encoding", "chunked")
-
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "流式传输不支持", http.StatusInternalServerError)
@@ -195,16 +221,23 @@ func (h *Handler) ExecuteStream(w http.ResponseWriter, r *http.Request) {
 	// 读取原始 body 内容
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		h.sendErrorEvent(w, "请求读取错误")
-		flusher.Flush()
+		h.logger.Warn("读取请求体失败", zap.Error(err))
+		http.Error(w, "请求读取错误", http.StatusBadRequest)
 		return
 	}
 
 	// 解析请求结构体
 	var req service.WorkflowRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		h.sendErrorEvent(w, "请求格式错误")
-		flusher.Flush()
+		h.logger.Warn("解析请求失败", zap.Error(err))
+		http.Error(w, "请求格式错误", http.StatusBadRequest)
+		return
+	}
+
+	// 请求体中的租户不得与请求头冲突
+	if _, err := actx.ResolveTenant(r.Context(), actx.Tenant{UserID: req.UserID, ArchiveID: req.ArchiveID}); err == actx.ErrTenantMismatch {
+		h.logger.Warn("请求体租户与请求头不一致", zap.String("user_id", req.UserID), zap.String("archive_id", req.ArchiveID))
+		http.Error(w, "请求体中的租户与请求头不一致", http.StatusBadRequest)
 		return
 	}
 
@@ -216,34 +249,75 @@ func (h *Handler) ExecuteStream(w http.ResponseWriter, r *http.Request) {
 		defer cancel()
 	}
 
-	err = h.workflowService.ExecuteStream(ctx, req, func(data string, done bool, err error) {
+	// 校验与额度检查
+	req.RunID = service.NewRunID()
+	run, err := h.workflowService.StartStream(ctx, req)
+	if err != nil {
+		switch err {
+		case service.ErrWorkflowNotFound:
+			http.Error(w, "工作流未找到", http.StatusNotFound)
+		case service.ErrInvalidRequest:
+			http.Error(w, "无效的请求", http.StatusBadRequest)
+		case usage.ErrQuotaExceeded:
+			h.writeQuotaExceeded(w)
+		default:
+			h.logger.Error("流式执行工作流失败", zap.Error(err), zap.String("workflow", req.Workflow))
+			http.Error(w, "执行工作流失败", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// 设置流式响应头
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Transfer-Encoding", "chunked")
+
+	// 首个事件告知本次运行 ID，写入的记忆可按其查询与回滚
+	runData, _ := json.Marshal(map[string]string{"run_id": run.RunID()})
+	h.sendEvent(w, "run", string(runData))
+	flusher.Flush()
+
+	// 完成信号留到处理结束后发送，以便携带用量；随完成信号一起到达的分片照常作为 data 发送
+	u, err := run.Run(func(data string, done bool, err error) {
 		if err != nil {
 			h.sendErrorEvent(w, err.Error())
 			flusher.Flush()
 			return
 		}
-
-		if done {
-			h.sendEvent(w, "done", data)
-		} else {
-			h.sendEvent(w, "data", data)
+		if data == "" && done {
+			return
 		}
+		h.sendEvent(w, "data", data)
 		flusher.Flush()
 	})
 
 	if err != nil {
-		switch err {
-		case service.ErrWorkflowNotFound:
-			h.sendErrorEvent(w, "工作流未找到")
-		case service.ErrInvalidRequest:
-			h.sendErrorEvent(w, "无效的请求")
-		default:
-			h.logger.Error("流式执行工作流失败", zap.Error(err), zap.String("workflow", req.Workflow))
-			h.sendErrorEvent(w, "执行工作流失败")
-		}
+		h.logger.Error("流式执行工作流失败", zap.Error(err), zap.String("workflow", req.Workflow))
+		errorData, _ := json.Marshal(streamEnd{Error: "执行工作流失败", RunID: run.RunID(), Usage: u, Degraded: run.Degraded()})
+		h.sendEvent(w, "error", string(errorData))
 		flusher.Flush()
 		return
 	}
+	doneData, _ := json.Marshal(streamEnd{RunID: run.RunID(), Usage: u, Degraded: run.Degraded()})
+	h.sendEvent(w, "done", string(doneData))
+	flusher.Flush()
+}
+
+// streamEnd 流式运行的最后一个事件（done 或 error）
+type streamEnd struct {
+	Error    string       `json:"error,omitempty"`
+	RunID    string       `json:"run_id"`
+	Usage    *usage.Usage `json:"usage,omitempty"`
+	Degraded bool         `json:"degraded,omitempty"`
+}
+
+// writeQuotaExceeded 返回 429 并提示额度重置时间
+func (h *Handler) writeQuotaExceeded(w http.ResponseWriter) {
+	if t := h.workflowService.UsageTracker(); t != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(t.RetryAfter().Seconds())))
+	}
+	http.Error(w, "租户额度已用尽", http.StatusTooManyRequests)
 }
 
 // sendEvent 发送 SSE 事件
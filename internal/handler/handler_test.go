@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ahs/internal/config"
+	actx "ahs/internal/context"
+	"ahs/internal/service"
+	"ahs/internal/service/usage"
+
+	"go.uber.org/zap"
+)
+
+// fakeManager 单个工作流：输出两段分片并记录用量，fail 时在输出后报错
+type fakeManager struct{ fail bool }
+
+func (m fakeManager) List() []string { return []string{"w"} }
+func (m fakeManager) Get(name string) (service.WorkflowProcessor, bool) {
+	return m, name == "w"
+}
+func (m fakeManager) GetInfo(name string) (*service.WorkflowInfo, error) {
+	return &service.WorkflowInfo{Name: name}, nil
+}
+func (m fakeManager) Process(ctx context.Context, input string) (string, error) {
+	return "ok", nil
+}
+func (m fakeManager) ProcessStream(ctx context.Context, input string, callback service.StreamCallback) error {
+	if c, ok := usage.CollectorFrom(ctx); ok {
+		c.Add(usage.Usage{PromptTokens: 3, CompletionTokens: 4, TotalTokens: 7})
+	}
+	callback("你", false, nil)
+	if m.fail {
+		return errors.New("model down")
+	}
+	callback("好", true, nil)
+	return nil
+}
+
+func newStreamHandler(t *testing.T, fail bool, limit int64) (*Handler, *usage.Tracker) {
+	t.Helper()
+	svc := service.NewWorkflowService(fakeManager{fail: fail})
+	tr, err := usage.NewTracker(config.UsageConfig{Enabled: true, DailyTokenLimit: limit, Mode: "reject"})
+	if err != nil {
+		t.Fatalf("tracker: %v", err)
+	}
+	svc.SetUsageTracker(tr)
+	return New(svc, zap.NewNop()), tr
+}
+
+func stream(h *Handler) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/stream", strings.NewReader(`{"workflow":"w","input":"hi"}`))
+	r = r.WithContext(actx.WithTenant(r.Context(), "u", "a"))
+	w := httptest.NewRecorder()
+	h.ExecuteStream(w, r)
+	return w
+}
+
+func TestExecuteStream_QuotaExceededBeforeStreaming(t *testing.T) {
+	h, tr := newStreamHandler(t, false, 5)
+	if err := tr.Record(context.Background(), actx.Tenant{UserID: "u", ArchiveID: "a"}, usage.Usage{TotalTokens: 10}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	w := stream(h)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("status %d, retry-after %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if strings.Contains(w.Header().Get("Content-Type"), "event-stream") || strings.Contains(w.Body.String(), "event:") {
+		t.Fatalf("stream started: %q", w.Body.String())
+	}
+}
+
+func TestExecuteStream_UsageInFinalEvent(t *testing.T) {
+	h, _ := newStreamHandler(t, false, 0)
+	body := stream(h).Body.String()
+	if !strings.Contains(body, "event: data\ndata: 好\n") {
+		t.Fatalf("last chunk lost: %q", body)
+	}
+	if !strings.HasSuffix(body, "\n\n") || !strings.Contains(body, "event: done\ndata: {") || !strings.Contains(body, `"total_tokens":7`) {
+		t.Fatalf("done without usage: %q", body)
+	}
+
+	// 处理失败时 error 事件同样携带用量
+	h, _ = newStreamHandler(t, true, 0)
+	body = stream(h).Body.String()
+	if strings.Contains(body, "event: done") || !strings.Contains(body, "event: error") || !strings.Contains(body, `"total_tokens":7`) {
+		t.Fatalf("error without usage: %q", body)
+	}
+}
+
+func TestExecuteStream_QuotaRequiresValidTenant(t *testing.T) {
+	h, _ := newStreamHandler(t, false, 100)
+	for name, body := range map[string]string{
+		"非法租户": `{"workflow":"w","input":"hi","user_id":"../x","archive_id":"a"}`,
+		"缺少存档": `{"workflow":"w","input":"hi","user_id":"u"}`,
+		"缺少租户": `{"workflow":"w","input":"hi"}`,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api/stream", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.ExecuteStream(w, r)
+		if w.Code != http.StatusBadRequest || strings.Contains(w.Body.String(), "event:") {
+			t.Fatalf("%s: status %d, body %q", name, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ahs/internal/config"
+	"ahs/internal/context"
+	"go.uber.org/zap"
+)
+
+const (
+	// AuthModeNone 不校验，信任上游网关
+	AuthModeNone = "none"
+	// AuthModeAPIKey 静态 API Key
+	AuthModeAPIKey = "api_key"
+	// AuthModeHMAC 共享密钥签名租户头部
+	AuthModeHMAC = "hmac"
+
+	defaultMaxClockSkew = 5 * time.Minute
+)
+
+// SignTenantHeaders 计算 hmac 模式下的签名
+// 签名串：{user_id}\n{archive_id}\n{timestamp}，HMAC-SHA256 后十六进制小写编码
+func SignTenantHeaders(secret, userID, archiveID, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(userID + "\n" + archiveID + "\n" + timestamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Auth 认证中间件
+// - api_key：从 X-API-Key 或 Authorization: Bearer 读取 Key，并校验 X-User-ID 是否在该 Key 的允许列表中
+// - hmac：校验 X-Signature 是否为 X-User-ID / X-Archive-ID / X-Timestamp 的签名，且时间戳在允许偏差内
+// 除 users 为 "*" 的 Key 外，非排除路径必须携带完整租户头部：缺少头部时处理器会回退到请求体中的租户，
+// 而请求体租户未经授权校验
+// 校验失败时返回与租户中间件一致的 APIError JSON。需放在 Tenant 中间件之前
+func Auth(cfg config.AuthConfig, logger *zap.Logger) Middleware {
+	mode := strings.ToLower(strings.TrimSpace(cfg.Mode))
+	if mode == "" || mode == AuthModeNone {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	var excludePatterns []*regexp.Regexp
+	for _, pattern := range cfg.ExcludePaths {
+		if compiled, err := regexp.Compile(pattern); err == nil {
+			excludePatterns = append(excludePatterns, compiled)
+		} else if logger != nil {
+			logger.Warn("认证中间件：无效的路径排除模式",
+				zap.String("pattern", pattern),
+				zap.Error(err),
+			)
+		}
+	}
+
+	skew := cfg.MaxClockSkew
+	if skew <= 0 {
+		skew = defaultMaxClockSkew
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// 预检请求与排除路径不校验
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, pattern := range excludePatterns {
+				if pattern.MatchString(r.URL.Path) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			userID := strings.TrimSpace(r.Header.Get("X-User-ID"))
+			archiveID := strings.TrimSpace(r.Header.Get("X-Archive-ID"))
+
+			var (
+				info   context.AuthInfo
+				status int
+				code   string
+				msg    string
+			)
+			switch mode {
+			case AuthModeAPIKey:
+				info, status, code, msg = checkAPIKey(r, cfg.APIKeys, userID, archiveID)
+			case AuthModeHMAC:
+				info, status, code, msg = checkHMAC(r, cfg.HMACSecret, skew, userID, archiveID)
+			default:
+				status, code, msg = http.StatusInternalServerError, "AUTH_MISCONFIGURED", "认证模式配置错误"
+			}
+
+			if status != 0 {
+				if logger != nil {
+					logger.Warn("认证失败",
+						zap.String("mode", mode),
+						zap.String("code", code),
+						zap.String("method", r.Method),
+						zap.String("path", r.URL.Path),
+						zap.String("remote_addr", r.RemoteAddr),
+						zap.String("user_id", userID),
+					)
+				}
+				if status == http.StatusUnauthorized {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="ahs"`)
+				}
+				writeAPIError(w, status, code, msg, map[string]string{"auth_mode": mode}, logger)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithAuth(r.Context(), info)))
+		})
+	}
+}
+
+// extractAPIKey 从 X-API-Key 或 Authorization: Bearer 读取 Key
+func extractAPIKey(r *http.Request) string {
+	if k := strings.TrimSpace(r.Header.Get("X-API-Key")); k != "" {
+		return k
+	}
+	authz := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(authz) > 7 && strings.EqualFold(authz[:7], "Bearer ") {
+		return strings.TrimSpace(authz[7:])
+	}
+	return ""
+}
+
+// checkAPIKey 校验 API Key 及其用户授权；status 为 0 表示通过
+func checkAPIKey(r *http.Request, keys []config.APIKeyConfig, userID, archiveID string) (context.AuthInfo, int, string, string) {
+	presented := extractAPIKey(r)
+	if presented == "" {
+		return context.AuthInfo{}, http.StatusUnauthorized, "MISSING_API_KEY", "缺少 API Key"
+	}
+
+	var matched *config.APIKeyConfig
+	for i := range keys {
+		if keys[i].Key != "" && subtle.ConstantTimeCompare([]byte(keys[i].Key), []byte(presented)) == 1 {
+			matched = &keys[i]
+			break
+		}
+	}
+	if matched == nil {
+		return context.AuthInfo{}, http.StatusUnauthorized, "INVALID_API_KEY", "API Key 无效"
+	}
+
+	// 受限 Key 必须通过头部声明租户，否则请求体中的任意 user_id 都能绕过允许列表
+	if (userID == "" || archiveID == "") && !userAllowed(matched.Users, "*") {
+		return context.AuthInfo{}, http.StatusBadRequest, "MISSING_TENANT_HEADERS", "该 API Key 需携带租户头部 X-User-ID / X-Archive-ID"
+	}
+	if userID != "" && !userAllowed(matched.Users, userID) {
+		return context.AuthInfo{}, http.StatusForbidden, "TENANT_NOT_ALLOWED", "该 API Key 无权访问此用户"
+	}
+
+	name := matched.Name
+	if name == "" {
+		name = "api_key"
+	}
+	return context.AuthInfo{Method: AuthModeAPIKey, Subject: name}, 0, "", ""
+}
+
+func userAllowed(users []string, userID string) bool {
+	for _, u := range users {
+		if u == "*" || u == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHMAC 校验租户头部签名；status 为 0 表示通过
+func checkHMAC(r *http.Request, secret string, skew time.Duration, userID, archiveID string) (context.AuthInfo, int, string, string) {
+	if secret == "" {
+		return context.AuthInfo{}, http.StatusInternalServerError, "AUTH_MISCONFIGURED", "认证模式配置错误"
+	}
+
+	// 签名只覆盖头部租户，缺少头部时请求体中的租户未经签名
+	if userID == "" || archiveID == "" {
+		return context.AuthInfo{}, http.StatusBadRequest, "MISSING_TENANT_HEADERS", "缺少签名覆盖的租户头部 X-User-ID / X-Archive-ID"
+	}
+
+	ts := strings.TrimSpace(r.Header.Get("X-Timestamp"))
+	sig := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Signature")))
+	if ts == "" || sig == "" {
+		return context.AuthInfo{}, http.StatusUnauthorized, "MISSING_SIGNATURE", "缺少签名头部 X-Timestamp / X-Signature"
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return context.AuthInfo{}, http.StatusUnauthorized, "INVALID_TIMESTAMP", "X-Timestamp 必须为 Unix 秒"
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > skew || d < -skew {
+		return context.AuthInfo{}, http.StatusUnauthorized, "SIGNATURE_EXPIRED", "签名已过期或时间偏差过大"
+	}
+
+	want := SignTenantHeaders(secret, userID, archiveID, ts)
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return context.AuthInfo{}, http.StatusUnauthorized, "INVALID_SIGNATURE", "签名校验失败"
+	}
+	return context.AuthInfo{Method: AuthModeHMAC, Subject: userID}, 0, "", ""
+}
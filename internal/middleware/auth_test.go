@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"ahs/internal/config"
+	"ahs/internal/context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func authProbe(t *testing.T, mw Middleware) (http.Handler, *context.AuthInfo) {
+	t.Helper()
+	var got context.AuthInfo
+	return mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = context.GetAuth(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})), &got
+}
+
+func TestAuth_APIKey(t *testing.T) {
+	cfg := config.AuthConfig{
+		Mode: AuthModeAPIKey,
+		APIKeys: []config.APIKeyConfig{
+			{Name: "frontend", Key: "k-front", Users: []string{"u1"}},
+			{Name: "ops", Key: "k-ops", Users: []string{"*"}},
+		},
+		ExcludePaths: []string{`^/health$`},
+	}
+	h, got := authProbe(t, Auth(cfg, nil))
+
+	cases := []struct {
+		name   string
+		path   string
+		header map[string]string
+		status int
+		code   string
+	}{
+		{"缺少Key", "/api/execute", map[string]string{"X-User-ID": "u1"}, http.StatusUnauthorized, "MISSING_API_KEY"},
+		{"无效Key", "/api/execute", map[string]string{"X-API-Key": "nope", "X-User-ID": "u1"}, http.StatusUnauthorized, "INVALID_API_KEY"},
+		{"越权用户", "/api/execute", map[string]string{"X-API-Key": "k-front", "X-User-ID": "u2", "X-Archive-ID": "a1"}, http.StatusForbidden, "TENANT_NOT_ALLOWED"},
+		{"允许用户", "/api/execute", map[string]string{"X-API-Key": "k-front", "X-User-ID": "u1", "X-Archive-ID": "a1"}, http.StatusOK, ""},
+		// 受限 Key 缺少租户头部时拒绝，避免回退到请求体中的 user_id
+		{"受限Key缺少头部", "/api/execute", map[string]string{"X-API-Key": "k-front"}, http.StatusBadRequest, "MISSING_TENANT_HEADERS"},
+		{"受限Key缺少存档", "/api/execute", map[string]string{"X-API-Key": "k-front", "X-User-ID": "u1"}, http.StatusBadRequest, "MISSING_TENANT_HEADERS"},
+		{"Bearer通配", "/api/execute", map[string]string{"Authorization": "Bearer k-ops", "X-User-ID": "anyone"}, http.StatusOK, ""},
+		{"通配Key无头部", "/api/workflows", map[string]string{"X-API-Key": "k-ops"}, http.StatusOK, ""},
+		{"排除路径", "/health", nil, http.StatusOK, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, c.path, nil)
+			for k, v := range c.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, c.status, rec.Code)
+			if c.code != "" {
+				assert.Contains(t, rec.Body.String(), c.code)
+			}
+		})
+	}
+
+	// 通过时注入认证信息
+	req := httptest.NewRequest(http.MethodPost, "/api/execute", nil)
+	req.Header.Set("X-API-Key", "k-front")
+	req.Header.Set("X-User-ID", "u1")
+	req.Header.Set("X-Archive-ID", "a1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, context.AuthInfo{Method: AuthModeAPIKey, Subject: "frontend"}, *got)
+}
+
+func TestAuth_HMAC(t *testing.T) {
+	const secret = "s3cret"
+	cfg := config.AuthConfig{Mode: AuthModeHMAC, HMACSecret: secret, MaxClockSkew: time.Minute}
+	h, _ := authProbe(t, Auth(cfg, nil))
+
+	send := func(user, archive string, ts time.Time, sig string) *httptest.ResponseRecorder {
+		tsStr := strconv.FormatInt(ts.Unix(), 10)
+		if sig == "" {
+			sig = SignTenantHeaders(secret, user, archive, tsStr)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/execute", nil)
+		req.Header.Set("X-User-ID", user)
+		req.Header.Set("X-Archive-ID", archive)
+		req.Header.Set("X-Timestamp", tsStr)
+		req.Header.Set("X-Signature", sig)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, send("u1", "a1", time.Now(), "").Code)
+
+	// 篡改租户头部：使用 u1 的签名冒充 u2
+	tsStr := strconv.FormatInt(time.Now().Unix(), 10)
+	forged := SignTenantHeaders(secret, "u1", "a1", tsStr)
+	rec := send("u2", "a1", time.Now(), forged)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "INVALID_SIGNATURE")
+
+	// 签名不覆盖请求体，缺少租户头部时拒绝
+	rec = send("", "", time.Now(), "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "MISSING_TENANT_HEADERS")
+
+	// 过期时间戳
+	rec = send("u1", "a1", time.Now().Add(-2*time.Minute), "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "SIGNATURE_EXPIRED")
+}
+
+func TestAuth_NoneIsPassthrough(t *testing.T) {
+	h, _ := authProbe(t, Auth(config.AuthConfig{Mode: AuthModeNone}, nil))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/execute", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
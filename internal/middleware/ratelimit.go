@@ -0,0 +1,266 @@
+package middleware
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ahs/internal/config"
+	"ahs/internal/context"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// maxWorkflowPeekBytes 按工作流限流时最多读取的请求体字节数
+const maxWorkflowPeekBytes = 1 << 20
+
+// limiterEntry LRU 中的限流器条目
+type limiterEntry struct {
+	key      string
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// limiterStore 按键管理限流器，LRU 淘汰 + 空闲过期
+type limiterStore struct {
+	mu      sync.Mutex
+	limit   rate.Limit
+	burst   int
+	maxKeys int
+	idleTTL time.Duration
+
+	items map[string]*list.Element
+	order *list.List // 前端为最近使用
+	now   func() time.Time
+}
+
+func newLimiterStore(qps, burst, maxKeys int, idleTTL time.Duration) *limiterStore {
+	if burst <= 0 {
+		burst = qps
+	}
+	return &limiterStore{
+		limit:   rate.Limit(qps),
+		burst:   burst,
+		maxKeys: maxKeys,
+		idleTTL: idleTTL,
+		items:   make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// get 返回键对应的限流器，不存在则创建
+func (s *limiterStore) get(key string) *rate.Limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if el, ok := s.items[key]; ok {
+		e := el.Value.(*limiterEntry)
+		e.lastSeen = now
+		s.order.MoveToFront(el)
+		return e.limiter
+	}
+
+	s.evict(now)
+	e := &limiterEntry{key: key, limiter: rate.NewLimiter(s.limit, s.burst), lastSeen: now}
+	s.items[key] = s.order.PushFront(e)
+	return e.limiter
+}
+
+// evict 淘汰空闲过期的条目，并在超出容量时淘汰最久未使用的条目（调用方持有锁）
+func (s *limiterStore) evict(now time.Time) {
+	for el := s.order.Back(); el != nil; {
+		e := el.Value.(*limiterEntry)
+		overCap := s.maxKeys > 0 && s.order.Len() >= s.maxKeys
+		idle := s.idleTTL > 0 && now.Sub(e.lastSeen) > s.idleTTL
+		if !overCap && !idle {
+			break
+		}
+		prev := el.Prev()
+		s.order.Remove(el)
+		delete(s.items, e.key)
+		el = prev
+	}
+}
+
+// len 当前限流器数量
+func (s *limiterStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+// KeyedRateLimit 按键限流中间件
+// - 已注入租户的请求按 X-User-ID 限流；排除路径等无租户请求按客户端 IP 限流
+// - PerWorkflow 时在键中追加请求体中的 workflow 名称
+// - 响应携带 RateLimit-Limit / RateLimit-Remaining / RateLimit-Reset，超限时附带 Retry-After
+// 需放在 Tenant 中间件之后
+func KeyedRateLimit(cfg config.RateLimitConfig, logger *zap.Logger) Middleware {
+	store := newLimiterStore(cfg.QPS, cfg.Burst, cfg.MaxKeys, cfg.IdleTTL)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := rateLimitKey(r, cfg)
+			lim := store.get(key)
+
+			now := time.Now()
+			res := lim.ReserveN(now, 1)
+			delay := res.DelayFrom(now)
+			setRateLimitHeaders(w, lim, now)
+
+			if delay > 0 {
+				res.CancelAt(now)
+				retry := int(math.Ceil(delay.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(retry))
+				if logger != nil {
+					logger.Warn("请求被限流",
+						zap.String("key", key),
+						zap.String("path", r.URL.Path),
+						zap.Duration("retry_after", delay),
+					)
+				}
+				writeAPIError(w, http.StatusTooManyRequests, "RATE_LIMITED", "请求过于频繁，请稍后再试",
+					map[string]string{"retry_after": strconv.Itoa(retry)}, logger)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setRateLimitHeaders 写入 RateLimit-* 标准头部
+func setRateLimitHeaders(w http.ResponseWriter, lim *rate.Limiter, now time.Time) {
+	burst := lim.Burst()
+	tokens := lim.TokensAt(now)
+	remaining := int(math.Floor(tokens))
+	if remaining < 0 {
+		remaining = 0
+	}
+	// Reset：令牌桶完全恢复所需秒数
+	reset := 0
+	if l := float64(lim.Limit()); l > 0 && tokens < float64(burst) {
+		reset = int(math.Ceil((float64(burst) - tokens) / l))
+	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(burst))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(reset))
+}
+
+// rateLimitKey 计算限流键
+func rateLimitKey(r *http.Request, cfg config.RateLimitConfig) string {
+	var key string
+	if t, ok := context.GetTenant(r.Context()); ok && t.UserID != "" {
+		key = "user:" + t.UserID
+	} else {
+		key = "ip:" + clientIP(r, cfg.TrustForwardedFor)
+	}
+	if cfg.PerWorkflow {
+		if wf := peekWorkflow(r); wf != "" {
+			key += "|workflow:" + wf
+		}
+	}
+	return key
+}
+
+// clientIP 获取客户端 IP；trustForwarded 时优先使用 X-Forwarded-For 的第一个地址
+func clientIP(r *http.Request, trustForwarded bool) string {
+	if trustForwarded {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			first := strings.TrimSpace(strings.Split(xff, ",")[0])
+			if first != "" {
+				return first
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// peekWorkflow 读取请求体中的 workflow 字段，并恢复请求体供后续处理器使用
+func peekWorkflow(r *http.Request) string {
+	if r.Body == nil || r.Method != http.MethodPost {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWorkflowPeekBytes))
+	if err != nil {
+		return ""
+	}
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+
+	var payload struct {
+		Workflow string `json:"workflow"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ""
+	}
+	return payload.Workflow
+}
+
+// StreamConcurrency 限制每个租户同时进行的流式运行数量
+// 仅作用于匹配 paths 的请求；超过上限返回 429
+func StreamConcurrency(maxPerTenant int, paths []string, logger *zap.Logger) Middleware {
+	var (
+		mu     sync.Mutex
+		active = make(map[string]int)
+	)
+	match := func(p string) bool {
+		for _, x := range paths {
+			if p == x {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maxPerTenant <= 0 || !match(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := "ip:" + clientIP(r, false)
+			if t, ok := context.GetTenant(r.Context()); ok && t.UserID != "" {
+				key = "user:" + t.UserID
+			}
+
+			mu.Lock()
+			if active[key] >= maxPerTenant {
+				mu.Unlock()
+				if logger != nil {
+					logger.Warn("流式运行并发超限",
+						zap.String("key", key),
+						zap.Int("limit", maxPerTenant),
+					)
+				}
+				w.Header().Set("Retry-After", "1")
+				writeAPIError(w, http.StatusTooManyRequests, "TOO_MANY_STREAMS", "同时进行的流式运行过多，请稍后再试",
+					map[string]string{"max_concurrent_streams": strconv.Itoa(maxPerTenant)}, logger)
+				return
+			}
+			active[key]++
+			mu.Unlock()
+
+			defer func() {
+				mu.Lock()
+				if active[key]--; active[key] <= 0 {
+					delete(active, key)
+				}
+				mu.Unlock()
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ahs/internal/config"
+	"ahs/internal/context"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyedRateLimit_PerTenantIsolationAndHeaders(t *testing.T) {
+	cfg := config.RateLimitConfig{Enabled: true, QPS: 1, Burst: 2, PerTenant: true, MaxKeys: 100}
+	h := KeyedRateLimit(cfg, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	do := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/execute", nil)
+		req = req.WithContext(context.WithTenant(req.Context(), user, "a1"))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// noisy 用户耗尽自己的突发额度
+	assert.Equal(t, http.StatusOK, do("noisy").Code)
+	assert.Equal(t, http.StatusOK, do("noisy").Code)
+	rec := do("noisy")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "RATE_LIMITED")
+
+	// 其他用户不受影响
+	rec = do("quiet")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+}
+
+func TestKeyedRateLimit_PerWorkflowKeepsBody(t *testing.T) {
+	cfg := config.RateLimitConfig{Enabled: true, QPS: 1, Burst: 1, PerTenant: true, PerWorkflow: true}
+	var got string
+	h := KeyedRateLimit(cfg, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	}))
+
+	body := `{"workflow":"echo","input":"hi"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/execute", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, body, got, "后续处理器应读到完整请求体")
+
+	// 同一 IP 的另一个工作流使用独立的限流器
+	req = httptest.NewRequest(http.MethodPost, "/api/execute", strings.NewReader(`{"workflow":"time"}`))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestLimiterStore_LRUAndIdleEviction(t *testing.T) {
+	s := newLimiterStore(10, 10, 2, time.Minute)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	a := s.get("a")
+	s.get("b")
+	s.get("a") // a 变为最近使用
+	s.get("c") // 超出容量，淘汰 b
+	require.Equal(t, 2, s.len())
+	assert.Same(t, a, s.get("a"))
+
+	// 空闲超时后全部回收
+	now = now.Add(2 * time.Minute)
+	s.get("d")
+	assert.Equal(t, 1, s.len())
+}
+
+func TestStreamConcurrency_PerTenantCap(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	h := StreamConcurrency(1, []string{"/api/stream"}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	newReq := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/stream", nil)
+		return req.WithContext(context.WithTenant(req.Context(), "u1", "a1"))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), newReq())
+		close(done)
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, newReq())
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Contains(t, rec.Body.String(), "TOO_MANY_STREAMS")
+
+	close(release)
+	<-done
+
+	// 第一个流结束后释放名额
+	release = make(chan struct{})
+	close(release)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, newReq())
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -21,6 +21,23 @@ type APIError struct {
 	Timestamp string            `json:"timestamp"`
 }
 
+// writeAPIError 以 APIError JSON 格式返回错误响应
+func writeAPIError(w http.ResponseWriter, status int, code, message string, details map[string]string, logger *zap.Logger) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	apiErr := APIError{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+
+	if err := json.NewEncoder(w).Encode(apiErr); err != nil && logger != nil {
+		logger.Error("中间件：编码错误响应失败", zap.String("code", code), zap.Error(err))
+	}
+}
+
 // Tenant 租户验证中间件
 // 从 HTTP 头部提取租户信息并注入到 context 中
 // 支持可选的租户验证和路径排除
@@ -66,23 +83,39 @@ func Tenant(cfg config.TenantConfig, logger *zap.Logger) Middleware {
 				}
 
 				// 返回错误响应
-				w.Header().Set("Content-Type", "application/json; charset=utf-8")
-				w.WriteHeader(http.StatusBadRequest)
-				
-				apiErr := APIError{
-					Code:      "MISSING_TENANT_HEADERS",
-					Message:   "缺少必需的租户头部信息",
-					Details: map[string]string{
+				writeAPIError(w, http.StatusBadRequest, "MISSING_TENANT_HEADERS", "缺少必需的租户头部信息",
+					map[string]string{
 						"required_headers": "X-User-ID, X-Archive-ID",
 						"missing_headers":  getMissingHeaders(userID, archiveID),
-					},
-					Timestamp: time.Now().Format(time.RFC3339),
+					}, logger)
+				return
+			}
+
+			// 校验租户 ID 格式（防止异常 ID 映射到存储路径）
+			for _, f := range []struct{ name, header, value string }{
+				{"user_id", "X-User-ID", userID},
+				{"archive_id", "X-Archive-ID", archiveID},
+			} {
+				if f.value == "" {
+					continue
 				}
-				
-				if err := json.NewEncoder(w).Encode(apiErr); err != nil && logger != nil {
-					logger.Error("租户中间件：编码错误响应失败", zap.Error(err))
+				if err := context.ValidateTenantID(f.value); err != nil {
+					if logger != nil {
+						logger.Warn("租户验证失败：租户ID不合法",
+							zap.String("path", r.URL.Path),
+							zap.String("remote_addr", r.RemoteAddr),
+							zap.String("header", f.header),
+							zap.Error(err),
+						)
+					}
+					writeAPIError(w, http.StatusBadRequest, "INVALID_TENANT_ID", "租户ID不合法",
+						map[string]string{
+							"header": f.header,
+							"reason": err.Error(),
+							"rule":   "1-64 个 ASCII 字母/数字/_-.@，以字母或数字开头，不含 ..",
+						}, logger)
+					return
 				}
-				return
 			}
 
 			// 注入租户信息到 context
@@ -122,7 +155,7 @@ func getMissingHeaders(userID, archiveID string) string {
 func NewDefaultTenantConfig() config.TenantConfig {
 	return config.TenantConfig{
 		Required:     true,
-		ExcludePaths: []string{`^/health$`, `^/api/workflows$`}, // 健康检查和工作流列表不需要租户
+		ExcludePaths: []string{`^/health$`, `^/api/workflows$`, `^/api/archives$`}, // 健康检查、工作流列表与归档列表/创建不需要完整租户
 	}
 }
 
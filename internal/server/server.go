@@ -11,6 +11,8 @@ import (
 	"ahs/internal/handler"
 	"ahs/internal/middleware"
 	"ahs/internal/service"
+	"ahs/internal/service/rag"
+	"ahs/internal/service/usage"
 
 	"go.uber.org/zap"
 	"golang.org/x/time/rate"
@@ -21,6 +23,7 @@ type Server struct {
 	config  *config.Config
 	logger  *zap.Logger
 	handler *handler.Handler
+	archive *handler.ArchiveHandler
 	server  *http.Server
 }
 
@@ -29,6 +32,16 @@ func New(cfg *config.Config, logger *zap.Logger, workflowManager service.Workflo
 	// 创建工作流服务
 	workflowService := service.NewWorkflowService(workflowManager)
 
+	// 用量统计与额度
+	// 初始化失败时拒绝启动，不在额度失效的情况下对外服务
+	tracker, err := usage.NewTracker(cfg.Usage)
+	if err != nil {
+		logger.Fatal("用量统计初始化失败", zap.Error(err))
+	}
+	if tracker != nil {
+		workflowService.SetUsageTracker(tracker)
+	}
+
 	// 创建处理器
 	h := handler.New(workflowService, logger)
 
@@ -36,6 +49,7 @@ func New(cfg *config.Config, logger *zap.Logger, workflowManager service.Workflo
 		config:  cfg,
 		logger:  logger,
 		handler: h,
+		archive: handler.NewArchiveHandler(rag.Default(), tracker, logger),
 	}
 }
 
@@ -84,6 +98,10 @@ func (s *Server) registerRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/workflows/", s.handler.WorkflowInfo)
 	mux.HandleFunc("/api/execute", s.handler.Execute)
 	mux.HandleFunc("/api/stream", s.handler.ExecuteStream)
+
+	// 归档生命周期
+	mux.HandleFunc("/api/archives", s.archive.Archives)
+	mux.HandleFunc("/api/archives/", s.archive.Archive)
 }
 
 // createMiddlewareChain 创建中间件链
@@ -99,13 +117,25 @@ func (s *Server) createMiddlewareChain() middleware.Chain {
 	// CORS中间件 - 使用配置化的安全CORS策略
 	middlewares = append(middlewares, middleware.ConfigurableCORS(s.config.CORS, s.logger))
 
+	// 认证中间件 - 校验租户头部的来源，必须在租户中间件之前
+	middlewares = append(middlewares, middleware.Auth(s.config.Auth, s.logger))
+
 	// 租户中间件 - 在业务逻辑之前验证租户信息
 	middlewares = append(middlewares, middleware.Tenant(s.config.Tenant, s.logger))
 
-	// 限流中间件
+	// 限流中间件 - 按租户限流依赖租户中间件注入的 context
 	if s.config.RateLimit.Enabled {
-		limiter := rate.NewLimiter(rate.Limit(s.config.RateLimit.QPS), s.config.RateLimit.Burst)
-		middlewares = append(middlewares, middleware.RateLimit(limiter))
+		if s.config.RateLimit.PerTenant {
+			middlewares = append(middlewares, middleware.KeyedRateLimit(s.config.RateLimit, s.logger))
+		} else {
+			limiter := rate.NewLimiter(rate.Limit(s.config.RateLimit.QPS), s.config.RateLimit.Burst)
+			middlewares = append(middlewares, middleware.RateLimit(limiter))
+		}
+	}
+
+	// 流式运行并发上限
+	if s.config.RateLimit.StreamConcurrency > 0 {
+		middlewares = append(middlewares, middleware.StreamConcurrency(s.config.RateLimit.StreamConcurrency, []string{"/api/stream"}, s.logger))
 	}
 
 	// 内容类型中间件
@@ -0,0 +1,451 @@
+package rag
+
+import (
+    "archive/tar"
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "sort"
+    "time"
+)
+
+// 归档（archive）管理：一个 archive_id 对应一个故事世界
+// - 列表：由本地存储推导（内存 ∪ 磁盘）
+// - 创建/分叉/快照导入导出：作用于全部本地记忆存储，连同记忆的历史版本（version.go）与本地三元组库
+// - 清除：同时清除向量库与本地三元组库
+
+var (
+    ErrArchiveExists   = errors.New("归档已存在")
+    ErrArchiveNotFound = errors.New("归档不存在")
+)
+
+// SnapshotFormatVersion 快照格式版本
+// 2：增加 versions.jsonl（历史版本）与 triples.jsonl（三元组）；版本 1 的快照仍可导入
+const SnapshotFormatVersion = 2
+
+const (
+    snapshotManifestName = "manifest.json"
+    snapshotMemoryName   = "memory.jsonl"
+    snapshotVersionsName = "versions.jsonl"
+    snapshotTriplesName  = "triples.jsonl"
+)
+
+// SnapshotManifest 快照清单
+type SnapshotManifest struct {
+    FormatVersion int       `json:"format_version"`
+    Namespace     string    `json:"namespace"`
+    Tenant        Tenant    `json:"tenant"`
+    Items         int       `json:"items"`
+    Versions      int       `json:"versions,omitempty"` // 历史版本数（不含当前版本）
+    Triples       int       `json:"triples,omitempty"`
+    ExportedAt    time.Time `json:"exported_at"`
+}
+
+// archiveData 归档的完整内容：当前记忆、各条目的历史版本（不含当前版本，按版本从旧到新）与三元组
+type archiveData struct {
+    items   []MemoryItem
+    history map[string][]MemoryItem
+    triples []Triple
+}
+
+func (d archiveData) versions() int {
+    n := 0
+    for _, vs := range d.history {
+        n += len(vs)
+    }
+    return n
+}
+
+// archiveStores 返回实现了 ArchiveStore 的本地存储
+func (m *Manager) archiveStores() []ArchiveStore {
+    var res []ArchiveStore
+    for _, st := range []Store{m.mem, m.disk} {
+        if st == nil {
+            continue
+        }
+        if as, ok := st.(ArchiveStore); ok {
+            res = append(res, as)
+        }
+    }
+    return res
+}
+
+// primaryArchiveStore 导出数据时的权威来源：优先磁盘，其次内存
+func (m *Manager) primaryArchiveStore() ArchiveStore {
+    for _, st := range []Store{m.disk, m.mem} {
+        if st == nil {
+            continue
+        }
+        if as, ok := st.(ArchiveStore); ok {
+            return as
+        }
+    }
+    return nil
+}
+
+// ListArchives 列出用户下的全部归档
+func (m *Manager) ListArchives(ctx context.Context, userID string) ([]string, error) {
+    if userID == "" {
+        return nil, errors.New("user_id 不能为空")
+    }
+    seen := make(map[string]bool)
+    for _, st := range m.archiveStores() {
+        ids, err := st.ListArchives(ctx, userID)
+        if err != nil {
+            return nil, err
+        }
+        for _, id := range ids {
+            seen[id] = true
+        }
+    }
+    res := make([]string, 0, len(seen))
+    for id := range seen {
+        res = append(res, id)
+    }
+    sort.Strings(res)
+    return res, nil
+}
+
+// ArchiveExists 判断归档是否存在
+func (m *Manager) ArchiveExists(ctx context.Context, t Tenant) (bool, error) {
+    ids, err := m.ListArchives(ctx, t.UserID)
+    if err != nil {
+        return false, err
+    }
+    for _, id := range ids {
+        if id == t.ArchiveID {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// CreateArchive 创建空归档；已存在时返回 ErrArchiveExists
+func (m *Manager) CreateArchive(ctx context.Context, t Tenant) error {
+    if err := m.ensureAbsent(ctx, t); err != nil {
+        return err
+    }
+    for _, st := range m.archiveStores() {
+        if err := st.Create(ctx, t); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// PurgeArchive 删除归档在全部本地存储中的数据
+// 注意：异步队列中尚未落盘的写入可能在清除后到达，调用方应避免并发写入
+func (m *Manager) PurgeArchive(ctx context.Context, t Tenant) error {
+    if t.UserID == "" || t.ArchiveID == "" {
+        return errors.New("tenant(user_id, archive_id) 不能为空")
+    }
+    var firstErr error
+    for _, st := range m.archiveStores() {
+        if err := st.Purge(ctx, t); err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+    if d, ok := m.vec.(vectorDeleter); ok {
+        if err := d.Purge(ctx, t); err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+    if ts, ok := m.Triples(); ok {
+        if err := ts.Purge(ctx, t); err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+    m.access.purge(t)
+    return firstErr
+}
+
+// ExportItems 导出归档内全部记忆（按写入顺序）
+func (m *Manager) ExportItems(ctx context.Context, t Tenant) ([]MemoryItem, error) {
+    st := m.primaryArchiveStore()
+    if st == nil {
+        return nil, errors.New("没有可导出的本地存储")
+    }
+    return st.Export(ctx, t)
+}
+
+// ForkArchive 将 src 归档的全部记忆复制到同一用户下的新归档 dstArchiveID
+// 目标已存在时返回 ErrArchiveExists；返回复制的条目数
+func (m *Manager) ForkArchive(ctx context.Context, src Tenant, dstArchiveID string) (int, error) {
+    ok, err := m.ArchiveExists(ctx, src)
+    if err != nil {
+        return 0, err
+    }
+    if !ok {
+        return 0, ErrArchiveNotFound
+    }
+    dst := Tenant{UserID: src.UserID, ArchiveID: dstArchiveID}
+    if err := m.ensureAbsent(ctx, dst); err != nil {
+        return 0, err
+    }
+    data, err := m.exportData(ctx, src)
+    if err != nil {
+        return 0, err
+    }
+    return m.importData(ctx, dst, data)
+}
+
+// exportData 读取归档的当前记忆、历史版本与三元组
+func (m *Manager) exportData(ctx context.Context, t Tenant) (archiveData, error) {
+    items, err := m.ExportItems(ctx, t)
+    if err != nil {
+        return archiveData{}, err
+    }
+    data := archiveData{items: items, history: make(map[string][]MemoryItem)}
+    for _, it := range items {
+        if itemVersion(it) <= 1 {
+            continue
+        }
+        vs, err := m.Versions(ctx, t, it.ID)
+        if err != nil {
+            return archiveData{}, err
+        }
+        if len(vs) > 1 {
+            data.history[it.ID] = vs[:len(vs)-1]
+        }
+    }
+    if ts, ok := m.Triples(); ok {
+        if data.triples, err = ts.MatchTriples(ctx, t, TriplePattern{AllTime: true}); err != nil {
+            return archiveData{}, err
+        }
+        // 按写入顺序导入，保持创建先后
+        sort.SliceStable(data.triples, func(i, j int) bool { return data.triples[i].CreatedAt.Before(data.triples[j].CreatedAt) })
+    }
+    return data, nil
+}
+
+// ExportArchive 将归档导出为 tar.gz 快照，包含 manifest.json、memory.jsonl（当前版本）、
+// versions.jsonl（历史版本）与 triples.jsonl（三元组）
+func (m *Manager) ExportArchive(ctx context.Context, t Tenant, w io.Writer) error {
+    data, err := m.exportData(ctx, t)
+    if err != nil {
+        return err
+    }
+
+    var memBuf, verBuf, triBuf bytes.Buffer
+    for i := range data.items {
+        if err := json.NewEncoder(&memBuf).Encode(&data.items[i]); err != nil {
+            return fmt.Errorf("encode json: %w", err)
+        }
+        for _, v := range data.history[data.items[i].ID] {
+            if err := json.NewEncoder(&verBuf).Encode(&v); err != nil {
+                return fmt.Errorf("encode json: %w", err)
+            }
+        }
+    }
+    for i := range data.triples {
+        if err := json.NewEncoder(&triBuf).Encode(&data.triples[i]); err != nil {
+            return fmt.Errorf("encode json: %w", err)
+        }
+    }
+    manifest, err := json.MarshalIndent(SnapshotManifest{
+        FormatVersion: SnapshotFormatVersion,
+        Namespace:     m.opts.Namespace,
+        Tenant:        t,
+        Items:         len(data.items),
+        Versions:      data.versions(),
+        Triples:       len(data.triples),
+        ExportedAt:    time.Now(),
+    }, "", "  ")
+    if err != nil {
+        return fmt.Errorf("encode manifest: %w", err)
+    }
+
+    gz := gzip.NewWriter(w)
+    tw := tar.NewWriter(gz)
+    for _, f := range []struct {
+        name string
+        data []byte
+    }{
+        {snapshotManifestName, manifest},
+        {snapshotMemoryName, memBuf.Bytes()},
+        {snapshotVersionsName, verBuf.Bytes()},
+        {snapshotTriplesName, triBuf.Bytes()},
+    } {
+        hdr := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.data)), ModTime: time.Now()}
+        if err := tw.WriteHeader(hdr); err != nil {
+            return fmt.Errorf("write tar header: %w", err)
+        }
+        if _, err := tw.Write(f.data); err != nil {
+            return fmt.Errorf("write tar: %w", err)
+        }
+    }
+    if err := tw.Close(); err != nil {
+        return fmt.Errorf("close tar: %w", err)
+    }
+    return gz.Close()
+}
+
+// ImportArchive 从 tar.gz 快照导入到归档 t（记忆的租户改写为 t）
+// 目标已存在时返回 ErrArchiveExists；返回导入的条目数
+func (m *Manager) ImportArchive(ctx context.Context, t Tenant, r io.Reader) (int, error) {
+    if err := m.ensureAbsent(ctx, t); err != nil {
+        return 0, err
+    }
+
+    gz, err := gzip.NewReader(r)
+    if err != nil {
+        return 0, fmt.Errorf("open gzip: %w", err)
+    }
+    defer gz.Close()
+
+    var (
+        manifest *SnapshotManifest
+        data     = archiveData{history: make(map[string][]MemoryItem)}
+    )
+    tr := tar.NewReader(gz)
+    for {
+        hdr, err := tr.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return 0, fmt.Errorf("read tar: %w", err)
+        }
+        switch hdr.Name {
+        case snapshotManifestName:
+            var mf SnapshotManifest
+            if err := json.NewDecoder(tr).Decode(&mf); err != nil {
+                return 0, fmt.Errorf("decode manifest: %w", err)
+            }
+            manifest = &mf
+        case snapshotMemoryName:
+            err = scanJSONL(tr, func(b []byte) error {
+                var it MemoryItem
+                if err := json.Unmarshal(b, &it); err != nil {
+                    return fmt.Errorf("decode memory: %w", err)
+                }
+                data.items = append(data.items, it)
+                return nil
+            })
+        case snapshotVersionsName:
+            err = scanJSONL(tr, func(b []byte) error {
+                var it MemoryItem
+                if err := json.Unmarshal(b, &it); err != nil {
+                    return fmt.Errorf("decode version: %w", err)
+                }
+                data.history[it.ID] = append(data.history[it.ID], it)
+                return nil
+            })
+        case snapshotTriplesName:
+            err = scanJSONL(tr, func(b []byte) error {
+                var t Triple
+                if err := json.Unmarshal(b, &t); err != nil {
+                    return fmt.Errorf("decode triple: %w", err)
+                }
+                data.triples = append(data.triples, t)
+                return nil
+            })
+        }
+        if err != nil {
+            return 0, err
+        }
+    }
+    if manifest == nil {
+        return 0, errors.New("快照缺少 manifest.json")
+    }
+    if manifest.FormatVersion > SnapshotFormatVersion {
+        return 0, fmt.Errorf("不支持的快照版本: %d", manifest.FormatVersion)
+    }
+    return m.importData(ctx, t, data)
+}
+
+// scanJSONL 逐行解析 JSONL，跳过空行
+func scanJSONL(r io.Reader, fn func([]byte) error) error {
+    sc := bufio.NewScanner(r)
+    sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+    for sc.Scan() {
+        if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+            continue
+        }
+        if err := fn(sc.Bytes()); err != nil {
+            return err
+        }
+    }
+    if err := sc.Err(); err != nil {
+        return fmt.Errorf("scan jsonl: %w", err)
+    }
+    return nil
+}
+
+// ensureAbsent 目标归档必须不存在
+func (m *Manager) ensureAbsent(ctx context.Context, t Tenant) error {
+    if t.UserID == "" || t.ArchiveID == "" {
+        return errors.New("tenant(user_id, archive_id) 不能为空")
+    }
+    ok, err := m.ArchiveExists(ctx, t)
+    if err != nil {
+        return err
+    }
+    if ok {
+        return ErrArchiveExists
+    }
+    return nil
+}
+
+// importData 以同步方式写入归档 t（租户改写为 t）：记忆连同历史版本写入全部本地存储，
+// 当前版本写入向量后端，三元组写入本地三元组库（未启用时忽略）；返回导入的记忆条数
+func (m *Manager) importData(ctx context.Context, t Tenant, data archiveData) (int, error) {
+    for _, st := range m.archiveStores() {
+        if err := st.Create(ctx, t); err != nil {
+            return 0, err
+        }
+    }
+    for i := range data.items {
+        it := data.items[i]
+        versions := append(append([]MemoryItem(nil), data.history[it.ID]...), it)
+        if it.ID == "" {
+            it.ID = NewID()
+            versions = []MemoryItem{it}
+        }
+        if err := m.importVersions(ctx, t, versions); err != nil {
+            return i, err
+        }
+    }
+    if ts, ok := m.Triples(); ok {
+        for _, tr := range data.triples {
+            tr.Tenant = t
+            if _, err := ts.SaveTriple(ctx, tr); err != nil {
+                return len(data.items), err
+            }
+        }
+    }
+    return len(data.items), nil
+}
+
+// importVersions 写入一条记忆的全部版本（按版本从旧到新，最后一个为当前版本）：
+// 首个版本 Save，其后逐个 Update，由存储把旧版本转入历史
+func (m *Manager) importVersions(ctx context.Context, t Tenant, versions []MemoryItem) error {
+    for i := range versions {
+        versions[i].Tenant = t
+        versions[i].Score = 0
+        versions[i].ID = versions[len(versions)-1].ID
+    }
+    for _, st := range m.localStores() {
+        for i, v := range versions {
+            v.Vector, v.Sources = nil, nil
+            var err error
+            if i == 0 {
+                err = st.Save(ctx, v)
+            } else {
+                err = st.Update(ctx, v)
+            }
+            if err != nil {
+                return err
+            }
+        }
+    }
+    if m.hasVec {
+        return m.saveVector(ctx, versions[len(versions)-1])
+    }
+    return nil
+}
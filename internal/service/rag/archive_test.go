@@ -0,0 +1,120 @@
+package rag
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestManager_ArchiveLifecycle(t *testing.T) {
+    m := newTestManager(t, nil)
+    ctx := context.Background()
+    src := Tenant{UserID: "u1", ArchiveID: "world-a"}
+
+    if err := m.CreateArchive(ctx, src); err != nil { t.Fatalf("create: %v", err) }
+    if err := m.CreateArchive(ctx, src); !errors.Is(err, ErrArchiveExists) {
+        t.Fatalf("expect ErrArchiveExists, got %v", err)
+    }
+    for _, c := range []string{"c1", "c2", "c3"} {
+        it := MemoryItem{Tenant: src, Content: c, CreatedAt: time.Now()}
+        if err := m.Save(ctx, it, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    }
+
+    // 分叉：复制全部记忆，之后两条故事线互不影响
+    n, err := m.ForkArchive(ctx, src, "world-b")
+    if err != nil || n != 3 { t.Fatalf("fork: n=%d err=%v", n, err) }
+    if _, err := m.ForkArchive(ctx, src, "world-b"); !errors.Is(err, ErrArchiveExists) {
+        t.Fatalf("fork onto existing archive should fail, got %v", err)
+    }
+    dst := Tenant{UserID: "u1", ArchiveID: "world-b"}
+    _ = m.Save(ctx, MemoryItem{Tenant: dst, Content: "only-b", CreatedAt: time.Now()}, SaveOptions{})
+
+    items, err := m.ExportItems(ctx, src)
+    if err != nil || len(items) != 3 { t.Fatalf("export src: %d %v", len(items), err) }
+    items, _ = m.ExportItems(ctx, dst)
+    if len(items) != 4 || items[0].Content != "c1" || items[0].Tenant != dst {
+        t.Fatalf("unexpected forked items: %+v", items)
+    }
+
+    ids, err := m.ListArchives(ctx, "u1")
+    if err != nil || len(ids) != 2 || ids[0] != "world-a" || ids[1] != "world-b" {
+        t.Fatalf("list: %v %v", ids, err)
+    }
+
+    // 清除后从列表与查询中消失
+    if err := m.PurgeArchive(ctx, src); err != nil { t.Fatalf("purge: %v", err) }
+    ids, _ = m.ListArchives(ctx, "u1")
+    if len(ids) != 1 || ids[0] != "world-b" { t.Fatalf("list after purge: %v", ids) }
+    qr, _ := m.Query(ctx, QueryRequest{Tenant: src, TopK: 10})
+    if len(qr.Items) != 0 { t.Fatalf("purged archive still queryable: %d", len(qr.Items)) }
+    if _, err := m.ForkArchive(ctx, src, "world-c"); !errors.Is(err, ErrArchiveNotFound) {
+        t.Fatalf("expect ErrArchiveNotFound, got %v", err)
+    }
+}
+
+func TestManager_SnapshotRoundTrip(t *testing.T) {
+    ctx := context.Background()
+    src := Tenant{UserID: "u1", ArchiveID: "a1"}
+
+    m1 := newTestManager(t, nil)
+    for _, c := range []string{"alpha", "beta"} {
+        _ = m1.Save(ctx, MemoryItem{Tenant: src, Content: c, Tags: []string{"t"}, CreatedAt: time.Now()}, SaveOptions{})
+    }
+    var buf bytes.Buffer
+    if err := m1.ExportArchive(ctx, src, &buf); err != nil { t.Fatalf("export: %v", err) }
+
+    // 导入到另一个实例、另一个用户下
+    m2 := newTestManager(t, nil)
+    dst := Tenant{UserID: "u2", ArchiveID: "restored"}
+    n, err := m2.ImportArchive(ctx, dst, bytes.NewReader(buf.Bytes()))
+    if err != nil || n != 2 { t.Fatalf("import: n=%d err=%v", n, err) }
+    items, _ := m2.ExportItems(ctx, dst)
+    if len(items) != 2 || items[1].Content != "beta" || items[1].Tenant != dst {
+        t.Fatalf("unexpected imported items: %+v", items)
+    }
+
+    if _, err := m2.ImportArchive(ctx, dst, bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrArchiveExists) {
+        t.Fatalf("import onto existing archive should fail, got %v", err)
+    }
+    if _, err := m2.ImportArchive(ctx, Tenant{UserID: "u2", ArchiveID: "bad"}, bytes.NewReader([]byte("not a tarball"))); err == nil {
+        t.Fatalf("expect error on malformed snapshot")
+    }
+}
+
+func TestManager_ForkAndSnapshotKeepVersionsAndTriples(t *testing.T) {
+    ctx := context.Background()
+    src := Tenant{UserID: "u1", ArchiveID: "a1"}
+    open := func() *Manager {
+        return newTestManager(t, func(o *RAGOptions) { o.Triple.Enable = true })
+    }
+    m1 := open()
+    _ = m1.Save(ctx, MemoryItem{ID: "age", Tenant: src, Content: "林夏十六岁"}, SaveOptions{})
+    it, _ := m1.Get(ctx, src, "age")
+    it.Content = "林夏十七岁"
+    if err := m1.Update(ctx, it); err != nil { t.Fatalf("update: %v", err) }
+    ts, _ := m1.Triples()
+    if _, err := ts.SaveTriple(ctx, Triple{Tenant: src, Subject: "林夏", Predicate: "sister_of", Object: "林秋"}); err != nil { t.Fatalf("triple: %v", err) }
+
+    check := func(m *Manager, dst Tenant) {
+        t.Helper()
+        vs, err := m.Versions(ctx, dst, "age")
+        if err != nil || contents(vs) != "林夏十六岁,林夏十七岁" || vs[1].Tenant != dst { t.Fatalf("versions: %s %v", contents(vs), err) }
+        ts, _ := m.Triples()
+        trs, err := ts.MatchTriples(ctx, dst, TriplePattern{Subject: "林夏"})
+        if err != nil || len(trs) != 1 || trs[0].Object != "林秋" || trs[0].Tenant != dst { t.Fatalf("triples: %+v %v", trs, err) }
+    }
+
+    // 分叉：历史版本与三元组一并复制
+    if _, err := m1.ForkArchive(ctx, src, "a2"); err != nil { t.Fatalf("fork: %v", err) }
+    check(m1, Tenant{UserID: "u1", ArchiveID: "a2"})
+
+    // 快照往返无损
+    var buf bytes.Buffer
+    if err := m1.ExportArchive(ctx, src, &buf); err != nil { t.Fatalf("export: %v", err) }
+    m2 := open()
+    dst := Tenant{UserID: "u2", ArchiveID: "restored"}
+    if n, err := m2.ImportArchive(ctx, dst, &buf); err != nil || n != 1 { t.Fatalf("import: %d %v", n, err) }
+    check(m2, dst)
+}
@@ -0,0 +1,562 @@
+package rag
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// 异步写入的可靠性
+// - 预写日志（Async.SpoolPath）：入队前追加 put 记录，处理完成（成功或转入死信）后追加 done 记录；
+//   启动时重放未完成的 put，队列清空时截断日志。文件为 {SpoolPath}/{Namespace}/spool.jsonl，每个实例独占
+// - 重试：失败后按 RetryBackoff 指数退避，最多重试 MaxRetries 次
+// - 死信：重试耗尽的写入追加到 deadletter.jsonl（无预写日志时仅计数与记录日志），可用 RequeueDeadLetters 重新写入
+// - 统计与日志：AsyncStats 返回计数；SetLogger 设置日志（默认不输出）
+// - 关闭：Close 等待队列排空直到 ctx 截止；超时后停止处理，未完成的写入保留在预写日志中，下次启动重放
+// - 顺序：队列中的写入按条目计数（pendingWrites），Update/Delete 先等待同一条目的排队写入完成，
+//   避免稍后落盘的原始条目覆盖更新或使已删除的条目复活
+
+const (
+    spoolName      = "spool.jsonl"
+    deadLetterName = "deadletter.jsonl"
+    spoolOpDone    = "done"
+)
+
+// spoolRecord 预写日志的一行
+type spoolRecord struct {
+    Op   string       `json:"op,omitempty"`
+    Seq  uint64       `json:"seq"`
+    Item *MemoryItem  `json:"item,omitempty"`
+    Opt  *SaveOptions `json:"opt,omitempty"`
+}
+
+// DeadLetter 重试耗尽的写入
+type DeadLetter struct {
+    Item     MemoryItem  `json:"item"`
+    Opt      SaveOptions `json:"opt"`
+    Error    string      `json:"error"`
+    Attempts int         `json:"attempts"`
+    FailedAt time.Time   `json:"failed_at"`
+}
+
+// AsyncStats 异步写入计数
+type AsyncStats struct {
+    Enqueued     int64 `json:"enqueued"`      // 入队
+    Saved        int64 `json:"saved"`         // 成功写入
+    Retries      int64 `json:"retries"`       // 重试次数
+    DeadLettered int64 `json:"dead_lettered"` // 重试耗尽
+    SyncFallback int64 `json:"sync_fallback"` // 队列满时同步写入
+    Replayed     int64 `json:"replayed"`      // 启动时从预写日志重放
+    Abandoned    int64 `json:"abandoned"`     // 关闭超时未处理（有预写日志时下次启动重放）
+    Pending      int   `json:"pending"`       // 当前队列长度
+}
+
+type asyncCounters struct {
+    enqueued, saved, retries, deadLettered, syncFallback, replayed, abandoned atomic.Int64
+}
+
+// pendingWrites 按条目统计已入队但尚未处理完成（成功、转入死信或关闭时放弃）的写入
+type pendingWrites struct {
+    mu      sync.Mutex
+    entries map[pendingKey]*pendingEntry
+}
+
+type pendingKey struct {
+    tenant Tenant
+    id     string
+}
+
+type pendingEntry struct {
+    n    int
+    done chan struct{} // 计数归零时关闭
+}
+
+func (p *pendingWrites) add(t Tenant, id string) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.entries == nil {
+        p.entries = make(map[pendingKey]*pendingEntry)
+    }
+    k := pendingKey{t, id}
+    e := p.entries[k]
+    if e == nil {
+        e = &pendingEntry{done: make(chan struct{})}
+        p.entries[k] = e
+    }
+    e.n++
+}
+
+func (p *pendingWrites) finish(t Tenant, id string) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    k := pendingKey{t, id}
+    if e := p.entries[k]; e != nil {
+        if e.n--; e.n <= 0 {
+            close(e.done)
+            delete(p.entries, k)
+        }
+    }
+}
+
+// wait 等待条目的排队写入全部处理完成，直到 ctx 截止
+func (p *pendingWrites) wait(ctx context.Context, t Tenant, id string) error {
+    p.mu.Lock()
+    e := p.entries[pendingKey{t, id}]
+    p.mu.Unlock()
+    if e == nil {
+        return nil
+    }
+    select {
+    case <-e.done:
+        return nil
+    case <-ctx.Done():
+        return fmt.Errorf("等待异步写入完成: %w", ctx.Err())
+    }
+}
+
+// waitTenant 等待租户当前排队的全部写入处理完成，直到 ctx 截止；之后入队的写入不在等待范围内
+func (p *pendingWrites) waitTenant(ctx context.Context, t Tenant) error {
+    p.mu.Lock()
+    var ids []string
+    for k := range p.entries {
+        if k.tenant == t {
+            ids = append(ids, k.id)
+        }
+    }
+    p.mu.Unlock()
+    for _, id := range ids {
+        if err := p.wait(ctx, t, id); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// spool 预写日志
+type spool struct {
+    mu          sync.Mutex
+    dir         string
+    sync        bool
+    f           *os.File
+    seq         uint64
+    outstanding int
+}
+
+// openSpool 打开预写日志并返回未完成的写入（按原顺序），同时将日志重写为仅含这些写入
+func openSpool(dir string, syncWrites bool) (*spool, []saveTask, error) {
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return nil, nil, fmt.Errorf("ensure spool dir: %w", err)
+    }
+    sp := &spool{dir: dir, sync: syncWrites}
+    path := filepath.Join(dir, spoolName)
+
+    var puts []spoolRecord
+    done := make(map[uint64]bool)
+    if f, err := os.Open(path); err == nil {
+        sc := bufio.NewScanner(f)
+        sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+        for sc.Scan() {
+            var rec spoolRecord
+            // 末尾残缺或损坏的行跳过：其 put 未完成写入，调用方尚未收到成功返回
+            if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+                continue
+            }
+            if rec.Seq > sp.seq {
+                sp.seq = rec.Seq
+            }
+            switch {
+            case rec.Op == spoolOpDone:
+                done[rec.Seq] = true
+            case rec.Item != nil:
+                puts = append(puts, rec)
+            }
+        }
+        _ = f.Close()
+    } else if !os.IsNotExist(err) {
+        return nil, nil, fmt.Errorf("open spool: %w", err)
+    }
+
+    var pending []saveTask
+    var buf []byte
+    for _, rec := range puts {
+        if done[rec.Seq] {
+            continue
+        }
+        opt := SaveOptions{}
+        if rec.Opt != nil {
+            opt = *rec.Opt
+        }
+        pending = append(pending, saveTask{item: *rec.Item, opt: opt, seq: rec.Seq})
+        b, _ := json.Marshal(rec)
+        buf = append(append(buf, b...), '\n')
+    }
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, buf, 0o644); err != nil {
+        return nil, nil, fmt.Errorf("rewrite spool: %w", err)
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        return nil, nil, fmt.Errorf("rewrite spool: %w", err)
+    }
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+    if err != nil {
+        return nil, nil, fmt.Errorf("open spool: %w", err)
+    }
+    sp.f = f
+    sp.outstanding = len(pending)
+    return sp, pending, nil
+}
+
+// put 记录待处理的写入并返回序号
+func (sp *spool) put(item MemoryItem, opt SaveOptions) (uint64, error) {
+    sp.mu.Lock()
+    defer sp.mu.Unlock()
+    sp.seq++
+    if err := sp.append(spoolRecord{Seq: sp.seq, Item: &item, Opt: &opt}, sp.sync); err != nil {
+        sp.seq--
+        return 0, err
+    }
+    sp.outstanding++
+    return sp.seq, nil
+}
+
+// done 标记写入已完成；全部完成时截断日志
+// SpoolSync 时截断与 done 记录同样 fsync：否则崩溃后丢失的 done 会重放旧 put，覆盖之后的 Update 或复活已删除的记忆
+func (sp *spool) done(seq uint64) {
+    sp.mu.Lock()
+    defer sp.mu.Unlock()
+    sp.outstanding--
+    if sp.outstanding <= 0 {
+        sp.outstanding = 0
+        if err := sp.f.Truncate(0); err == nil {
+            if sp.sync {
+                _ = sp.f.Sync()
+            }
+            return
+        }
+    }
+    _ = sp.append(spoolRecord{Op: spoolOpDone, Seq: seq}, sp.sync)
+}
+
+func (sp *spool) append(rec spoolRecord, fsync bool) error {
+    b, err := json.Marshal(rec)
+    if err != nil {
+        return fmt.Errorf("encode spool: %w", err)
+    }
+    if _, err := sp.f.Write(append(b, '\n')); err != nil {
+        return fmt.Errorf("write spool: %w", err)
+    }
+    if fsync {
+        if err := sp.f.Sync(); err != nil {
+            return fmt.Errorf("fsync spool: %w", err)
+        }
+    }
+    return nil
+}
+
+// deadLetter 追加死信记录
+func (sp *spool) deadLetter(dl DeadLetter) error {
+    sp.mu.Lock()
+    defer sp.mu.Unlock()
+    f, err := os.OpenFile(filepath.Join(sp.dir, deadLetterName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+    if err != nil {
+        return fmt.Errorf("open dead letter: %w", err)
+    }
+    defer f.Close()
+    if err := json.NewEncoder(f).Encode(&dl); err != nil {
+        return fmt.Errorf("write dead letter: %w", err)
+    }
+    return f.Sync()
+}
+
+func (sp *spool) close() error {
+    sp.mu.Lock()
+    defer sp.mu.Unlock()
+    return sp.f.Close()
+}
+
+// SetLogger 设置异步写入的日志（nil 关闭日志）
+func (m *Manager) SetLogger(l *zap.Logger) {
+    if l == nil {
+        l = zap.NewNop()
+    }
+    m.logMu.Lock()
+    m.logger = l
+    m.logMu.Unlock()
+}
+
+// Logger 返回 SetLogger 设置的日志（未设置时为空日志），供工具记录记忆操作
+func (m *Manager) Logger() *zap.Logger {
+    return m.log()
+}
+
+func (m *Manager) log() *zap.Logger {
+    m.logMu.RLock()
+    defer m.logMu.RUnlock()
+    if m.logger == nil {
+        return zap.NewNop()
+    }
+    return m.logger
+}
+
+// AsyncStats 返回异步写入计数
+func (m *Manager) AsyncStats() AsyncStats {
+    c := &m.stats
+    st := AsyncStats{
+        Enqueued:     c.enqueued.Load(),
+        Saved:        c.saved.Load(),
+        Retries:      c.retries.Load(),
+        DeadLettered: c.deadLettered.Load(),
+        SyncFallback: c.syncFallback.Load(),
+        Replayed:     c.replayed.Load(),
+        Abandoned:    c.abandoned.Load(),
+    }
+    m.mu.RLock()
+    if m.asyncCh != nil && !m.closed {
+        st.Pending = len(m.asyncCh)
+    }
+    m.mu.RUnlock()
+    return st
+}
+
+// enqueue 记录预写日志后入队；队列满或已关闭时返回 false，由调用方同步写入（调用方持有 m.mu 读锁）
+func (m *Manager) enqueue(task saveTask) (bool, error) {
+    if m.spool != nil {
+        seq, err := m.spool.put(task.item, task.opt)
+        if err != nil {
+            return false, err
+        }
+        task.seq = seq
+    }
+    // 先计数再发送，worker 可能在发送返回前就已处理完成
+    m.pending.add(task.item.Tenant, task.item.ID)
+    select {
+    case m.asyncCh <- task:
+        m.stats.enqueued.Add(1)
+        return true, nil
+    default:
+        m.pending.finish(task.item.Tenant, task.item.ID)
+        if m.spool != nil {
+            m.spool.done(task.seq)
+        }
+        return false, nil
+    }
+}
+
+func (m *Manager) worker() {
+    defer m.wg.Done()
+    for task := range m.asyncCh {
+        // 关闭超时：剩余任务留在预写日志中
+        if m.abort.Load() {
+            m.stats.abandoned.Add(1)
+        } else {
+            m.process(task)
+        }
+        m.pending.finish(task.item.Tenant, task.item.ID)
+    }
+}
+
+// process 带重试地执行异步写入，重试耗尽时转入死信
+func (m *Manager) process(task saveTask) {
+    maxRetries := m.opts.Async.MaxRetries
+    backoff := m.opts.Async.RetryBackoff
+    if backoff <= 0 {
+        backoff = 100 * time.Millisecond
+    }
+
+    // 独立于调用方的上下文保证落盘不被上游过早取消，仅在关闭超时时取消；设定超时避免卡死
+    base := m.haltCtx
+    if base == nil {
+        base = context.Background()
+    }
+    var err error
+    attempts := 0
+    for {
+        attempts++
+        ctx, cancel := context.WithTimeout(base, 10*time.Second)
+        err = m.saveTask(ctx, task)
+        cancel()
+        if err == nil || attempts > maxRetries || m.abort.Load() {
+            break
+        }
+        m.stats.retries.Add(1)
+        m.log().Warn("异步写入失败，稍后重试",
+            zap.String("用户", task.item.Tenant.UserID),
+            zap.String("归档", task.item.Tenant.ArchiveID),
+            zap.String("记忆ID", task.item.ID),
+            zap.Int("已尝试", attempts),
+            zap.Error(err),
+        )
+        select {
+        case <-time.After(backoff):
+        case <-base.Done():
+        }
+        backoff *= 2
+    }
+
+    switch {
+    case err == nil:
+        m.stats.saved.Add(1)
+    case m.abort.Load():
+        // 关闭超时：保留在预写日志中等待重放
+        m.stats.abandoned.Add(1)
+        return
+    default:
+        m.stats.deadLettered.Add(1)
+        m.log().Error("异步写入重试耗尽，转入死信",
+            zap.String("用户", task.item.Tenant.UserID),
+            zap.String("归档", task.item.Tenant.ArchiveID),
+            zap.String("记忆ID", task.item.ID),
+            zap.Int("已尝试", attempts),
+            zap.Error(err),
+        )
+        if m.spool != nil {
+            dl := DeadLetter{Item: task.item, Opt: task.opt, Error: err.Error(), Attempts: attempts, FailedAt: time.Now()}
+            if derr := m.spool.deadLetter(dl); derr != nil {
+                // 死信写入失败时保留预写日志记录，下次启动重放
+                m.log().Error("写入死信失败", zap.Error(derr))
+                return
+            }
+        }
+    }
+    if m.spool != nil {
+        m.spool.done(task.seq)
+    }
+}
+
+// replay 重放预写日志中未完成的写入；队列满或已关闭时直接处理（Close 等待其完成）
+// 调用方已为 tasks 计入 pending，送入队列的由 worker 结束计数
+func (m *Manager) replay(tasks []saveTask) {
+    defer m.wg.Done()
+    for _, task := range tasks {
+        if m.abort.Load() {
+            m.stats.abandoned.Add(1)
+            m.pending.finish(task.item.Tenant, task.item.ID)
+            continue
+        }
+        m.stats.replayed.Add(1)
+        m.mu.RLock()
+        if !m.closed {
+            select {
+            case m.asyncCh <- task:
+                m.mu.RUnlock()
+                continue
+            default:
+            }
+        }
+        m.mu.RUnlock()
+        m.process(task)
+        m.pending.finish(task.item.Tenant, task.item.ID)
+    }
+}
+
+// DeadLetters 返回死信记录
+func (m *Manager) DeadLetters(ctx context.Context) ([]DeadLetter, error) {
+    if m.spool == nil {
+        return nil, errors.New("未配置异步预写日志")
+    }
+    m.spool.mu.Lock()
+    defer m.spool.mu.Unlock()
+    return readDeadLetters(filepath.Join(m.spool.dir, deadLetterName))
+}
+
+func readDeadLetters(path string) ([]DeadLetter, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("open dead letter: %w", err)
+    }
+    defer f.Close()
+    var res []DeadLetter
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+    for sc.Scan() {
+        var dl DeadLetter
+        if err := json.Unmarshal(sc.Bytes(), &dl); err == nil {
+            res = append(res, dl)
+        }
+    }
+    return res, sc.Err()
+}
+
+// RequeueDeadLetters 同步重新写入全部死信，返回成功条数；仍失败的保留在死信文件中
+func (m *Manager) RequeueDeadLetters(ctx context.Context) (int, error) {
+    if m.spool == nil {
+        return 0, errors.New("未配置异步预写日志")
+    }
+    m.spool.mu.Lock()
+    defer m.spool.mu.Unlock()
+    path := filepath.Join(m.spool.dir, deadLetterName)
+    dls, err := readDeadLetters(path)
+    if err != nil || len(dls) == 0 {
+        return 0, err
+    }
+    var buf []byte
+    ok := 0
+    for _, dl := range dls {
+        if err := m.saveSync(ctx, dl.Item, dl.Opt); err != nil {
+            dl.Error, dl.Attempts, dl.FailedAt = err.Error(), dl.Attempts+1, time.Now()
+            b, _ := json.Marshal(dl)
+            buf = append(append(buf, b...), '\n')
+            continue
+        }
+        ok++
+    }
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, buf, 0o644); err != nil {
+        return ok, fmt.Errorf("rewrite dead letter: %w", err)
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        return ok, fmt.Errorf("rewrite dead letter: %w", err)
+    }
+    return ok, nil
+}
+
+// drain 关闭队列并等待排空；ctx 截止时停止处理剩余任务（调用方已将 closed 置为 true）
+func (m *Manager) drain(ctx context.Context) error {
+    if m.asyncCh != nil {
+        close(m.asyncCh)
+    }
+    done := make(chan struct{})
+    go func() {
+        m.wg.Wait()
+        close(done)
+    }()
+    select {
+    case <-done:
+    case <-ctx.Done():
+        m.abort.Store(true)
+        if m.halt != nil {
+            m.halt()
+        }
+        <-done
+    }
+    if m.halt != nil {
+        m.halt()
+    }
+    var err error
+    if n := m.stats.abandoned.Load(); n > 0 {
+        err = fmt.Errorf("异步写入未在截止前完成: %d 条（%w）", n, ctx.Err())
+        if m.spool != nil {
+            m.log().Warn("异步写入未在截止前完成，已保留在预写日志中", zap.Int64("条数", n))
+        } else {
+            m.log().Error("异步写入未在截止前完成，已丢弃", zap.Int64("条数", n))
+        }
+    }
+    if m.spool != nil {
+        if cerr := m.spool.close(); cerr != nil && err == nil {
+            err = cerr
+        }
+    }
+    return err
+}
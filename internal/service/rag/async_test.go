@@ -0,0 +1,198 @@
+package rag
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// flakyStore 测试用持久化后端：前 fail 次写入失败（<0 始终失败），block 非空时写入阻塞到其关闭或 ctx 取消；
+// 内嵌 Store 非空时成功的写入转交给它
+type flakyStore struct {
+    Store
+    mu    sync.Mutex
+    fail  int
+    block chan struct{}
+    saved []string
+}
+
+func (s *flakyStore) Save(ctx context.Context, item MemoryItem) error {
+    if s.block != nil {
+        select {
+        case <-s.block:
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.fail != 0 {
+        if s.fail > 0 { s.fail-- }
+        return errors.New("disk unavailable")
+    }
+    s.saved = append(s.saved, item.ID)
+    if s.Store != nil {
+        return s.Store.Save(ctx, item)
+    }
+    return nil
+}
+
+func (s *flakyStore) Close(ctx context.Context) error { return nil }
+
+func (s *flakyStore) ids() string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    res := append([]string(nil), s.saved...)
+    sort.Strings(res)
+    return strings.Join(res, ",")
+}
+
+// spoolOptions 仅异步落盘，预写日志位于 spool，JSONL 数据位于 disk
+// 需要替换持久化后端时在创建后赋值 m.disk（仅用于无待重放写入时）
+func spoolOptions(spool, disk string) func(*RAGOptions) {
+    return func(o *RAGOptions) {
+        o.InMemory.Enable = false
+        o.DiskJSON.RootPath = disk
+        o.Async.Enable = true
+        o.Async.SpoolPath = spool
+        o.Async.RetryBackoff = time.Millisecond
+    }
+}
+
+func exportIDs(t *testing.T, m *Manager, ten Tenant) string {
+    t.Helper()
+    all, err := m.disk.(ArchiveStore).Export(context.Background(), ten)
+    if err != nil { t.Fatalf("export: %v", err) }
+    sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+    return ids(all)
+}
+
+func TestAsync_SpoolReplayAfterCrash(t *testing.T) {
+    spool := t.TempDir()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+
+    // 模拟崩溃：三条入队，一条已完成，末尾一行写了一半
+    dir := filepath.Join(spool, "default")
+    sp, _, err := openSpool(dir, false)
+    if err != nil { t.Fatalf("open spool: %v", err) }
+    for _, id := range []string{"a", "b", "c"} {
+        seq, err := sp.put(MemoryItem{ID: id, Tenant: ten, Content: id}, SaveOptions{})
+        if err != nil { t.Fatalf("put: %v", err) }
+        if id == "b" { sp.done(seq) }
+    }
+    _ = sp.close()
+    appendRaw(t, filepath.Join(dir, spoolName), []byte(`{"seq":9,"item":{"id":"torn"`))
+
+    disk := t.TempDir()
+    m := newTestManager(t, spoolOptions(spool, disk))
+    if err := m.Close(context.Background()); err != nil { t.Fatalf("close: %v", err) }
+    if got := exportIDs(t, m, ten); got != "a,c" { t.Fatalf("replayed: %s", got) }
+    if s := m.AsyncStats(); s.Replayed != 2 || s.Saved != 2 { t.Fatalf("stats: %+v", s) }
+
+    // 全部完成后预写日志被截断，再次启动无重放
+    if fi, _ := os.Stat(filepath.Join(dir, spoolName)); fi.Size() != 0 { t.Fatalf("spool not truncated: %d bytes", fi.Size()) }
+    m2 := newTestManager(t, spoolOptions(spool, t.TempDir()))
+    _ = m2.Close(context.Background())
+    if s := m2.AsyncStats(); s.Replayed != 0 { t.Fatalf("unexpected replay: %+v", s) }
+}
+
+func TestAsync_RetryAndDeadLetter(t *testing.T) {
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    st := &flakyStore{fail: 2}
+    m := newTestManager(t, spoolOptions(t.TempDir(), t.TempDir()))
+    m.disk = st
+
+    // 失败两次后成功
+    if err := m.Save(ctx, MemoryItem{ID: "ok", Tenant: ten, Content: "x"}, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    // 始终失败：重试 MaxRetries 次后转入死信
+    waitFor(t, func() bool { return m.AsyncStats().Saved == 1 })
+    st.mu.Lock()
+    st.fail = -1
+    st.mu.Unlock()
+    if err := m.Save(ctx, MemoryItem{ID: "bad", Tenant: ten, Content: "y"}, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    waitFor(t, func() bool { return m.AsyncStats().DeadLettered == 1 })
+
+    if s := m.AsyncStats(); s.Enqueued != 2 || s.Retries != 5 { t.Fatalf("stats: %+v", s) }
+    dls, err := m.DeadLetters(ctx)
+    if err != nil || len(dls) != 1 || dls[0].Item.ID != "bad" || dls[0].Attempts != 4 || dls[0].Error != "disk unavailable" { t.Fatalf("dead letters: %+v %v", dls, err) }
+
+    // 后端恢复后重新写入死信
+    st.mu.Lock()
+    st.fail = 0
+    st.mu.Unlock()
+    if n, err := m.RequeueDeadLetters(ctx); err != nil || n != 1 { t.Fatalf("requeue: %d %v", n, err) }
+    if dls, _ := m.DeadLetters(ctx); len(dls) != 0 { t.Fatalf("dead letters left: %+v", dls) }
+    if err := m.Close(ctx); err != nil { t.Fatalf("close: %v", err) }
+    if st.ids() != "bad,ok" { t.Fatalf("saved: %s", st.ids()) }
+}
+
+func TestAsync_CloseDeadlineKeepsSpool(t *testing.T) {
+    spool := t.TempDir()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    st := &flakyStore{block: make(chan struct{})}
+    m := newTestManager(t, spoolOptions(spool, t.TempDir()))
+    m.disk = st
+    for _, id := range []string{"a", "b", "c"} {
+        if err := m.Save(context.Background(), MemoryItem{ID: id, Tenant: ten, Content: id}, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    }
+
+    // 后端卡住：截止后放弃处理，返回错误
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+    err := m.Close(ctx)
+    if err == nil || !errors.Is(err, context.DeadlineExceeded) { t.Fatalf("expect deadline error, got %v", err) }
+    if s := m.AsyncStats(); s.Abandoned != 3 || s.DeadLettered != 0 { t.Fatalf("stats: %+v", s) }
+
+    // 下次启动从预写日志重放
+    m2 := newTestManager(t, spoolOptions(spool, t.TempDir()))
+    if err := m2.Close(context.Background()); err != nil { t.Fatalf("close: %v", err) }
+    if got := exportIDs(t, m2, ten); got != "a,b,c" { t.Fatalf("replayed: %s", got) }
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+    t.Helper()
+    deadline := time.Now().Add(2 * time.Second)
+    for !cond() {
+        if time.Now().After(deadline) { t.Fatalf("timeout") }
+        time.Sleep(time.Millisecond)
+    }
+}
+
+func TestAsync_UpdateDeleteWaitForQueuedWrite(t *testing.T) {
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    disk := t.TempDir()
+    m := newTestManager(t, func(o *RAGOptions) {
+        o.DiskJSON.RootPath = disk
+        o.Async.Enable = true
+    })
+    // 落盘阻塞：条目已在内存缓存中可见，但仍在队列里
+    real := m.disk
+    gate := &flakyStore{Store: real, block: make(chan struct{})}
+    m.disk = gate
+
+    _ = m.Save(ctx, MemoryItem{ID: "gone", Tenant: ten, Content: "删除我"}, SaveOptions{})
+    _ = m.Save(ctx, MemoryItem{ID: "kept", Tenant: ten, Content: "旧内容"}, SaveOptions{})
+    time.AfterFunc(20*time.Millisecond, func() { close(gate.block) })
+
+    if err := m.Delete(ctx, ten, "gone"); err != nil { t.Fatalf("delete: %v", err) }
+    it, err := m.Get(ctx, ten, "kept")
+    if err != nil { t.Fatalf("get: %v", err) }
+    it.Content = "新内容"
+    if err := m.Update(ctx, it); err != nil { t.Fatalf("update: %v", err) }
+    if err := m.Close(ctx); err != nil { t.Fatalf("close: %v", err) }
+    _ = real.Close(ctx)
+
+    // 重启后从磁盘回放：删除与更新均未被排队的原始写入覆盖
+    m2 := newTestManager(t, spoolOptions(t.TempDir(), disk))
+    defer m2.Close(ctx)
+    if _, err := m2.Get(ctx, ten, "gone"); !errors.Is(err, ErrNotFound) { t.Fatalf("deleted item came back: %v", err) }
+    if got, err := m2.Get(ctx, ten, "kept"); err != nil || got.Content != "新内容" { t.Fatalf("update lost: %+v %v", got, err) }
+}
@@ -0,0 +1,137 @@
+package rag
+
+import (
+    "hash/fnv"
+    "math"
+    "strconv"
+    "strings"
+)
+
+// BM25 倒排索引（每租户一个），由本地存储在写入/删除时增量维护
+// 打分：idf(t) * tf*(k1+1) / (tf + k1*(1 - b + b*len/avgLen))
+
+const (
+    bm25K1 = 1.2
+    bm25B  = 0.75
+)
+
+type bm25Doc struct {
+    length int
+    tf     map[string]int
+}
+
+type bm25Index struct {
+    docs     map[string]bm25Doc
+    postings map[string]map[string]int // term -> docKey -> tf
+    totalLen int
+}
+
+func newBM25Index() *bm25Index {
+    return &bm25Index{
+        docs:     make(map[string]bm25Doc),
+        postings: make(map[string]map[string]int),
+    }
+}
+
+// docKey 索引中的文档键：优先使用 ID；无 ID 的旧数据以创建时间 + 内容哈希代替
+func docKey(it MemoryItem) string {
+    if it.ID != "" {
+        return it.ID
+    }
+    h := fnv.New64a()
+    _, _ = h.Write([]byte(it.Content))
+    return "~" + strconv.FormatInt(it.CreatedAt.UnixNano(), 36) + ":" + strconv.FormatUint(h.Sum64(), 36)
+}
+
+// Len 已索引的文档数
+func (x *bm25Index) Len() int { return len(x.docs) }
+
+// Add 索引文档；键已存在时先移除旧版本
+func (x *bm25Index) Add(it MemoryItem) {
+    key := docKey(it)
+    x.Remove(key)
+
+    tokens := Tokenize(it.Content)
+    tf := make(map[string]int)
+    for _, t := range tokens {
+        tf[t]++
+    }
+    x.docs[key] = bm25Doc{length: len(tokens), tf: tf}
+    x.totalLen += len(tokens)
+    for t, n := range tf {
+        p := x.postings[t]
+        if p == nil {
+            p = make(map[string]int)
+            x.postings[t] = p
+        }
+        p[key] = n
+    }
+}
+
+// Remove 移除文档
+func (x *bm25Index) Remove(key string) {
+    d, ok := x.docs[key]
+    if !ok {
+        return
+    }
+    for t := range d.tf {
+        if p := x.postings[t]; p != nil {
+            delete(p, key)
+            if len(p) == 0 {
+                delete(x.postings, t)
+            }
+        }
+    }
+    x.totalLen -= d.length
+    delete(x.docs, key)
+}
+
+// Score 计算查询对各文档的 BM25 分数，仅返回分数 > 0 的文档
+func (x *bm25Index) Score(query string) map[string]float64 {
+    scores := make(map[string]float64)
+    n := float64(len(x.docs))
+    if n == 0 {
+        return scores
+    }
+    avgLen := float64(x.totalLen) / n
+    if avgLen == 0 {
+        avgLen = 1
+    }
+
+    seen := make(map[string]bool)
+    for _, t := range TokenizeQuery(query) {
+        if seen[t] {
+            continue
+        }
+        seen[t] = true
+        p := x.postings[t]
+        if len(p) == 0 {
+            continue
+        }
+        df := float64(len(p))
+        idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+        for key, tf := range p {
+            dl := float64(x.docs[key].length)
+            f := float64(tf)
+            scores[key] += idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*dl/avgLen))
+        }
+    }
+    return scores
+}
+
+// scoreByQuery 为已按其他条件过滤的候选打分，保留命中分词或包含查询子串的条目（排序由调用方完成）
+func scoreByQuery(idx *bm25Index, cands []MemoryItem, query string) []MemoryItem {
+    scores := idx.Score(query)
+    q := strings.ToLower(query)
+
+    res := make([]MemoryItem, 0, len(cands))
+    for _, it := range cands {
+        s, ok := scores[docKey(it)]
+        if !ok && !strings.Contains(strings.ToLower(it.Content), q) {
+            continue
+        }
+        it.Score = s
+        res = append(res, it)
+    }
+    return res
+}
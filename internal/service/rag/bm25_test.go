@@ -0,0 +1,66 @@
+package rag
+
+import (
+    "context"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestTokenize_CJKBigramsAndLatinWords(t *testing.T) {
+    got := TokenizeQuery("主角的妹妹 Alice-2")
+    want := []string{"主角", "角的", "的妹", "妹妹", "alice", "2"}
+    if !reflect.DeepEqual(got, want) { t.Fatalf("query tokens: %v", got) }
+
+    // 文档侧额外包含单字
+    doc := Tokenize("妹妹")
+    if !reflect.DeepEqual(doc, []string{"妹妹", "妹", "妹"}) { t.Fatalf("doc tokens: %v", doc) }
+    if !reflect.DeepEqual(TokenizeQuery("妹"), []string{"妹"}) { t.Fatalf("single char query") }
+}
+
+func TestBM25_RanksAndMaintainsIncrementally(t *testing.T) {
+    stores := map[string]Store{
+        "memory": NewMemoryStore(InMemoryOptions{Enable: true}),
+    }
+    disk, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: t.TempDir()})
+    if err != nil { t.Fatalf("new disk store: %v", err) }
+    stores["disk"] = disk
+
+    for name, st := range stores {
+        t.Run(name, func(t *testing.T) {
+            ctx := context.Background()
+            ten := Tenant{UserID: "u", ArchiveID: "a"}
+            base := time.Now()
+            docs := []MemoryItem{
+                {ID: "1", Content: "主角有一个妹妹，名叫小雨"},
+                {ID: "2", Content: "主角的妹妹喜欢画画，妹妹很安静"},
+                {ID: "3", Content: "反派住在北方的城堡"},
+                {ID: "4", Content: "The hero's sister paints"},
+            }
+            for i, d := range docs {
+                d.Tenant = ten
+                d.CreatedAt = base.Add(time.Duration(i) * time.Millisecond)
+                if err := st.Save(ctx, d); err != nil { t.Fatalf("save: %v", err) }
+            }
+
+            // 非精确子串也能召回，且按相关度排序并填充 Score
+            qr, err := st.Query(ctx, QueryRequest{Tenant: ten, Query: "主角的妹妹", TopK: 10})
+            if err != nil { t.Fatalf("query: %v", err) }
+            if len(qr.Items) != 2 || qr.Items[0].ID != "2" || qr.Items[1].ID != "1" {
+                t.Fatalf("unexpected ranking: %+v", qr.Items)
+            }
+            if qr.Items[0].Score <= qr.Items[1].Score || qr.Items[1].Score <= 0 {
+                t.Fatalf("scores not filled: %v %v", qr.Items[0].Score, qr.Items[1].Score)
+            }
+
+            qr, _ = st.Query(ctx, QueryRequest{Tenant: ten, Query: "SISTER", TopK: 10})
+            if len(qr.Items) != 1 || qr.Items[0].ID != "4" { t.Fatalf("latin query: %+v", qr.Items) }
+
+            // 删除与更新后索引同步
+            if err := st.Delete(ctx, ten, "2"); err != nil { t.Fatalf("delete: %v", err) }
+            if err := st.Update(ctx, MemoryItem{ID: "3", Tenant: ten, Content: "反派其实是主角的妹妹", CreatedAt: base}); err != nil { t.Fatalf("update: %v", err) }
+            qr, _ = st.Query(ctx, QueryRequest{Tenant: ten, Query: "主角的妹妹", TopK: 1})
+            if len(qr.Items) != 1 || qr.Items[0].ID != "3" { t.Fatalf("after delete/update: %+v", qr.Items) }
+        })
+    }
+}
@@ -0,0 +1,692 @@
+package rag
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+
+    "ahs/internal/tenantpath"
+
+    bolt "go.etcd.io/bbolt"
+)
+
+// boltStore 基于 bbolt 的事务型本地存储（Bolt.Enable 时替代 DiskJSON 作为持久化后端）
+// 文件：{RootPath}/{Namespace}.db；每租户一个桶：{enc(user_id)} / {enc(archive_id)}，其下子桶：
+// - items:   seq(8 字节大端) -> 记忆 JSON；seq 单调递增，保持首次写入顺序，更新保留原 seq
+// - ids:     id -> seq
+// - kind:    kind \x00 seq -> 空
+// - tag:     tag \x00 seq -> 空
+// - created: created_at(8 字节大端纳秒) seq -> 空
+// - versions: id \x00 version(8 字节大端) -> 被取代的历史版本 JSON（见 version.go；旧库首次产生历史时创建）
+// 写入、更新、删除与索引维护在同一事务内完成；文本查询使用每租户 BM25 索引（首次查询时构建，随写入增量维护）
+
+var (
+    bucketItems    = []byte("items")
+    bucketIDs      = []byte("ids")
+    bucketKind     = []byte("kind")
+    bucketTag      = []byte("tag")
+    bucketCreated  = []byte("created")
+    bucketVersions = []byte("versions")
+)
+
+type boltStore struct {
+    db *bolt.DB
+
+    mu    sync.Mutex
+    index map[string]*bm25Index // 租户桶路径 -> BM25 索引（受 mu 保护）
+}
+
+// NewBoltStore 打开（或创建）bbolt 存储
+func NewBoltStore(ns string, opts BoltOptions) (Store, error) {
+    if opts.RootPath == "" {
+        return nil, errors.New("Bolt.RootPath 不能为空")
+    }
+    if err := os.MkdirAll(opts.RootPath, 0o755); err != nil {
+        return nil, fmt.Errorf("ensure dir: %w", err)
+    }
+    timeout := opts.Timeout
+    if timeout <= 0 {
+        timeout = time.Second
+    }
+    // bbolt 以文件锁独占数据库，其他进程已打开时等待 timeout 后报错
+    db, err := bolt.Open(filepath.Join(opts.RootPath, ns+".db"), 0o644, &bolt.Options{Timeout: timeout})
+    if err != nil {
+        return nil, fmt.Errorf("open bolt: %w", err)
+    }
+    return &boltStore{db: db, index: make(map[string]*bm25Index)}, nil
+}
+
+func seqKey(seq uint64) []byte {
+    k := make([]byte, 8)
+    binary.BigEndian.PutUint64(k, seq)
+    return k
+}
+
+func indexKey(prefix string, seq []byte) []byte {
+    k := make([]byte, 0, len(prefix)+1+len(seq))
+    k = append(k, prefix...)
+    k = append(k, 0)
+    return append(k, seq...)
+}
+
+func createdKey(t time.Time, seq []byte) []byte {
+    k := make([]byte, 16)
+    binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+    copy(k[8:], seq)
+    return k
+}
+
+func tenantKey(t Tenant) string {
+    return tenantpath.Encode(t.UserID) + "/" + tenantpath.Encode(t.ArchiveID)
+}
+
+// tenantBucket 返回租户桶；create 时按需创建（含子桶），否则不存在时返回 nil
+func tenantBucket(tx *bolt.Tx, t Tenant, create bool) (*bolt.Bucket, error) {
+    user, archive := []byte(tenantpath.Encode(t.UserID)), []byte(tenantpath.Encode(t.ArchiveID))
+    if !create {
+        ub := tx.Bucket(user)
+        if ub == nil {
+            return nil, nil
+        }
+        return ub.Bucket(archive), nil
+    }
+    ub, err := tx.CreateBucketIfNotExists(user)
+    if err != nil {
+        return nil, fmt.Errorf("create bucket: %w", err)
+    }
+    b, err := ub.CreateBucketIfNotExists(archive)
+    if err != nil {
+        return nil, fmt.Errorf("create bucket: %w", err)
+    }
+    for _, name := range [][]byte{bucketItems, bucketIDs, bucketKind, bucketTag, bucketCreated, bucketVersions} {
+        if _, err := b.CreateBucketIfNotExists(name); err != nil {
+            return nil, fmt.Errorf("create bucket: %w", err)
+        }
+    }
+    return b, nil
+}
+
+// putIndexes 写入二级索引
+func putIndexes(b *bolt.Bucket, it MemoryItem, seq []byte) error {
+    if it.Kind != "" {
+        if err := b.Bucket(bucketKind).Put(indexKey(string(it.Kind), seq), nil); err != nil {
+            return err
+        }
+    }
+    for _, tag := range it.Tags {
+        if err := b.Bucket(bucketTag).Put(indexKey(tag, seq), nil); err != nil {
+            return err
+        }
+    }
+    return b.Bucket(bucketCreated).Put(createdKey(it.CreatedAt, seq), nil)
+}
+
+// removeItem 删除条目及其索引
+func removeItem(b *bolt.Bucket, seq []byte) (MemoryItem, error) {
+    var it MemoryItem
+    v := b.Bucket(bucketItems).Get(seq)
+    if v == nil {
+        return it, ErrNotFound
+    }
+    if err := json.Unmarshal(v, &it); err != nil {
+        return it, fmt.Errorf("decode item: %w", err)
+    }
+    if it.Kind != "" {
+        if err := b.Bucket(bucketKind).Delete(indexKey(string(it.Kind), seq)); err != nil {
+            return it, err
+        }
+    }
+    for _, tag := range it.Tags {
+        if err := b.Bucket(bucketTag).Delete(indexKey(tag, seq)); err != nil {
+            return it, err
+        }
+    }
+    if err := b.Bucket(bucketCreated).Delete(createdKey(it.CreatedAt, seq)); err != nil {
+        return it, err
+    }
+    if it.ID != "" {
+        if err := b.Bucket(bucketIDs).Delete([]byte(it.ID)); err != nil {
+            return it, err
+        }
+    }
+    return it, b.Bucket(bucketItems).Delete(seq)
+}
+
+// versionKey 历史版本键：id \x00 version
+func versionKey(id string, version int) []byte {
+    k := make([]byte, 0, len(id)+9)
+    k = append(k, id...)
+    k = append(k, 0)
+    return binary.BigEndian.AppendUint64(k, uint64(version))
+}
+
+// putVersion 保留被取代的版本
+func putVersion(b *bolt.Bucket, it MemoryItem) error {
+    vb, err := b.CreateBucketIfNotExists(bucketVersions)
+    if err != nil {
+        return fmt.Errorf("create bucket: %w", err)
+    }
+    data, err := json.Marshal(it)
+    if err != nil {
+        return fmt.Errorf("encode item: %w", err)
+    }
+    return vb.Put(versionKey(it.ID, it.Version), data)
+}
+
+// dropVersions 删除条目的全部历史版本
+func dropVersions(b *bolt.Bucket, id string) error {
+    vb := b.Bucket(bucketVersions)
+    if vb == nil || id == "" {
+        return nil
+    }
+    prefix := append([]byte(id), 0)
+    var keys [][]byte
+    c := vb.Cursor()
+    for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+        keys = append(keys, append([]byte(nil), k...))
+    }
+    for _, k := range keys {
+        if err := vb.Delete(k); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// put 写入条目：ID 已存在时替换并保留原 seq（upsert），否则分配新 seq
+// 替换时新条目的 Version 更大则保留原条目为历史版本
+func put(b *bolt.Bucket, it MemoryItem, mustExist bool) error {
+    var seq []byte
+    if it.ID != "" {
+        if old := b.Bucket(bucketIDs).Get([]byte(it.ID)); old != nil {
+            seq = append([]byte(nil), old...)
+            prev, err := removeItem(b, seq)
+            if err != nil {
+                return err
+            }
+            if it.Version > prev.Version {
+                if err := putVersion(b, prev); err != nil {
+                    return err
+                }
+            }
+        }
+    }
+    if seq == nil {
+        if mustExist {
+            return ErrNotFound
+        }
+        n, err := b.Bucket(bucketItems).NextSequence()
+        if err != nil {
+            return err
+        }
+        seq = seqKey(n)
+    }
+    data, err := json.Marshal(it)
+    if err != nil {
+        return fmt.Errorf("encode item: %w", err)
+    }
+    if err := b.Bucket(bucketItems).Put(seq, data); err != nil {
+        return err
+    }
+    if it.ID != "" {
+        if err := b.Bucket(bucketIDs).Put([]byte(it.ID), seq); err != nil {
+            return err
+        }
+    }
+    return putIndexes(b, it, seq)
+}
+
+func (s *boltStore) Save(ctx context.Context, item MemoryItem) error {
+    return s.SaveBatch(ctx, []MemoryItem{item})
+}
+
+// SaveBatch 在一个事务内保存多条记忆（迁移与导入使用）
+func (s *boltStore) SaveBatch(ctx context.Context, items []MemoryItem) error {
+    now := time.Now()
+    for i := range items {
+        if items[i].CreatedAt.IsZero() {
+            items[i].CreatedAt = now
+        }
+    }
+    err := s.db.Update(func(tx *bolt.Tx) error {
+        for _, it := range items {
+            b, err := tenantBucket(tx, it.Tenant, true)
+            if err != nil {
+                return err
+            }
+            if err := put(b, it, false); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    s.mu.Lock()
+    for _, it := range items {
+        if idx := s.index[tenantKey(it.Tenant)]; idx != nil {
+            idx.Add(it)
+        }
+    }
+    s.mu.Unlock()
+    return nil
+}
+
+func (s *boltStore) Update(ctx context.Context, item MemoryItem) error {
+    err := s.db.Update(func(tx *bolt.Tx) error {
+        b, err := tenantBucket(tx, item.Tenant, false)
+        if err != nil {
+            return err
+        }
+        if b == nil || item.ID == "" {
+            return ErrNotFound
+        }
+        return put(b, item, true)
+    })
+    if err != nil {
+        return err
+    }
+    s.mu.Lock()
+    if idx := s.index[tenantKey(item.Tenant)]; idx != nil {
+        idx.Add(item)
+    }
+    s.mu.Unlock()
+    return nil
+}
+
+func (s *boltStore) Delete(ctx context.Context, t Tenant, id string) error {
+    err := s.db.Update(func(tx *bolt.Tx) error {
+        b, err := tenantBucket(tx, t, false)
+        if err != nil {
+            return err
+        }
+        if b == nil || id == "" {
+            return ErrNotFound
+        }
+        seq := b.Bucket(bucketIDs).Get([]byte(id))
+        if seq == nil {
+            return ErrNotFound
+        }
+        if _, err := removeItem(b, append([]byte(nil), seq...)); err != nil {
+            return err
+        }
+        return dropVersions(b, id)
+    })
+    if err != nil {
+        return err
+    }
+    s.mu.Lock()
+    if idx := s.index[tenantKey(t)]; idx != nil {
+        idx.Remove(id)
+    }
+    s.mu.Unlock()
+    return nil
+}
+
+func (s *boltStore) Get(ctx context.Context, t Tenant, id string) (MemoryItem, error) {
+    var it MemoryItem
+    err := s.db.View(func(tx *bolt.Tx) error {
+        b, err := tenantBucket(tx, t, false)
+        if err != nil {
+            return err
+        }
+        if b == nil || id == "" {
+            return ErrNotFound
+        }
+        seq := b.Bucket(bucketIDs).Get([]byte(id))
+        if seq == nil {
+            return ErrNotFound
+        }
+        return json.Unmarshal(b.Bucket(bucketItems).Get(seq), &it)
+    })
+    return it, err
+}
+
+// Versions 返回条目的历史版本与当前版本（从旧到新）
+func (s *boltStore) Versions(ctx context.Context, t Tenant, id string) ([]MemoryItem, error) {
+    var res []MemoryItem
+    err := s.db.View(func(tx *bolt.Tx) error {
+        b, err := tenantBucket(tx, t, false)
+        if err != nil {
+            return err
+        }
+        if b == nil || id == "" {
+            return ErrNotFound
+        }
+        seq := b.Bucket(bucketIDs).Get([]byte(id))
+        if seq == nil {
+            return ErrNotFound
+        }
+        if vb := b.Bucket(bucketVersions); vb != nil {
+            prefix := append([]byte(id), 0)
+            c := vb.Cursor()
+            for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+                var it MemoryItem
+                if err := json.Unmarshal(v, &it); err != nil {
+                    return fmt.Errorf("decode item: %w", err)
+                }
+                res = append(res, it)
+            }
+        }
+        var cur MemoryItem
+        if err := json.Unmarshal(b.Bucket(bucketItems).Get(seq), &cur); err != nil {
+            return err
+        }
+        res = append(res, cur)
+        return nil
+    })
+    return res, err
+}
+
+// candidates 按类型与标签索引求候选 seq（nil 表示未使用索引，需全量遍历）
+// 类型之间取并集，标签之间取交集
+func candidates(b *bolt.Bucket, req QueryRequest) map[string]bool {
+    var set map[string]bool
+    scan := func(bucket []byte, prefix string) map[string]bool {
+        res := make(map[string]bool)
+        p := indexKey(prefix, nil)
+        c := b.Bucket(bucket).Cursor()
+        for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+            res[string(k[len(p):])] = true
+        }
+        return res
+    }
+    if len(req.Kinds) > 0 {
+        set = make(map[string]bool)
+        for _, k := range req.Kinds {
+            for seq := range scan(bucketKind, string(k)) {
+                set[seq] = true
+            }
+        }
+    }
+    if req.TagMode == TagModeAny && len(req.Tags) > 0 {
+        union := make(map[string]bool)
+        for _, tag := range req.Tags {
+            for seq := range scan(bucketTag, tag) {
+                if set == nil || set[seq] {
+                    union[seq] = true
+                }
+            }
+        }
+        return union
+    }
+    for _, tag := range req.Tags {
+        got := scan(bucketTag, tag)
+        if set == nil {
+            set = got
+            continue
+        }
+        for seq := range set {
+            if !got[seq] {
+                delete(set, seq)
+            }
+        }
+    }
+    return set
+}
+
+func (s *boltStore) Query(ctx context.Context, req QueryRequest) (QueryResult, error) {
+    plan, err := planQuery(req)
+    if err != nil {
+        return QueryResult{}, err
+    }
+    now := time.Now()
+    var res []MemoryItem
+    err = s.db.View(func(tx *bolt.Tx) error {
+        b, err := tenantBucket(tx, req.Tenant, false)
+        if err != nil || b == nil {
+            return err
+        }
+        set := candidates(b, req)
+        if set != nil && len(set) == 0 {
+            return nil
+        }
+        // 按 created 索引从新到旧遍历；无文本查询时取够一页（及同一时刻的条目）即止
+        need := 0
+        if req.Query == "" {
+            need = plan.need()
+        }
+        items := b.Bucket(bucketItems)
+        c := b.Bucket(bucketCreated).Cursor()
+        for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+            seq := k[8:]
+            if set != nil && !set[string(seq)] {
+                continue
+            }
+            if need > 0 && len(res) >= need && int64(binary.BigEndian.Uint64(k)) < res[len(res)-1].CreatedAt.UnixNano() {
+                break
+            }
+            v := items.Get(seq)
+            if v == nil {
+                continue
+            }
+            var it MemoryItem
+            if err := json.Unmarshal(v, &it); err != nil {
+                return fmt.Errorf("decode item: %w", err)
+            }
+            if !matchItem(it, req, now) || !plan.before(it.CreatedAt, it.ID) {
+                continue
+            }
+            res = append(res, it)
+        }
+        return nil
+    })
+    if err != nil {
+        return QueryResult{}, err
+    }
+    if req.Query != "" {
+        s.mu.Lock()
+        defer s.mu.Unlock()
+        idx, err := s.indexOf(req.Tenant)
+        if err != nil {
+            return QueryResult{}, err
+        }
+        res = scoreByQuery(idx, res, req.Query)
+    }
+    return plan.page(res), nil
+}
+
+// indexOf 返回租户的 BM25 索引，未构建时由全部条目构建（调用方持有 mu）
+func (s *boltStore) indexOf(t Tenant) (*bm25Index, error) {
+    key := tenantKey(t)
+    if idx := s.index[key]; idx != nil {
+        return idx, nil
+    }
+    all, err := s.Export(context.Background(), t)
+    if err != nil {
+        return nil, err
+    }
+    idx := newBM25Index()
+    for _, it := range all {
+        idx.Add(it)
+    }
+    s.index[key] = idx
+    return idx, nil
+}
+
+func (s *boltStore) Close(ctx context.Context) error {
+    return s.db.Close()
+}
+
+func (s *boltStore) ListArchives(ctx context.Context, userID string) ([]string, error) {
+    var res []string
+    err := s.db.View(func(tx *bolt.Tx) error {
+        ub := tx.Bucket([]byte(tenantpath.Encode(userID)))
+        if ub == nil {
+            return nil
+        }
+        return ub.ForEach(func(k, v []byte) error {
+            if v != nil {
+                return nil
+            }
+            if id, err := tenantpath.Decode(string(k)); err == nil {
+                res = append(res, id)
+            }
+            return nil
+        })
+    })
+    sort.Strings(res)
+    return res, err
+}
+
+func (s *boltStore) Create(ctx context.Context, t Tenant) error {
+    return s.db.Update(func(tx *bolt.Tx) error {
+        _, err := tenantBucket(tx, t, true)
+        return err
+    })
+}
+
+// Export 按首次写入顺序导出
+func (s *boltStore) Export(ctx context.Context, t Tenant) ([]MemoryItem, error) {
+    var res []MemoryItem
+    err := s.db.View(func(tx *bolt.Tx) error {
+        b, err := tenantBucket(tx, t, false)
+        if err != nil || b == nil {
+            return err
+        }
+        return b.Bucket(bucketItems).ForEach(func(k, v []byte) error {
+            var it MemoryItem
+            if err := json.Unmarshal(v, &it); err != nil {
+                return fmt.Errorf("decode item: %w", err)
+            }
+            res = append(res, it)
+            return nil
+        })
+    })
+    return res, err
+}
+
+func (s *boltStore) Purge(ctx context.Context, t Tenant) error {
+    s.mu.Lock()
+    delete(s.index, tenantKey(t))
+    s.mu.Unlock()
+    return s.db.Update(func(tx *bolt.Tx) error {
+        ub := tx.Bucket([]byte(tenantpath.Encode(t.UserID)))
+        if ub == nil || ub.Bucket([]byte(tenantpath.Encode(t.ArchiveID))) == nil {
+            return nil
+        }
+        return ub.DeleteBucket([]byte(tenantpath.Encode(t.ArchiveID)))
+    })
+}
+
+// Tenants 列出全部租户（Compactor）
+func (s *boltStore) Tenants(ctx context.Context) ([]Tenant, error) {
+    var res []Tenant
+    err := s.db.View(func(tx *bolt.Tx) error {
+        return tx.ForEach(func(name []byte, ub *bolt.Bucket) error {
+            userID, err := tenantpath.Decode(string(name))
+            if err != nil {
+                return nil
+            }
+            return ub.ForEach(func(k, v []byte) error {
+                if v != nil {
+                    return nil
+                }
+                if archiveID, err := tenantpath.Decode(string(k)); err == nil {
+                    res = append(res, Tenant{UserID: userID, ArchiveID: archiveID})
+                }
+                return nil
+            })
+        })
+    })
+    return res, err
+}
+
+// Compact 按保留策略删除过期、超龄与超出大小上限（从最旧开始）的条目（Compactor）
+// 删除在事务内完成，无墓碑与段；Bytes* 为条目编码大小之和
+func (s *boltStore) Compact(ctx context.Context, t Tenant, p CompactPolicy) (CompactReport, error) {
+    rep := CompactReport{Tenant: t}
+    if p.Now.IsZero() {
+        p.Now = time.Now()
+    }
+    var removed []MemoryItem
+    err := s.db.Update(func(tx *bolt.Tx) error {
+        b, err := tenantBucket(tx, t, false)
+        if err != nil || b == nil {
+            return err
+        }
+        type entry struct {
+            seq  []byte
+            size int
+            it   MemoryItem
+        }
+        var live []entry
+        err = b.Bucket(bucketItems).ForEach(func(k, v []byte) error {
+            var it MemoryItem
+            if err := json.Unmarshal(v, &it); err != nil {
+                return fmt.Errorf("decode item: %w", err)
+            }
+            rep.BytesBefore += int64(len(v))
+            live = append(live, entry{append([]byte(nil), k...), len(v), it})
+            return nil
+        })
+        if err != nil {
+            return err
+        }
+
+        var drop [][]byte
+        kept := live[:0]
+        var total int64
+        for _, e := range live {
+            switch {
+            case e.it.ExpiresAt != nil && e.it.ExpiresAt.Before(p.Now):
+                rep.Expired++
+            case p.MaxAge > 0 && !e.it.CreatedAt.IsZero() && p.Now.Sub(e.it.CreatedAt) > p.MaxAge:
+                rep.AgedOut++
+            default:
+                kept = append(kept, e)
+                total += int64(e.size)
+                continue
+            }
+            drop = append(drop, e.seq)
+            removed = append(removed, e.it)
+        }
+        start := 0
+        if p.MaxBytes > 0 {
+            for start < len(kept) && total > p.MaxBytes {
+                total -= int64(kept[start].size)
+                drop = append(drop, kept[start].seq)
+                removed = append(removed, kept[start].it)
+                rep.OverSize++
+                start++
+            }
+        }
+        rep.Kept = len(kept) - start
+        rep.BytesAfter = total
+        for _, seq := range drop {
+            it, err := removeItem(b, seq)
+            if err != nil {
+                return err
+            }
+            if err := dropVersions(b, it.ID); err != nil {
+                return err
+            }
+        }
+        rep.Rewritten = len(drop) > 0
+        return nil
+    })
+    if err != nil {
+        return rep, err
+    }
+    s.mu.Lock()
+    idx := s.index[tenantKey(t)]
+    for _, it := range removed {
+        if it.ID != "" {
+            rep.RemovedIDs = append(rep.RemovedIDs, it.ID)
+        }
+        if idx != nil {
+            idx.Remove(docKey(it))
+        }
+    }
+    s.mu.Unlock()
+    return rep, nil
+}
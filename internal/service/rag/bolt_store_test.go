@@ -0,0 +1,153 @@
+package rag
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+)
+
+func newTestBoltStore(t *testing.T, root string) *boltStore {
+    t.Helper()
+    st, err := NewBoltStore("ns", BoltOptions{Enable: true, RootPath: root})
+    if err != nil { t.Fatalf("new bolt store: %v", err) }
+    return st.(*boltStore)
+}
+
+func ids(items []MemoryItem) string {
+    var res []string
+    for _, it := range items { res = append(res, it.ID) }
+    return strings.Join(res, ",")
+}
+
+func TestBoltStore_CRUDIndexesAndReopen(t *testing.T) {
+    root := t.TempDir()
+    s := newTestBoltStore(t, root)
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    base := time.Now().Add(-time.Hour)
+    past := time.Now().Add(-time.Minute)
+
+    items := []MemoryItem{
+        {ID: "1", Tenant: ten, Kind: KindFact, Tags: []string{"x", "y"}, Content: "林夏住在王城"},
+        {ID: "2", Tenant: ten, Kind: KindNote, Tags: []string{"x"}, Content: "北境下雪"},
+        {ID: "3", Tenant: ten, Kind: KindFact, Tags: []string{"y"}, Content: "王城有灯塔"},
+        {ID: "4", Tenant: ten, Kind: KindFact, Tags: []string{"x", "y"}, Content: "过期的王城传闻", ExpiresAt: &past},
+    }
+    for i, it := range items {
+        it.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+        if err := s.Save(ctx, it); err != nil { t.Fatalf("save: %v", err) }
+    }
+
+    // 类型取并集、标签取交集，按写入顺序从新到旧，过期条目过滤
+    r, _ := s.Query(ctx, QueryRequest{Tenant: ten, Kinds: []MemoryKind{KindFact}})
+    if ids(r.Items) != "3,1" { t.Fatalf("kind: %s", ids(r.Items)) }
+    r, _ = s.Query(ctx, QueryRequest{Tenant: ten, Tags: []string{"x", "y"}})
+    if ids(r.Items) != "1" { t.Fatalf("tags: %s", ids(r.Items)) }
+    r, _ = s.Query(ctx, QueryRequest{Tenant: ten, Kinds: []MemoryKind{KindNote}, Tags: []string{"y"}})
+    if len(r.Items) != 0 { t.Fatalf("kind+tag: %s", ids(r.Items)) }
+    r, _ = s.Query(ctx, QueryRequest{Tenant: ten, TopK: 2})
+    if ids(r.Items) != "3,2" { t.Fatalf("topk: %s", ids(r.Items)) }
+    r, _ = s.Query(ctx, QueryRequest{Tenant: ten, Query: "王城"})
+    if len(r.Items) != 2 || r.Items[0].Score <= 0 { t.Fatalf("text: %+v", r.Items) }
+
+    // 更新保留原位置并更新索引；删除同时移除索引
+    if err := s.Update(ctx, MemoryItem{ID: "1", Tenant: ten, Kind: KindNote, Tags: []string{"z"}, Content: "林夏搬去南港", CreatedAt: base}); err != nil { t.Fatalf("update: %v", err) }
+    r, _ = s.Query(ctx, QueryRequest{Tenant: ten, Tags: []string{"x"}})
+    if ids(r.Items) != "2" { t.Fatalf("tag index after update: %s", ids(r.Items)) }
+    r, _ = s.Query(ctx, QueryRequest{Tenant: ten, Query: "南港"})
+    if ids(r.Items) != "1" { t.Fatalf("text index after update: %s", ids(r.Items)) }
+    if err := s.Delete(ctx, ten, "2"); err != nil { t.Fatalf("delete: %v", err) }
+    if err := s.Delete(ctx, ten, "2"); !errors.Is(err, ErrNotFound) { t.Fatalf("double delete: %v", err) }
+    if err := s.Update(ctx, MemoryItem{ID: "2", Tenant: ten}); !errors.Is(err, ErrNotFound) { t.Fatalf("update deleted: %v", err) }
+    r, _ = s.Query(ctx, QueryRequest{Tenant: ten, Query: "北境"})
+    if len(r.Items) != 0 { t.Fatalf("deleted item in text index: %+v", r.Items) }
+
+    all, _ := s.Export(ctx, ten)
+    if ids(all) != "1,3,4" { t.Fatalf("export order: %s", ids(all)) }
+    if _, err := s.Get(ctx, Tenant{UserID: "u", ArchiveID: "b"}, "1"); !errors.Is(err, ErrNotFound) { t.Fatalf("tenant leak: %v", err) }
+
+    // 重新打开后数据与索引一致
+    _ = s.Close(ctx)
+    s2 := newTestBoltStore(t, root)
+    defer s2.Close(ctx)
+    got, err := s2.Get(ctx, ten, "1")
+    if err != nil || got.Content != "林夏搬去南港" { t.Fatalf("reopen get: %+v %v", got, err) }
+    r, _ = s2.Query(ctx, QueryRequest{Tenant: ten, Tags: []string{"z"}})
+    if ids(r.Items) != "1" { t.Fatalf("reopen tag: %s", ids(r.Items)) }
+}
+
+func TestBoltStore_ArchivesAndCompact(t *testing.T) {
+    s := newTestBoltStore(t, t.TempDir())
+    defer s.Close(context.Background())
+    ctx := context.Background()
+    now := time.Now()
+    a, b := Tenant{UserID: "U_1", ArchiveID: "a"}, Tenant{UserID: "U_1", ArchiveID: "b/c"}
+    _ = s.Create(ctx, a)
+    for i := 0; i < 5; i++ {
+        _ = s.Save(ctx, MemoryItem{ID: fmt.Sprintf("m%d", i), Tenant: b, Content: strings.Repeat("字", 10), CreatedAt: now.Add(time.Duration(i-5) * time.Minute)})
+    }
+    _ = s.Save(ctx, MemoryItem{ID: "old", Tenant: b, Content: "旧", CreatedAt: now.AddDate(0, 0, -30)})
+
+    archives, _ := s.ListArchives(ctx, "U_1")
+    if strings.Join(archives, ",") != "a,b/c" { t.Fatalf("archives: %v", archives) }
+    tenants, _ := s.Tenants(ctx)
+    if len(tenants) != 2 { t.Fatalf("tenants: %+v", tenants) }
+
+    rep, err := s.Compact(ctx, b, CompactPolicy{Now: now, MaxAge: 7 * 24 * time.Hour, MaxBytes: 450})
+    if err != nil { t.Fatalf("compact: %v", err) }
+    if !rep.Rewritten || rep.AgedOut != 1 || rep.OverSize == 0 || rep.Kept+rep.OverSize != 5 || rep.BytesAfter > 450 { t.Fatalf("report: %+v", rep) }
+    if rep.RemovedIDs[0] != "old" || rep.RemovedIDs[1] != "m0" { t.Fatalf("removed: %v", rep.RemovedIDs) }
+    all, _ := s.Export(ctx, b)
+    if len(all) != rep.Kept || all[len(all)-1].ID != "m4" { t.Fatalf("after compact: %s", ids(all)) }
+
+    if err := s.Purge(ctx, b); err != nil { t.Fatalf("purge: %v", err) }
+    archives, _ = s.ListArchives(ctx, "U_1")
+    if strings.Join(archives, ",") != "a" { t.Fatalf("after purge: %v", archives) }
+}
+
+func TestMigrateDiskToBolt(t *testing.T) {
+    diskRoot, boltRoot := t.TempDir(), t.TempDir()
+    ds, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: diskRoot, MaxFileBytes: 300})
+    if err != nil { t.Fatalf("new disk store: %v", err) }
+    ctx := context.Background()
+    t1, t2 := Tenant{UserID: "u", ArchiveID: "a"}, Tenant{UserID: "v", ArchiveID: "b"}
+    for i := 0; i < 8; i++ {
+        _ = ds.Save(ctx, MemoryItem{ID: fmt.Sprintf("m%d", i), Tenant: t1, Kind: KindNote, Tags: []string{"t"}, Content: strings.Repeat("雪", 20)})
+    }
+    _ = ds.Update(ctx, MemoryItem{ID: "m1", Tenant: t1, Kind: KindFact, Content: "已更新"})
+    _ = ds.Delete(ctx, t1, "m2")
+    _ = ds.Save(ctx, MemoryItem{ID: "x", Tenant: t2, Content: "另一个租户"})
+
+    rep, err := MigrateDiskToBolt(ctx, "ns", DiskJSONOptions{RootPath: diskRoot}, BoltOptions{RootPath: boltRoot}, true)
+    if err != nil || rep.Items() != 8 { t.Fatalf("dry run: %+v %v", rep, err) }
+    for i := 0; i < 2; i++ {
+        // 可重复执行
+        rep, err = MigrateDiskToBolt(ctx, "ns", DiskJSONOptions{RootPath: diskRoot}, BoltOptions{RootPath: boltRoot}, false)
+        if err != nil || len(rep.Tenants) != 2 || rep.Items() != 8 { t.Fatalf("migrate: %+v %v", rep, err) }
+    }
+
+    bs := newTestBoltStore(t, boltRoot)
+    defer bs.Close(ctx)
+    for _, ten := range []Tenant{t1, t2} {
+        want, _ := ds.(*diskJSONStore).Export(ctx, ten)
+        got, _ := bs.Export(ctx, ten)
+        if ids(got) != ids(want) { t.Fatalf("order mismatch: %s vs %s", ids(got), ids(want)) }
+    }
+    r, _ := bs.Query(ctx, QueryRequest{Tenant: t1, Kinds: []MemoryKind{KindFact}})
+    if ids(r.Items) != "m1" || r.Items[0].Content != "已更新" { t.Fatalf("migrated index: %+v", r.Items) }
+}
+
+func TestManager_BoltBackend(t *testing.T) {
+    m := newTestManager(t, func(o *RAGOptions) { o.Bolt.Enable = true })
+    if _, ok := m.disk.(*boltStore); !ok { t.Fatalf("expect bolt backend, got %T", m.disk) }
+
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    if err := m.Save(ctx, MemoryItem{ID: "1", Tenant: ten, Content: "持久化到 bbolt"}, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    if _, err := m.disk.Get(ctx, ten, "1"); err != nil { t.Fatalf("default route should reach bolt: %v", err) }
+    archives, _ := m.ListArchives(ctx, "u")
+    if len(archives) != 1 || archives[0] != "a" { t.Fatalf("archives: %v", archives) }
+}
@@ -0,0 +1,432 @@
+package rag
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "ahs/internal/tenantpath"
+)
+
+// 压缩与保留策略（RetentionOptions）
+// - 后台按 Interval 周期压缩全部租户；也可调用 Manager.Compact 手动触发
+// - 压缩：回放全部段，丢弃墓碑与被原地覆盖的旧记录、已过期条目、超过 MaxDays 的条目，
+//   总大小仍超过 MaxBytes 时从最旧的条目开始丢弃；结果写入单个新段
+// - 保留条目的历史版本（version.go）按版本顺序一并写入，与条目一起计大小、一起丢弃
+// - 原子性：先封存活动段，再以 tmp + fsync + rename 写出首行为 op=base 的新段，最后删除旧段；
+//   任何一步中断，回放结果均与压缩前或压缩后一致
+// - 被丢弃的条目同步从内存缓存与向量库删除
+
+const segmentPrefix = "data-"
+
+// CompactPolicy 单次压缩的保留策略
+type CompactPolicy struct {
+    Now      time.Time
+    MaxAge   time.Duration // <=0 不限
+    MaxBytes int64         // 每租户上限，<=0 不限
+}
+
+// CompactReport 单个租户的压缩结果
+type CompactReport struct {
+    Tenant      Tenant   `json:"tenant"`
+    Rewritten   bool     `json:"rewritten"`              // 是否重写了文件
+    Segments    int      `json:"segments"`               // 参与合并的段数
+    BytesBefore int64    `json:"bytes_before"`
+    BytesAfter  int64    `json:"bytes_after"`
+    Kept        int      `json:"kept"`
+    Obsolete    int      `json:"obsolete"`               // 墓碑与被原地覆盖的旧记录
+    Versions    int      `json:"versions"`               // 随保留条目写入的历史版本记录
+    Expired     int      `json:"expired"`                // ExpiresAt 已过
+    AgedOut     int      `json:"aged_out"`               // 超过 MaxDays
+    OverSize    int      `json:"over_size"`              // 为满足 MaxBytes 丢弃
+    RemovedIDs  []string `json:"removed_ids,omitempty"`  // 被丢弃的有效条目 ID
+}
+
+// CompactSummary 一轮压缩的汇总
+type CompactSummary struct {
+    StartedAt  time.Time       `json:"started_at"`
+    FinishedAt time.Time       `json:"finished_at"`
+    Tenants    []CompactReport `json:"tenants"`
+    Errors     []string        `json:"errors,omitempty"`
+}
+
+// Removed 本轮丢弃的有效条目总数
+func (s CompactSummary) Removed() int {
+    n := 0
+    for _, r := range s.Tenants {
+        n += r.Expired + r.AgedOut + r.OverSize
+    }
+    return n
+}
+
+// Compactor 可压缩的存储（磁盘 JSONL 实现）
+type Compactor interface {
+    Tenants(ctx context.Context) ([]Tenant, error)
+    Compact(ctx context.Context, t Tenant, p CompactPolicy) (CompactReport, error)
+}
+
+// segments 返回租户的封存段路径（按 seq 升序）
+func (s *diskJSONStore) segments(t Tenant) ([]string, error) {
+    dir := filepath.Dir(s.pathOf(t))
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("read dir: %w", err)
+    }
+    type seg struct {
+        seq  int
+        path string
+    }
+    var segs []seg
+    for _, e := range entries {
+        if seq, ok := segmentSeq(e.Name()); ok && !e.IsDir() {
+            segs = append(segs, seg{seq, filepath.Join(dir, e.Name())})
+        }
+    }
+    sort.Slice(segs, func(i, j int) bool { return segs[i].seq < segs[j].seq })
+    res := make([]string, len(segs))
+    for i, sg := range segs {
+        res[i] = sg.path
+    }
+    return res, nil
+}
+
+// segmentSeq 解析 data-{seq}.jsonl
+func segmentSeq(name string) (int, bool) {
+    if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, ".jsonl") {
+        return 0, false
+    }
+    n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), ".jsonl"))
+    return n, err == nil && n >= 0
+}
+
+// nextSegment 返回下一个封存段路径
+func (s *diskJSONStore) nextSegment(t Tenant) (string, error) {
+    segs, err := s.segments(t)
+    if err != nil {
+        return "", err
+    }
+    next := 1
+    if len(segs) > 0 {
+        last, _ := segmentSeq(filepath.Base(segs[len(segs)-1]))
+        next = last + 1
+    }
+    return filepath.Join(filepath.Dir(s.pathOf(t)), fmt.Sprintf("%s%06d.jsonl", segmentPrefix, next)), nil
+}
+
+// Tenants 列出命名空间下全部租户
+func (s *diskJSONStore) Tenants(ctx context.Context) ([]Tenant, error) {
+    users, err := os.ReadDir(s.baseDir())
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("read dir: %w", err)
+    }
+    var res []Tenant
+    for _, u := range users {
+        if !u.IsDir() {
+            continue
+        }
+        userID, err := tenantpath.Decode(u.Name())
+        if err != nil {
+            continue
+        }
+        archives, err := s.ListArchives(ctx, userID)
+        if err != nil {
+            return nil, err
+        }
+        for _, a := range archives {
+            res = append(res, Tenant{UserID: userID, ArchiveID: a})
+        }
+    }
+    return res, nil
+}
+
+// Compact 按策略压缩租户数据，见文件头说明
+func (s *diskJSONStore) Compact(ctx context.Context, t Tenant, p CompactPolicy) (CompactReport, error) {
+    rep := CompactReport{Tenant: t}
+    if p.Now.IsZero() {
+        p.Now = time.Now()
+    }
+
+    err := s.locked(t, false, func() (err error) {
+        rep, err = s.compact(t, p, rep)
+        return err
+    })
+    return rep, err
+}
+
+// compact 压缩租户数据（调用方持有锁）
+func (s *diskJSONStore) compact(t Tenant, p CompactPolicy, rep CompactReport) (CompactReport, error) {
+    segs, err := s.segments(t)
+    if err != nil {
+        return rep, err
+    }
+    files := append(segs, s.pathOf(t))
+    for _, fp := range files {
+        if st, err := os.Stat(fp); err == nil {
+            rep.BytesBefore += st.Size()
+            if st.Size() > 0 {
+                rep.Segments++
+            }
+        }
+    }
+
+    var rp replayer
+    if err := s.replayTenant(t, &rp); err != nil {
+        return rep, err
+    }
+    live := rp.live()
+    history := 0
+    for _, h := range rp.history {
+        history += len(h)
+    }
+    rep.Obsolete = rp.records - len(live) - history
+
+    // 过期与年龄
+    kept := make([]MemoryItem, 0, len(live))
+    var removed []MemoryItem
+    for _, it := range live {
+        switch {
+        case it.ExpiresAt != nil && it.ExpiresAt.Before(p.Now):
+            rep.Expired++
+        case p.MaxAge > 0 && !it.CreatedAt.IsZero() && p.Now.Sub(it.CreatedAt) > p.MaxAge:
+            rep.AgedOut++
+        default:
+            kept = append(kept, it)
+            continue
+        }
+        removed = append(removed, it)
+    }
+
+    // 编码（每个条目一组：历史版本在前，首个为普通记录，其余为 op=update）并按大小上限从最旧的条目开始丢弃
+    lines := make([][]byte, len(kept))
+    var total int64
+    for i := range kept {
+        versions := append(append([]MemoryItem(nil), rp.history[kept[i].ID]...), kept[i])
+        for j := range versions {
+            rec := diskRecord{MemoryItem: versions[j]}
+            if j > 0 {
+                rec.Op = opUpdate
+            }
+            b, err := encodeLine(&rec)
+            if err != nil {
+                return rep, err
+            }
+            lines[i] = append(append(lines[i], b...), '\n')
+        }
+        total += int64(len(lines[i]))
+    }
+    start := 0
+    if p.MaxBytes > 0 {
+        for start < len(kept) && total > p.MaxBytes {
+            total -= int64(len(lines[start]))
+            removed = append(removed, kept[start])
+            rep.OverSize++
+            start++
+        }
+    }
+    kept, lines = kept[start:], lines[start:]
+    rep.Kept = len(kept)
+    for _, it := range kept {
+        rep.Versions += len(rp.history[it.ID])
+    }
+    for _, it := range removed {
+        if it.ID != "" {
+            rep.RemovedIDs = append(rep.RemovedIDs, it.ID)
+        }
+    }
+
+    // 无可回收内容且只有一个段时不重写
+    if rep.Obsolete == 0 && len(removed) == 0 && rep.Segments <= 1 {
+        rep.BytesAfter = rep.BytesBefore
+        return rep, nil
+    }
+
+    if _, err := s.seal(t); err != nil {
+        return rep, err
+    }
+    old, err := s.segments(t)
+    if err != nil {
+        return rep, err
+    }
+    dst, err := s.nextSegment(t)
+    if err != nil {
+        return rep, err
+    }
+    size, err := writeSegment(dst, lines)
+    if err != nil {
+        return rep, err
+    }
+    for _, fp := range old {
+        if err := os.Remove(fp); err != nil && !os.IsNotExist(err) {
+            return rep, fmt.Errorf("remove segment: %w", err)
+        }
+        _ = os.Remove(sidecarPath(fp))
+    }
+    delete(s.tenants, s.pathOf(t))
+    rep.Rewritten = true
+    rep.BytesAfter = size
+    return rep, nil
+}
+
+// writeSegment 以 tmp + fsync + rename 原子写出压缩段（首行为 op=base）
+func writeSegment(dst string, lines [][]byte) (int64, error) {
+    tmp := dst + ".tmp"
+    f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+    if err != nil {
+        return 0, fmt.Errorf("open file: %w", err)
+    }
+    base, _ := encodeLine(&diskRecord{Op: opBase})
+    size := int64(0)
+    write := func(b []byte) error {
+        n, err := f.Write(b)
+        size += int64(n)
+        return err
+    }
+    err = write(append(base, '\n'))
+    for i := 0; err == nil && i < len(lines); i++ {
+        err = write(lines[i])
+    }
+    if err == nil {
+        err = f.Sync()
+    }
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        _ = os.Remove(tmp)
+        return 0, fmt.Errorf("write segment: %w", err)
+    }
+    if err := os.Rename(tmp, dst); err != nil {
+        _ = os.Remove(tmp)
+        return 0, fmt.Errorf("rename segment: %w", err)
+    }
+    if d, err := os.Open(filepath.Dir(dst)); err == nil {
+        _ = d.Sync()
+        _ = d.Close()
+    }
+    return size, nil
+}
+
+// compactor Manager 的后台压缩状态
+type compactor struct {
+    mu   sync.Mutex // 串行化压缩轮次
+    last *CompactSummary
+    stop chan struct{}
+    done chan struct{}
+}
+
+// retentionPolicy 由 RetentionOptions 生成策略
+func (m *Manager) retentionPolicy() CompactPolicy {
+    p := CompactPolicy{Now: time.Now(), MaxBytes: m.opts.Retention.MaxBytes}
+    if m.opts.Retention.MaxDays > 0 {
+        p.MaxAge = time.Duration(m.opts.Retention.MaxDays) * 24 * time.Hour
+    }
+    return p
+}
+
+// Compact 按 RetentionOptions 压缩磁盘存储的全部租户，返回本轮汇总
+// 单个租户失败不影响其他租户，错误记入 Errors
+func (m *Manager) Compact(ctx context.Context) (CompactSummary, error) {
+    c, ok := m.disk.(Compactor)
+    if !ok {
+        return CompactSummary{}, errors.New("磁盘存储未启用或不支持压缩")
+    }
+    m.compact.mu.Lock()
+    defer m.compact.mu.Unlock()
+
+    sum := CompactSummary{StartedAt: time.Now()}
+    tenants, err := c.Tenants(ctx)
+    if err != nil {
+        return sum, err
+    }
+    policy := m.retentionPolicy()
+    for _, t := range tenants {
+        if ctx.Err() != nil {
+            sum.Errors = append(sum.Errors, ctx.Err().Error())
+            break
+        }
+        rep, err := c.Compact(ctx, t, policy)
+        if err != nil {
+            sum.Errors = append(sum.Errors, fmt.Sprintf("%s/%s: %v", t.UserID, t.ArchiveID, err))
+            continue
+        }
+        m.evictRemoved(ctx, t, rep.RemovedIDs)
+        sum.Tenants = append(sum.Tenants, rep)
+    }
+    sum.FinishedAt = time.Now()
+    m.compact.last = &sum
+    return sum, nil
+}
+
+// evictRemoved 将压缩丢弃的条目从内存缓存、向量库与检索统计删除
+func (m *Manager) evictRemoved(ctx context.Context, t Tenant, ids []string) {
+    m.access.forget(t, ids...)
+    vd, hasVD := m.vec.(vectorDeleter)
+    for _, id := range ids {
+        if m.mem != nil {
+            _ = m.mem.Delete(ctx, t, id)
+        }
+        if hasVD {
+            _ = vd.Delete(ctx, t, id)
+        }
+    }
+}
+
+// LastCompaction 最近一轮压缩的汇总
+func (m *Manager) LastCompaction() (CompactSummary, bool) {
+    m.compact.mu.Lock()
+    defer m.compact.mu.Unlock()
+    if m.compact.last == nil {
+        return CompactSummary{}, false
+    }
+    return *m.compact.last, true
+}
+
+// startCompactor 启动后台压缩
+func (m *Manager) startCompactor() {
+    if !m.opts.Retention.Enable || m.disk == nil {
+        return
+    }
+    interval := m.opts.Retention.Interval
+    if interval <= 0 {
+        interval = time.Hour
+    }
+    m.compact.stop = make(chan struct{})
+    m.compact.done = make(chan struct{})
+    go func() {
+        defer close(m.compact.done)
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-m.compact.stop:
+                return
+            case <-ticker.C:
+                ctx, cancel := context.WithTimeout(context.Background(), interval)
+                _, _ = m.Compact(ctx)
+                cancel()
+            }
+        }
+    }()
+}
+
+// stopCompactor 停止后台压缩并等待当前轮次结束
+func (m *Manager) stopCompactor() {
+    if m.compact.stop == nil {
+        return
+    }
+    close(m.compact.stop)
+    <-m.compact.done
+}
@@ -0,0 +1,136 @@
+package rag
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func newTestDiskStore(t *testing.T, maxFileBytes int64) *diskJSONStore {
+    t.Helper()
+    st, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: t.TempDir(), MaxFileBytes: maxFileBytes})
+    if err != nil { t.Fatalf("new disk store: %v", err) }
+    return st.(*diskJSONStore)
+}
+
+func dataFiles(t *testing.T, s *diskJSONStore, ten Tenant) []string {
+    t.Helper()
+    entries, _ := os.ReadDir(filepath.Dir(s.pathOf(ten)))
+    var names []string
+    for _, e := range entries { names = append(names, e.Name()) }
+    return names
+}
+
+func TestDiskCompact_DropsObsoleteExpiredAndAged(t *testing.T) {
+    s := newTestDiskStore(t, 0)
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    now := time.Now()
+    past := now.Add(-time.Minute)
+
+    items := []MemoryItem{
+        {ID: "old", Tenant: ten, Content: "很久以前", CreatedAt: now.AddDate(0, 0, -40)},
+        {ID: "exp", Tenant: ten, Content: "已过期", CreatedAt: now, ExpiresAt: &past},
+        {ID: "upd", Tenant: ten, Content: "v1", CreatedAt: now},
+        {ID: "del", Tenant: ten, Content: "将删除", CreatedAt: now},
+        {ID: "keep", Tenant: ten, Content: "保留", CreatedAt: now},
+    }
+    for _, it := range items {
+        if err := s.Save(ctx, it); err != nil { t.Fatalf("save: %v", err) }
+    }
+    upd := items[2]
+    upd.Content = "v2"
+    if err := s.Update(ctx, upd); err != nil { t.Fatalf("update: %v", err) }
+    if err := s.Delete(ctx, ten, "del"); err != nil { t.Fatalf("delete: %v", err) }
+
+    rep, err := s.Compact(ctx, ten, CompactPolicy{Now: now, MaxAge: 30 * 24 * time.Hour})
+    if err != nil { t.Fatalf("compact: %v", err) }
+    // 记录：5 条保存 + 1 更新 + 1 墓碑 = 7，有效 4 条
+    if !rep.Rewritten || rep.Obsolete != 3 || rep.Expired != 1 || rep.AgedOut != 1 || rep.Kept != 2 {
+        t.Fatalf("unexpected report: %+v", rep)
+    }
+    if strings.Join(rep.RemovedIDs, ",") != "old,exp" { t.Fatalf("removed ids: %v", rep.RemovedIDs) }
+    if rep.BytesAfter >= rep.BytesBefore { t.Fatalf("expect smaller file: %+v", rep) }
+
+    all, _ := s.readAll(ten)
+    if len(all) != 2 || all[0].ID != "upd" || all[0].Content != "v2" || all[1].ID != "keep" { t.Fatalf("after compact: %+v", all) }
+    if files := dataFiles(t, s, ten); len(files) != 1 || !strings.HasPrefix(files[0], segmentPrefix) { t.Fatalf("expect single segment: %v", files) }
+
+    // 压缩后继续写入与查询；再次压缩无可回收内容时不重写
+    if err := s.Save(ctx, MemoryItem{ID: "new", Tenant: ten, Content: "新写入", CreatedAt: now}); err != nil { t.Fatalf("save: %v", err) }
+    qr, _ := s.Query(ctx, QueryRequest{Tenant: ten, Query: "新写入"})
+    if len(qr.Items) != 1 || qr.Items[0].ID != "new" { t.Fatalf("query after compact: %+v", qr.Items) }
+    rep, _ = s.Compact(ctx, ten, CompactPolicy{Now: now})
+    if !rep.Rewritten || rep.Segments != 2 || rep.Kept != 3 { t.Fatalf("merge segments: %+v", rep) }
+    rep, _ = s.Compact(ctx, ten, CompactPolicy{Now: now})
+    if rep.Rewritten { t.Fatalf("expect no rewrite: %+v", rep) }
+}
+
+func TestDiskCompact_RotationAndMaxBytes(t *testing.T) {
+    s := newTestDiskStore(t, 200)
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    base := time.Now().Add(-time.Hour)
+    for i := 0; i < 10; i++ {
+        it := MemoryItem{ID: fmt.Sprintf("m%02d", i), Tenant: ten, Content: strings.Repeat("字", 20), CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+        if err := s.Save(ctx, it); err != nil { t.Fatalf("save: %v", err) }
+    }
+    segs, _ := s.segments(ten)
+    if len(segs) < 3 { t.Fatalf("expect rotated segments, got %v", dataFiles(t, s, ten)) }
+    all, _ := s.readAll(ten)
+    if len(all) != 10 || all[0].ID != "m00" || all[9].ID != "m09" { t.Fatalf("read across segments: %d", len(all)) }
+
+    // 上限只够约 3 条：从最旧的条目开始丢弃
+    rep, err := s.Compact(ctx, ten, CompactPolicy{MaxBytes: 600})
+    if err != nil { t.Fatalf("compact: %v", err) }
+    if rep.OverSize == 0 || rep.Kept+rep.OverSize != 10 || rep.RemovedIDs[0] != "m00" { t.Fatalf("unexpected report: %+v", rep) }
+    all, _ = s.readAll(ten)
+    if len(all) != rep.Kept || all[len(all)-1].ID != "m09" { t.Fatalf("expect newest kept: %+v", all) }
+}
+
+func TestDiskCompact_InterruptedBeforeCleanupReplaysBase(t *testing.T) {
+    s := newTestDiskStore(t, 0)
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    for _, id := range []string{"a", "b", "c"} {
+        _ = s.Save(ctx, MemoryItem{ID: id, Tenant: ten, Content: id, CreatedAt: time.Now()})
+    }
+    // 模拟压缩写出新段后、删除旧段前中断
+    if _, err := s.seal(ten); err != nil { t.Fatalf("seal: %v", err) }
+    dst, _ := s.nextSegment(ten)
+    line := []byte(`{"id":"b","tenant":{"user_id":"u","archive_id":"a"},"content":"b","created_at":"2024-01-01T00:00:00Z"}` + "\n")
+    if _, err := writeSegment(dst, [][]byte{line}); err != nil { t.Fatalf("write segment: %v", err) }
+
+    all, _ := s.readAll(ten)
+    if len(all) != 1 || all[0].ID != "b" { t.Fatalf("base segment should supersede older ones: %+v", all) }
+}
+
+func TestManager_CompactEvictsCacheAndReports(t *testing.T) {
+    m := newTestManager(t, func(o *RAGOptions) { o.Retention = RetentionOptions{Enable: true, MaxDays: 7, Interval: 20 * time.Millisecond} })
+
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    _ = m.Save(ctx, MemoryItem{ID: "old", Tenant: ten, Content: "旧事", CreatedAt: time.Now().AddDate(0, 0, -10)}, SaveOptions{})
+    _ = m.Save(ctx, MemoryItem{ID: "new", Tenant: ten, Content: "新事", CreatedAt: time.Now()}, SaveOptions{})
+
+    // 后台压缩按周期运行并记录汇总
+    deadline := time.Now().Add(2 * time.Second)
+    for {
+        if sum, ok := m.LastCompaction(); ok && sum.Removed() == 1 {
+            break
+        }
+        if time.Now().After(deadline) { t.Fatalf("background compaction did not run") }
+        time.Sleep(10 * time.Millisecond)
+    }
+    if _, err := m.Get(ctx, ten, "old"); !errors.Is(err, ErrNotFound) { t.Fatalf("aged item should be evicted from cache: %v", err) }
+    if _, err := m.Get(ctx, ten, "new"); err != nil { t.Fatalf("get new: %v", err) }
+
+    // 无磁盘存储时手动压缩报错
+    m2 := newTestManager(t, func(o *RAGOptions) { o.DiskJSON.Enable = false })
+    if _, err := m2.Compact(ctx); err == nil { t.Fatalf("expect error without disk store") }
+}
@@ -1,25 +1,44 @@
 package rag
 
-import "time"
+import (
+    "errors"
+    "fmt"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "ahs/internal/config"
+)
 
 // RAGOptions 记忆系统配置
 // - InMemory: 进程内缓存
 // - DiskJSON: 本地 JSONL 持久化
-// - Vector/Triple: 外部服务（HTTP）占位，后续对接
+// - Bolt: 本地 bbolt 事务型持久化；启用时替代 DiskJSON（可用 cmd/ragmigrate 导入已有 JSONL 数据）
+// - Vector: 向量检索；Endpoint 为空时使用本地向量库（RootPath 持久化），否则使用 HTTP 客户端；向量由 Embedder 生成
+// - Triple: 三元组（知识图谱）；Endpoint 为空时使用本地三元组库（RootPath 持久化），否则使用 HTTP 客户端（协议见 http_client.go）
+// - Retrieval: 混合检索（融合、权重、单后端超时）
+// - Ranking: 按相关度、重要性与时间衰减排序，权重按记忆类型配置
+// - Dedupe: 保存时检测近重复条目并合并
 // - Async: 异步写入配置
-// - Retention: 预留压缩/保留策略
+// - Retention: 保留策略与后台压缩
+// - Consolidation: 以 LLM 将短期记忆整理为长期记忆/事实（需 SetSummarizer）
 // - Namespace: 预留命名空间
 // - ServiceMode: 偏好服务化/HTTP 对外
 // 说明：遵循用户偏好，默认启用 JSON 持久化与异步写入。
 type RAGOptions struct {
-    InMemory    InMemoryOptions
-    DiskJSON    DiskJSONOptions
-    Vector      VectorOptions
-    Triple      TripleOptions
-    Async       AsyncOptions
-    Retention   RetentionOptions
-    Namespace   string
-    ServiceMode bool // 预留：服务接口模式
+    InMemory      InMemoryOptions
+    DiskJSON      DiskJSONOptions
+    Bolt          BoltOptions
+    Vector        VectorOptions
+    Triple        TripleOptions
+    Retrieval     RetrievalOptions
+    Ranking       RankingOptions
+    Dedupe        DedupeOptions
+    Async         AsyncOptions
+    Retention     RetentionOptions
+    Consolidation ConsolidationOptions
+    Namespace     string
+    ServiceMode   bool // 预留：服务接口模式
 }
 
 type InMemoryOptions struct {
@@ -31,40 +50,117 @@ type InMemoryOptions struct {
 type DiskJSONOptions struct {
     Enable    bool
     RootPath  string // 数据根目录
-    // 预留：按大小轮转、合并压缩
+    // 活动段超过该大小时封存为新段（<=0 不轮转），封存段由压缩合并
     MaxFileBytes int64
+    // 刷盘策略：none（默认，交给操作系统）/ always（每条记录 fsync）/ group（组提交）
+    Sync string
+}
+
+type BoltOptions struct {
+    Enable   bool
+    RootPath string        // 数据根目录，数据库文件为 {RootPath}/{Namespace}.db
+    Timeout  time.Duration // 等待数据库文件锁的超时（<=0 为 1s）
 }
 
 type VectorOptions struct {
     Enable   bool
+    RootPath string // 本地向量库数据根目录（Endpoint 为空时使用）
     Endpoint string
     APIKey   string
     Index    string
-    // Embedding 配置预留
+    // Embedding：provider 为 hash（默认，本地确定性）或 openai（OpenAI 兼容接口）
     EmbeddingProvider string
     EmbeddingModel    string
     EmbeddingEndpoint string
     EmbeddingAPIKey   string
     Dim               int
+    HTTP              HTTPClientOptions // Endpoint 非空时生效
 }
 
 type TripleOptions struct {
     Enable   bool
+    RootPath string // 本地三元组库数据根目录（Endpoint 为空时使用）
     Endpoint string
     APIKey   string
-    SchemaVersion string
+    SchemaVersion string // 三元组格式版本，为空使用 TripleSchemaVersion
+    HTTP          HTTPClientOptions
+}
+
+// HTTPClientOptions 外部检索服务的 HTTP 客户端配置
+// 网络错误、429 与 5xx 按指数退避重试（Retry-After 优先），其余错误不重试
+type HTTPClientOptions struct {
+    Timeout      time.Duration // 单次请求超时
+    MaxRetries   int           // 最大重试次数（不含首次）
+    RetryBackoff time.Duration // 首次重试等待，之后翻倍
+}
+
+// RetrievalOptions 混合检索配置（见 hybrid.go）
+// 权重 <=0 视为 1；BackendTimeout <=0 表示不单独限时
+type RetrievalOptions struct {
+    Fusion         string        // rrf（默认）或 weighted
+    RRFK           int           // RRF 常数 k，<=0 使用 DefaultRRFK
+    LocalWeight    float64
+    VectorWeight   float64
+    TripleWeight   float64
+    BackendTimeout time.Duration // 单个后端（含重排器）的检索超时
+}
+
+// RankingOptions 重要性与时间衰减排序（见 ranking.go）
+// 仅作用于 score 排序，在重排之前；Enable 为 false 时按融合分数排序
+type RankingOptions struct {
+    Enable              bool
+    Default             RankWeights                // 未在 Kinds 中配置的类型
+    Kinds               map[MemoryKind]RankWeights // 按类型整体替换 Default
+    DefaultImportance   float64                    // 未设置 Importance 的条目按此值计
+    Candidates          int                        // 每个后端至少取回的候选数，<=0 只取当前页所需
+    AccessFlushInterval time.Duration              // 检索统计快照的周期，<=0 使用 1 分钟
+    AccessPath          string                     // 检索统计快照目录（按 Namespace 分子目录），为空时只保存在内存，重启后丢失
 }
 
+// RankWeights 单一类型的排序权重
+type RankWeights struct {
+    Relevance  float64
+    Importance float64
+    Recency    float64
+    HalfLife   time.Duration // 时间衰减半衰期，<=0 不衰减
+}
+
+// AsyncOptions 异步写入；可靠性（预写日志、重试、死信）见 async.go
 type AsyncOptions struct {
-    Enable    bool
-    QueueSize int
-    Workers   int
+    Enable       bool
+    QueueSize    int
+    Workers      int
+    SpoolPath    string        // 预写日志目录（按 Namespace 分子目录），为空时不启用，进程退出时队列中的写入丢失
+    SpoolSync    bool          // 每条入队记录 fsync 后返回，完成标记同样 fsync
+    MaxRetries   int           // 失败重试次数，<0 不重试
+    RetryBackoff time.Duration // 首次重试间隔，之后指数退避；<=0 使用 100ms
 }
 
+// RetentionOptions 磁盘数据保留策略，Enable 时后台按 Interval 压缩（见 compact.go）
 type RetentionOptions struct {
-    Enable  bool
-    MaxDays int
-    MaxBytes int64
+    Enable   bool
+    MaxDays  int           // 条目最长保留天数，<=0 不限
+    MaxBytes int64         // 每租户数据上限（字节），<=0 不限；超出时丢弃最旧的条目
+    Interval time.Duration // 压缩周期，<=0 使用 1 小时
+}
+
+// DedupeOptions 保存时的近重复检测（见 dedupe.go）
+type DedupeOptions struct {
+    Enable          bool
+    Threshold       float64      // 规范化文本 Shingle 集合的 Jaccard 系数阈值 (0,1]
+    Shingle         int          // Shingle 长度（字符），<=0 使用 2
+    Candidates      int          // 参与比较的全文检索候选数，<=0 使用 10
+    VectorThreshold float64      // 启用向量后端时的相似度阈值，<=0 不使用向量
+    Kinds           []MemoryKind // 参与检测的类型，为空表示全部
+}
+
+// ConsolidationOptions 短期记忆整理（见 consolidate.go），Enable 且设置了 Summarizer 时后台运行
+type ConsolidationOptions struct {
+    Enable    bool
+    Interval  time.Duration // 整理全部租户的周期，<=0 使用 1 小时
+    Threshold int           // 租户新增短期记忆达到该数量时立即整理，<=0 只按周期
+    BatchSize int           // 每次交给摘要器的条数，<=0 使用 20
+    MinAge    time.Duration // 只整理创建超过该时长的短期记忆
 }
 
 // DefaultOptions 返回符合用户偏好的默认配置
@@ -80,15 +176,305 @@ func DefaultOptions() RAGOptions {
             RootPath:    "data/rag",
             MaxFileBytes: 0,
         },
-        Vector: VectorOptions{Enable: false},
-        Triple: TripleOptions{Enable: false},
+        Bolt: BoltOptions{
+            Enable:   false,
+            RootPath: "data/rag_bolt",
+            Timeout:  time.Second,
+        },
+        Vector: VectorOptions{
+            Enable:            false,
+            RootPath:          "data/rag_vector",
+            EmbeddingProvider: EmbeddingProviderHash,
+            Dim:               DefaultEmbeddingDim,
+            HTTP:              DefaultHTTPClientOptions(),
+        },
+        Triple: TripleOptions{
+            Enable:        false,
+            RootPath:      "data/rag_triple",
+            SchemaVersion: TripleSchemaVersion,
+            HTTP:          DefaultHTTPClientOptions(),
+        },
+        Retrieval: DefaultRetrievalOptions(),
+        Ranking:   DefaultRankingOptions(),
+        Dedupe: DedupeOptions{
+            Enable:          false,
+            Threshold:       0.8,
+            Shingle:         2,
+            Candidates:      10,
+            VectorThreshold: 0.95,
+        },
         Async: AsyncOptions{
-            Enable:    true,
-            QueueSize: 1024,
-            Workers:   1,
+            Enable:       true,
+            QueueSize:    1024,
+            Workers:      1,
+            SpoolPath:    "data/rag_spool",
+            MaxRetries:   3,
+            RetryBackoff: 200 * time.Millisecond,
+        },
+        Retention: RetentionOptions{Enable: false, Interval: time.Hour},
+        Consolidation: ConsolidationOptions{
+            Enable:    false,
+            Interval:  time.Hour,
+            Threshold: 50,
+            BatchSize: 20,
+            MinAge:    30 * time.Minute,
         },
-        Retention: RetentionOptions{Enable: false},
         Namespace:   "default",
         ServiceMode: true,
     }
 }
+
+// DefaultHTTPClientOptions 外部检索服务的默认 HTTP 配置
+func DefaultHTTPClientOptions() HTTPClientOptions {
+    return HTTPClientOptions{
+        Timeout:      5 * time.Second,
+        MaxRetries:   2,
+        RetryBackoff: 200 * time.Millisecond,
+    }
+}
+
+// DefaultRetrievalOptions 混合检索默认配置
+func DefaultRetrievalOptions() RetrievalOptions {
+    return RetrievalOptions{
+        Fusion:         FusionRRF,
+        RRFK:           DefaultRRFK,
+        LocalWeight:    1,
+        VectorWeight:   1,
+        TripleWeight:   1,
+        BackendTimeout: 3 * time.Second,
+    }
+}
+
+// DefaultRankingOptions 默认排序：事实不随时间衰减，短期记忆一天减半
+func DefaultRankingOptions() RankingOptions {
+    return RankingOptions{
+        Enable:  true,
+        Default: RankWeights{Relevance: 1, Importance: 0.5, Recency: 0.5, HalfLife: 7 * 24 * time.Hour},
+        Kinds: map[MemoryKind]RankWeights{
+            KindShortTerm: {Relevance: 1, Importance: 0.3, Recency: 1, HalfLife: 24 * time.Hour},
+            KindLongTerm:  {Relevance: 1, Importance: 0.5, Recency: 0.3, HalfLife: 90 * 24 * time.Hour},
+            KindFact:      {Relevance: 1, Importance: 0.5, Recency: 0},
+        },
+        DefaultImportance:   0.5,
+        Candidates:          50,
+        AccessFlushInterval: time.Minute,
+        AccessPath:          "data/rag_access",
+    }
+}
+
+// OptionsFromConfig 将配置文件的 rag 段映射为 RAGOptions 并校验
+func OptionsFromConfig(c config.RAGConfig) (RAGOptions, error) {
+    httpOpts := func(h config.RAGHTTPConfig) HTTPClientOptions {
+        return HTTPClientOptions{Timeout: h.Timeout, MaxRetries: h.MaxRetries, RetryBackoff: h.RetryBackoff}
+    }
+    weights := func(w config.RAGRankWeightsConfig) RankWeights {
+        return RankWeights{Relevance: w.Relevance, Importance: w.Importance, Recency: w.Recency, HalfLife: w.HalfLife}
+    }
+    var dedupeKinds []MemoryKind
+    for _, k := range c.Dedupe.Kinds {
+        dedupeKinds = append(dedupeKinds, MemoryKind(k))
+    }
+    var kinds map[MemoryKind]RankWeights
+    if len(c.Ranking.Kinds) > 0 {
+        kinds = make(map[MemoryKind]RankWeights, len(c.Ranking.Kinds))
+        for k, w := range c.Ranking.Kinds {
+            kinds[MemoryKind(k)] = weights(w)
+        }
+    }
+    opts := RAGOptions{
+        InMemory: InMemoryOptions{
+            Enable:     c.InMemory.Enabled,
+            MaxEntries: c.InMemory.MaxEntries,
+            TTL:        c.InMemory.TTL,
+        },
+        DiskJSON: DiskJSONOptions{
+            Enable:       c.DiskJSON.Enabled,
+            RootPath:     c.DiskJSON.RootPath,
+            MaxFileBytes: c.DiskJSON.MaxFileBytes,
+            Sync:         c.DiskJSON.Sync,
+        },
+        Bolt: BoltOptions{
+            Enable:   c.Bolt.Enabled,
+            RootPath: c.Bolt.RootPath,
+            Timeout:  c.Bolt.Timeout,
+        },
+        Vector: VectorOptions{
+            Enable:            c.Vector.Enabled,
+            RootPath:          c.Vector.RootPath,
+            Endpoint:          c.Vector.Endpoint,
+            APIKey:            c.Vector.APIKey,
+            Index:             c.Vector.Index,
+            EmbeddingProvider: c.Vector.EmbeddingProvider,
+            EmbeddingModel:    c.Vector.EmbeddingModel,
+            EmbeddingEndpoint: c.Vector.EmbeddingEndpoint,
+            EmbeddingAPIKey:   c.Vector.EmbeddingAPIKey,
+            Dim:               c.Vector.Dim,
+            HTTP:              httpOpts(c.Vector.HTTP),
+        },
+        Triple: TripleOptions{
+            Enable:        c.Triple.Enabled,
+            RootPath:      c.Triple.RootPath,
+            Endpoint:      c.Triple.Endpoint,
+            APIKey:        c.Triple.APIKey,
+            SchemaVersion: c.Triple.SchemaVersion,
+            HTTP:          httpOpts(c.Triple.HTTP),
+        },
+        Retrieval: RetrievalOptions{
+            Fusion:         c.Retrieval.Fusion,
+            RRFK:           c.Retrieval.RRFK,
+            LocalWeight:    c.Retrieval.LocalWeight,
+            VectorWeight:   c.Retrieval.VectorWeight,
+            TripleWeight:   c.Retrieval.TripleWeight,
+            BackendTimeout: c.Retrieval.BackendTimeout,
+        },
+        Ranking: RankingOptions{
+            Enable:              c.Ranking.Enabled,
+            Default:             weights(c.Ranking.Default),
+            Kinds:               kinds,
+            DefaultImportance:   c.Ranking.DefaultImportance,
+            Candidates:          c.Ranking.Candidates,
+            AccessFlushInterval: c.Ranking.AccessFlushInterval,
+            AccessPath:          c.Ranking.AccessPath,
+        },
+        Dedupe: DedupeOptions{
+            Enable:          c.Dedupe.Enabled,
+            Threshold:       c.Dedupe.Threshold,
+            Shingle:         c.Dedupe.Shingle,
+            Candidates:      c.Dedupe.Candidates,
+            VectorThreshold: c.Dedupe.VectorThreshold,
+            Kinds:           dedupeKinds,
+        },
+        Async: AsyncOptions{
+            Enable:       c.Async.Enabled,
+            QueueSize:    c.Async.QueueSize,
+            Workers:      c.Async.Workers,
+            SpoolPath:    c.Async.SpoolPath,
+            SpoolSync:    c.Async.SpoolSync,
+            MaxRetries:   c.Async.MaxRetries,
+            RetryBackoff: c.Async.RetryBackoff,
+        },
+        Retention: RetentionOptions{
+            Enable:   c.Retention.Enabled,
+            MaxDays:  c.Retention.MaxDays,
+            MaxBytes: c.Retention.MaxBytes,
+            Interval: c.Retention.Interval,
+        },
+        Consolidation: ConsolidationOptions{
+            Enable:    c.Consolidation.Enabled,
+            Interval:  c.Consolidation.Interval,
+            Threshold: c.Consolidation.Threshold,
+            BatchSize: c.Consolidation.BatchSize,
+            MinAge:    c.Consolidation.MinAge,
+        },
+        Namespace:   c.Namespace,
+        ServiceMode: true,
+    }
+    if err := opts.Validate(); err != nil {
+        return RAGOptions{}, err
+    }
+    return opts, nil
+}
+
+// Validate 校验配置，返回全部问题；NewManager 创建前调用
+func (o RAGOptions) Validate() error {
+    var errs []error
+    check := func(bad bool, format string, args ...any) {
+        if bad {
+            errs = append(errs, fmt.Errorf(format, args...))
+        }
+    }
+    negative := func(name string, d time.Duration) {
+        check(d < 0, "%s 不能为负数: %s", name, d)
+    }
+
+    ns := o.Namespace
+    check(ns == "" || ns == "." || ns == ".." || filepath.Base(ns) != ns || strings.ContainsAny(ns, `/\`),
+        "Namespace 必须是单级目录名: %q", ns)
+
+    check(o.InMemory.MaxEntries < 0, "InMemory.MaxEntries 不能为负数: %d", o.InMemory.MaxEntries)
+    negative("InMemory.TTL", o.InMemory.TTL)
+
+    if o.DiskJSON.Enable && !o.Bolt.Enable {
+        check(o.DiskJSON.RootPath == "", "DiskJSON.RootPath 不能为空")
+        check(o.DiskJSON.MaxFileBytes < 0, "DiskJSON.MaxFileBytes 不能为负数: %d", o.DiskJSON.MaxFileBytes)
+        if _, err := validSync(o.DiskJSON.Sync); err != nil {
+            errs = append(errs, err)
+        }
+    }
+    if o.Bolt.Enable {
+        check(o.Bolt.RootPath == "", "Bolt.RootPath 不能为空")
+        negative("Bolt.Timeout", o.Bolt.Timeout)
+    }
+
+    httpChecks := func(prefix string, h HTTPClientOptions) {
+        negative(prefix+".HTTP.Timeout", h.Timeout)
+        negative(prefix+".HTTP.RetryBackoff", h.RetryBackoff)
+        check(h.MaxRetries < 0, "%s.HTTP.MaxRetries 不能为负数: %d", prefix, h.MaxRetries)
+    }
+    if v := o.Vector; v.Enable {
+        check(v.Endpoint == "" && v.RootPath == "", "Vector.RootPath 与 Vector.Endpoint 不能同时为空")
+        check(v.Dim < 0, "Vector.Dim 不能为负数: %d", v.Dim)
+        switch strings.ToLower(v.EmbeddingProvider) {
+        case "", EmbeddingProviderHash:
+        case EmbeddingProviderOpenAI:
+            check(v.EmbeddingEndpoint == "", "Vector.EmbeddingEndpoint 不能为空（openai）")
+            check(v.EmbeddingModel == "", "Vector.EmbeddingModel 不能为空（openai）")
+            check(v.Dim <= 0, "使用 openai embedding 时必须配置 Vector.Dim")
+        default:
+            check(true, "Vector.EmbeddingProvider 不支持: %s", v.EmbeddingProvider)
+        }
+        httpChecks("Vector", v.HTTP)
+    }
+    if tr := o.Triple; tr.Enable {
+        check(tr.Endpoint == "" && tr.RootPath == "", "Triple.RootPath 与 Triple.Endpoint 不能同时为空")
+        // 本地三元组库只支持当前格式版本；HTTP 后端原样透传
+        check(tr.Endpoint == "" && tr.SchemaVersion != "" && tr.SchemaVersion != TripleSchemaVersion,
+            "Triple.SchemaVersion 不支持: %s（当前 %s）", tr.SchemaVersion, TripleSchemaVersion)
+        httpChecks("Triple", tr.HTTP)
+    }
+
+    r := o.Retrieval
+    check(r.Fusion != "" && !strings.EqualFold(r.Fusion, FusionRRF) && !strings.EqualFold(r.Fusion, FusionWeighted),
+        "Retrieval.Fusion 不支持: %s", r.Fusion)
+    check(r.RRFK < 0, "Retrieval.RRFK 不能为负数: %d", r.RRFK)
+    check(r.LocalWeight < 0 || r.VectorWeight < 0 || r.TripleWeight < 0, "Retrieval 权重不能为负数")
+    negative("Retrieval.BackendTimeout", r.BackendTimeout)
+
+    if rk := o.Ranking; rk.Enable {
+        checkWeights := func(name string, w RankWeights) {
+            check(w.Relevance < 0 || w.Importance < 0 || w.Recency < 0, "%s 权重不能为负数", name)
+            negative(name+".HalfLife", w.HalfLife)
+        }
+        checkWeights("Ranking.Default", rk.Default)
+        for k, w := range rk.Kinds {
+            checkWeights(fmt.Sprintf("Ranking.Kinds[%s]", k), w)
+        }
+        check(rk.DefaultImportance < 0 || rk.DefaultImportance > 1, "Ranking.DefaultImportance 必须在 0 到 1 之间: %g", rk.DefaultImportance)
+        check(rk.Candidates < 0, "Ranking.Candidates 不能为负数: %d", rk.Candidates)
+        negative("Ranking.AccessFlushInterval", rk.AccessFlushInterval)
+    }
+
+    if d := o.Dedupe; d.Enable {
+        check(d.Threshold <= 0 || d.Threshold > 1, "Dedupe.Threshold 必须在 0 到 1 之间（不含 0）: %g", d.Threshold)
+        check(d.Shingle < 0, "Dedupe.Shingle 不能为负数: %d", d.Shingle)
+        check(d.Candidates < 0, "Dedupe.Candidates 不能为负数: %d", d.Candidates)
+    }
+
+    if a := o.Async; a.Enable {
+        check(a.QueueSize < 0, "Async.QueueSize 不能为负数: %d", a.QueueSize)
+        check(a.Workers < 0, "Async.Workers 不能为负数: %d", a.Workers)
+        negative("Async.RetryBackoff", a.RetryBackoff)
+    }
+    if rt := o.Retention; rt.Enable {
+        check(rt.MaxDays < 0, "Retention.MaxDays 不能为负数: %d", rt.MaxDays)
+        check(rt.MaxBytes < 0, "Retention.MaxBytes 不能为负数: %d", rt.MaxBytes)
+        negative("Retention.Interval", rt.Interval)
+    }
+    if co := o.Consolidation; co.Enable {
+        negative("Consolidation.Interval", co.Interval)
+        negative("Consolidation.MinAge", co.MinAge)
+        check(co.Threshold < 0, "Consolidation.Threshold 不能为负数: %d", co.Threshold)
+        check(co.BatchSize < 0, "Consolidation.BatchSize 不能为负数: %d", co.BatchSize)
+    }
+    return errors.Join(errs...)
+}
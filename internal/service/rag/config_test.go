@@ -0,0 +1,67 @@
+package rag
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "ahs/internal/config"
+)
+
+func loadRAGConfig(t *testing.T, yaml string) config.RAGConfig {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil { t.Fatalf("write config: %v", err) }
+    cfg, err := config.Load(path)
+    if err != nil { t.Fatalf("load config: %v", err) }
+    return cfg.RAG
+}
+
+func TestOptionsFromConfig_DefaultsMatch(t *testing.T) {
+    // 配置文件缺省 rag 段时与 DefaultOptions 一致
+    opts, err := OptionsFromConfig(loadRAGConfig(t, "server:\n  port: 8081\n"))
+    if err != nil { t.Fatalf("options: %v", err) }
+    if want := DefaultOptions(); !reflect.DeepEqual(opts, want) { t.Fatalf("defaults differ:\n got %+v\nwant %+v", opts, want) }
+
+    opts, err = OptionsFromConfig(loadRAGConfig(t, `
+rag:
+  namespace: novel
+  bolt: { enabled: true, root_path: /var/lib/ahs/bolt }
+  async: { workers: 4, spool_path: "" }
+  vector: { enabled: true, endpoint: "http://vec:8000", http: { timeout: 2s } }
+  retention: { enabled: true, max_days: 30, interval: 30m }
+  ranking: { kinds: { fact: { recency: 0.2, half_life: 720h }, note: { importance: 1 } } }
+  dedupe: { enabled: true, kinds: [fact, long_term] }
+`))
+    if err != nil { t.Fatalf("options: %v", err) }
+    if opts.Namespace != "novel" || !opts.Bolt.Enable || opts.Bolt.RootPath != "/var/lib/ahs/bolt" || opts.Async.Workers != 4 || opts.Async.SpoolPath != "" { t.Fatalf("overrides: %+v", opts) }
+    if opts.Vector.Endpoint != "http://vec:8000" || opts.Vector.HTTP.Timeout.String() != "2s" || opts.Vector.HTTP.MaxRetries != 2 { t.Fatalf("vector: %+v", opts.Vector) }
+    if k := opts.Ranking.Kinds; k[KindFact] != (RankWeights{Relevance: 1, Importance: 0.5, Recency: 0.2, HalfLife: 720 * time.Hour}) || k[KindNote] != (RankWeights{Importance: 1}) || k[KindShortTerm] != DefaultRankingOptions().Kinds[KindShortTerm] { t.Fatalf("ranking kinds: %+v", k) }
+    if d := opts.Dedupe; !d.Enable || d.Threshold != 0.8 || len(d.Kinds) != 2 || d.Kinds[1] != KindLongTerm { t.Fatalf("dedupe: %+v", d) }
+    if opts.Retention.MaxDays != 30 || opts.Retention.Interval.String() != "30m0s" || opts.DiskJSON.RootPath != "data/rag" { t.Fatalf("retention: %+v", opts.Retention) }
+}
+
+func TestOptionsFromConfig_Invalid(t *testing.T) {
+    _, err := OptionsFromConfig(loadRAGConfig(t, `
+rag:
+  namespace: "../escape"
+  disk_json: { sync: sometimes }
+  retrieval: { fusion: max }
+  triple: { enabled: true, schema_version: "2" }
+  ranking: { default_importance: 2, kinds: { fact: { recency: -1 } } }
+  dedupe: { enabled: true, threshold: 1.5 }
+  consolidation: { enabled: true, batch_size: -1 }
+`))
+    if err == nil { t.Fatalf("expect error") }
+    for _, want := range []string{"Namespace", "DiskJSON.Sync", "Retrieval.Fusion", "Triple.SchemaVersion", "Ranking.DefaultImportance", "Ranking.Kinds[fact]", "Dedupe.Threshold", "Consolidation.BatchSize"} {
+        if !strings.Contains(err.Error(), want) { t.Fatalf("missing %s in %v", want, err) }
+    }
+
+    // NewManager 同样拒绝无效配置
+    opts := DefaultOptions()
+    opts.Async.Workers = -1
+    if _, err := NewManager(opts, nil, nil); err == nil || !strings.Contains(err.Error(), "Async.Workers") { t.Fatalf("new manager: %v", err) }
+}
@@ -0,0 +1,479 @@
+package rag
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "hash/fnv"
+    "math"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// 短期记忆整理（ConsolidationOptions）
+// - 定期（Interval）整理全部租户；租户新增短期记忆达到 Threshold 条时立即整理该租户；也可调用 Manager.Consolidate 手动触发
+// - 租户内创建超过 MinAge、未过期且未整理的 short_term 记忆按 (CreatedAt, ID) 排序，每 BatchSize 条一组交给 Summarizer，
+//   产出的 long_term/fact 记忆同步写入，随后原始记忆过期（ExpiresAt=整理时刻，之后由压缩清除）
+// - 关联（Meta）：整理结果记录 consolidated_from（所依据的原始 ID）、consolidation_group（组 ID）、
+//   consolidation_members（组内全部原始 ID）与 consolidation_parts（组内结果条数）；原始记忆记录 consolidated_into（整理结果 ID）
+// - 幂等：组 ID 由原始 ID 决定，整理结果 ID 为 {组 ID}-{序号}；中断后重跑时，完整的组只补做原始记忆过期，
+//   不完整的组（结果未全部写入）删除已写入的部分后重新整理
+
+// Meta 键（整理关联）
+const (
+    MetaConsolidatedFrom     = "consolidated_from"
+    MetaConsolidatedInto     = "consolidated_into"
+    MetaConsolidationGroup   = "consolidation_group"
+    MetaConsolidationMembers = "consolidation_members"
+    MetaConsolidationParts   = "consolidation_parts"
+)
+
+// Consolidated 摘要器产出的一条整理结果
+type Consolidated struct {
+    Content    string     `json:"content"`
+    Kind       MemoryKind `json:"kind"`                 // long_term（默认）或 fact
+    Importance float64    `json:"importance,omitempty"` // [0,1]，超出范围时截断
+    Tags       []string   `json:"tags,omitempty"`
+    Sources    []string   `json:"sources,omitempty"` // 概括的原始记忆 ID，为空表示整组
+}
+
+// Summarizer 将一组短期记忆概括为长期记忆或事实（LLM 实现见 summarizer.go）
+// 返回空结果视为失败，该组保持原样等待下次整理
+type Summarizer interface {
+    Summarize(ctx context.Context, items []MemoryItem) ([]Consolidated, error)
+}
+
+// ConsolidateReport 单个租户的整理结果
+type ConsolidateReport struct {
+    Tenant    Tenant   `json:"tenant"`
+    Groups    int      `json:"groups"`              // 本次整理的组数
+    Sources   int      `json:"sources"`             // 过期的原始记忆数（含补做）
+    Created   []string `json:"created,omitempty"`   // 新写入的整理结果 ID
+    Recovered int      `json:"recovered"`           // 中断后补做过期的原始记忆数
+    Discarded int      `json:"discarded"`           // 删除的不完整组的结果数
+    Errors    []string `json:"errors,omitempty"`
+}
+
+// ConsolidateSummary 一轮整理的汇总
+type ConsolidateSummary struct {
+    StartedAt  time.Time           `json:"started_at"`
+    FinishedAt time.Time           `json:"finished_at"`
+    Tenants    []ConsolidateReport `json:"tenants"`
+    Errors     []string            `json:"errors,omitempty"`
+}
+
+// tenantLister 可列出全部租户的存储（磁盘存储与内存存储实现）
+type tenantLister interface {
+    Tenants(ctx context.Context) ([]Tenant, error)
+}
+
+// consolidator 整理任务状态
+type consolidator struct {
+    mu         sync.Mutex // 串行化整理
+    sm         sync.Mutex // 保护以下字段
+    summarizer Summarizer
+    counts     map[Tenant]int // 上次整理后新增的短期记忆
+    due        map[Tenant]bool
+    last       *ConsolidateSummary
+    kick       chan struct{}
+    stop       chan struct{}
+    done       chan struct{}
+    cancel     context.CancelFunc
+}
+
+// SetSummarizer 设置整理使用的摘要器；nil 表示关闭整理
+func (m *Manager) SetSummarizer(s Summarizer) {
+    m.consol.sm.Lock()
+    m.consol.summarizer = s
+    m.consol.sm.Unlock()
+}
+
+func (m *Manager) getSummarizer() Summarizer {
+    m.consol.sm.Lock()
+    defer m.consol.sm.Unlock()
+    return m.consol.summarizer
+}
+
+// LastConsolidation 最近一轮整理的汇总
+func (m *Manager) LastConsolidation() (ConsolidateSummary, bool) {
+    m.consol.sm.Lock()
+    defer m.consol.sm.Unlock()
+    if m.consol.last == nil {
+        return ConsolidateSummary{}, false
+    }
+    return *m.consol.last, true
+}
+
+// noteShortTerm 记录新增短期记忆，达到阈值时唤醒后台整理
+func (m *Manager) noteShortTerm(t Tenant) {
+    co := m.opts.Consolidation
+    if !co.Enable || co.Threshold <= 0 || m.consol.kick == nil {
+        return
+    }
+    c := &m.consol
+    c.sm.Lock()
+    if c.counts == nil {
+        c.counts = make(map[Tenant]int)
+        c.due = make(map[Tenant]bool)
+    }
+    c.counts[t]++
+    reached := c.counts[t] >= co.Threshold
+    if reached {
+        c.counts[t] = 0
+        c.due[t] = true
+    }
+    c.sm.Unlock()
+    if reached {
+        select {
+        case c.kick <- struct{}{}:
+        default:
+        }
+    }
+}
+
+// takeDue 取出达到阈值的租户
+func (c *consolidator) takeDue() []Tenant {
+    c.sm.Lock()
+    defer c.sm.Unlock()
+    var res []Tenant
+    for t := range c.due {
+        res = append(res, t)
+    }
+    c.due = make(map[Tenant]bool)
+    return res
+}
+
+// Consolidate 整理全部租户
+func (m *Manager) Consolidate(ctx context.Context) (ConsolidateSummary, error) {
+    sum := ConsolidateSummary{StartedAt: time.Now()}
+    var lister tenantLister
+    for _, st := range []Store{m.disk, m.mem} {
+        if l, ok := st.(tenantLister); ok {
+            lister = l
+            break
+        }
+    }
+    if lister == nil {
+        return sum, errors.New("本地存储未启用或不支持列出租户")
+    }
+    tenants, err := lister.Tenants(ctx)
+    if err != nil {
+        return sum, err
+    }
+    for _, t := range tenants {
+        if ctx.Err() != nil {
+            sum.Errors = append(sum.Errors, ctx.Err().Error())
+            break
+        }
+        rep, err := m.ConsolidateTenant(ctx, t)
+        if err != nil {
+            sum.Errors = append(sum.Errors, fmt.Sprintf("%s/%s: %v", t.UserID, t.ArchiveID, err))
+            continue
+        }
+        if rep.Groups > 0 || rep.Sources > 0 || rep.Discarded > 0 || len(rep.Errors) > 0 {
+            sum.Tenants = append(sum.Tenants, rep)
+        }
+    }
+    sum.FinishedAt = time.Now()
+    m.consol.sm.Lock()
+    m.consol.last = &sum
+    m.consol.sm.Unlock()
+    return sum, nil
+}
+
+// ConsolidateTenant 整理单个租户；单组失败记入报告并继续
+func (m *Manager) ConsolidateTenant(ctx context.Context, t Tenant) (ConsolidateReport, error) {
+    rep := ConsolidateReport{Tenant: t}
+    s := m.getSummarizer()
+    if s == nil {
+        return rep, errors.New("未设置摘要器")
+    }
+    src, ok := m.authoritative().(ArchiveStore)
+    if !ok {
+        return rep, errors.New("本地存储未启用或不支持导出")
+    }
+    m.consol.mu.Lock()
+    defer m.consol.mu.Unlock()
+
+    m.ensureWarm(ctx, t)
+    all, err := src.Export(ctx, t)
+    if err != nil {
+        return rep, err
+    }
+    now := time.Now()
+
+    // 已写入的整理结果：完整的组覆盖其原始记忆，不完整的组删除后重新整理
+    parts := make(map[string][]MemoryItem)
+    for _, it := range all {
+        if gid, _ := it.Meta[MetaConsolidationGroup].(string); gid != "" {
+            parts[gid] = append(parts[gid], it)
+        }
+    }
+    covered := make(map[string][]string) // 原始 ID -> 整理结果 ID
+    for gid, ps := range parts {
+        if want, ok := metaInt(ps[0].Meta[MetaConsolidationParts]); ok && want == len(ps) {
+            var ids []string
+            for _, p := range ps {
+                ids = append(ids, p.ID)
+            }
+            sort.Strings(ids)
+            for _, sid := range metaStrings(ps[0].Meta[MetaConsolidationMembers]) {
+                covered[sid] = ids
+            }
+            continue
+        }
+        for _, p := range ps {
+            if err := m.Delete(ctx, t, p.ID); err != nil && !errors.Is(err, ErrNotFound) {
+                return rep, fmt.Errorf("删除不完整的整理结果 %s: %w", gid, err)
+            }
+            rep.Discarded++
+        }
+    }
+
+    // 待整理的短期记忆；已被完整的组覆盖的只补做过期
+    var pending []MemoryItem
+    minAge := m.opts.Consolidation.MinAge
+    for _, it := range all {
+        if it.Kind != KindShortTerm || it.ExpiresAt != nil && !it.ExpiresAt.After(now) {
+            continue
+        }
+        if _, done := it.Meta[MetaConsolidatedInto]; done {
+            continue
+        }
+        if into, ok := covered[it.ID]; ok {
+            if err := m.expireConsolidated(ctx, it, into, now); err != nil {
+                return rep, err
+            }
+            rep.Recovered++
+            rep.Sources++
+            continue
+        }
+        if minAge > 0 && now.Sub(it.CreatedAt) < minAge {
+            continue
+        }
+        pending = append(pending, it)
+    }
+    sort.Slice(pending, func(i, j int) bool {
+        return recencyLess(pending[j].CreatedAt, pending[j].ID, pending[i].CreatedAt, pending[i].ID)
+    })
+
+    batch := m.opts.Consolidation.BatchSize
+    if batch <= 0 {
+        batch = 20
+    }
+    for lo := 0; lo < len(pending); lo += batch {
+        if err := ctx.Err(); err != nil {
+            return rep, err
+        }
+        hi := lo + batch
+        if hi > len(pending) {
+            hi = len(pending)
+        }
+        created, err := m.consolidateGroup(ctx, s, pending[lo:hi], now)
+        if err != nil {
+            rep.Errors = append(rep.Errors, err.Error())
+            m.log().Warn("短期记忆整理失败",
+                zap.String("用户", t.UserID),
+                zap.String("归档", t.ArchiveID),
+                zap.Error(err),
+            )
+            continue
+        }
+        rep.Groups++
+        rep.Sources += hi - lo
+        rep.Created = append(rep.Created, created...)
+    }
+    return rep, nil
+}
+
+// consolidateGroup 概括一组短期记忆：写入整理结果后使原始记忆过期
+func (m *Manager) consolidateGroup(ctx context.Context, s Summarizer, group []MemoryItem, now time.Time) ([]string, error) {
+    ids := make([]string, len(group))
+    inGroup := make(map[string]bool, len(group))
+    for i, it := range group {
+        ids[i] = it.ID
+        inGroup[it.ID] = true
+    }
+    gid := consolidationGroupID(ids)
+
+    out, err := s.Summarize(ctx, group)
+    if err != nil {
+        return nil, fmt.Errorf("组 %s 摘要失败: %w", gid, err)
+    }
+    var results []Consolidated
+    for _, c := range out {
+        if strings.TrimSpace(c.Content) != "" {
+            results = append(results, c)
+        }
+    }
+    if len(results) == 0 {
+        return nil, fmt.Errorf("组 %s 摘要器未返回结果", gid)
+    }
+
+    created := make([]string, 0, len(results))
+    for i, c := range results {
+        kind := c.Kind
+        if kind != KindFact {
+            kind = KindLongTerm
+        }
+        var from []string
+        for _, sid := range c.Sources {
+            if inGroup[sid] {
+                from = append(from, sid)
+            }
+        }
+        if len(from) == 0 {
+            from = ids
+        }
+        item := MemoryItem{
+            ID:         fmt.Sprintf("%s-%d", gid, i+1),
+            Tenant:     group[0].Tenant,
+            Content:    strings.TrimSpace(c.Content),
+            Tags:       c.Tags,
+            Kind:       kind,
+            CreatedAt:  now,
+            Importance: math.Min(math.Max(c.Importance, 0), 1),
+            Meta: map[string]any{
+                MetaConsolidatedFrom:     from,
+                MetaConsolidationGroup:   gid,
+                MetaConsolidationMembers: ids,
+                MetaConsolidationParts:   len(results),
+            },
+        }
+        if err := m.saveSync(ctx, item, SaveOptions{ToMemory: true, ToDisk: true, ToVector: true}); err != nil {
+            return nil, fmt.Errorf("组 %s 写入整理结果: %w", gid, err)
+        }
+        created = append(created, item.ID)
+    }
+    for _, it := range group {
+        if err := m.expireConsolidated(ctx, it, created, now); err != nil {
+            return created, fmt.Errorf("组 %s: %w", gid, err)
+        }
+    }
+    return created, nil
+}
+
+// expireConsolidated 原始记忆记录整理结果并过期；向量库中的副本一并删除
+// 以当前值为准只改动 Meta[consolidated_into] 与 ExpiresAt，整理期间的并发修改不被覆盖
+func (m *Manager) expireConsolidated(ctx context.Context, it MemoryItem, into []string, now time.Time) error {
+    _, err := m.modify(ctx, it.Tenant, it.ID, func(cur *MemoryItem) error {
+        if cur.Meta == nil {
+            cur.Meta = make(map[string]any, 1)
+        }
+        cur.Meta[MetaConsolidatedInto] = into
+        cur.ExpiresAt = &now
+        return nil
+    })
+    if err != nil && !errors.Is(err, ErrNotFound) {
+        return fmt.Errorf("过期原始记忆 %s: %w", it.ID, err)
+    }
+    if d, ok := m.vec.(vectorDeleter); ok {
+        _ = d.Delete(ctx, it.Tenant, it.ID)
+    }
+    return nil
+}
+
+// authoritative 权威本地存储：磁盘优先
+func (m *Manager) authoritative() Store {
+    if m.disk != nil {
+        return m.disk
+    }
+    return m.mem
+}
+
+// consolidationGroupID 由原始 ID 决定的组 ID
+func consolidationGroupID(ids []string) string {
+    sorted := append([]string(nil), ids...)
+    sort.Strings(sorted)
+    h := fnv.New64a()
+    for _, id := range sorted {
+        _, _ = h.Write([]byte(id))
+        _, _ = h.Write([]byte{0})
+    }
+    return fmt.Sprintf("cons-%016x", h.Sum64())
+}
+
+// metaStrings 读取字符串列表（磁盘读回时为 []any）
+func metaStrings(v any) []string {
+    switch x := v.(type) {
+    case []string:
+        return x
+    case []any:
+        res := make([]string, 0, len(x))
+        for _, e := range x {
+            if s, ok := e.(string); ok {
+                res = append(res, s)
+            }
+        }
+        return res
+    }
+    return nil
+}
+
+// metaInt 读取整数（磁盘读回时为 float64）
+func metaInt(v any) (int, bool) {
+    switch x := v.(type) {
+    case int:
+        return x, true
+    case float64:
+        return int(x), true
+    }
+    return 0, false
+}
+
+// startConsolidator 后台定期整理，并响应阈值触发
+func (m *Manager) startConsolidator() {
+    co := m.opts.Consolidation
+    if !co.Enable || len(m.localStores()) == 0 {
+        return
+    }
+    interval := co.Interval
+    if interval <= 0 {
+        interval = time.Hour
+    }
+    c := &m.consol
+    c.kick = make(chan struct{}, 1)
+    c.stop = make(chan struct{})
+    c.done = make(chan struct{})
+    ctx, cancel := context.WithCancel(context.Background())
+    c.cancel = cancel
+    go func() {
+        defer close(c.done)
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-c.stop:
+                return
+            case <-ticker.C:
+                if m.getSummarizer() != nil {
+                    _, _ = m.Consolidate(ctx)
+                }
+            case <-c.kick:
+                if m.getSummarizer() == nil {
+                    continue
+                }
+                for _, t := range c.takeDue() {
+                    if _, err := m.ConsolidateTenant(ctx, t); err != nil {
+                        m.log().Warn("短期记忆整理失败", zap.String("用户", t.UserID), zap.String("归档", t.ArchiveID), zap.Error(err))
+                    }
+                }
+            }
+        }
+    }()
+}
+
+// stopConsolidator 停止后台整理；进行中的整理被取消（可重跑）
+func (m *Manager) stopConsolidator() {
+    c := &m.consol
+    if c.stop == nil {
+        return
+    }
+    c.cancel()
+    close(c.stop)
+    <-c.done
+}
@@ -0,0 +1,208 @@
+package rag
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "regexp"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/cloudwego/eino/components/model"
+    "github.com/cloudwego/eino/schema"
+)
+
+// fakeChatModel 测试用对话模型：按提示词中的记忆 ID 生成回复
+type fakeChatModel struct {
+    mu    sync.Mutex
+    calls int
+    err   error
+    reply func(ids []string) string
+}
+
+var promptIDPattern = regexp.MustCompile(`\[([^\]]+)\]`)
+
+func (f *fakeChatModel) Generate(ctx context.Context, in []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.calls++
+    if f.err != nil {
+        return nil, f.err
+    }
+    var ids []string
+    for _, m := range promptIDPattern.FindAllStringSubmatch(in[len(in)-1].Content, -1) {
+        ids = append(ids, m[1])
+    }
+    return schema.AssistantMessage(f.reply(ids), nil), nil
+}
+
+func (f *fakeChatModel) Stream(ctx context.Context, in []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+    return nil, errors.New("not supported")
+}
+
+func (f *fakeChatModel) callCount() int {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return f.calls
+}
+
+// twoParts 每组产出一条事实（依据第一条）与一条长期记忆（依据整组）
+func twoParts(ids []string) string {
+    return fmt.Sprintf("```json\n{\"memories\":[{\"content\":\"事实 %s\",\"kind\":\"fact\",\"importance\":0.8,\"sources\":[%q]},"+
+        "{\"content\":\"概括 %s\",\"kind\":\"long_term\",\"tags\":[\"整理\"]}]}\n```", ids[0], ids[0], strings.Join(ids, "+"))
+}
+
+func saveShortTerm(t *testing.T, m *Manager, ten Tenant, n int, at time.Time) {
+    t.Helper()
+    for i := 0; i < n; i++ {
+        it := MemoryItem{ID: fmt.Sprintf("s%d", i), Tenant: ten, Kind: KindShortTerm, Content: fmt.Sprintf("第 %d 段对话", i), CreatedAt: at.Add(time.Duration(i) * time.Second)}
+        if err := m.Save(context.Background(), it, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    }
+}
+
+func TestConsolidate_SummarizesAndExpires(t *testing.T) {
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    m := newTestManager(t, func(o *RAGOptions) { o.Consolidation = ConsolidationOptions{BatchSize: 3, MinAge: time.Minute} })
+    defer m.Close(ctx)
+    cm := &fakeChatModel{reply: twoParts}
+    m.SetSummarizer(NewChatSummarizer(cm))
+
+    saveShortTerm(t, m, ten, 5, time.Now().Add(-time.Hour))
+    _ = m.Save(ctx, MemoryItem{ID: "fresh", Tenant: ten, Kind: KindShortTerm, Content: "刚说的话"}, SaveOptions{})
+    _ = m.Save(ctx, MemoryItem{ID: "note", Tenant: ten, Kind: KindNote, Content: "备注"}, SaveOptions{})
+
+    rep, err := m.ConsolidateTenant(ctx, ten)
+    if err != nil { t.Fatalf("consolidate: %v", err) }
+    if rep.Groups != 2 || rep.Sources != 5 || len(rep.Created) != 4 || len(rep.Errors) != 0 { t.Fatalf("report: %+v", rep) }
+
+    // 原始短期记忆过期并指向整理结果；未到 MinAge 的与其他类型不受影响
+    r, _ := m.Query(ctx, QueryRequest{Tenant: ten, Kinds: []MemoryKind{KindShortTerm, KindNote}})
+    if got := ids(r.Items); got != "note,fresh" { t.Fatalf("remaining: %s", got) }
+    s0, err := m.Get(ctx, ten, "s0")
+    if err != nil || s0.ExpiresAt == nil || len(metaStrings(s0.Meta[MetaConsolidatedInto])) != 2 { t.Fatalf("s0: %+v %v", s0, err) }
+
+    // 整理结果关联原始记忆
+    r, _ = m.Query(ctx, QueryRequest{Tenant: ten, Kinds: []MemoryKind{KindFact}, Sort: SortRecency})
+    if len(r.Items) != 2 { t.Fatalf("facts: %+v", r.Items) }
+    for _, f := range r.Items {
+        from := metaStrings(f.Meta[MetaConsolidatedFrom])
+        if len(from) != 1 || f.Importance != 0.8 || !strings.Contains(f.Content, from[0]) { t.Fatalf("fact: %+v", f) }
+    }
+    lt, _ := m.Get(ctx, ten, metaStrings(s0.Meta[MetaConsolidatedInto])[1])
+    if lt.Kind != KindLongTerm || strings.Join(metaStrings(lt.Meta[MetaConsolidatedFrom]), ",") != "s0,s1,s2" { t.Fatalf("long term: %+v", lt) }
+
+    // 重跑无事可做
+    rep, _ = m.ConsolidateTenant(ctx, ten)
+    if rep.Groups != 0 || rep.Sources != 0 || cm.callCount() != 2 { t.Fatalf("rerun: %+v calls=%d", rep, cm.callCount()) }
+}
+
+func TestConsolidate_ResumesAfterInterruption(t *testing.T) {
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    disk := t.TempDir()
+    m := newTestManager(t, func(o *RAGOptions) { o.DiskJSON.RootPath, o.Consolidation = disk, ConsolidationOptions{BatchSize: 2} })
+    cm := &fakeChatModel{reply: twoParts}
+    m.SetSummarizer(NewChatSummarizer(cm))
+    saveShortTerm(t, m, ten, 4, time.Now().Add(-time.Hour))
+    rep, err := m.ConsolidateTenant(ctx, ten)
+    if err != nil || len(rep.Created) != 4 { t.Fatalf("consolidate: %+v %v", rep, err) }
+
+    // 模拟中断：第一组结果已写入但原始记忆未过期；第二组只写入了一条结果
+    for _, id := range []string{"s0", "s1", "s2", "s3"} {
+        it, _ := m.Get(ctx, ten, id)
+        it.ExpiresAt, it.Meta = nil, nil
+        if err := m.Update(ctx, it); err != nil { t.Fatalf("restore %s: %v", id, err) }
+    }
+    second := rep.Created[2:]
+    if err := m.Delete(ctx, ten, second[1]); err != nil { t.Fatalf("delete: %v", err) }
+    if err := m.Close(ctx); err != nil { t.Fatalf("close: %v", err) }
+
+    m2 := newTestManager(t, func(o *RAGOptions) { o.DiskJSON.RootPath, o.Consolidation = disk, ConsolidationOptions{BatchSize: 2} })
+    defer m2.Close(ctx)
+    m2.SetSummarizer(NewChatSummarizer(cm))
+    rep, err = m2.ConsolidateTenant(ctx, ten)
+    if err != nil { t.Fatalf("resume: %v", err) }
+    if rep.Recovered != 2 || rep.Discarded != 1 || rep.Groups != 1 || rep.Sources != 4 || cm.callCount() != 3 { t.Fatalf("resume report: %+v calls=%d", rep, cm.callCount()) }
+    // 重新整理的组 ID 不变，结果覆盖原位置
+    if strings.Join(rep.Created, ",") != strings.Join(second, ",") { t.Fatalf("regenerated ids: %v vs %v", rep.Created, second) }
+
+    r, _ := m2.Query(ctx, QueryRequest{Tenant: ten, Kinds: []MemoryKind{KindShortTerm}})
+    if len(r.Items) != 0 { t.Fatalf("originals left: %s", ids(r.Items)) }
+    r, _ = m2.Query(ctx, QueryRequest{Tenant: ten, Kinds: []MemoryKind{KindFact, KindLongTerm}, TopK: 100})
+    if len(r.Items) != 4 { t.Fatalf("summaries: %s", ids(r.Items)) }
+}
+
+// hookSummarizer 摘要前执行一次 hook，用于在读取分组与过期原始记忆之间插入并发修改
+type hookSummarizer struct {
+    Summarizer
+    once sync.Once
+    hook func()
+}
+
+func (s *hookSummarizer) Summarize(ctx context.Context, items []MemoryItem) ([]Consolidated, error) {
+    s.once.Do(s.hook)
+    return s.Summarizer.Summarize(ctx, items)
+}
+
+func TestConsolidate_KeepsConcurrentUpdate(t *testing.T) {
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    m := newTestManager(t, func(o *RAGOptions) { o.Consolidation = ConsolidationOptions{} })
+    defer m.Close(ctx)
+    saveShortTerm(t, m, ten, 2, time.Now().Add(-time.Hour))
+
+    // 整理期间原始记忆被修改：过期时只改动整理相关字段，修改不被分组时读取的旧值覆盖
+    m.SetSummarizer(&hookSummarizer{Summarizer: NewChatSummarizer(&fakeChatModel{reply: twoParts}), hook: func() {
+        it, _ := m.Get(ctx, ten, "s0")
+        it.Content, it.Tags = "第 0 段对话（更正）", []string{"更正"}
+        if err := m.Update(ctx, it); err != nil { t.Errorf("update: %v", err) }
+    }})
+    rep, err := m.ConsolidateTenant(ctx, ten)
+    if err != nil || rep.Groups != 1 || len(rep.Errors) != 0 { t.Fatalf("report: %+v %v", rep, err) }
+    s0, err := m.Get(ctx, ten, "s0")
+    if err != nil || s0.Content != "第 0 段对话（更正）" || s0.Version != 2 || len(s0.Tags) != 1 { t.Fatalf("update reverted: %+v %v", s0, err) }
+    if s0.ExpiresAt == nil || len(metaStrings(s0.Meta[MetaConsolidatedInto])) != 2 { t.Fatalf("not expired: %+v", s0) }
+}
+
+func TestConsolidate_FailureKeepsOriginals(t *testing.T) {
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    m := newTestManager(t, func(o *RAGOptions) { o.Consolidation = ConsolidationOptions{} })
+    defer m.Close(ctx)
+    saveShortTerm(t, m, ten, 2, time.Now().Add(-time.Hour))
+
+    if _, err := m.ConsolidateTenant(ctx, ten); err == nil { t.Fatalf("expect error without summarizer") }
+    for _, cm := range []*fakeChatModel{
+        {err: errors.New("rate limited")},
+        {reply: func([]string) string { return "抱歉，我无法完成" }},
+        {reply: func([]string) string { return `{"memories":[]}` }},
+    } {
+        m.SetSummarizer(NewChatSummarizer(cm))
+        rep, err := m.ConsolidateTenant(ctx, ten)
+        if err != nil || rep.Groups != 0 || len(rep.Errors) != 1 { t.Fatalf("report: %+v %v", rep, err) }
+        r, _ := m.Query(ctx, QueryRequest{Tenant: ten, Kinds: []MemoryKind{KindShortTerm}})
+        if len(r.Items) != 2 { t.Fatalf("originals touched: %s", ids(r.Items)) }
+    }
+}
+
+func TestConsolidate_Threshold(t *testing.T) {
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    m := newTestManager(t, func(o *RAGOptions) { o.Consolidation = ConsolidationOptions{Enable: true, Interval: time.Hour, Threshold: 3} })
+    defer m.Close(ctx)
+    cm := &fakeChatModel{reply: twoParts}
+    m.SetSummarizer(NewChatSummarizer(cm))
+
+    saveShortTerm(t, m, ten, 2, time.Now())
+    time.Sleep(20 * time.Millisecond)
+    if cm.callCount() != 0 { t.Fatalf("consolidated below threshold") }
+    _ = m.Save(ctx, MemoryItem{ID: "s2", Tenant: ten, Kind: KindShortTerm, Content: "第三段"}, SaveOptions{})
+    waitFor(t, func() bool {
+        r, _ := m.Query(ctx, QueryRequest{Tenant: ten, Kinds: []MemoryKind{KindShortTerm}})
+        return len(r.Items) == 0
+    })
+    if cm.callCount() != 1 { t.Fatalf("calls: %d", cm.callCount()) }
+}
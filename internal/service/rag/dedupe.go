@@ -0,0 +1,186 @@
+package rag
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "time"
+    "unicode"
+)
+
+// 保存时的近重复检测（DedupeOptions，Save/SaveWithResult 时生效）
+// - 候选：同租户、同类型、未过期的记忆，取本地全文检索前 Candidates 条；启用向量后端时另取向量检索结果
+// - 相似度：规范化文本（小写，去除标点、符号与空白）的字符 Shingle 集合的 Jaccard 系数，达到 Threshold 视为重复；
+//   向量检索分数达到 VectorThreshold 同样视为重复，取两者中相似度最高的候选
+// - 合并：不追加新条目，改为更新已有条目：标签取并集、Meta 补充缺失的键（来源保留键除外）、重要性取较大者、
+//   过期时间取较晚者（nil 为不过期）、HitCount 加一并刷新 UpdatedAt；正文与创建时间保持不变
+// - 检测与写入在同一把锁内完成，并发保存相同内容时只保留一条；
+//   异步队列中尚未落盘的写入对检测不可见（内存缓存启用时写入即可见）
+
+// SaveResult 保存结果；Merged 时 ID 为被合并进的已有条目
+type SaveResult struct {
+    ID         string  `json:"id"`
+    Merged     bool    `json:"merged,omitempty"`
+    Similarity float64 `json:"similarity,omitempty"`
+}
+
+// dedupes 该类型是否参与近重复检测
+func (o DedupeOptions) dedupes(k MemoryKind) bool {
+    if !o.Enable {
+        return false
+    }
+    if len(o.Kinds) == 0 {
+        return true
+    }
+    for _, want := range o.Kinds {
+        if want == k {
+            return true
+        }
+    }
+    return false
+}
+
+// mergeDuplicate 查找近重复条目并合并；未找到时返回 false
+func (m *Manager) mergeDuplicate(ctx context.Context, item MemoryItem, opt SaveOptions) (SaveResult, bool, error) {
+    id, sim := m.findDuplicate(ctx, item)
+    if id == "" {
+        return SaveResult{}, false, nil
+    }
+    now := time.Now()
+    // 在 rewrite 锁内以条目当前值合并，检测之后的并发修改不被覆盖
+    dst, err := m.modify(ctx, item.Tenant, id, func(dst *MemoryItem) error {
+        if dst.ExpiresAt != nil && !dst.ExpiresAt.After(now) {
+            return ErrNotFound
+        }
+        mergeInto(dst, item, now)
+        return nil
+    })
+    if errors.Is(err, ErrNotFound) {
+        // 候选仅存在于向量后端（或已过期）时按新条目保存
+        return SaveResult{}, false, nil
+    }
+    if err != nil {
+        return SaveResult{}, false, err
+    }
+    // 向量后端保存的副本同步标签等字段
+    if _, _, toVec, _ := m.route(opt); toVec {
+        if err := m.saveVector(ctx, dst); err != nil {
+            return SaveResult{}, false, err
+        }
+    }
+    return SaveResult{ID: dst.ID, Merged: true, Similarity: sim}, true, nil
+}
+
+// findDuplicate 返回相似度最高且达到阈值的候选 ID
+func (m *Manager) findDuplicate(ctx context.Context, item MemoryItem) (string, float64) {
+    do := m.opts.Dedupe
+    shingles := shingleSet(item.Content, do.Shingle)
+    if len(shingles) == 0 {
+        return "", 0
+    }
+    topK := do.Candidates
+    if topK <= 0 {
+        topK = 10
+    }
+    req := QueryRequest{
+        Tenant: item.Tenant,
+        Query:  item.Content,
+        TopK:   topK,
+        Kinds:  []MemoryKind{item.Kind},
+        Sort:   SortScore,
+    }
+    m.ensureWarm(ctx, item.Tenant)
+    bestID, best := "", 0.0
+    for _, c := range m.queryLocal(ctx, req) {
+        if c.ID == item.ID {
+            continue
+        }
+        if sim := jaccard(shingles, shingleSet(c.Content, do.Shingle)); sim >= do.Threshold && sim > best {
+            bestID, best = c.ID, sim
+        }
+    }
+    if m.hasVec && do.VectorThreshold > 0 {
+        req.Vector = item.Vector
+        for _, c := range m.queryVector(ctx, req) {
+            if c.ID != item.ID && c.Score >= do.VectorThreshold && c.Score > best {
+                bestID, best = c.ID, c.Score
+            }
+        }
+    }
+    return bestID, best
+}
+
+// mergeInto 将重复保存的条目合并进已有条目
+func mergeInto(dst *MemoryItem, src MemoryItem, now time.Time) {
+    for _, tag := range src.Tags {
+        if !hasTag(dst.Tags, tag) {
+            dst.Tags = append(dst.Tags, tag)
+        }
+    }
+    for k, v := range src.Meta {
+        // 来源保留键只记录首次写入，避免回滚后续运行时误删已有条目
+        if _, ok := dst.Meta[k]; ok || isProvenanceKey(k) {
+            continue
+        }
+        if dst.Meta == nil {
+            dst.Meta = make(map[string]any, len(src.Meta))
+        }
+        dst.Meta[k] = v
+    }
+    if src.Importance > dst.Importance {
+        dst.Importance = src.Importance
+    }
+    if dst.ExpiresAt != nil && (src.ExpiresAt == nil || src.ExpiresAt.After(*dst.ExpiresAt)) {
+        dst.ExpiresAt = src.ExpiresAt
+    }
+    dst.HitCount++
+    dst.UpdatedAt = &now
+}
+
+// normalizeText 小写并去除标点、符号与空白
+func normalizeText(s string) []rune {
+    var out []rune
+    for _, r := range strings.ToLower(s) {
+        if unicode.IsLetter(r) || unicode.IsNumber(r) {
+            out = append(out, r)
+        }
+    }
+    return out
+}
+
+// shingleSet 规范化文本的字符 k-gram 集合；短于 k 时整体作为一个元素
+func shingleSet(s string, k int) map[string]struct{} {
+    rs := normalizeText(s)
+    if len(rs) == 0 {
+        return nil
+    }
+    if k <= 0 {
+        k = 2
+    }
+    set := make(map[string]struct{})
+    if len(rs) <= k {
+        set[string(rs)] = struct{}{}
+        return set
+    }
+    for i := 0; i+k <= len(rs); i++ {
+        set[string(rs[i:i+k])] = struct{}{}
+    }
+    return set
+}
+
+// jaccard 集合的 Jaccard 系数
+func jaccard(a, b map[string]struct{}) float64 {
+    if len(a) == 0 || len(b) == 0 {
+        return 0
+    }
+    if len(a) > len(b) {
+        a, b = b, a
+    }
+    inter := 0
+    for k := range a {
+        if _, ok := b[k]; ok {
+            inter++
+        }
+    }
+    return float64(inter) / float64(len(a)+len(b)-inter)
+}
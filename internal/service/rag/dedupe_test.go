@@ -0,0 +1,131 @@
+package rag
+
+import (
+    "context"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestShingleJaccard(t *testing.T) {
+    a := shingleSet("主角叫林夏。", 2)
+    if sim := jaccard(a, shingleSet(" 主角叫 林夏！", 2)); sim != 1 { t.Fatalf("normalized: %g", sim) }
+    if sim := jaccard(a, shingleSet("反派叫林夏", 2)); sim > 0.5 { t.Fatalf("different subject: %g", sim) }
+    if sim := jaccard(shingleSet("Lin Xia", 3), shingleSet("lin-xia!", 3)); sim != 1 { t.Fatalf("latin: %g", sim) }
+    if len(shingleSet("，。！", 2)) != 0 || jaccard(nil, a) != 0 { t.Fatalf("empty text should not match") }
+}
+
+func TestDedupe_MergesIntoExisting(t *testing.T) {
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    disk := t.TempDir()
+    m := newTestManager(t, func(o *RAGOptions) { o.DiskJSON.RootPath, o.Dedupe.Enable = disk, true })
+    expire := time.Now().Add(time.Hour)
+
+    first, err := m.SaveWithResult(ctx, MemoryItem{Tenant: ten, Kind: KindFact, Content: "主角叫林夏", Tags: []string{"人物"}, Importance: 0.5, ExpiresAt: &expire}, SaveOptions{})
+    if err != nil || first.Merged || first.ID == "" { t.Fatalf("first: %+v %v", first, err) }
+    res, err := m.SaveWithResult(ctx, MemoryItem{Tenant: ten, Kind: KindFact, Content: "主角叫林夏。", Tags: []string{"设定", "人物"}, Importance: 0.9, Meta: map[string]any{"chapter": 3}}, SaveOptions{})
+    if err != nil || !res.Merged || res.ID != first.ID || res.Similarity != 1 { t.Fatalf("merge: %+v %v", res, err) }
+    res, _ = m.SaveWithResult(ctx, MemoryItem{Tenant: ten, Kind: KindFact, Content: "主角叫 林夏！", Importance: 0.2}, SaveOptions{})
+    if !res.Merged || res.ID != first.ID { t.Fatalf("second merge: %+v", res) }
+
+    // 内容不同或类型不同时照常追加
+    for _, it := range []MemoryItem{
+        {Tenant: ten, Kind: KindFact, Content: "反派叫林夏"},
+        {Tenant: ten, Kind: KindNote, Content: "主角叫林夏"},
+    } {
+        if res, _ := m.SaveWithResult(ctx, it, SaveOptions{}); res.Merged { t.Fatalf("unexpected merge: %+v", it) }
+    }
+    r, _ := m.Query(ctx, QueryRequest{Tenant: ten, TopK: 10})
+    if len(r.Items) != 3 { t.Fatalf("items: %s", ids(r.Items)) }
+    if err := m.Close(ctx); err != nil { t.Fatalf("close: %v", err) }
+
+    // 合并结果持久化：正文不变，标签并集，重要性取较大者，补充 Meta，不再过期
+    m2 := newTestManager(t, func(o *RAGOptions) { o.DiskJSON.RootPath, o.Dedupe.Enable = disk, true })
+    defer m2.Close(ctx)
+    it, err := m2.Get(ctx, ten, first.ID)
+    if err != nil { t.Fatalf("get: %v", err) }
+    if it.Content != "主角叫林夏" || strings.Join(it.Tags, ",") != "人物,设定" || it.Importance != 0.9 || it.ExpiresAt != nil { t.Fatalf("merged: %+v", it) }
+    if n, _ := metaInt(it.Meta["chapter"]); it.HitCount != 2 || it.UpdatedAt == nil || n != 3 { t.Fatalf("hit stats: %+v", it) }
+}
+
+// getHookStore 读取后执行一次 hook，用于在读改写之间插入并发操作
+type getHookStore struct {
+    Store
+    once sync.Once
+    hook func()
+}
+
+func (s *getHookStore) Get(ctx context.Context, t Tenant, id string) (MemoryItem, error) {
+    it, err := s.Store.Get(ctx, t, id)
+    if s.hook != nil { s.once.Do(s.hook) }
+    return it, err
+}
+
+func TestDedupe_MergeKeepsConcurrentUpdate(t *testing.T) {
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    // 只保留磁盘存储，读取必经 getHookStore
+    m := newTestManager(t, func(o *RAGOptions) { o.InMemory.Enable, o.Dedupe.Enable = false, true })
+    defer m.Close(ctx)
+    first, err := m.SaveWithResult(ctx, MemoryItem{Tenant: ten, Kind: KindFact, Content: "主角叫林夏", Tags: []string{"人物"}}, SaveOptions{})
+    if err != nil { t.Fatalf("save: %v", err) }
+
+    // 合并读取已有条目之后、写入之前，另一协程调整其重要性
+    hs := &getHookStore{Store: m.disk}
+    m.disk = hs
+    updated := make(chan error, 1)
+    hs.hook = func() {
+        go func() {
+            _, err := m.modify(ctx, ten, first.ID, func(it *MemoryItem) error {
+                it.Importance = 0.7
+                return nil
+            })
+            updated <- err
+        }()
+        time.Sleep(20 * time.Millisecond)
+    }
+    res, err := m.SaveWithResult(ctx, MemoryItem{Tenant: ten, Kind: KindFact, Content: "主角叫林夏。", Tags: []string{"设定"}}, SaveOptions{})
+    if err != nil || !res.Merged { t.Fatalf("merge: %+v %v", res, err) }
+    if err := <-updated; err != nil { t.Fatalf("update: %v", err) }
+
+    it, _ := m.Get(ctx, ten, first.ID)
+    if it.Importance != 0.7 || strings.Join(it.Tags, ",") != "人物,设定" || it.HitCount != 1 { t.Fatalf("lost update: %+v", it) }
+}
+
+func TestDedupe_KindsAndExpired(t *testing.T) {
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    m := newTestManager(t, func(o *RAGOptions) { o.Dedupe.Enable, o.Dedupe.Kinds = true, []MemoryKind{KindFact} })
+    defer m.Close(ctx)
+
+    for i := 0; i < 2; i++ {
+        if res, _ := m.SaveWithResult(ctx, MemoryItem{Tenant: ten, Kind: KindShortTerm, Content: "好的"}, SaveOptions{}); res.Merged { t.Fatalf("short term merged") }
+    }
+    // 已过期的条目不参与合并
+    past := time.Now().Add(-time.Minute)
+    _ = m.Save(ctx, MemoryItem{ID: "old", Tenant: ten, Kind: KindFact, Content: "灯塔在北岸", ExpiresAt: &past}, SaveOptions{})
+    if res, _ := m.SaveWithResult(ctx, MemoryItem{Tenant: ten, Kind: KindFact, Content: "灯塔在北岸"}, SaveOptions{}); res.Merged { t.Fatalf("merged into expired: %+v", res) }
+}
+
+func TestDedupe_VectorSimilarity(t *testing.T) {
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    m := newTestManager(t, func(o *RAGOptions) {
+        o.Vector.Enable = true
+        o.Dedupe.Enable = true
+        o.Dedupe.VectorThreshold = 0.9
+    })
+    defer m.Close(ctx)
+
+    vec := make([]float32, DefaultEmbeddingDim)
+    vec[0] = 1
+    opt := SaveOptions{ToDisk: true, ToVector: true}
+    first, err := m.SaveWithResult(ctx, MemoryItem{Tenant: ten, Content: "林夏是故事的主角", Vector: vec}, opt)
+    if err != nil { t.Fatalf("save: %v", err) }
+    // 文本差异大但向量相同
+    res, err := m.SaveWithResult(ctx, MemoryItem{Tenant: ten, Content: "本书以林夏的视角展开", Vector: vec, Tags: []string{"视角"}}, opt)
+    if err != nil || !res.Merged || res.ID != first.ID || res.Similarity < 0.9 { t.Fatalf("vector merge: %+v %v", res, err) }
+    if it, _ := m.Get(ctx, ten, first.ID); it.HitCount != 1 || !hasTag(it.Tags, "视角") { t.Fatalf("merged: %+v", it) }
+}
@@ -0,0 +1,243 @@
+package rag
+
+import (
+    "bytes"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+// 磁盘存储的持久性
+// - 记录校验：每行为 {json}\t{crc32c 十六进制 8 位}；无后缀的旧数据按原样解析
+// - 租户锁：读写期间对租户目录加 flock 排他锁，多个进程共享同一数据卷时互斥（非 unix 平台仅进程内互斥）；
+//   其他进程写入后，活动段大小或目录修改时间变化，索引增量追上或整体重建
+// - 刷盘策略（DiskJSON.Sync）：none 交给操作系统；always 每条记录 fsync 后返回；
+//   group 组提交，并发写入在锁外等待同一次 fsync
+// - 崩溃恢复：活动段末尾缺少换行且无法解析的残缺记录在加载时截断，原始内容记入隔离文件
+
+const (
+    SyncNone   = "none"
+    SyncAlways = "always"
+    SyncGroup  = "group"
+)
+
+// ErrChecksum 记录校验失败
+var ErrChecksum = errors.New("record checksum mismatch")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// encodeLine 编码一行记录（不含换行）
+func encodeLine(rec *diskRecord) ([]byte, error) {
+    b, err := json.Marshal(rec)
+    if err != nil {
+        return nil, fmt.Errorf("encode json: %w", err)
+    }
+    var sum [4]byte
+    c := crc32.Checksum(b, crcTable)
+    sum[0], sum[1], sum[2], sum[3] = byte(c>>24), byte(c>>16), byte(c>>8), byte(c)
+    b = append(b, '\t')
+    return hex.AppendEncode(b, sum[:]), nil
+}
+
+// decodeLine 校验并解析一行记录
+func decodeLine(body []byte) (diskRecord, error) {
+    var rec diskRecord
+    if i := bytes.LastIndexByte(body, '\t'); i >= 0 && len(body)-i-1 == 8 {
+        var sum [4]byte
+        if _, err := hex.Decode(sum[:], body[i+1:]); err != nil {
+            return rec, fmt.Errorf("decode checksum: %w", err)
+        }
+        want := uint32(sum[0])<<24 | uint32(sum[1])<<16 | uint32(sum[2])<<8 | uint32(sum[3])
+        body = body[:i]
+        if crc32.Checksum(body, crcTable) != want {
+            return rec, ErrChecksum
+        }
+    }
+    if err := json.Unmarshal(body, &rec); err != nil {
+        return rec, err
+    }
+    return rec, nil
+}
+
+// validSync 校验刷盘策略
+func validSync(p string) (string, error) {
+    switch p {
+    case "":
+        return SyncNone, nil
+    case SyncNone, SyncAlways, SyncGroup:
+        return p, nil
+    }
+    return "", fmt.Errorf("DiskJSON.Sync 不支持: %s", p)
+}
+
+// locked 在进程内锁与租户目录锁下执行 fn；create 时先创建租户目录，否则目录不存在时只持有进程内锁
+func (s *diskJSONStore) locked(t Tenant, create bool, fn func() error) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    dir := filepath.Dir(s.pathOf(t))
+    if create {
+        if err := os.MkdirAll(dir, 0o755); err != nil {
+            return fmt.Errorf("ensure dir: %w", err)
+        }
+    }
+    unlock, err := lockDir(dir)
+    if err != nil {
+        return err
+    }
+    defer unlock()
+    return fn()
+}
+
+// groupCommit 组提交：写入方登记待刷盘文件，由首个等待者统一 fsync，覆盖其之前登记的全部写入
+type groupCommit struct {
+    mu     sync.Mutex // 同一时刻只有一个刷盘者
+    synced uint64
+
+    pmu     sync.Mutex
+    seq     uint64
+    pending map[string]struct{}
+}
+
+// mark 登记写入并返回其序号
+func (g *groupCommit) mark(path string) uint64 {
+    g.pmu.Lock()
+    defer g.pmu.Unlock()
+    if g.pending == nil {
+        g.pending = make(map[string]struct{})
+    }
+    g.pending[path] = struct{}{}
+    g.seq++
+    return g.seq
+}
+
+// wait 等待序号为 seq 的写入落盘；0 表示无需等待
+func (g *groupCommit) wait(seq uint64) error {
+    if seq == 0 {
+        return nil
+    }
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    if g.synced >= seq {
+        return nil
+    }
+    g.pmu.Lock()
+    paths, upto := g.pending, g.seq
+    g.pending = nil
+    g.pmu.Unlock()
+
+    for p := range paths {
+        if err := syncFile(p); err != nil {
+            // 未刷盘的文件放回，由下一个等待者重试
+            g.pmu.Lock()
+            if g.pending == nil {
+                g.pending = make(map[string]struct{})
+            }
+            for q := range paths {
+                g.pending[q] = struct{}{}
+            }
+            g.pmu.Unlock()
+            return err
+        }
+    }
+    g.synced = upto
+    return nil
+}
+
+// syncFile fsync 文件；文件已被封存改名时跳过（封存前已刷盘）
+func syncFile(path string) error {
+    f, err := os.OpenFile(path, os.O_RDWR, 0)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil
+        }
+        return fmt.Errorf("open file: %w", err)
+    }
+    defer f.Close()
+    if err := f.Sync(); err != nil {
+        return fmt.Errorf("fsync: %w", err)
+    }
+    return nil
+}
+
+// syncDir fsync 目录，使改名与新建文件持久化
+func syncDir(dir string) {
+    if d, err := os.Open(dir); err == nil {
+        _ = d.Sync()
+        _ = d.Close()
+    }
+}
+
+// recoverTail 截断活动段末尾的残缺记录（调用方持有租户锁）
+// 末尾缺少换行但可以完整解析时补齐换行；否则截断到最后一个换行并返回残缺内容
+func recoverTail(path string) (*QuarantineEntry, error) {
+    f, err := os.OpenFile(path, os.O_RDWR, 0)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("open file: %w", err)
+    }
+    defer f.Close()
+    st, err := f.Stat()
+    if err != nil || st.Size() == 0 {
+        return nil, err
+    }
+    size := st.Size()
+    last := make([]byte, 1)
+    if _, err := f.ReadAt(last, size-1); err != nil {
+        return nil, fmt.Errorf("read tail: %w", err)
+    }
+    if last[0] == '\n' {
+        return nil, nil
+    }
+
+    // 向前查找最后一个换行
+    start := int64(0)
+    buf := make([]byte, 64*1024)
+    for end := size; end > 0; {
+        off := end - int64(len(buf))
+        if off < 0 {
+            off = 0
+        }
+        chunk := buf[:end-off]
+        if _, err := f.ReadAt(chunk, off); err != nil && err != io.EOF {
+            return nil, fmt.Errorf("read tail: %w", err)
+        }
+        if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+            start = off + int64(i) + 1
+            break
+        }
+        end = off
+    }
+    tail := make([]byte, size-start)
+    if _, err := f.ReadAt(tail, start); err != nil && err != io.EOF {
+        return nil, fmt.Errorf("read tail: %w", err)
+    }
+
+    _, derr := decodeLine(tail)
+    if derr == nil {
+        if _, err := f.WriteAt([]byte{'\n'}, size); err != nil {
+            return nil, fmt.Errorf("terminate tail: %w", err)
+        }
+        return nil, f.Sync()
+    }
+    if err := f.Truncate(start); err != nil {
+        return nil, fmt.Errorf("truncate torn tail: %w", err)
+    }
+    if err := f.Sync(); err != nil {
+        return nil, fmt.Errorf("fsync: %w", err)
+    }
+    return &QuarantineEntry{
+        Segment: filepath.Base(path),
+        Offset:  start,
+        Length:  len(tail),
+        Error:   "torn tail: " + derr.Error(),
+        Data:    tail,
+    }, nil
+}
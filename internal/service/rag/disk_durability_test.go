@@ -0,0 +1,150 @@
+package rag
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "sync"
+    "testing"
+)
+
+func appendRaw(t *testing.T, path string, data []byte) {
+    t.Helper()
+    f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+    if err != nil { t.Fatalf("open: %v", err) }
+    if _, err := f.Write(data); err != nil { t.Fatalf("write: %v", err) }
+    _ = f.Close()
+}
+
+func reopenDisk(t *testing.T, s *diskJSONStore) *diskJSONStore {
+    t.Helper()
+    st, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: s.root})
+    if err != nil { t.Fatalf("reopen: %v", err) }
+    return st.(*diskJSONStore)
+}
+
+func TestDiskDurability_TornTailTruncatedOnStartup(t *testing.T) {
+    s := newTestDiskStore(t, 0)
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    for _, id := range []string{"a", "b"} {
+        if err := s.Save(ctx, MemoryItem{ID: id, Tenant: ten, Content: "内容" + id}); err != nil { t.Fatalf("save: %v", err) }
+    }
+    fp := s.pathOf(ten)
+    before, _ := os.ReadFile(fp)
+
+    // 模拟崩溃：下一条记录只写入了一半
+    line, _ := encodeLine(&diskRecord{MemoryItem: MemoryItem{ID: "c", Tenant: ten, Content: "写了一半的记录"}})
+    appendRaw(t, fp, line[:len(line)/2])
+
+    s2 := reopenDisk(t, s)
+    after, _ := os.ReadFile(fp)
+    if !bytes.Equal(after, before) { t.Fatalf("torn tail should be truncated:\n%s", after) }
+    q, _ := s2.Quarantined(ctx, ten)
+    if len(q) != 1 || !strings.HasPrefix(q[0].Error, "torn tail") || q[0].Offset != int64(len(before)) { t.Fatalf("quarantine: %+v", q) }
+
+    // 截断后继续写入不会与残缺内容拼接
+    if err := s2.Save(ctx, MemoryItem{ID: "d", Tenant: ten, Content: "恢复后"}); err != nil { t.Fatalf("save: %v", err) }
+    r, _ := reopenDisk(t, s).Query(ctx, QueryRequest{Tenant: ten})
+    if len(r.Items) != 3 || r.Items[0].ID != "d" { t.Fatalf("after recovery: %+v", r.Items) }
+
+    // 完整但缺少换行的末尾记录保留并补齐换行
+    line, _ = encodeLine(&diskRecord{MemoryItem: MemoryItem{ID: "e", Tenant: ten, Content: "完整"}})
+    appendRaw(t, fp, line)
+    s3 := reopenDisk(t, s)
+    if _, err := s3.Get(ctx, ten, "e"); err != nil { t.Fatalf("complete tail should be kept: %v", err) }
+    if data, _ := os.ReadFile(fp); data[len(data)-1] != '\n' { t.Fatalf("newline not restored") }
+}
+
+func TestDiskDurability_TornTailFromAnotherWriter(t *testing.T) {
+    s := newTestDiskStore(t, 0)
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    _ = s.Save(ctx, MemoryItem{ID: "a", Tenant: ten, Content: "前"})
+
+    // 运行中另一写入方崩溃留下残缺记录：下一次访问截断后再追加
+    line, _ := encodeLine(&diskRecord{MemoryItem: MemoryItem{ID: "x", Tenant: ten, Content: "残缺"}})
+    appendRaw(t, s.pathOf(ten), line[:10])
+    if err := s.Save(ctx, MemoryItem{ID: "b", Tenant: ten, Content: "后"}); err != nil { t.Fatalf("save: %v", err) }
+    if _, err := s.Get(ctx, ten, "b"); err != nil { t.Fatalf("get: %v", err) }
+
+    data, _ := os.ReadFile(s.pathOf(ten))
+    for _, l := range splitLines(data) {
+        if _, err := decodeLine(l); err != nil { t.Fatalf("corrupt line left in file: %q: %v", l, err) }
+    }
+    r, _ := reopenDisk(t, s).Query(ctx, QueryRequest{Tenant: ten})
+    if len(r.Items) != 2 { t.Fatalf("reopen: %+v", r.Items) }
+}
+
+func TestDiskDurability_ChecksumMismatchQuarantined(t *testing.T) {
+    s := newTestDiskStore(t, 0)
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    for _, id := range []string{"a", "b", "c"} {
+        _ = s.Save(ctx, MemoryItem{ID: id, Tenant: ten, Content: "content-" + id})
+    }
+
+    // 翻转一个字节：行仍是合法 JSON，但校验和不符
+    fp := s.pathOf(ten)
+    data, _ := os.ReadFile(fp)
+    data = bytes.Replace(data, []byte("content-b"), []byte("content-B"), 1)
+    _ = os.WriteFile(fp, data, 0o644)
+
+    s2 := reopenDisk(t, s)
+    if _, err := s2.Get(ctx, ten, "b"); !errors.Is(err, ErrNotFound) { t.Fatalf("corrupted record should be skipped: %v", err) }
+    r, _ := s2.Query(ctx, QueryRequest{Tenant: ten})
+    if len(r.Items) != 2 { t.Fatalf("intact records: %+v", r.Items) }
+    q, _ := s2.Quarantined(ctx, ten)
+    if len(q) != 1 || q[0].Error != ErrChecksum.Error() { t.Fatalf("quarantine: %+v", q) }
+
+    // 无校验后缀的旧记录仍可读取
+    appendRaw(t, fp, []byte(`{"id":"legacy","tenant":{"user_id":"u","archive_id":"a"},"content":"旧格式"}`+"\n"))
+    if _, err := reopenDisk(t, s).Get(ctx, ten, "legacy"); err != nil { t.Fatalf("legacy line: %v", err) }
+}
+
+func TestDiskDurability_SharedVolumeWriters(t *testing.T) {
+    root := t.TempDir()
+    open := func(sync string) *diskJSONStore {
+        st, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: root, MaxFileBytes: 4096, Sync: sync})
+        if err != nil { t.Fatalf("open: %v", err) }
+        return st.(*diskJSONStore)
+    }
+    // 两个实例模拟共享数据卷的两个副本，各自持有独立的目录锁
+    s1, s2 := open(SyncGroup), open(SyncAlways)
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+
+    var wg sync.WaitGroup
+    errs := make(chan error, 8)
+    for w, st := range []*diskJSONStore{s1, s1, s2, s2} {
+        wg.Add(1)
+        go func(w int, st *diskJSONStore) {
+            defer wg.Done()
+            for i := 0; i < 50; i++ {
+                if err := st.Save(ctx, MemoryItem{ID: fmt.Sprintf("w%d-%02d", w, i), Tenant: ten, Content: strings.Repeat("记", 10)}); err != nil {
+                    errs <- err
+                    return
+                }
+            }
+        }(w, st)
+    }
+    wg.Wait()
+    close(errs)
+    for err := range errs { t.Fatalf("save: %v", err) }
+
+    // 一个实例写入后另一个实例立即可见
+    if _, err := s2.Get(ctx, ten, "w0-49"); err != nil { t.Fatalf("cross-instance get: %v", err) }
+    _ = s1.Save(ctx, MemoryItem{ID: "last", Tenant: ten, Content: "最后"})
+    r, _ := s2.Query(ctx, QueryRequest{Tenant: ten, TopK: 1})
+    if len(r.Items) != 1 || r.Items[0].ID != "last" { t.Fatalf("catch up: %+v", r.Items) }
+
+    all, err := open("").Export(ctx, ten)
+    if err != nil || len(all) != 201 { t.Fatalf("expect 201 items, got %d: %v", len(all), err) }
+    q, _ := s1.Quarantined(ctx, ten)
+    if len(q) != 0 { t.Fatalf("interleaved writes: %+v", q) }
+
+    if _, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: root, Sync: "sometimes"}); err == nil { t.Fatalf("expect invalid sync policy error") }
+}
@@ -0,0 +1,632 @@
+package rag
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "hash/fnv"
+    "io"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// 磁盘存储的段与索引
+// - 段：活动段 data.jsonl（seq=0）与封存段 data-{seq}.jsonl；封存段不再修改
+// - 旁路索引：封存段对应 data-{seq}.idx，首行为 {"v":1,"size":段大小}，其后每行一条记录元数据
+//   （op、id、偏移、长度、kind、tags、时间），按段内顺序排列；段大小不符或缺失时重新扫描段并重写
+// - 租户索引：启动时由旁路索引与活动段扫描重建，记录每个有效条目的位置与过滤字段；
+//   查询只按索引过滤，再按偏移读取命中的记录。全文（BM25 与小写正文）在首次文本查询时加载，之后增量维护
+// - 损坏检测：无法解析的行记入 quarantine.jsonl（段名、偏移、长度、错误与原始内容），不参与回放
+
+const (
+    activeSeq      = 0
+    sidecarVersion = 1
+    quarantineName = "quarantine.jsonl"
+    opCorrupt      = "corrupt" // 旁路索引中标记损坏行
+)
+
+// recMeta 记录元数据（旁路索引的一行）
+type recMeta struct {
+    Op        string     `json:"op,omitempty"`
+    ID        string     `json:"id,omitempty"`
+    Off       int64      `json:"off"`
+    Len       int        `json:"len"`
+    Kind      MemoryKind `json:"kind,omitempty"`
+    Tags      []string   `json:"tags,omitempty"`
+    CreatedAt time.Time  `json:"created_at"`
+    ExpiresAt *time.Time `json:"expires_at,omitempty"`
+    Version   int        `json:"version,omitempty"`
+}
+
+type sidecarHeader struct {
+    V    int   `json:"v"`
+    Size int64 `json:"size"`
+}
+
+// QuarantineEntry 损坏记录
+type QuarantineEntry struct {
+    Tenant     Tenant    `json:"tenant"`
+    Segment    string    `json:"segment"`
+    Offset     int64     `json:"offset"`
+    Length     int       `json:"length"`
+    Error      string    `json:"error"`
+    DetectedAt time.Time `json:"detected_at"`
+    Data       []byte    `json:"data,omitempty"`
+}
+
+// Quarantiner 可报告损坏记录的存储
+type Quarantiner interface {
+    Quarantined(ctx context.Context, t Tenant) ([]QuarantineEntry, error)
+}
+
+// indexEntry 租户索引中的条目
+type indexEntry struct {
+    recMeta
+    seq int
+}
+
+// tenantIndex 单个租户的内存索引（受 diskJSONStore.mu 保护）
+type tenantIndex struct {
+    entries []indexEntry
+    alive   []bool
+    pos     map[string]int          // id -> entries 下标
+    history map[string][]indexEntry // id -> 被取代的旧版本（从旧到新，见 version.go）
+    active  []recMeta               // 活动段的记录元数据，封存时写入旁路索引
+    size    int64                   // 活动段已索引的字节数
+    dirMod  time.Time               // 加载或最近一次写入后的租户目录修改时间
+
+    // unordered 为 false 时 entries 按 CreatedAt 非递减排列（按时间查询可从末尾扫描到一页即止）
+    unordered bool
+    latest    time.Time
+
+    text  bool       // 全文是否已加载
+    bm25  *bm25Index
+    lower []string   // 与 entries 对齐的小写正文（子串匹配）
+}
+
+func newTenantIndex() *tenantIndex {
+    return &tenantIndex{pos: make(map[string]int)}
+}
+
+// textKey BM25 文档键：无 ID 的旧数据以下标代替
+func (x *tenantIndex) textKey(i int) string {
+    if id := x.entries[i].ID; id != "" {
+        return id
+    }
+    return "~" + strconv.Itoa(i)
+}
+
+// apply 按回放语义更新索引；全文已加载时 item 用于维护全文
+func (x *tenantIndex) apply(m recMeta, seq int, item *MemoryItem) {
+    switch m.Op {
+    case opBase:
+        *x = tenantIndex{pos: make(map[string]int), active: x.active, size: x.size, dirMod: x.dirMod}
+        return
+    case opCorrupt:
+        return
+    }
+    i, seen := x.pos[m.ID]
+    switch {
+    case m.Op == opDelete:
+        if seen {
+            if x.text {
+                x.bm25.Remove(x.textKey(i))
+                x.lower[i] = ""
+            }
+            x.alive[i] = false
+            delete(x.pos, m.ID)
+            delete(x.history, m.ID)
+        }
+        return
+    case seen:
+        if !x.entries[i].CreatedAt.Equal(m.CreatedAt) {
+            x.unordered = true
+        }
+        if m.Version > x.entries[i].Version {
+            if x.history == nil {
+                x.history = make(map[string][]indexEntry)
+            }
+            x.history[m.ID] = append(x.history[m.ID], x.entries[i])
+        }
+        x.entries[i] = indexEntry{recMeta: m, seq: seq}
+    case m.Op == opUpdate:
+        return
+    default:
+        if m.CreatedAt.Before(x.latest) {
+            x.unordered = true
+        } else {
+            x.latest = m.CreatedAt
+        }
+        i = len(x.entries)
+        if m.ID != "" {
+            x.pos[m.ID] = i
+        }
+        x.entries = append(x.entries, indexEntry{recMeta: m, seq: seq})
+        x.alive = append(x.alive, true)
+        if x.text {
+            x.lower = append(x.lower, "")
+        }
+    }
+    if x.text && item != nil {
+        doc := *item
+        doc.ID = x.textKey(i)
+        x.bm25.Add(doc)
+        x.lower[i] = strings.ToLower(item.Content)
+    }
+}
+
+// metaOf 由记录生成元数据（off/len 由调用方填写）
+func metaOf(rec diskRecord) recMeta {
+    m := recMeta{Op: rec.Op, ID: rec.ID}
+    if rec.Op == "" || rec.Op == opUpdate {
+        m.Kind = rec.Kind
+        m.Tags = append([]string(nil), rec.Tags...)
+        m.CreatedAt = rec.CreatedAt
+        m.ExpiresAt = rec.ExpiresAt
+        m.Version = rec.Version
+    }
+    return m
+}
+
+func (s *diskJSONStore) segmentPath(t Tenant, seq int) string {
+    if seq == activeSeq {
+        return s.pathOf(t)
+    }
+    return filepath.Join(filepath.Dir(s.pathOf(t)), fmt.Sprintf("%s%06d.jsonl", segmentPrefix, seq))
+}
+
+func sidecarPath(segPath string) string {
+    return strings.TrimSuffix(segPath, ".jsonl") + ".idx"
+}
+
+// seal 将活动段封存为新的封存段并写出旁路索引（调用方持有锁）；活动段不存在或为空时返回 false
+func (s *diskJSONStore) seal(t Tenant) (bool, error) {
+    fp := s.pathOf(t)
+    st, err := os.Stat(fp)
+    if err != nil || st.Size() == 0 {
+        return false, nil
+    }
+    dst, err := s.nextSegment(t)
+    if err != nil {
+        return false, err
+    }
+    seq, _ := segmentSeq(filepath.Base(dst))
+    if s.sync != SyncNone {
+        if err := syncFile(fp); err != nil {
+            return false, err
+        }
+    }
+    if err := os.Rename(fp, dst); err != nil {
+        return false, fmt.Errorf("seal segment: %w", err)
+    }
+    if s.sync != SyncNone {
+        syncDir(filepath.Dir(dst))
+    }
+    if x := s.tenants[fp]; x != nil {
+        for i := range x.entries {
+            if x.entries[i].seq == activeSeq {
+                x.entries[i].seq = seq
+            }
+        }
+        if x.size == st.Size() {
+            // 旁路索引写入失败不影响数据，下次加载时重新扫描
+            _ = writeSidecar(sidecarPath(dst), st.Size(), x.active)
+        }
+        x.active, x.size = nil, 0
+    }
+    return true, nil
+}
+
+// writeSidecar 原子写出旁路索引
+func writeSidecar(path string, size int64, metas []recMeta) error {
+    var buf bytes.Buffer
+    enc := json.NewEncoder(&buf)
+    if err := enc.Encode(sidecarHeader{V: sidecarVersion, Size: size}); err != nil {
+        return err
+    }
+    for i := range metas {
+        if err := enc.Encode(&metas[i]); err != nil {
+            return err
+        }
+    }
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+        return fmt.Errorf("write sidecar: %w", err)
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        _ = os.Remove(tmp)
+        return fmt.Errorf("rename sidecar: %w", err)
+    }
+    return nil
+}
+
+// readSidecar 读取旁路索引；与段大小不符或损坏时返回 false
+func readSidecar(path string, size int64) ([]recMeta, bool) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, false
+    }
+    defer f.Close()
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+    if !sc.Scan() {
+        return nil, false
+    }
+    var h sidecarHeader
+    if err := json.Unmarshal(sc.Bytes(), &h); err != nil || h.V != sidecarVersion || h.Size != size {
+        return nil, false
+    }
+    var metas []recMeta
+    for sc.Scan() {
+        var m recMeta
+        if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+            return nil, false
+        }
+        metas = append(metas, m)
+    }
+    return metas, sc.Err() == nil
+}
+
+// scanSegment 从 from 偏移开始逐行扫描段，对每条记录调用 fn（损坏行 rec 为 nil），
+// 返回损坏行、段大小，以及最后一行是否缺少换行
+func scanSegment(path string, from int64, fn func(m recMeta, rec *diskRecord)) ([]QuarantineEntry, int64, bool, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, 0, false, nil
+        }
+        return nil, 0, false, fmt.Errorf("open file: %w", err)
+    }
+    defer f.Close()
+    if from > 0 {
+        if _, err := f.Seek(from, io.SeekStart); err != nil {
+            return nil, 0, false, fmt.Errorf("seek segment: %w", err)
+        }
+    }
+
+    var (
+        bad  []QuarantineEntry
+        off  = from
+        torn bool
+    )
+    r := bufio.NewReaderSize(f, 64*1024)
+    for {
+        line, err := r.ReadBytes('\n')
+        if len(line) > 0 {
+            torn = line[len(line)-1] != '\n'
+            body := bytes.TrimRight(line, "\r\n")
+            if len(bytes.TrimSpace(body)) > 0 {
+                if rec, derr := decodeLine(body); derr != nil {
+                    fn(recMeta{Op: opCorrupt, Off: off, Len: len(body)}, nil)
+                    bad = append(bad, QuarantineEntry{
+                        Segment: filepath.Base(path),
+                        Offset:  off,
+                        Length:  len(body),
+                        Error:   derr.Error(),
+                        Data:    append([]byte(nil), body...),
+                    })
+                } else {
+                    m := metaOf(rec)
+                    m.Off, m.Len = off, len(body)
+                    fn(m, &rec)
+                }
+            }
+            off += int64(len(line))
+        }
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, 0, false, fmt.Errorf("read segment: %w", err)
+        }
+    }
+    return bad, off, torn, nil
+}
+
+// tenantIdx 返回租户索引（调用方持有锁）
+// 未加载时加载；其他进程追加了活动段时增量追上，封存、压缩等改变目录的操作后整体重建
+func (s *diskJSONStore) tenantIdx(t Tenant) (*tenantIndex, error) {
+    key := s.pathOf(t)
+    if x := s.tenants[key]; x != nil {
+        size, mod := s.fingerprint(t)
+        if size == x.size && mod.Equal(x.dirMod) {
+            return x, nil
+        }
+        if size > x.size && mod.Equal(x.dirMod) {
+            if ok, err := s.catchUp(t, x); err != nil || ok {
+                return x, err
+            }
+        }
+        delete(s.tenants, key)
+    }
+    x, err := s.loadIndex(t)
+    if err != nil {
+        return nil, err
+    }
+    s.tenants[key] = x
+    return x, nil
+}
+
+// fingerprint 活动段大小与租户目录修改时间
+func (s *diskJSONStore) fingerprint(t Tenant) (int64, time.Time) {
+    var size int64
+    var mod time.Time
+    if st, err := os.Stat(s.pathOf(t)); err == nil {
+        size = st.Size()
+    }
+    if st, err := os.Stat(filepath.Dir(s.pathOf(t))); err == nil {
+        mod = st.ModTime()
+    }
+    return size, mod
+}
+
+// catchUp 回放其他进程追加到活动段的记录；末尾残缺时返回 false 交由整体重建处理
+func (s *diskJSONStore) catchUp(t Tenant, x *tenantIndex) (bool, error) {
+    var metas []recMeta
+    var items []*MemoryItem
+    bad, size, torn, err := scanSegment(s.pathOf(t), x.size, func(m recMeta, rec *diskRecord) {
+        metas = append(metas, m)
+        if rec != nil {
+            items = append(items, &rec.MemoryItem)
+        } else {
+            items = append(items, nil)
+        }
+    })
+    if err != nil || torn {
+        return false, err
+    }
+    for i, m := range metas {
+        x.active = append(x.active, m)
+        x.apply(m, activeSeq, items[i])
+    }
+    x.size = size
+    if len(bad) > 0 {
+        if err := s.quarantine(t, bad); err != nil {
+            return false, err
+        }
+    }
+    _, x.dirMod = s.fingerprint(t)
+    return true, nil
+}
+
+// loadIndex 由旁路索引与段扫描重建租户索引：先截断活动段末尾的残缺记录，新发现的损坏行写入隔离文件
+func (s *diskJSONStore) loadIndex(t Tenant) (*tenantIndex, error) {
+    x := newTenantIndex()
+    var bad []QuarantineEntry
+    q, err := recoverTail(s.pathOf(t))
+    if err != nil {
+        return nil, err
+    }
+    if q != nil {
+        bad = append(bad, *q)
+    }
+    segs, err := s.segments(t)
+    if err != nil {
+        return nil, err
+    }
+    for _, path := range append(segs, s.pathOf(t)) {
+        seq, _ := segmentSeq(filepath.Base(path))
+        if seq != activeSeq {
+            if st, err := os.Stat(path); err == nil {
+                if metas, ok := readSidecar(sidecarPath(path), st.Size()); ok {
+                    for _, m := range metas {
+                        x.apply(m, seq, nil)
+                    }
+                    continue
+                }
+            }
+        }
+        var metas []recMeta
+        b, size, _, err := scanSegment(path, 0, func(m recMeta, _ *diskRecord) {
+            metas = append(metas, m)
+            x.apply(m, seq, nil)
+        })
+        if err != nil {
+            return nil, err
+        }
+        bad = append(bad, b...)
+        if seq == activeSeq {
+            x.active, x.size = metas, size
+        } else {
+            _ = writeSidecar(sidecarPath(path), size, metas)
+        }
+    }
+    if len(bad) > 0 {
+        if err := s.quarantine(t, bad); err != nil {
+            return nil, err
+        }
+    }
+    _, x.dirMod = s.fingerprint(t)
+    return x, nil
+}
+
+// quarantine 追加新发现的损坏行（按段名、偏移与内容去重）
+func (s *diskJSONStore) quarantine(t Tenant, bad []QuarantineEntry) error {
+    existing, err := s.Quarantined(context.Background(), t)
+    if err != nil {
+        return err
+    }
+    key := func(q QuarantineEntry) string {
+        h := fnv.New64a()
+        _, _ = h.Write(q.Data)
+        return q.Segment + ":" + strconv.FormatInt(q.Offset, 10) + ":" + strconv.FormatUint(h.Sum64(), 36)
+    }
+    seen := make(map[string]bool, len(existing))
+    for _, q := range existing {
+        seen[key(q)] = true
+    }
+    fp := filepath.Join(filepath.Dir(s.pathOf(t)), quarantineName)
+    f, err := os.OpenFile(fp, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+    if err != nil {
+        return fmt.Errorf("open quarantine: %w", err)
+    }
+    defer f.Close()
+    enc := json.NewEncoder(f)
+    now := time.Now()
+    for _, q := range bad {
+        if seen[key(q)] {
+            continue
+        }
+        q.Tenant = t
+        q.DetectedAt = now
+        if err := enc.Encode(&q); err != nil {
+            return fmt.Errorf("encode quarantine: %w", err)
+        }
+    }
+    return nil
+}
+
+// Quarantined 返回租户的损坏记录（含原始内容）
+func (s *diskJSONStore) Quarantined(ctx context.Context, t Tenant) ([]QuarantineEntry, error) {
+    f, err := os.Open(filepath.Join(filepath.Dir(s.pathOf(t)), quarantineName))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("open quarantine: %w", err)
+    }
+    defer f.Close()
+    var res []QuarantineEntry
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+    for sc.Scan() {
+        var q QuarantineEntry
+        if err := json.Unmarshal(sc.Bytes(), &q); err == nil {
+            res = append(res, q)
+        }
+    }
+    return res, sc.Err()
+}
+
+// loadText 加载全文：逐段读取有效条目的正文构建 BM25 与小写正文（调用方持有锁）
+func (s *diskJSONStore) loadText(t Tenant, x *tenantIndex) error {
+    if x.text {
+        return nil
+    }
+    all := make([]int, 0, len(x.entries))
+    for i := range x.entries {
+        if x.alive[i] {
+            all = append(all, i)
+        }
+    }
+    items, err := s.readEntries(t, x, all)
+    if err != nil {
+        return err
+    }
+    x.bm25 = newBM25Index()
+    x.lower = make([]string, len(x.entries))
+    for k, i := range all {
+        doc := items[k]
+        doc.ID = x.textKey(i)
+        x.bm25.Add(doc)
+        x.lower[i] = strings.ToLower(items[k].Content)
+    }
+    x.text = true
+    return nil
+}
+
+// readEntries 按偏移读取条目（结果与 idxs 一一对应）
+func (s *diskJSONStore) readEntries(t Tenant, x *tenantIndex, idxs []int) ([]MemoryItem, error) {
+    es := make([]indexEntry, len(idxs))
+    for k, i := range idxs {
+        es[k] = x.entries[i]
+    }
+    return s.readRecords(t, es)
+}
+
+// readRecords 按偏移读取记录（结果与 es 一一对应）
+// 同一段的读取合并为一次打开；条目较多时整段读入
+func (s *diskJSONStore) readRecords(t Tenant, es []indexEntry) ([]MemoryItem, error) {
+    res := make([]MemoryItem, len(es))
+    bySeq := make(map[int][]int)
+    for k, e := range es {
+        bySeq[e.seq] = append(bySeq[e.seq], k)
+    }
+    for seq, ks := range bySeq {
+        f, err := os.Open(s.segmentPath(t, seq))
+        if err != nil {
+            return nil, fmt.Errorf("open segment: %w", err)
+        }
+        var whole []byte
+        if len(ks) > 64 {
+            whole, err = io.ReadAll(f)
+            if err != nil {
+                f.Close()
+                return nil, fmt.Errorf("read segment: %w", err)
+            }
+        }
+        for _, k := range ks {
+            e := es[k]
+            var buf []byte
+            if whole != nil && e.Off+int64(e.Len) <= int64(len(whole)) {
+                buf = whole[e.Off : e.Off+int64(e.Len)]
+            } else {
+                buf = make([]byte, e.Len)
+                if _, err := f.ReadAt(buf, e.Off); err != nil {
+                    f.Close()
+                    return nil, fmt.Errorf("read record: %w", err)
+                }
+            }
+            rec, err := decodeLine(buf)
+            if err != nil {
+                f.Close()
+                return nil, fmt.Errorf("decode record at %d: %w", e.Off, err)
+            }
+            res[k] = rec.MemoryItem
+        }
+        f.Close()
+    }
+    return res, nil
+}
+
+// loadAll 启动时为全部租户重建索引
+func (s *diskJSONStore) loadAll(ctx context.Context) error {
+    tenants, err := s.Tenants(ctx)
+    if err != nil {
+        return err
+    }
+    for _, t := range tenants {
+        err := s.locked(t, false, func() error {
+            _, err := s.tenantIdx(t)
+            return err
+        })
+        if err != nil {
+            return fmt.Errorf("load index %s/%s: %w", t.UserID, t.ArchiveID, err)
+        }
+    }
+    return nil
+}
+
+// Quarantined 返回磁盘存储中租户的损坏记录；t 为空时返回全部租户
+func (m *Manager) Quarantined(ctx context.Context, t *Tenant) ([]QuarantineEntry, error) {
+    q, ok := m.disk.(Quarantiner)
+    if !ok {
+        return nil, errors.New("磁盘存储未启用或不支持损坏检测")
+    }
+    if t != nil {
+        return q.Quarantined(ctx, *t)
+    }
+    c, ok := m.disk.(Compactor)
+    if !ok {
+        return nil, errors.New("磁盘存储不支持列举租户")
+    }
+    tenants, err := c.Tenants(ctx)
+    if err != nil {
+        return nil, err
+    }
+    var res []QuarantineEntry
+    for _, ten := range tenants {
+        es, err := q.Quarantined(ctx, ten)
+        if err != nil {
+            return nil, err
+        }
+        res = append(res, es...)
+    }
+    return res, nil
+}
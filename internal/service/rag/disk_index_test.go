@@ -0,0 +1,169 @@
+package rag
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestDiskIndex_SidecarAndReload(t *testing.T) {
+    s := newTestDiskStore(t, 300)
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    base := time.Now().Add(-time.Hour)
+    for i := 0; i < 12; i++ {
+        it := MemoryItem{ID: fmt.Sprintf("m%02d", i), Tenant: ten, Kind: KindNote, Content: fmt.Sprintf("第%d条 %s", i, strings.Repeat("雪", 20)), CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+        if i%3 == 0 { it.Tags = []string{"hot"} }
+        if err := s.Save(ctx, it); err != nil { t.Fatalf("save: %v", err) }
+    }
+    _ = s.Update(ctx, MemoryItem{ID: "m00", Tenant: ten, Kind: KindNote, Tags: []string{"hot"}, Content: "改写后的龙", CreatedAt: base})
+    _ = s.Delete(ctx, ten, "m03")
+
+    // 封存段都有旁路索引
+    segs, _ := s.segments(ten)
+    if len(segs) < 2 { t.Fatalf("expect rotated segments") }
+    for _, fp := range segs {
+        if _, err := os.Stat(sidecarPath(fp)); err != nil { t.Fatalf("missing sidecar for %s", fp) }
+    }
+
+    check := func(st *diskJSONStore) {
+        t.Helper()
+        r, err := st.Query(ctx, QueryRequest{Tenant: ten, Tags: []string{"hot"}})
+        if err != nil { t.Fatalf("query: %v", err) }
+        var ids []string
+        for _, it := range r.Items { ids = append(ids, it.ID) }
+        if strings.Join(ids, ",") != "m09,m06,m00" { t.Fatalf("tag query: %v", ids) }
+        r, _ = st.Query(ctx, QueryRequest{Tenant: ten, Query: "龙"})
+        if len(r.Items) != 1 || r.Items[0].Content != "改写后的龙" { t.Fatalf("text query: %+v", r.Items) }
+        r, _ = st.Query(ctx, QueryRequest{Tenant: ten, TopK: 2})
+        if len(r.Items) != 2 || r.Items[0].ID != "m11" { t.Fatalf("topk: %+v", r.Items) }
+        if _, err := st.Get(ctx, ten, "m03"); err != ErrNotFound { t.Fatalf("deleted item: %v", err) }
+    }
+    check(s)
+
+    // 重新打开：由旁路索引重建；旁路索引过期（大小不符）时重新扫描段
+    _ = os.WriteFile(sidecarPath(segs[0]), []byte(`{"v":1,"size":1}`+"\n"), 0o644)
+    s2, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: s.root, MaxFileBytes: 300})
+    if err != nil { t.Fatalf("reopen: %v", err) }
+    check(s2.(*diskJSONStore))
+    data, _ := os.ReadFile(sidecarPath(segs[0]))
+    if len(splitLines(data)) < 2 { t.Fatalf("stale sidecar should be rewritten: %s", data) }
+}
+
+func TestDiskIndex_QuarantineCorruptLines(t *testing.T) {
+    s := newTestDiskStore(t, 0)
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    _ = s.Save(ctx, MemoryItem{ID: "a", Tenant: ten, Content: "前"})
+
+    f, _ := os.OpenFile(s.pathOf(ten), os.O_APPEND|os.O_WRONLY, 0o644)
+    _, _ = f.WriteString("{\"id\":\"broken\",\"content\":\n")
+    _ = f.Close()
+
+    // 追加检测到外部修改，重新加载索引；损坏行被隔离，前后记录不受影响
+    _ = s.Save(ctx, MemoryItem{ID: "b", Tenant: ten, Content: "后"})
+    r, _ := s.Query(ctx, QueryRequest{Tenant: ten})
+    if len(r.Items) != 2 || r.Items[0].ID != "b" { t.Fatalf("query around corrupt line: %+v", r.Items) }
+
+    for i := 0; i < 2; i++ {
+        // 多次重新打开不重复隔离
+        st, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: s.root})
+        if err != nil { t.Fatalf("reopen: %v", err) }
+        q, err := st.(Quarantiner).Quarantined(ctx, ten)
+        if err != nil { t.Fatalf("quarantined: %v", err) }
+        if len(q) != 1 || q[0].Segment != "data.jsonl" || q[0].Offset == 0 || !strings.Contains(string(q[0].Data), "broken") || q[0].Error == "" {
+            t.Fatalf("unexpected quarantine report: %+v", q)
+        }
+    }
+    if _, err := os.Stat(filepath.Join(filepath.Dir(s.pathOf(ten)), quarantineName)); err != nil { t.Fatalf("quarantine file: %v", err) }
+
+    // Manager 汇总全部租户
+    m := newTestManager(t, func(o *RAGOptions) { o.DiskJSON.RootPath, o.Namespace = s.root, "ns" })
+    all, err := m.Quarantined(ctx, nil)
+    if err != nil || len(all) != 1 || all[0].Tenant != ten { t.Fatalf("manager report: %+v %v", all, err) }
+}
+
+// benchTenantSize 基准的单租户条目数
+const benchTenantSize = 100_000
+
+// newBenchDiskStore 直接写出 10 万条记录的封存段与活动段，再打开存储（计入一次索引重建）
+func newBenchDiskStore(b *testing.B) (*diskJSONStore, Tenant) {
+    b.Helper()
+    root := b.TempDir()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    s := &diskJSONStore{root: root, namespace: "ns"}
+    dir := filepath.Dir(s.pathOf(ten))
+    if err := os.MkdirAll(dir, 0o755); err != nil { b.Fatalf("mkdir: %v", err) }
+
+    words := []string{"王城", "北境", "林夏", "林秋", "南港", "龙", "雪原", "商队", "灯塔", "旧港"}
+    base := time.Now().Add(-24 * time.Hour)
+    var seg, active []byte
+    for i := 0; i < benchTenantSize; i++ {
+        it := MemoryItem{
+            ID:        fmt.Sprintf("m%06d", i),
+            Tenant:    ten,
+            Kind:      []MemoryKind{KindNote, KindFact, KindShortTerm}[i%3],
+            Tags:      []string{fmt.Sprintf("t%d", i%50)},
+            Content:   fmt.Sprintf("第%d条记录：%s 与 %s 在 %s 相遇", i, words[i%10], words[(i/10)%10], words[(i/100)%10]),
+            CreatedAt: base.Add(time.Duration(i) * time.Second),
+        }
+        line, _ := json.Marshal(diskRecord{MemoryItem: it})
+        if i < benchTenantSize*9/10 {
+            seg = append(append(seg, line...), '\n')
+        } else {
+            active = append(append(active, line...), '\n')
+        }
+    }
+    _ = os.WriteFile(filepath.Join(dir, segmentPrefix+"000001.jsonl"), seg, 0o644)
+    _ = os.WriteFile(s.pathOf(ten), active, 0o644)
+
+    st, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: root})
+    if err != nil { b.Fatalf("open: %v", err) }
+    return st.(*diskJSONStore), ten
+}
+
+func BenchmarkDiskQuery_100k(b *testing.B) {
+    s, ten := newBenchDiskStore(b)
+    ctx := context.Background()
+    cases := []struct {
+        name string
+        req  QueryRequest
+    }{
+        {"recent", QueryRequest{Tenant: ten, TopK: 10}},
+        {"kind_tag", QueryRequest{Tenant: ten, Kinds: []MemoryKind{KindFact}, Tags: []string{"t7"}, TopK: 10}},
+        {"text", QueryRequest{Tenant: ten, Query: "林夏 灯塔", TopK: 10}},
+    }
+    // 全文在首次文本查询时加载，单独计时
+    b.Run("text_load", func(b *testing.B) {
+        for i := 0; i < b.N; i++ {
+            s.tenants[s.pathOf(ten)].text = false
+            if err := s.loadText(ten, s.tenants[s.pathOf(ten)]); err != nil { b.Fatalf("load text: %v", err) }
+        }
+    })
+    for _, c := range cases {
+        b.Run(c.name, func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                r, err := s.Query(ctx, c.req)
+                if err != nil || len(r.Items) == 0 { b.Fatalf("query: %d %v", len(r.Items), err) }
+            }
+        })
+    }
+    b.Run("get", func(b *testing.B) {
+        for i := 0; i < b.N; i++ {
+            if _, err := s.Get(ctx, ten, fmt.Sprintf("m%06d", i%benchTenantSize)); err != nil { b.Fatalf("get: %v", err) }
+        }
+    })
+}
+
+func BenchmarkDiskOpen_100k(b *testing.B) {
+    s, _ := newBenchDiskStore(b)
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        if _, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: s.root}); err != nil { b.Fatalf("open: %v", err) }
+    }
+}
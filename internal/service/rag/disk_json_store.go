@@ -3,48 +3,87 @@ package rag
 import (
     "bufio"
     "context"
-    "encoding/json"
     "errors"
     "fmt"
     "os"
     "path/filepath"
+    "sort"
     "strings"
+    "sync"
     "time"
+
+    "ahs/internal/tenantpath"
 )
 
 // diskJSONStore 基于 JSONL 的本地持久化
-// 每个租户一个 JSONL 文件：{RootPath}/{Namespace}/{user_id}/{archive_id}/data.jsonl
-// 逐行追加；查询时顺序读取并在内存中过滤（中小规模适用）
+// 每个租户一个目录：{RootPath}/{Namespace}/{enc(user_id)}/{enc(archive_id)}/
+// - data.jsonl 为活动段；超过 MaxFileBytes 时封存为 data-{seq}.jsonl，seq 递增
+// - 读取时按 seq 顺序回放封存段，最后回放活动段
+// 目录名使用 tenantpath.Encode 可逆编码，不同 ID 不会映射到同一目录
+// 逐行追加；查询走租户内存索引（disk_index.go），只读取命中的记录
+// 文件只追加不改写：更新追加 op=update 的完整新版本，删除追加 op=delete 的墓碑记录，
+// 读取时按 ID 回放（更新保留原位置，墓碑移除该条目）；压缩（compact.go）以 op=base 段整体替换旧数据
+// Version 更大的 op=update 记录为新版本，被取代的记录作为历史版本保留在索引中（Versions，见 version.go）
+// 文本查询使用 BM25：每租户倒排索引在首次文本查询时构建，之后随写入/更新/删除增量维护
 
 type diskJSONStore struct {
     root      string
     namespace string
     maxBytes  int64
+    sync      string      // 刷盘策略，见 disk_durability.go
+    commit    groupCommit // sync=group 时的组提交
+
+    mu      sync.Mutex              // 串行化写入与索引访问，保证 Update/Delete 的存在性检查与追加原子
+    tenants map[string]*tenantIndex // 租户文件路径 -> 内存索引（受 mu 保护）
+}
+
+const (
+    opUpdate = "update"
+    opDelete = "delete"
+    opBase   = "base" // 压缩段首行：丢弃此前回放的全部数据
+)
+
+// diskRecord JSONL 中的一行：记忆本身，或带 op 的变更记录
+type diskRecord struct {
+    MemoryItem
+    Op        string     `json:"op,omitempty"`
+    DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 func NewDiskJSONStore(ns string, opts DiskJSONOptions) (Store, error) {
     if opts.RootPath == "" {
         return nil, errors.New("DiskJSON.RootPath 不能为空")
     }
-    return &diskJSONStore{
+    policy, err := validSync(opts.Sync)
+    if err != nil {
+        return nil, err
+    }
+    s := &diskJSONStore{
         root:      opts.RootPath,
         namespace: ns,
         maxBytes:  opts.MaxFileBytes,
-    }, nil
+        sync:      policy,
+        tenants:   make(map[string]*tenantIndex),
+    }
+    // 旧版本目录名为原始 ID（仅做了简单替换），启动时迁移到编码布局
+    if _, err := tenantpath.MigrateLegacy(s.baseDir()); err != nil {
+        return nil, fmt.Errorf("migrate legacy layout: %w", err)
+    }
+    // 启动时重建全部租户索引，同时检测损坏记录
+    if err := s.loadAll(context.Background()); err != nil {
+        return nil, err
+    }
+    return s, nil
 }
 
-func (s *diskJSONStore) pathOf(t Tenant) string {
-    // {RootPath}/{Namespace}/{user_id}/{archive_id}/data.jsonl
-    dir := filepath.Join(s.root, s.namespace, safePath(t.UserID), safePath(t.ArchiveID))
-    return filepath.Join(dir, "data.jsonl")
+// baseDir 命名空间根目录：{RootPath}/{Namespace}（命名空间来自配置，不做编码）
+func (s *diskJSONStore) baseDir() string {
+    return filepath.Join(s.root, s.namespace)
 }
 
-func safePath(p string) string {
-    p = strings.TrimSpace(p)
-    p = strings.ReplaceAll(p, "..", "_")
-    p = strings.ReplaceAll(p, string(os.PathSeparator), "_")
-    if p == "" { p = "_" }
-    return p
+func (s *diskJSONStore) pathOf(t Tenant) string {
+    // {RootPath}/{Namespace}/{enc(user_id)}/{enc(archive_id)}/data.jsonl
+    return filepath.Join(tenantpath.Dir(s.baseDir(), t.UserID, t.ArchiveID), "data.jsonl")
 }
 
 func (s *diskJSONStore) ensureDir(filePath string) error {
@@ -52,94 +91,428 @@ func (s *diskJSONStore) ensureDir(filePath string) error {
 }
 
 func (s *diskJSONStore) Save(ctx context.Context, item MemoryItem) error {
-    fp := s.pathOf(item.Tenant)
-    if err := s.ensureDir(fp); err != nil {
-        return fmt.Errorf("ensure dir: %w", err)
-    }
-
     // 如果未设置时间戳，自动填充
     if item.CreatedAt.IsZero() {
         item.CreatedAt = time.Now()
     }
 
+    var seq uint64
+    err := s.locked(item.Tenant, true, func() (err error) {
+        seq, err = s.appendRecord(item.Tenant, diskRecord{MemoryItem: item})
+        return err
+    })
+    if err != nil {
+        return err
+    }
+    return s.commit.wait(seq)
+}
+
+// appendRecord 追加一行记录并更新租户索引（调用方持有锁）
+// 返回组提交序号（sync=group 时调用方在锁外等待落盘，否则为 0）
+func (s *diskJSONStore) appendRecord(t Tenant, rec diskRecord) (uint64, error) {
+    fp := s.pathOf(t)
+    x, err := s.tenantIdx(t)
+    if err != nil {
+        return 0, err
+    }
+
+    line, err := encodeLine(&rec)
+    if err != nil {
+        return 0, err
+    }
     f, err := os.OpenFile(fp, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
     if err != nil {
-        return fmt.Errorf("open file: %w", err)
+        return 0, fmt.Errorf("open file: %w", err)
     }
     defer f.Close()
+    st, err := f.Stat()
+    if err != nil {
+        return 0, fmt.Errorf("stat file: %w", err)
+    }
+    // 整行一次写入，崩溃时最多留下末尾一条残缺记录
+    if _, err := f.Write(append(line, '\n')); err != nil {
+        return 0, fmt.Errorf("write record: %w", err)
+    }
+    var seq uint64
+    switch s.sync {
+    case SyncAlways:
+        if err := f.Sync(); err != nil {
+            return 0, fmt.Errorf("fsync: %w", err)
+        }
+    case SyncGroup:
+        seq = s.commit.mark(fp)
+    }
+
+    if st.Size() != x.size {
+        // 活动段与索引不一致（持有锁时不应发生），重新加载索引
+        delete(s.tenants, fp)
+        if x, err = s.tenantIdx(t); err != nil {
+            return 0, err
+        }
+    } else {
+        m := metaOf(rec)
+        m.Off, m.Len = st.Size(), len(line)
+        x.active = append(x.active, m)
+        x.size = st.Size() + int64(len(line)) + 1
+        x.apply(m, activeSeq, &rec.MemoryItem)
+    }
 
-    enc := json.NewEncoder(f)
-    if err := enc.Encode(&item); err != nil {
-        return fmt.Errorf("encode json: %w", err)
+    // 活动段超过 maxBytes 时封存，后续写入新的活动段；旧段由压缩合并
+    if s.maxBytes > 0 && x.size > s.maxBytes {
+        if _, err := s.seal(t); err != nil {
+            return 0, err
+        }
     }
+    _, x.dirMod = s.fingerprint(t)
+    return seq, nil
+}
 
-    // 简单的大小限制（可选）：超过 maxBytes 时不处理（预留压缩策略）
-    if s.maxBytes > 0 {
-        if st, err := f.Stat(); err == nil && st.Size() > s.maxBytes {
-            // 预留：触发后台压缩/轮转
+// readAll 按写入顺序读取租户全部段，回放更新与墓碑后返回当前有效的记忆
+func (s *diskJSONStore) readAll(t Tenant) ([]MemoryItem, error) {
+    var rp replayer
+    if err := s.replayTenant(t, &rp); err != nil {
+        return nil, err
+    }
+    return rp.live(), nil
+}
+
+// replayTenant 依次回放封存段与活动段
+func (s *diskJSONStore) replayTenant(t Tenant, rp *replayer) error {
+    segs, err := s.segments(t)
+    if err != nil {
+        return err
+    }
+    for _, fp := range append(segs, s.pathOf(t)) {
+        if err := rp.replayFile(fp); err != nil {
+            return err
         }
     }
     return nil
 }
 
-func (s *diskJSONStore) Query(ctx context.Context, req QueryRequest) (QueryResult, error) {
-    fp := s.pathOf(req.Tenant)
+// replayer 回放 JSONL 记录
+type replayer struct {
+    all     []MemoryItem
+    alive   []bool
+    pos     map[string]int          // id -> all 中的位置
+    history map[string][]MemoryItem // id -> 被取代的旧版本（从旧到新）
+    records int                     // 自最近一次 base 以来回放的记录数
+}
+
+func (rp *replayer) replayFile(fp string) error {
     f, err := os.Open(fp)
     if err != nil {
         if os.IsNotExist(err) {
-            return QueryResult{Items: nil}, nil
+            return nil
         }
-        return QueryResult{}, fmt.Errorf("open file: %w", err)
+        return fmt.Errorf("open file: %w", err)
     }
     defer f.Close()
 
-    // 顺序读取后逆序筛选，保证新数据优先
-    var all []MemoryItem
     sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
     for sc.Scan() {
-        var it MemoryItem
-        if err := json.Unmarshal(sc.Bytes(), &it); err == nil {
-            all = append(all, it)
+        rec, err := decodeLine(sc.Bytes())
+        if err != nil {
+            continue
         }
+        rp.apply(rec)
     }
     if err := sc.Err(); err != nil {
-        return QueryResult{}, fmt.Errorf("scan jsonl: %w", err)
+        return fmt.Errorf("scan jsonl: %w", err)
+    }
+    return nil
+}
+
+func (rp *replayer) apply(rec diskRecord) {
+    if rp.pos == nil || rec.Op == opBase {
+        *rp = replayer{pos: make(map[string]int), history: make(map[string][]MemoryItem)}
+        if rec.Op == opBase {
+            return
+        }
+    }
+    rp.records++
+    i, seen := rp.pos[rec.ID]
+    switch {
+    case rec.Op == opDelete:
+        if seen {
+            rp.alive[i] = false
+            delete(rp.pos, rec.ID)
+            delete(rp.history, rec.ID)
+        }
+    case seen:
+        // op=update 或重复保存同一 ID：新记录替换旧记录，保留原位置；版本更大时旧记录转入历史
+        if rec.Version > rp.all[i].Version {
+            rp.history[rec.ID] = append(rp.history[rec.ID], rp.all[i])
+        }
+        rp.all[i] = rec.MemoryItem
+    case rec.Op == opUpdate:
+        // 原记录不存在（已删除），忽略
+    default:
+        if rec.ID != "" {
+            rp.pos[rec.ID] = len(rp.all)
+        }
+        rp.all = append(rp.all, rec.MemoryItem)
+        rp.alive = append(rp.alive, true)
     }
+}
 
+// live 当前有效的记忆（按首次写入顺序）
+func (rp *replayer) live() []MemoryItem {
+    var res []MemoryItem
+    for i, it := range rp.all {
+        if rp.alive[i] {
+            res = append(res, it)
+        }
+    }
+    return res
+}
+
+func (s *diskJSONStore) Query(ctx context.Context, req QueryRequest) (QueryResult, error) {
+    var res QueryResult
+    err := s.locked(req.Tenant, false, func() (err error) {
+        res, err = s.query(req)
+        return err
+    })
+    return res, err
+}
+
+// query 按索引筛选并读取命中的记录（调用方持有锁）
+func (s *diskJSONStore) query(req QueryRequest) (QueryResult, error) {
+    plan, err := planQuery(req)
+    if err != nil {
+        return QueryResult{}, err
+    }
+    x, err := s.tenantIdx(req.Tenant)
+    if err != nil {
+        return QueryResult{}, err
+    }
+
+    // 按索引筛选；按时间排序且无需读取正文时，entries 有序则从新到旧扫描到一页（及同一时刻的条目）即止
     now := time.Now()
-    res := make([]MemoryItem, 0, len(all))
-    for i := len(all) - 1; i >= 0; i-- {
-        it := all[i]
-        // 过期过滤
-        if it.ExpiresAt != nil && it.ExpiresAt.Before(now) {
+    need := plan.need()
+    early := need > 0 && !x.unordered && req.Query == "" && len(req.Meta) == 0 && plan.sort == SortRecency
+    var cands []int
+    for i := len(x.entries) - 1; i >= 0; i-- {
+        if !x.alive[i] {
             continue
         }
-        // 类型过滤
-        if len(req.Kinds) > 0 {
-            ok := false
-            for _, k := range req.Kinds {
-                if it.Kind == k { ok = true; break }
+        e := &x.entries[i]
+        if early && len(cands) >= need && e.CreatedAt.Before(x.entries[cands[len(cands)-1]].CreatedAt) {
+            break
+        }
+        if !plan.before(e.CreatedAt, e.ID) || !matchFilters(MemoryItem{Kind: e.Kind, Tags: e.Tags, CreatedAt: e.CreatedAt, ExpiresAt: e.ExpiresAt}, req, now) {
+            continue
+        }
+        cands = append(cands, i)
+    }
+
+    var scores map[int]float64
+    if req.Query != "" {
+        if err := s.loadText(req.Tenant, x); err != nil {
+            return QueryResult{}, err
+        }
+        sc := x.bm25.Score(req.Query)
+        q := strings.ToLower(req.Query)
+        scores = make(map[int]float64)
+        kept := cands[:0]
+        for _, i := range cands {
+            v, ok := sc[x.textKey(i)]
+            // 分词未命中时退回子串匹配
+            if !ok && !strings.Contains(x.lower[i], q) {
+                continue
+            }
+            scores[i] = v
+            kept = append(kept, i)
+        }
+        cands = kept
+    }
+    byScore := plan.sort == SortScore
+    sort.SliceStable(cands, func(a, b int) bool {
+        i, j := cands[a], cands[b]
+        if byScore && scores[i] != scores[j] {
+            return scores[i] > scores[j]
+        }
+        ei, ej := &x.entries[i], &x.entries[j]
+        return recencyLess(ei.CreatedAt, ei.ID, ej.CreatedAt, ej.ID)
+    })
+
+    read := func(idx []int) ([]MemoryItem, error) {
+        items, err := s.readEntries(req.Tenant, x, idx)
+        if err != nil {
+            return nil, err
+        }
+        for k, i := range idx {
+            items[k].Score = scores[i]
+        }
+        return items, nil
+    }
+
+    // Meta 谓词需读取记录：按顺序分批读取并过滤，直到足够一页
+    if len(req.Meta) > 0 {
+        var items []MemoryItem
+        batch := 64
+        for start := 0; start < len(cands) && (need == 0 || len(items) < need); start += batch {
+            end := start + batch
+            if end > len(cands) {
+                end = len(cands)
+            }
+            got, err := read(cands[start:end])
+            if err != nil {
+                return QueryResult{}, err
             }
-            if !ok { continue }
-        }
-        // 标签过滤（子集包含）
-        if len(req.Tags) > 0 {
-            tagOK := true
-            for _, want := range req.Tags {
-                found := false
-                for _, t := range it.Tags { if t == want { found = true; break } }
-                if !found { tagOK = false; break }
+            for _, it := range got {
+                if matchMeta(it.Meta, req.Meta) {
+                    items = append(items, it)
+                }
             }
-            if !tagOK { continue }
         }
-        // 文本包含
-        if req.Query != "" && !strings.Contains(strings.ToLower(it.Content), strings.ToLower(req.Query)) {
-            continue
+        return plan.page(items), nil
+    }
+
+    lo, hi, more := plan.window(len(cands))
+    items, err := read(cands[lo:hi])
+    if err != nil {
+        return QueryResult{}, err
+    }
+    res := QueryResult{Items: items}
+    if more && len(items) > 0 {
+        res.NextCursor = plan.next(items[len(items)-1], hi)
+    }
+    return res, nil
+}
+
+func (s *diskJSONStore) Get(ctx context.Context, t Tenant, id string) (MemoryItem, error) {
+    var it MemoryItem
+    err := s.locked(t, false, func() (err error) {
+        it, err = s.get(t, id)
+        return err
+    })
+    return it, err
+}
+
+// get 按索引定位并读取单条记忆（调用方持有锁）
+func (s *diskJSONStore) get(t Tenant, id string) (MemoryItem, error) {
+    x, err := s.tenantIdx(t)
+    if err != nil {
+        return MemoryItem{}, err
+    }
+    i, ok := x.pos[id]
+    if id == "" || !ok {
+        return MemoryItem{}, ErrNotFound
+    }
+    items, err := s.readEntries(t, x, []int{i})
+    if err != nil {
+        return MemoryItem{}, err
+    }
+    return items[0], nil
+}
+
+// Versions 按索引读取条目的历史版本与当前版本（从旧到新）
+func (s *diskJSONStore) Versions(ctx context.Context, t Tenant, id string) ([]MemoryItem, error) {
+    var res []MemoryItem
+    err := s.locked(t, false, func() error {
+        x, err := s.tenantIdx(t)
+        if err != nil {
+            return err
         }
-        res = append(res, it)
-        if req.TopK > 0 && len(res) >= req.TopK { break }
+        i, ok := x.pos[id]
+        if id == "" || !ok {
+            return ErrNotFound
+        }
+        es := append(append([]indexEntry(nil), x.history[id]...), x.entries[i])
+        res, err = s.readRecords(t, es)
+        return err
+    })
+    return res, err
+}
+
+func (s *diskJSONStore) Update(ctx context.Context, item MemoryItem) error {
+    var seq uint64
+    err := s.locked(item.Tenant, false, func() (err error) {
+        if _, err := s.get(item.Tenant, item.ID); err != nil {
+            return err
+        }
+        seq, err = s.appendRecord(item.Tenant, diskRecord{MemoryItem: item, Op: opUpdate})
+        return err
+    })
+    if err != nil {
+        return err
     }
-    return QueryResult{Items: res}, nil
+    return s.commit.wait(seq)
+}
+
+func (s *diskJSONStore) Delete(ctx context.Context, t Tenant, id string) error {
+    var seq uint64
+    err := s.locked(t, false, func() (err error) {
+        if _, err := s.get(t, id); err != nil {
+            return err
+        }
+        now := time.Now()
+        seq, err = s.appendRecord(t, diskRecord{
+            MemoryItem: MemoryItem{ID: id, Tenant: t},
+            Op:         opDelete,
+            DeletedAt:  &now,
+        })
+        return err
+    })
+    if err != nil {
+        return err
+    }
+    return s.commit.wait(seq)
 }
 
 func (s *diskJSONStore) Close(ctx context.Context) error { return nil }
+
+func (s *diskJSONStore) ListArchives(ctx context.Context, userID string) ([]string, error) {
+    entries, err := os.ReadDir(filepath.Join(s.baseDir(), tenantpath.Encode(userID)))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("read dir: %w", err)
+    }
+    var res []string
+    for _, e := range entries {
+        if !e.IsDir() {
+            continue
+        }
+        // 无法解码的目录（如哈希兜底名）不属于可列举的归档
+        if id, err := tenantpath.Decode(e.Name()); err == nil {
+            res = append(res, id)
+        }
+    }
+    sort.Strings(res)
+    return res, nil
+}
+
+func (s *diskJSONStore) Create(ctx context.Context, t Tenant) error {
+    fp := s.pathOf(t)
+    if err := s.ensureDir(fp); err != nil {
+        return fmt.Errorf("ensure dir: %w", err)
+    }
+    f, err := os.OpenFile(fp, os.O_CREATE|os.O_WRONLY, 0o644)
+    if err != nil {
+        return fmt.Errorf("open file: %w", err)
+    }
+    return f.Close()
+}
+
+func (s *diskJSONStore) Export(ctx context.Context, t Tenant) ([]MemoryItem, error) {
+    var items []MemoryItem
+    err := s.locked(t, false, func() (err error) {
+        items, err = s.readAll(t)
+        return err
+    })
+    return items, err
+}
+
+func (s *diskJSONStore) Purge(ctx context.Context, t Tenant) error {
+    return s.locked(t, false, func() error {
+        delete(s.tenants, s.pathOf(t))
+        if err := os.RemoveAll(filepath.Dir(s.pathOf(t))); err != nil {
+            return fmt.Errorf("remove archive: %w", err)
+        }
+        return nil
+    })
+}
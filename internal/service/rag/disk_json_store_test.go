@@ -2,9 +2,12 @@ package rag
 
 import (
     "context"
+    "os"
     "path/filepath"
     "testing"
     "time"
+
+    "ahs/internal/tenantpath"
 )
 
 func TestDiskJSONStore_SaveQuery_BasicFilters(t *testing.T) {
@@ -59,11 +62,42 @@ func TestDiskJSONStore_MultiTenantIsolation(t *testing.T) {
     if len(rb.Items) != 1 || rb.Items[0].ID != "B1" { t.Fatalf("expect only B1, got %+v", rb.Items) }
 
     // 校验文件路径隔离（不同用户归档路径不同）
-    pA := filepath.Clean(filepath.Join(tmp, "ns", safePath(tenA.UserID), safePath(tenA.ArchiveID), "data.jsonl"))
-    pB := filepath.Clean(filepath.Join(tmp, "ns", safePath(tenB.UserID), safePath(tenB.ArchiveID), "data.jsonl"))
+    pA := filepath.Clean(filepath.Join(tenantpath.Dir(filepath.Join(tmp, "ns"), tenA.UserID, tenA.ArchiveID), "data.jsonl"))
+    pB := filepath.Clean(filepath.Join(tenantpath.Dir(filepath.Join(tmp, "ns"), tenB.UserID, tenB.ArchiveID), "data.jsonl"))
     if pA == pB { t.Fatalf("tenant files should differ: %s vs %s", pA, pB) }
 }
 
+func TestDiskJSONStore_NoPathCollision_And_LegacyMigration(t *testing.T) {
+    tmp := t.TempDir()
+    ctx := context.Background()
+
+    // 旧布局：目录名即原始 ID
+    legacy := filepath.Join(tmp, "ns", "UserA", "arc_1")
+    if err := os.MkdirAll(legacy, 0o755); err != nil { t.Fatalf("mkdir: %v", err) }
+    line := `{"id":"old","tenant":{"user_id":"UserA","archive_id":"arc_1"},"content":"legacy","created_at":"2024-01-01T00:00:00Z"}` + "\n"
+    if err := os.WriteFile(filepath.Join(legacy, "data.jsonl"), []byte(line), 0o644); err != nil { t.Fatalf("write: %v", err) }
+
+    st, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: tmp})
+    if err != nil { t.Fatalf("new disk store: %v", err) }
+
+    // 迁移后旧数据仍可按原租户读取
+    qr, err := st.Query(ctx, QueryRequest{Tenant: Tenant{UserID: "UserA", ArchiveID: "arc_1"}, TopK: 10})
+    if err != nil { t.Fatalf("query: %v", err) }
+    if len(qr.Items) != 1 || qr.Items[0].ID != "old" { t.Fatalf("expect migrated legacy item, got %+v", qr.Items) }
+    if _, err := os.Stat(legacy); !os.IsNotExist(err) { t.Fatalf("legacy dir should be moved, stat err=%v", err) }
+
+    // 旧 safePath 下 "a/b" 与 "a_b" 会落到同一目录，编码后互不干扰
+    tX := Tenant{UserID: "u", ArchiveID: "a/b"}
+    tY := Tenant{UserID: "u", ArchiveID: "a_b"}
+    if err := st.Save(ctx, MemoryItem{ID: "x", Tenant: tX, Content: "x"}); err != nil { t.Fatalf("save x: %v", err) }
+    if err := st.Save(ctx, MemoryItem{ID: "y", Tenant: tY, Content: "y"}); err != nil { t.Fatalf("save y: %v", err) }
+    rx, _ := st.Query(ctx, QueryRequest{Tenant: tX, TopK: 10})
+    ry, _ := st.Query(ctx, QueryRequest{Tenant: tY, TopK: 10})
+    if len(rx.Items) != 1 || rx.Items[0].ID != "x" || len(ry.Items) != 1 || ry.Items[0].ID != "y" {
+        t.Fatalf("tenants collided: x=%+v y=%+v", rx.Items, ry.Items)
+    }
+}
+
 func TestDiskJSONStore_AutoCreatedAt_Expiry_TopK(t *testing.T) {
     tmp := t.TempDir()
     st, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: tmp})
@@ -89,3 +123,53 @@ func TestDiskJSONStore_AutoCreatedAt_Expiry_TopK(t *testing.T) {
     if err != nil { t.Fatalf("query: %v", err) }
     if len(qr.Items) != 1 || qr.Items[0].ID != "3" { t.Fatalf("expect only latest non-expired item 3, got %+v", qr.Items) }
 }
+
+func TestDiskJSONStore_UpdateDelete_Tombstones(t *testing.T) {
+    tmp := t.TempDir()
+    st, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: tmp})
+    if err != nil { t.Fatalf("new disk store: %v", err) }
+
+    ctx := context.Background()
+    ten := Tenant{UserID: "u1", ArchiveID: "a1"}
+    for _, id := range []string{"1", "2", "3"} {
+        if err := st.Save(ctx, MemoryItem{ID: id, Tenant: ten, Content: "v" + id, CreatedAt: time.Now()}); err != nil { t.Fatalf("save %s: %v", id, err) }
+    }
+
+    // 更新：保留原位置
+    upd := MemoryItem{ID: "1", Tenant: ten, Content: "v1-fixed", CreatedAt: time.Now()}
+    if err := st.Update(ctx, upd); err != nil { t.Fatalf("update: %v", err) }
+    got, err := st.Get(ctx, ten, "1")
+    if err != nil || got.Content != "v1-fixed" { t.Fatalf("get after update: %+v %v", got, err) }
+
+    // 删除：写墓碑，文件只追加
+    if err := st.Delete(ctx, ten, "2"); err != nil { t.Fatalf("delete: %v", err) }
+    if _, err := st.Get(ctx, ten, "2"); err != ErrNotFound { t.Fatalf("expect ErrNotFound, got %v", err) }
+    if err := st.Delete(ctx, ten, "2"); err != ErrNotFound { t.Fatalf("double delete: %v", err) }
+    if err := st.Update(ctx, MemoryItem{ID: "2", Tenant: ten, Content: "x"}); err != ErrNotFound { t.Fatalf("update deleted: %v", err) }
+
+    // 按 CreatedAt 从新到旧：更新后的 1 带新的时间戳
+    qr, err := st.Query(ctx, QueryRequest{Tenant: ten, TopK: 10})
+    if err != nil { t.Fatalf("query: %v", err) }
+    if len(qr.Items) != 2 || qr.Items[0].Content != "v1-fixed" || qr.Items[1].ID != "3" {
+        t.Fatalf("unexpected items: %+v", qr.Items)
+    }
+
+    // 重新打开后回放结果一致
+    st2, _ := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: tmp})
+    qr2, _ := st2.Query(ctx, QueryRequest{Tenant: ten, TopK: 10})
+    if len(qr2.Items) != 2 { t.Fatalf("reopen: %+v", qr2.Items) }
+    b, _ := os.ReadFile(filepath.Join(tenantpath.Dir(filepath.Join(tmp, "ns"), "u1", "a1"), "data.jsonl"))
+    if n := len(splitLines(b)); n != 5 { t.Fatalf("expect 5 appended lines, got %d", n) }
+}
+
+func splitLines(b []byte) [][]byte {
+    var res [][]byte
+    start := 0
+    for i, c := range b {
+        if c == '\n' {
+            res = append(res, b[start:i])
+            start = i + 1
+        }
+    }
+    return res
+}
@@ -0,0 +1,178 @@
+package rag
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "hash/fnv"
+    "io"
+    "math"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// Embedder 文本向量化接口
+// - Embed: 批量向量化，返回与输入一一对应的向量
+// - Dim: 向量维度
+type Embedder interface {
+    Embed(ctx context.Context, texts []string) ([][]float32, error)
+    Dim() int
+}
+
+// 向量化提供方（VectorOptions.EmbeddingProvider）
+const (
+    EmbeddingProviderHash   = "hash"   // 本地哈希向量，确定性、无需网络，适合离线与测试
+    EmbeddingProviderOpenAI = "openai" // OpenAI 兼容的 /embeddings 接口
+)
+
+// DefaultEmbeddingDim 未配置维度时哈希向量的默认维度
+const DefaultEmbeddingDim = 256
+
+// NewEmbedder 按配置创建 Embedder
+func NewEmbedder(opts VectorOptions) (Embedder, error) {
+    switch strings.ToLower(opts.EmbeddingProvider) {
+    case "", EmbeddingProviderHash:
+        return NewHashingEmbedder(opts.Dim), nil
+    case EmbeddingProviderOpenAI:
+        return NewOpenAIEmbedder(opts.EmbeddingEndpoint, opts.EmbeddingAPIKey, opts.EmbeddingModel, opts.Dim)
+    default:
+        return nil, fmt.Errorf("未知的 embedding provider: %s", opts.EmbeddingProvider)
+    }
+}
+
+// HashingEmbedder 基于特征哈希的确定性向量（词袋 + 符号哈希，L2 归一化）
+// 语义能力有限，但同词重合度高的文本余弦相似度高
+type HashingEmbedder struct {
+    dim int
+}
+
+func NewHashingEmbedder(dim int) *HashingEmbedder {
+    if dim <= 0 {
+        dim = DefaultEmbeddingDim
+    }
+    return &HashingEmbedder{dim: dim}
+}
+
+func (e *HashingEmbedder) Dim() int { return e.dim }
+
+func (e *HashingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+    res := make([][]float32, len(texts))
+    for i, text := range texts {
+        v := make([]float32, e.dim)
+        for _, tok := range Tokenize(text) {
+            h := fnv.New64a()
+            _, _ = h.Write([]byte(tok))
+            sum := h.Sum64()
+            idx := int(sum % uint64(e.dim))
+            if sum>>63 == 1 {
+                v[idx]--
+            } else {
+                v[idx]++
+            }
+        }
+        normalize(v)
+        res[i] = v
+    }
+    return res, nil
+}
+
+// OpenAIEmbedder 调用 OpenAI 兼容的 POST {endpoint}/embeddings
+type OpenAIEmbedder struct {
+    endpoint string
+    apiKey   string
+    model    string
+    dim      int
+    client   *http.Client
+}
+
+func NewOpenAIEmbedder(endpoint, apiKey, model string, dim int) (*OpenAIEmbedder, error) {
+    if endpoint == "" {
+        return nil, errors.New("EmbeddingEndpoint 不能为空")
+    }
+    if model == "" {
+        return nil, errors.New("EmbeddingModel 不能为空")
+    }
+    if dim <= 0 {
+        return nil, errors.New("使用 openai embedding 时必须配置 Dim")
+    }
+    return &OpenAIEmbedder{
+        endpoint: strings.TrimRight(endpoint, "/"),
+        apiKey:   apiKey,
+        model:    model,
+        dim:      dim,
+        client:   &http.Client{Timeout: 30 * time.Second},
+    }, nil
+}
+
+func (e *OpenAIEmbedder) Dim() int { return e.dim }
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+    if len(texts) == 0 {
+        return nil, nil
+    }
+    body, err := json.Marshal(map[string]any{"model": e.model, "input": texts})
+    if err != nil {
+        return nil, fmt.Errorf("encode request: %w", err)
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint+"/embeddings", bytes.NewReader(body))
+    if err != nil {
+        return nil, fmt.Errorf("build request: %w", err)
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if e.apiKey != "" {
+        req.Header.Set("Authorization", "Bearer "+e.apiKey)
+    }
+
+    resp, err := e.client.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("embedding request: %w", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return nil, fmt.Errorf("embedding request: status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+    }
+
+    var out struct {
+        Data []struct {
+            Index     int       `json:"index"`
+            Embedding []float32 `json:"embedding"`
+        } `json:"data"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+        return nil, fmt.Errorf("decode response: %w", err)
+    }
+    if len(out.Data) != len(texts) {
+        return nil, fmt.Errorf("embedding 数量不匹配: 期望 %d，实际 %d", len(texts), len(out.Data))
+    }
+    res := make([][]float32, len(texts))
+    for _, d := range out.Data {
+        if d.Index < 0 || d.Index >= len(texts) {
+            return nil, fmt.Errorf("embedding index 越界: %d", d.Index)
+        }
+        if len(d.Embedding) != e.dim {
+            return nil, fmt.Errorf("embedding 维度不匹配: 期望 %d，实际 %d", e.dim, len(d.Embedding))
+        }
+        normalize(d.Embedding)
+        res[d.Index] = d.Embedding
+    }
+    return res, nil
+}
+
+// normalize 原地 L2 归一化，归一化后余弦相似度即点积
+func normalize(v []float32) {
+    var sum float64
+    for _, x := range v {
+        sum += float64(x) * float64(x)
+    }
+    if sum == 0 {
+        return
+    }
+    n := float32(math.Sqrt(sum))
+    for i := range v {
+        v[i] /= n
+    }
+}
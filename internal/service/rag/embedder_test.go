@@ -0,0 +1,59 @@
+package rag
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestHashingEmbedder_DeterministicAndNormalized(t *testing.T) {
+    e := NewHashingEmbedder(64)
+    ctx := context.Background()
+    a, _ := e.Embed(ctx, []string{"主角的妹妹喜欢画画"})
+    b, _ := e.Embed(ctx, []string{"主角的妹妹喜欢画画"})
+    if len(a[0]) != 64 { t.Fatalf("dim: %d", len(a[0])) }
+    var norm float32
+    for i := range a[0] {
+        if a[0][i] != b[0][i] { t.Fatalf("not deterministic") }
+        norm += a[0][i] * a[0][i]
+    }
+    if norm < 0.999 || norm > 1.001 { t.Fatalf("not normalized: %v", norm) }
+}
+
+func TestOpenAIEmbedder_RequestAndResponse(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/v1/embeddings" || r.Header.Get("Authorization") != "Bearer sk-test" {
+            http.Error(w, "bad request", http.StatusBadRequest)
+            return
+        }
+        var in struct {
+            Model string   `json:"model"`
+            Input []string `json:"input"`
+        }
+        _ = json.NewDecoder(r.Body).Decode(&in)
+        if in.Model != "text-embedding-3-small" { http.Error(w, "bad model", http.StatusBadRequest); return }
+        // 故意乱序返回，客户端按 index 还原
+        type datum struct {
+            Index     int       `json:"index"`
+            Embedding []float32 `json:"embedding"`
+        }
+        out := struct{ Data []datum `json:"data"` }{}
+        for i := len(in.Input) - 1; i >= 0; i-- {
+            out.Data = append(out.Data, datum{Index: i, Embedding: []float32{float32(i + 1), 0, 0}})
+        }
+        _ = json.NewEncoder(w).Encode(out)
+    }))
+    defer srv.Close()
+
+    e, err := NewOpenAIEmbedder(srv.URL+"/v1/", "sk-test", "text-embedding-3-small", 3)
+    if err != nil { t.Fatalf("new embedder: %v", err) }
+    vs, err := e.Embed(context.Background(), []string{"a", "b"})
+    if err != nil { t.Fatalf("embed: %v", err) }
+    if len(vs) != 2 || vs[0][0] != 1 || vs[1][0] != 1 { t.Fatalf("unexpected vectors: %v", vs) }
+
+    // 维度不匹配
+    e4, _ := NewOpenAIEmbedder(srv.URL+"/v1", "sk-test", "text-embedding-3-small", 4)
+    if _, err := e4.Embed(context.Background(), []string{"a"}); err == nil { t.Fatalf("expect dim mismatch error") }
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package rag
+
+// lockDir 非 unix 平台不支持目录 flock，仅依赖进程内锁
+func lockDir(dir string) (func(), error) {
+    return func() {}, nil
+}
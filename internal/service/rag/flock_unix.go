@@ -0,0 +1,28 @@
+//go:build unix
+
+package rag
+
+import (
+    "fmt"
+    "os"
+    "syscall"
+)
+
+// lockDir 对目录加 flock 排他锁（阻塞）；目录不存在时不加锁
+func lockDir(dir string) (func(), error) {
+    f, err := os.Open(dir)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return func() {}, nil
+        }
+        return nil, fmt.Errorf("open lock dir: %w", err)
+    }
+    if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+        _ = f.Close()
+        return nil, fmt.Errorf("flock: %w", err)
+    }
+    return func() {
+        _ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+        _ = f.Close()
+    }, nil
+}
@@ -0,0 +1,322 @@
+package rag
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// 外部检索服务的 HTTP 客户端（VectorClient / TripleClient）
+//
+// 传输约定：
+// - 全部接口均为 POST {Endpoint}{path}，请求与响应体为 JSON（Content-Type: application/json）
+// - 配置 APIKey 时携带 Authorization: Bearer {APIKey}
+// - 请求头携带 X-User-ID / X-Archive-ID，请求体携带 tenant；服务端必须按租户隔离
+// - 成功返回 2xx；404 视为目标不存在（ErrNotFound）；错误体建议为 {"error": "..."}
+// - 网络错误、429 与 5xx 按指数退避重试，响应含 Retry-After（秒）时以其为准；其余 4xx 不重试
+// - 检索结果中租户与请求不一致的条目会被客户端丢弃（缺省租户视为请求租户）
+//
+// 向量服务（HTTPVectorClient）：
+//   POST /v1/vectors/upsert  {"index", "tenant", "items": [MemoryItem]}       -> {}
+//   POST /v1/vectors/query   {"index", "tenant", "query", "vector", "top_k",
+//                             "tags", "kinds"}                                -> {"items": [MemoryItem]}
+//   POST /v1/vectors/delete  {"index", "tenant", "ids": [string]}             -> {"deleted": int}
+//   POST /v1/vectors/purge   {"index", "tenant"}                              -> {}
+// MemoryItem.vector 缺省时由服务端自行向量化；返回条目的 score 为相似度（越大越相关）
+//
+// 三元组服务（HTTPTripleClient）：
+//   POST /v1/triples/save    {"schema_version", "tenant", "items": [MemoryItem]} -> {}
+//   POST /v1/triples/query   {"schema_version", "tenant", "query", "top_k",
+//                             "tags", "kinds"}                                  -> {"items": [MemoryItem]}
+
+const (
+    pathVectorUpsert = "/v1/vectors/upsert"
+    pathVectorQuery  = "/v1/vectors/query"
+    pathVectorDelete = "/v1/vectors/delete"
+    pathVectorPurge  = "/v1/vectors/purge"
+    pathTripleSave   = "/v1/triples/save"
+    pathTripleQuery  = "/v1/triples/query"
+)
+
+// HTTPStatusError 外部服务返回的非 2xx 响应
+type HTTPStatusError struct {
+    StatusCode int
+    Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+    return fmt.Sprintf("status %d: %s", e.StatusCode, e.Body)
+}
+
+// httpDoer 带超时、重试与租户头的 JSON POST
+type httpDoer struct {
+    endpoint   string
+    apiKey     string
+    client     *http.Client
+    maxRetries int
+    backoff    time.Duration
+}
+
+func newHTTPDoer(endpoint, apiKey string, o HTTPClientOptions) (*httpDoer, error) {
+    if endpoint == "" {
+        return nil, errors.New("Endpoint 不能为空")
+    }
+    def := DefaultHTTPClientOptions()
+    if o.Timeout <= 0 { o.Timeout = def.Timeout }
+    if o.MaxRetries < 0 { o.MaxRetries = 0 }
+    if o.RetryBackoff <= 0 { o.RetryBackoff = def.RetryBackoff }
+    return &httpDoer{
+        endpoint:   strings.TrimRight(endpoint, "/"),
+        apiKey:     apiKey,
+        client:     &http.Client{Timeout: o.Timeout},
+        maxRetries: o.MaxRetries,
+        backoff:    o.RetryBackoff,
+    }, nil
+}
+
+// post 发送请求并解码响应到 out（out 可为 nil）
+func (d *httpDoer) post(ctx context.Context, path string, t Tenant, in, out any) error {
+    body, err := json.Marshal(in)
+    if err != nil {
+        return fmt.Errorf("encode request: %w", err)
+    }
+    wait := d.backoff
+    for attempt := 0; ; attempt++ {
+        retryAfter, err := d.once(ctx, path, t, body, out)
+        if err == nil {
+            return nil
+        }
+        if attempt >= d.maxRetries || !retryable(err) || ctx.Err() != nil {
+            return fmt.Errorf("%s: %w", path, err)
+        }
+        delay := wait
+        if retryAfter > 0 {
+            delay = retryAfter
+        }
+        select {
+        case <-ctx.Done():
+            return fmt.Errorf("%s: %w", path, ctx.Err())
+        case <-time.After(delay):
+        }
+        wait *= 2
+    }
+}
+
+// once 单次请求；返回服务端建议的重试等待（Retry-After）
+func (d *httpDoer) once(ctx context.Context, path string, t Tenant, body []byte, out any) (time.Duration, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint+path, bytes.NewReader(body))
+    if err != nil {
+        return 0, fmt.Errorf("build request: %w", err)
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-User-ID", t.UserID)
+    req.Header.Set("X-Archive-ID", t.ArchiveID)
+    if d.apiKey != "" {
+        req.Header.Set("Authorization", "Bearer "+d.apiKey)
+    }
+
+    resp, err := d.client.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        var ra time.Duration
+        if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+            ra = time.Duration(s) * time.Second
+        }
+        return ra, &HTTPStatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+    }
+    if out == nil {
+        _, _ = io.Copy(io.Discard, resp.Body)
+        return 0, nil
+    }
+    if err := json.NewDecoder(resp.Body).Decode(out); err != nil && err != io.EOF {
+        return 0, fmt.Errorf("decode response: %w", err)
+    }
+    return 0, nil
+}
+
+// retryable 网络错误、429 与 5xx 可重试
+func retryable(err error) bool {
+    var se *HTTPStatusError
+    if errors.As(err, &se) {
+        return se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= 500
+    }
+    return true
+}
+
+// notFound 将 404 映射为 ErrNotFound
+func notFound(err error) error {
+    var se *HTTPStatusError
+    if errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+        return ErrNotFound
+    }
+    return err
+}
+
+// scopeItems 丢弃不属于租户 t 的条目，缺省租户视为 t
+func scopeItems(items []MemoryItem, t Tenant) []MemoryItem {
+    res := items[:0]
+    for _, it := range items {
+        if it.Tenant == (Tenant{}) {
+            it.Tenant = t
+        }
+        if it.Tenant != t {
+            continue
+        }
+        res = append(res, it)
+    }
+    return res
+}
+
+type httpQueryBody struct {
+    Index         string       `json:"index,omitempty"`
+    SchemaVersion string       `json:"schema_version,omitempty"`
+    Tenant        Tenant       `json:"tenant"`
+    Query         string       `json:"query,omitempty"`
+    Vector        []float32    `json:"vector,omitempty"`
+    TopK          int          `json:"top_k,omitempty"`
+    Tags          []string     `json:"tags,omitempty"`
+    Kinds         []MemoryKind `json:"kinds,omitempty"`
+}
+
+type httpItemsBody struct {
+    Index         string       `json:"index,omitempty"`
+    SchemaVersion string       `json:"schema_version,omitempty"`
+    Tenant        Tenant       `json:"tenant"`
+    Items         []MemoryItem `json:"items,omitempty"`
+    IDs           []string     `json:"ids,omitempty"`
+}
+
+// HTTPVectorClient 外部向量服务客户端，实现 VectorClient 与删除能力
+type HTTPVectorClient struct {
+    doer  *httpDoer
+    index string
+}
+
+// NewHTTPVectorClient 按 VectorOptions（Endpoint/APIKey/Index/HTTP）创建客户端
+func NewHTTPVectorClient(opts VectorOptions) (*HTTPVectorClient, error) {
+    d, err := newHTTPDoer(opts.Endpoint, opts.APIKey, opts.HTTP)
+    if err != nil {
+        return nil, fmt.Errorf("vector: %w", err)
+    }
+    return &HTTPVectorClient{doer: d, index: opts.Index}, nil
+}
+
+func (c *HTTPVectorClient) Save(ctx context.Context, item MemoryItem) error {
+    if item.ID == "" {
+        return errors.New("id 不能为空")
+    }
+    item.Score = 0
+    item.Sources = nil
+    body := httpItemsBody{Index: c.index, Tenant: item.Tenant, Items: []MemoryItem{item}}
+    return c.doer.post(ctx, pathVectorUpsert, item.Tenant, body, nil)
+}
+
+func (c *HTTPVectorClient) Query(ctx context.Context, req QueryRequest) (QueryResult, error) {
+    body := httpQueryBody{
+        Index:  c.index,
+        Tenant: req.Tenant,
+        Query:  req.Query,
+        Vector: req.Vector,
+        TopK:   req.TopK,
+        Tags:   req.Tags,
+        Kinds:  req.Kinds,
+    }
+    var out QueryResult
+    if err := c.doer.post(ctx, pathVectorQuery, req.Tenant, body, &out); err != nil {
+        return QueryResult{}, err
+    }
+    return QueryResult{Items: scopeItems(out.Items, req.Tenant)}, nil
+}
+
+// Delete 删除向量，服务端返回 404 或 deleted=0 时返回 ErrNotFound
+func (c *HTTPVectorClient) Delete(ctx context.Context, t Tenant, id string) error {
+    var out struct {
+        Deleted *int `json:"deleted"`
+    }
+    body := httpItemsBody{Index: c.index, Tenant: t, IDs: []string{id}}
+    if err := c.doer.post(ctx, pathVectorDelete, t, body, &out); err != nil {
+        return notFound(err)
+    }
+    if out.Deleted != nil && *out.Deleted == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+// Purge 删除租户的全部向量
+func (c *HTTPVectorClient) Purge(ctx context.Context, t Tenant) error {
+    body := httpItemsBody{Index: c.index, Tenant: t}
+    if err := c.doer.post(ctx, pathVectorPurge, t, body, nil); err != nil && !errors.Is(notFound(err), ErrNotFound) {
+        return err
+    }
+    return nil
+}
+
+// HTTPTripleClient 外部三元组服务客户端，实现 TripleClient
+type HTTPTripleClient struct {
+    doer          *httpDoer
+    schemaVersion string
+}
+
+// NewHTTPTripleClient 按 TripleOptions（Endpoint/APIKey/SchemaVersion/HTTP）创建客户端
+func NewHTTPTripleClient(opts TripleOptions) (*HTTPTripleClient, error) {
+    d, err := newHTTPDoer(opts.Endpoint, opts.APIKey, opts.HTTP)
+    if err != nil {
+        return nil, fmt.Errorf("triple: %w", err)
+    }
+    return &HTTPTripleClient{doer: d, schemaVersion: opts.SchemaVersion}, nil
+}
+
+func (c *HTTPTripleClient) QueryTriples(ctx context.Context, req QueryRequest) (QueryResult, error) {
+    body := httpQueryBody{
+        SchemaVersion: c.schemaVersion,
+        Tenant:        req.Tenant,
+        Query:         req.Query,
+        TopK:          req.TopK,
+        Tags:          req.Tags,
+        Kinds:         req.Kinds,
+    }
+    var out QueryResult
+    if err := c.doer.post(ctx, pathTripleQuery, req.Tenant, body, &out); err != nil {
+        return QueryResult{}, err
+    }
+    return QueryResult{Items: scopeItems(out.Items, req.Tenant)}, nil
+}
+
+// SaveTriples 按租户分批保存（每个请求只含一个租户的条目）
+func (c *HTTPTripleClient) SaveTriples(ctx context.Context, items []MemoryItem) error {
+    var (
+        order  []Tenant
+        groups = make(map[Tenant][]MemoryItem)
+    )
+    for _, it := range items {
+        if it.Tenant.UserID == "" || it.Tenant.ArchiveID == "" {
+            return errors.New("tenant(user_id, archive_id) 不能为空")
+        }
+        if _, ok := groups[it.Tenant]; !ok {
+            order = append(order, it.Tenant)
+        }
+        it.Score = 0
+        it.Vector = nil
+        it.Sources = nil
+        groups[it.Tenant] = append(groups[it.Tenant], it)
+    }
+    for _, t := range order {
+        body := httpItemsBody{SchemaVersion: c.schemaVersion, Tenant: t, Items: groups[t]}
+        if err := c.doer.post(ctx, pathTripleSave, t, body, nil); err != nil {
+            return err
+        }
+    }
+    return nil
+}
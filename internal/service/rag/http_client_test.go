@@ -0,0 +1,205 @@
+package rag
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// fakeVectorService 按 http_client.go 的协议实现的最小向量服务
+type fakeVectorService struct {
+    mu    sync.Mutex
+    items map[Tenant][]MemoryItem
+    // leak 为真时查询额外返回其他租户的条目
+    leak bool
+}
+
+func (f *fakeVectorService) handler(t *testing.T) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("Authorization") != "Bearer k" {
+            http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+            return
+        }
+        var in struct {
+            Index  string       `json:"index"`
+            Tenant Tenant       `json:"tenant"`
+            Items  []MemoryItem `json:"items"`
+            IDs    []string     `json:"ids"`
+        }
+        _ = json.NewDecoder(r.Body).Decode(&in)
+        if in.Index != "novel" { t.Errorf("index: %q", in.Index) }
+        if r.Header.Get("X-User-ID") != in.Tenant.UserID || r.Header.Get("X-Archive-ID") != in.Tenant.ArchiveID {
+            t.Errorf("tenant header mismatch: %v", in.Tenant)
+        }
+        f.mu.Lock()
+        defer f.mu.Unlock()
+        switch r.URL.Path {
+        case pathVectorUpsert:
+            f.items[in.Tenant] = append(f.items[in.Tenant], in.Items...)
+            _, _ = w.Write([]byte(`{}`))
+        case pathVectorQuery:
+            res := append([]MemoryItem(nil), f.items[in.Tenant]...)
+            if f.leak {
+                res = append(res, MemoryItem{ID: "x", Tenant: Tenant{UserID: "other", ArchiveID: "a"}, Content: "leak"})
+            }
+            _ = json.NewEncoder(w).Encode(QueryResult{Items: res})
+        case pathVectorDelete:
+            n := 0
+            kept := f.items[in.Tenant][:0]
+            for _, it := range f.items[in.Tenant] {
+                if it.ID == in.IDs[0] { n++; continue }
+                kept = append(kept, it)
+            }
+            f.items[in.Tenant] = kept
+            _ = json.NewEncoder(w).Encode(map[string]int{"deleted": n})
+        case pathVectorPurge:
+            delete(f.items, in.Tenant)
+        default:
+            http.NotFound(w, r)
+        }
+    })
+}
+
+func TestHTTPVectorClient_Contract(t *testing.T) {
+    fs := &fakeVectorService{items: make(map[Tenant][]MemoryItem), leak: true}
+    srv := httptest.NewServer(fs.handler(t))
+    defer srv.Close()
+
+    c, err := NewHTTPVectorClient(VectorOptions{Endpoint: srv.URL + "/", APIKey: "k", Index: "novel"})
+    if err != nil { t.Fatalf("new client: %v", err) }
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+
+    if err := c.Save(ctx, MemoryItem{ID: "1", Tenant: ten, Content: "妹妹喜欢画画", Vector: []float32{1, 0}}); err != nil { t.Fatalf("save: %v", err) }
+    r, err := c.Query(ctx, QueryRequest{Tenant: ten, Query: "画画", TopK: 5})
+    if err != nil { t.Fatalf("query: %v", err) }
+    if len(r.Items) != 1 || r.Items[0].ID != "1" { t.Fatalf("expect only own tenant items, got %+v", r.Items) }
+
+    if err := c.Delete(ctx, ten, "1"); err != nil { t.Fatalf("delete: %v", err) }
+    if err := c.Delete(ctx, ten, "1"); !errors.Is(err, ErrNotFound) { t.Fatalf("expect ErrNotFound, got %v", err) }
+    if err := c.Purge(ctx, ten); err != nil { t.Fatalf("purge: %v", err) }
+
+    // 鉴权失败（4xx）不重试
+    bad, _ := NewHTTPVectorClient(VectorOptions{Endpoint: srv.URL, APIKey: "wrong", Index: "novel", HTTP: HTTPClientOptions{MaxRetries: 3}})
+    var se *HTTPStatusError
+    if err := bad.Save(ctx, MemoryItem{ID: "2", Tenant: ten}); !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized {
+        t.Fatalf("expect 401, got %v", err)
+    }
+}
+
+func TestHTTPClient_RetriesTransientErrors(t *testing.T) {
+    var calls int32
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch atomic.AddInt32(&calls, 1) {
+        case 1:
+            w.WriteHeader(http.StatusServiceUnavailable)
+        case 2:
+            w.WriteHeader(http.StatusTooManyRequests)
+        default:
+            _ = json.NewEncoder(w).Encode(QueryResult{Items: []MemoryItem{{ID: "t1", Content: "主角-妹妹-画画"}}})
+        }
+    }))
+    defer srv.Close()
+
+    c, _ := NewHTTPTripleClient(TripleOptions{Endpoint: srv.URL, SchemaVersion: "v1", HTTP: HTTPClientOptions{MaxRetries: 2, RetryBackoff: time.Millisecond}})
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    r, err := c.QueryTriples(context.Background(), QueryRequest{Tenant: ten, Query: "妹妹"})
+    if err != nil { t.Fatalf("query: %v", err) }
+    if atomic.LoadInt32(&calls) != 3 { t.Fatalf("expect 3 calls, got %d", calls) }
+    // 缺省租户视为请求租户
+    if len(r.Items) != 1 || r.Items[0].Tenant != ten { t.Fatalf("unexpected items: %+v", r.Items) }
+
+    // 重试耗尽
+    atomic.StoreInt32(&calls, 0)
+    c1, _ := NewHTTPTripleClient(TripleOptions{Endpoint: srv.URL, HTTP: HTTPClientOptions{MaxRetries: 1, RetryBackoff: time.Millisecond}})
+    if _, err := c1.QueryTriples(context.Background(), QueryRequest{Tenant: ten}); err == nil { t.Fatalf("expect error after retries exhausted") }
+    if atomic.LoadInt32(&calls) != 2 { t.Fatalf("expect 2 calls, got %d", calls) }
+}
+
+func TestHTTPClient_Timeout(t *testing.T) {
+    block := make(chan struct{})
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case <-block:
+        case <-r.Context().Done():
+        }
+    }))
+    defer srv.Close()
+    defer close(block)
+
+    c, _ := NewHTTPVectorClient(VectorOptions{Endpoint: srv.URL, HTTP: HTTPClientOptions{Timeout: 50 * time.Millisecond}})
+    start := time.Now()
+    if _, err := c.Query(context.Background(), QueryRequest{Tenant: Tenant{UserID: "u", ArchiveID: "a"}, Query: "q"}); err == nil {
+        t.Fatalf("expect timeout error")
+    }
+    if time.Since(start) > 2*time.Second { t.Fatalf("timeout not applied") }
+}
+
+func TestHTTPTripleClient_SaveGroupsByTenant(t *testing.T) {
+    var (
+        mu   sync.Mutex
+        reqs []httpItemsBody
+    )
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != pathTripleSave { http.NotFound(w, r); return }
+        var in httpItemsBody
+        _ = json.NewDecoder(r.Body).Decode(&in)
+        mu.Lock()
+        reqs = append(reqs, in)
+        mu.Unlock()
+    }))
+    defer srv.Close()
+
+    c, _ := NewHTTPTripleClient(TripleOptions{Endpoint: srv.URL, SchemaVersion: "v1"})
+    a := Tenant{UserID: "u", ArchiveID: "a"}
+    b := Tenant{UserID: "u", ArchiveID: "b"}
+    err := c.SaveTriples(context.Background(), []MemoryItem{
+        {ID: "1", Tenant: a}, {ID: "2", Tenant: b}, {ID: "3", Tenant: a},
+    })
+    if err != nil { t.Fatalf("save: %v", err) }
+    if len(reqs) != 2 { t.Fatalf("expect 2 requests, got %d", len(reqs)) }
+    if reqs[0].Tenant != a || len(reqs[0].Items) != 2 || reqs[0].SchemaVersion != "v1" { t.Fatalf("unexpected first batch: %+v", reqs[0]) }
+    if reqs[1].Tenant != b || len(reqs[1].Items) != 1 { t.Fatalf("unexpected second batch: %+v", reqs[1]) }
+
+    if err := c.SaveTriples(context.Background(), []MemoryItem{{ID: "4"}}); err == nil { t.Fatalf("expect tenant error") }
+}
+
+func TestManager_HTTPBackendsFromOptions(t *testing.T) {
+    fs := &fakeVectorService{items: make(map[Tenant][]MemoryItem)}
+    vsrv := httptest.NewServer(fs.handler(t))
+    defer vsrv.Close()
+    var triSaved int32
+    tsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case pathTripleSave:
+            atomic.AddInt32(&triSaved, 1)
+        case pathTripleQuery:
+            _ = json.NewEncoder(w).Encode(QueryResult{Items: []MemoryItem{{ID: "t1", Content: "主角-妹妹-画画", Score: 0.5}}})
+        }
+    }))
+    defer tsrv.Close()
+
+    m := newTestManager(t, func(o *RAGOptions) {
+        o.DiskJSON.Enable = false
+        o.Vector = VectorOptions{Enable: true, Endpoint: vsrv.URL, APIKey: "k", Index: "novel", Dim: 16}
+        o.Triple = TripleOptions{Enable: true, Endpoint: tsrv.URL, SchemaVersion: "v1"}
+    })
+
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    if err := m.Save(ctx, MemoryItem{Tenant: ten, Content: "妹妹喜欢画画"}, SaveOptions{ToVector: true, ToTriple: true}); err != nil { t.Fatalf("save: %v", err) }
+    if len(fs.items[ten]) != 1 || len(fs.items[ten][0].Vector) != 16 { t.Fatalf("expect embedded vector upserted: %+v", fs.items[ten]) }
+    if atomic.LoadInt32(&triSaved) != 1 { t.Fatalf("expect triple save") }
+
+    r, err := m.Query(ctx, QueryRequest{Tenant: ten, Query: "画画", UseVector: true, UseTriple: true})
+    if err != nil { t.Fatalf("query: %v", err) }
+    ids := map[string]bool{}
+    for _, it := range r.Items { ids[it.ID] = true }
+    if !ids["t1"] || !ids[fs.items[ten][0].ID] { t.Fatalf("expect vector and triple results, got %+v", r.Items) }
+}
@@ -0,0 +1,259 @@
+package rag
+
+import (
+    "context"
+    "strings"
+    "sync"
+    "time"
+)
+
+// 混合检索：本地（内存/磁盘）、向量、三元组后端并发检索，各自限时
+// - 每个后端产出一个有序列表；只有一个后端有结果时保持其原有排序与分数（本地为 BM25 分数或 CreatedAt）
+// - 多个后端有结果时融合：rrf（默认）按名次 weight/(k+rank) 累加；weighted 按列表内最高分归一化后加权累加
+// - 向量与三元组后端（尤其是外部服务）不保证执行全部过滤条件，融合前按 matchItem 统一过滤
+// - 融合结果按 ID 去重，Score 为融合分数，Sources 记录贡献的后端
+// - 配置了 Reranker 且有文本查询时对候选重排；重排失败时保留融合顺序
+
+// 检索来源（MemoryItem.Sources）
+const (
+    SourceMemory = "memory"
+    SourceDisk   = "disk"
+    SourceVector = "vector"
+    SourceTriple = "triple"
+)
+
+// 融合方式（RetrievalOptions.Fusion）
+const (
+    FusionRRF      = "rrf"
+    FusionWeighted = "weighted"
+)
+
+// DefaultRRFK RRF 常数 k 的默认值
+const DefaultRRFK = 60
+
+// Reranker 候选重排接口
+// 返回重排后的条目（可截断、可改写 Score），不得引入新条目
+type Reranker interface {
+    Rerank(ctx context.Context, query string, items []MemoryItem) ([]MemoryItem, error)
+}
+
+// SetReranker 设置重排器；nil 表示关闭重排
+func (m *Manager) SetReranker(r Reranker) {
+    m.mu.Lock()
+    m.reranker = r
+    m.mu.Unlock()
+}
+
+func (m *Manager) getReranker() Reranker {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    return m.reranker
+}
+
+// rankedList 单个后端的检索结果（已按相关度排序）
+type rankedList struct {
+    weight float64
+    items  []MemoryItem
+}
+
+// backendCtx 为单个后端设置超时
+func (m *Manager) backendCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+    if d := m.opts.Retrieval.BackendTimeout; d > 0 {
+        return context.WithTimeout(ctx, d)
+    }
+    return context.WithCancel(ctx)
+}
+
+// fanOut 并发检索各后端；失败或超时的后端视为无结果
+// 返回的列表依次为本地、向量、三元组
+func (m *Manager) fanOut(ctx context.Context, req QueryRequest) []rankedList {
+    ro := m.opts.Retrieval
+    useVec := req.UseVector && m.hasVec && (req.Query != "" || len(req.Vector) > 0)
+    useTri := req.UseTriple && m.hasTri
+
+    lists := make([]rankedList, 3)
+    var wg sync.WaitGroup
+    run := func(i int, weight float64, fn func(ctx context.Context) []MemoryItem) {
+        if weight <= 0 {
+            weight = 1
+        }
+        lists[i].weight = weight
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            bctx, cancel := m.backendCtx(ctx)
+            defer cancel()
+            lists[i].items = fn(bctx)
+        }()
+    }
+
+    run(0, ro.LocalWeight, func(ctx context.Context) []MemoryItem { return m.queryLocal(ctx, req) })
+    if useVec {
+        run(1, ro.VectorWeight, func(ctx context.Context) []MemoryItem { return m.queryVector(ctx, req) })
+    }
+    if useTri {
+        run(2, ro.TripleWeight, func(ctx context.Context) []MemoryItem {
+            r, err := m.tri.QueryTriples(ctx, req)
+            if err != nil {
+                return nil
+            }
+            return withSource(r.Items, SourceTriple)
+        })
+    }
+    wg.Wait()
+    now := time.Now()
+    for i := 1; i < len(lists); i++ {
+        lists[i].items = filterItems(lists[i].items, req, now)
+    }
+    return lists
+}
+
+// filterItems 原地保留满足过滤条件（时间范围、类型、标签、Meta、过期）的条目
+func filterItems(items []MemoryItem, req QueryRequest, now time.Time) []MemoryItem {
+    kept := items[:0]
+    for _, it := range items {
+        if matchItem(it, req, now) {
+            kept = append(kept, it)
+        }
+    }
+    return kept
+}
+
+// queryLocal 内存优先，缓存不完整时合并磁盘
+func (m *Manager) queryLocal(ctx context.Context, req QueryRequest) []MemoryItem {
+    var merged []MemoryItem
+    if m.mem != nil {
+        r, err := m.mem.Query(ctx, req)
+        if err == nil {
+            merged = append(merged, withSource(r.Items, SourceMemory)...)
+        }
+    }
+    if m.disk != nil && !m.cacheComplete(req.Tenant) {
+        r, err := m.disk.Query(ctx, req)
+        if err == nil {
+            merged = append(merged, withSource(r.Items, SourceDisk)...)
+        }
+    }
+    return mergeByID(merged, sortOf(req) == SortScore)
+}
+
+// queryVector 按需生成查询向量后检索向量后端
+func (m *Manager) queryVector(ctx context.Context, req QueryRequest) []MemoryItem {
+    if len(req.Vector) == 0 && m.embedder != nil {
+        vs, err := m.embedder.Embed(ctx, []string{req.Query})
+        if err != nil {
+            return nil
+        }
+        req.Vector = vs[0]
+    }
+    r, err := m.vec.Query(ctx, req)
+    if err != nil {
+        return nil
+    }
+    return withSource(r.Items, SourceVector)
+}
+
+// withSource 将来源写入条目（覆盖后端返回的 Sources）
+func withSource(items []MemoryItem, src string) []MemoryItem {
+    for i := range items {
+        items[i].Sources = []string{src}
+    }
+    return items
+}
+
+// fuseLists 只有一个列表有结果时原样返回，否则融合
+func fuseLists(lists []rankedList, ro RetrievalOptions) []MemoryItem {
+    var nonEmpty []rankedList
+    for _, l := range lists {
+        if len(l.items) > 0 {
+            nonEmpty = append(nonEmpty, l)
+        }
+    }
+    switch len(nonEmpty) {
+    case 0:
+        return nil
+    case 1:
+        return nonEmpty[0].items
+    }
+    return fuse(nonEmpty, ro)
+}
+
+// fuse 融合多个有序列表，按 docKey 去重，Score 为融合分数
+func fuse(lists []rankedList, ro RetrievalOptions) []MemoryItem {
+    k := ro.RRFK
+    if k <= 0 {
+        k = DefaultRRFK
+    }
+    weighted := strings.EqualFold(ro.Fusion, FusionWeighted)
+
+    var (
+        order  []string
+        items  = make(map[string]*MemoryItem)
+        scores = make(map[string]float64)
+    )
+    for _, l := range lists {
+        maxScore := 0.0
+        for _, it := range l.items {
+            if it.Score > maxScore {
+                maxScore = it.Score
+            }
+        }
+        for rank, it := range l.items {
+            var s float64
+            if weighted {
+                if maxScore > 0 {
+                    s = l.weight * it.Score / maxScore
+                }
+            } else {
+                s = l.weight / float64(k+rank+1)
+            }
+            key := docKey(it)
+            scores[key] += s
+            if prev, ok := items[key]; ok {
+                prev.Sources = unionSources(prev.Sources, it.Sources)
+                continue
+            }
+            cp := it
+            items[key] = &cp
+            order = append(order, key)
+        }
+    }
+
+    res := make([]MemoryItem, 0, len(order))
+    for _, key := range order {
+        it := *items[key]
+        it.Score = scores[key]
+        res = append(res, it)
+    }
+    sortItems(res, true)
+    return res
+}
+
+// unionSources 合并来源（保持顺序、去重）
+func unionSources(a, b []string) []string {
+    for _, s := range b {
+        found := false
+        for _, x := range a {
+            if x == s { found = true; break }
+        }
+        if !found {
+            a = append(a, s)
+        }
+    }
+    return a
+}
+
+// rerank 调用重排器；失败或超时时返回原顺序
+func (m *Manager) rerank(ctx context.Context, query string, items []MemoryItem) []MemoryItem {
+    r := m.getReranker()
+    if r == nil || query == "" || len(items) == 0 {
+        return items
+    }
+    bctx, cancel := m.backendCtx(ctx)
+    defer cancel()
+    out, err := r.Rerank(bctx, query, append([]MemoryItem(nil), items...))
+    if err != nil {
+        return items
+    }
+    return out
+}
@@ -0,0 +1,149 @@
+package rag
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+)
+
+// stubVector / stubTriple 返回固定结果的外部后端，delay 模拟慢服务
+type stubVector struct {
+    items []MemoryItem
+    delay time.Duration
+}
+
+func (s stubVector) Query(ctx context.Context, req QueryRequest) (QueryResult, error) {
+    if err := sleepCtx(ctx, s.delay); err != nil { return QueryResult{}, err }
+    return QueryResult{Items: append([]MemoryItem(nil), s.items...)}, nil
+}
+func (stubVector) Save(ctx context.Context, item MemoryItem) error { return nil }
+
+type stubTriple struct {
+    items []MemoryItem
+    delay time.Duration
+}
+
+func (s stubTriple) QueryTriples(ctx context.Context, req QueryRequest) (QueryResult, error) {
+    if err := sleepCtx(ctx, s.delay); err != nil { return QueryResult{}, err }
+    return QueryResult{Items: append([]MemoryItem(nil), s.items...)}, nil
+}
+func (stubTriple) SaveTriples(ctx context.Context, items []MemoryItem) error { return nil }
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+    if d <= 0 { return nil }
+    select {
+    case <-ctx.Done():
+        return ctx.Err()
+    case <-time.After(d):
+        return nil
+    }
+}
+
+type reverseReranker struct{ err error }
+
+func (r reverseReranker) Rerank(ctx context.Context, query string, items []MemoryItem) ([]MemoryItem, error) {
+    if r.err != nil { return nil, r.err }
+    for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+        items[i], items[j] = items[j], items[i]
+    }
+    return items, nil
+}
+
+func TestFuse_RRFAndWeighted(t *testing.T) {
+    now := time.Now()
+    local := rankedList{weight: 1, items: []MemoryItem{
+        {ID: "a", Score: 3, CreatedAt: now, Sources: []string{SourceMemory}},
+        {ID: "b", Score: 2, CreatedAt: now, Sources: []string{SourceMemory}},
+    }}
+    vec := rankedList{weight: 1, items: []MemoryItem{
+        {ID: "c", Score: 0.9, CreatedAt: now, Sources: []string{SourceVector}},
+        {ID: "b", Score: 0.3, CreatedAt: now, Sources: []string{SourceVector}},
+    }}
+
+    res := fuse([]rankedList{local, vec}, RetrievalOptions{Fusion: FusionRRF})
+    if len(res) != 3 || res[0].ID != "b" { t.Fatalf("rrf: item in both lists should rank first: %+v", res) }
+    if len(res[0].Sources) != 2 || res[0].Sources[0] != SourceMemory || res[0].Sources[1] != SourceVector {
+        t.Fatalf("sources not merged: %v", res[0].Sources)
+    }
+
+    // weighted：向量权重足够大时向量第一名胜出
+    vec.weight = 5
+    res = fuse([]rankedList{local, vec}, RetrievalOptions{Fusion: FusionWeighted})
+    if res[0].ID != "c" { t.Fatalf("weighted: expect c first, got %+v", res) }
+}
+
+func TestManager_HybridQuery_TimeoutSourcesRerank(t *testing.T) {
+    opts := DefaultOptions()
+    opts.DiskJSON.Enable = false
+    opts.Async.Enable = false
+    opts.Retrieval.BackendTimeout = 50 * time.Millisecond
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    vec := stubVector{items: []MemoryItem{{ID: "v1", Tenant: ten, Content: "妹妹的画", Score: 0.7}}}
+    slow := stubTriple{items: []MemoryItem{{ID: "t1", Tenant: ten, Content: "主角-妹妹"}}, delay: 2 * time.Second}
+    m, err := NewManager(opts, vec, slow)
+    if err != nil { t.Fatalf("new manager: %v", err) }
+    defer m.Close(context.Background())
+
+    ctx := context.Background()
+    if err := m.Save(ctx, MemoryItem{ID: "m1", Tenant: ten, Content: "妹妹喜欢画画"}, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+
+    // 仅本地：保持 BM25 排序，来源为 memory
+    r, _ := m.Query(ctx, QueryRequest{Tenant: ten, Query: "画画"})
+    if len(r.Items) != 1 || len(r.Items[0].Sources) != 1 || r.Items[0].Sources[0] != SourceMemory { t.Fatalf("local only: %+v", r.Items) }
+
+    // 慢三元组后端超时被丢弃，其余结果正常融合
+    start := time.Now()
+    r, err = m.Query(ctx, QueryRequest{Tenant: ten, Query: "画画", UseVector: true, UseTriple: true})
+    if err != nil { t.Fatalf("query: %v", err) }
+    if time.Since(start) > time.Second { t.Fatalf("backend timeout not applied") }
+    if len(r.Items) != 2 || r.Items[0].ID != "m1" || r.Items[1].ID != "v1" { t.Fatalf("unexpected fused result: %+v", r.Items) }
+    if r.Items[1].Sources[0] != SourceVector { t.Fatalf("vector source missing: %+v", r.Items[1]) }
+
+    // 重排器生效；失败时保留融合顺序
+    m.SetReranker(reverseReranker{})
+    r, _ = m.Query(ctx, QueryRequest{Tenant: ten, Query: "画画", UseVector: true})
+    if r.Items[0].ID != "v1" { t.Fatalf("rerank not applied: %+v", r.Items) }
+    m.SetReranker(reverseReranker{err: errors.New("boom")})
+    r, _ = m.Query(ctx, QueryRequest{Tenant: ten, Query: "画画", UseVector: true})
+    if r.Items[0].ID != "m1" { t.Fatalf("rerank failure should keep order: %+v", r.Items) }
+
+    // 本地存储不保存 Sources
+    got, _ := m.Get(ctx, ten, "m1")
+    if got.Sources != nil { t.Fatalf("sources persisted: %+v", got) }
+}
+
+func TestManager_HybridQuery_FiltersExternalResults(t *testing.T) {
+    opts := DefaultOptions()
+    opts.DiskJSON.Enable = false
+    opts.Async.Enable = false
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    now := time.Now()
+    past := now.Add(-time.Hour)
+    // 外部后端忽略过滤条件，原样返回全部条目
+    vec := stubVector{items: []MemoryItem{
+        {ID: "v_ok", Tenant: ten, Content: "妹妹的画", CreatedAt: now, Tags: []string{"角色"}, Meta: map[string]any{"chapter": 3}},
+        {ID: "v_old", Tenant: ten, Content: "妹妹的旧画", CreatedAt: now.Add(-48 * time.Hour), Tags: []string{"角色"}, Meta: map[string]any{"chapter": 3}},
+        {ID: "v_excluded", Tenant: ten, Content: "妹妹的草稿", CreatedAt: now, Tags: []string{"角色", "草稿"}, Meta: map[string]any{"chapter": 3}},
+        {ID: "v_meta", Tenant: ten, Content: "妹妹的信", CreatedAt: now, Tags: []string{"角色"}, Meta: map[string]any{"chapter": 4}},
+        {ID: "v_expired", Tenant: ten, Content: "妹妹的梦", CreatedAt: now, Tags: []string{"角色"}, Meta: map[string]any{"chapter": 3}, ExpiresAt: &past},
+    }}
+    tri := stubTriple{items: []MemoryItem{
+        {ID: "t_untagged", Tenant: ten, Kind: KindTriple, Content: "妹妹-住在-王城", CreatedAt: now},
+    }}
+    m, err := NewManager(opts, vec, tri)
+    if err != nil { t.Fatalf("new manager: %v", err) }
+    defer m.Close(context.Background())
+
+    ctx := context.Background()
+    after := now.Add(-24 * time.Hour)
+    r, err := m.Query(ctx, QueryRequest{Tenant: ten, Query: "妹妹", UseVector: true, UseTriple: true,
+        CreatedAfter: &after, Tags: []string{"角色"}, ExcludeTags: []string{"草稿"},
+        Meta: []MetaFilter{{Key: "chapter", Value: 3}}})
+    if err != nil { t.Fatalf("query: %v", err) }
+    if len(r.Items) != 1 || r.Items[0].ID != "v_ok" { t.Fatalf("external results should be filtered: %+v", r.Items) }
+
+    // TagMode any：命中任一标签即可
+    r, _ = m.Query(ctx, QueryRequest{Tenant: ten, Query: "妹妹", UseVector: true, Tags: []string{"草稿", "无关"}, TagMode: TagModeAny})
+    if len(r.Items) != 1 || r.Items[0].ID != "v_excluded" { t.Fatalf("tag mode any: %+v", r.Items) }
+}
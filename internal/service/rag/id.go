@@ -0,0 +1,64 @@
+package rag
+
+import (
+    "crypto/rand"
+    "encoding/binary"
+    "sync"
+    "time"
+)
+
+// 记忆 ID：ULID 风格，26 位 Crockford Base32
+// - 前 48 bit 为毫秒时间戳，字典序即时间序
+// - 后 80 bit 为随机数；同一毫秒内单调递增，保证进程内严格有序
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var idGen struct {
+    mu       sync.Mutex
+    lastMs   uint64
+    lastRand [10]byte
+}
+
+// NewID 生成可排序的唯一记忆 ID
+func NewID() string {
+    return newIDAt(time.Now())
+}
+
+func newIDAt(t time.Time) string {
+    ms := uint64(t.UnixMilli())
+
+    idGen.mu.Lock()
+    if ms <= idGen.lastMs {
+        // 同一毫秒（或时钟回拨）：沿用上次时间戳，随机部分 +1
+        ms = idGen.lastMs
+        for i := len(idGen.lastRand) - 1; i >= 0; i-- {
+            idGen.lastRand[i]++
+            if idGen.lastRand[i] != 0 {
+                break
+            }
+        }
+    } else {
+        idGen.lastMs = ms
+        _, _ = rand.Read(idGen.lastRand[:])
+    }
+    var raw [16]byte
+    binary.BigEndian.PutUint16(raw[0:2], uint16(ms>>32))
+    binary.BigEndian.PutUint32(raw[2:6], uint32(ms))
+    copy(raw[6:], idGen.lastRand[:])
+    idGen.mu.Unlock()
+
+    return encodeBase32(raw)
+}
+
+// encodeBase32 将 128 bit 编码为 26 位 Crockford Base32（首位仅 3 bit）
+func encodeBase32(raw [16]byte) string {
+    hi := binary.BigEndian.Uint64(raw[0:8])
+    lo := binary.BigEndian.Uint64(raw[8:16])
+    var out [26]byte
+    for i := 25; i >= 0; i-- {
+        out[i] = crockford[lo&0x1f]
+        lo = lo>>5 | hi<<59
+        hi >>= 5
+    }
+    return string(out[:])
+}
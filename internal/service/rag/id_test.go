@@ -0,0 +1,24 @@
+package rag
+
+import (
+    "testing"
+    "time"
+)
+
+func TestNewID_SortableAndUnique(t *testing.T) {
+    seen := make(map[string]bool)
+    prev := ""
+    for i := 0; i < 10000; i++ {
+        id := NewID()
+        if len(id) != 26 { t.Fatalf("unexpected length: %q", id) }
+        if seen[id] { t.Fatalf("duplicate id: %s", id) }
+        if id <= prev { t.Fatalf("not monotonic: %s <= %s", id, prev) }
+        seen[id] = true
+        prev = id
+    }
+
+    // 时间前缀决定顺序
+    a := newIDAt(time.Now().Add(time.Hour))
+    b := newIDAt(time.Now().Add(2 * time.Hour))
+    if a >= b || a[:10] == b[:10] { t.Fatalf("time prefix not ordered: %s %s", a, b) }
+}
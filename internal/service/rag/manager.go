@@ -3,13 +3,19 @@ package rag
 import (
     "context"
     "errors"
+    "fmt"
+    "maps"
+    "path/filepath"
     "sync"
+    "sync/atomic"
     "time"
+
+    "go.uber.org/zap"
 )
 
 // Manager 记忆系统入口，负责：
-// - 路由写入：内存/磁盘（JSON），外部服务（预留）
-// - 异步写入：降低写路径延迟
+// - 路由写入：内存/磁盘（JSON），向量后端（写入前按需生成向量），三元组（显式 ToTriple 时）
+// - 异步写入：降低写路径延迟；预写日志、重试与死信见 async.go
 // - 检索：本地优先，后续可融合外部检索结果
 // - 多租户隔离：通过 Tenant 实现
 
@@ -17,32 +23,63 @@ type Manager struct {
     opts RAGOptions
 
     mem  Store          // 可选内存后端
-    disk Store          // 可选 JSONL 后端
+    disk Store          // 可选持久化后端：JSONL 或 bbolt
+
+    vec      VectorClient // 向量检索：外部传入，或按配置创建本地向量库
+    hasVec   bool         // 是否配置了真实的向量后端（非 Noop）
+    embedder Embedder     // 向量化；未启用向量时为 nil
+    tri      TripleClient // 三元组检索：外部传入，或按配置创建 HTTP 客户端
+    hasTri   bool         // 是否配置了真实的三元组后端（非 Noop）
+    reranker Reranker     // 可选：检索结果重排（SetReranker）
 
-    vec  VectorClient   // 预留：外部向量检索
-    tri  TripleClient   // 预留：外部三元组检索
+    compact compactor     // 后台压缩（RetentionOptions）
+    access  accessTracker // 检索统计（见 ranking.go）
+    consol  consolidator  // 短期记忆整理（见 consolidate.go）
+    dedupe  sync.Mutex    // 近重复检测与写入互斥（见 dedupe.go）
+    rewrite sync.Mutex    // 条目读改写互斥：Update/Delete
 
     // 异步写入
     asyncCh chan saveTask
+    spool   *spool       // 预写日志（Async.SpoolPath）
+    abort   atomic.Bool  // 关闭超时，worker 不再处理剩余任务
+    halt    context.CancelFunc // 关闭超时时取消进行中的写入
+    haltCtx context.Context
+    stats   asyncCounters
+    pending pendingWrites // 队列中尚未落盘的写入，Update/Delete 先等待同一条目的写入完成
     wg      sync.WaitGroup
     mu      sync.RWMutex
     closed  bool
+
+    logMu  sync.RWMutex
+    logger *zap.Logger
 }
 
 type saveTask struct {
-    ctx  context.Context
-    item MemoryItem
-    opt  SaveOptions
+    ctx     context.Context
+    item    MemoryItem
+    opt     SaveOptions
+    memDone bool   // 内存缓存已同步写入，worker 只需落盘
+    seq     uint64 // 预写日志序号
 }
 
 func NewManager(opts RAGOptions, vec VectorClient, tri TripleClient) (*Manager, error) {
-    m := &Manager{opts: opts}
+    if err := opts.Validate(); err != nil {
+        return nil, fmt.Errorf("rag 配置无效: %w", err)
+    }
+    m := &Manager{opts: opts, logger: zap.NewNop()}
 
     // 存储后端
     if opts.InMemory.Enable {
         m.mem = NewMemoryStore(opts.InMemory)
     }
-    if opts.DiskJSON.Enable {
+    switch {
+    case opts.Bolt.Enable:
+        bs, err := NewBoltStore(opts.Namespace, opts.Bolt)
+        if err != nil {
+            return nil, err
+        }
+        m.disk = bs
+    case opts.DiskJSON.Enable:
         ds, err := NewDiskJSONStore(opts.Namespace, opts.DiskJSON)
         if err != nil {
             return nil, err
@@ -50,14 +87,52 @@ func NewManager(opts RAGOptions, vec VectorClient, tri TripleClient) (*Manager,
         m.disk = ds
     }
 
-    // 外部客户端
+    // 向量：外部传入优先；启用时按 Endpoint 选择 HTTP 客户端或本地向量库
+    if opts.Vector.Enable {
+        emb, err := NewEmbedder(opts.Vector)
+        if err != nil {
+            return nil, err
+        }
+        m.embedder = emb
+        if vec == nil && opts.Vector.Endpoint == "" {
+            lv, err := NewLocalVectorStore(filepath.Join(opts.Vector.RootPath, opts.Namespace), emb)
+            if err != nil {
+                return nil, err
+            }
+            vec = lv
+        } else if vec == nil {
+            hv, err := NewHTTPVectorClient(opts.Vector)
+            if err != nil {
+                return nil, err
+            }
+            vec = hv
+        }
+    }
     if vec != nil {
         m.vec = vec
+        m.hasVec = true
     } else {
         m.vec = NoopVectorClient{}
     }
+    // 三元组：外部传入优先；启用时按 Endpoint 选择 HTTP 客户端或本地三元组库
+    if tri == nil && opts.Triple.Enable {
+        if opts.Triple.Endpoint != "" {
+            ht, err := NewHTTPTripleClient(opts.Triple)
+            if err != nil {
+                return nil, err
+            }
+            tri = ht
+        } else {
+            lt, err := NewLocalTripleStore(filepath.Join(opts.Triple.RootPath, opts.Namespace), opts.Triple.SchemaVersion)
+            if err != nil {
+                return nil, err
+            }
+            tri = lt
+        }
+    }
     if tri != nil {
         m.tri = tri
+        m.hasTri = true
     } else {
         m.tri = NoopTripleClient{}
     }
@@ -68,33 +143,39 @@ func NewManager(opts RAGOptions, vec VectorClient, tri TripleClient) (*Manager,
         if size <= 0 { size = 1024 }
         workers := opts.Async.Workers
         if workers <= 0 { workers = 1 }
+        var pending []saveTask
+        if opts.Async.SpoolPath != "" {
+            sp, tasks, err := openSpool(filepath.Join(opts.Async.SpoolPath, opts.Namespace), opts.Async.SpoolSync)
+            if err != nil {
+                return nil, err
+            }
+            m.spool, pending = sp, tasks
+        }
         m.asyncCh = make(chan saveTask, size)
+        m.haltCtx, m.halt = context.WithCancel(context.Background())
         for i := 0; i < workers; i++ {
             m.wg.Add(1)
             go m.worker()
         }
-    }
-    return m, nil
-}
-
-func (m *Manager) worker() {
-    defer m.wg.Done()
-    for task := range m.asyncCh {
-        // 背景上下文保证落盘不被上游过早取消
-        ctx := context.Background()
-        if task.ctx != nil {
-            // 设定短超时，避免卡死
-            var cancel context.CancelFunc
-            ctx, cancel = context.WithTimeout(ctx, 10*time.Second)
-            _ = m.saveSync(ctx, task.item, task.opt)
-            cancel()
-            continue
+        // 重放上次未完成的写入
+        if len(pending) > 0 {
+            for _, task := range pending {
+                m.pending.add(task.item.Tenant, task.item.ID)
+            }
+            m.wg.Add(1)
+            go m.replay(pending)
         }
-        _ = m.saveSync(ctx, task.item, task.opt)
     }
+    if err := m.initAccess(); err != nil {
+        return nil, err
+    }
+    m.startCompactor()
+    m.startAccessFlusher()
+    m.startConsolidator()
+    return m, nil
 }
 
-// Close 关闭后台资源
+// Close 关闭后台资源：等待异步队列排空直到 ctx 截止，未完成的写入保留在预写日志中并返回错误
 func (m *Manager) Close(ctx context.Context) error {
     // 幂等关闭：仅第一次生效
     m.mu.Lock()
@@ -103,95 +184,402 @@ func (m *Manager) Close(ctx context.Context) error {
         return nil
     }
     m.closed = true
-    ch := m.asyncCh
     m.mu.Unlock()
 
-    if ch != nil {
-        close(ch)
+    m.stopConsolidator()
+    err := m.drain(ctx)
+    if ferr := m.stopAccessFlusher(); ferr != nil && err == nil {
+        err = fmt.Errorf("快照检索统计: %w", ferr)
     }
-    m.wg.Wait()
+    m.stopCompactor()
     if m.mem != nil { _ = m.mem.Close(ctx) }
     if m.disk != nil { _ = m.disk.Close(ctx) }
-    return nil
+    return err
 }
 
 // Save 写入记忆
+// - 未设置 ID 时分配可排序的唯一 ID（见 NewID）；需要回传 ID 的调用方可预先调用 NewID 赋值
 // - 根据 SaveOptions 或默认配置路由到内存/磁盘
+// - context 携带来源（actx.WithProvenance）时写入 Meta 保留键（见 provenance.go）
+// - Dedupe.Enable 时近重复的条目合并进已有条目，不再追加（见 dedupe.go）
 // - 异步模式：推送到队列
 func (m *Manager) Save(ctx context.Context, item MemoryItem, opt SaveOptions) error {
+    _, err := m.SaveWithResult(ctx, item, opt)
+    return err
+}
+
+// SaveWithResult 同 Save，并返回条目 ID 及是否合并进了已有的近重复条目（Dedupe.Enable 时，见 dedupe.go）
+func (m *Manager) SaveWithResult(ctx context.Context, item MemoryItem, opt SaveOptions) (SaveResult, error) {
     if item.Tenant.UserID == "" || item.Tenant.ArchiveID == "" {
-        return errors.New("tenant(user_id, archive_id) 不能为空")
+        return SaveResult{}, errors.New("tenant(user_id, archive_id) 不能为空")
+    }
+    if err := validImportance(item.Importance); err != nil {
+        return SaveResult{}, err
+    }
+    if item.ID == "" {
+        item.ID = NewID()
     }
     if item.CreatedAt.IsZero() {
         item.CreatedAt = time.Now()
     }
+    item.Meta = stampProvenance(ctx, item.Meta)
+    if m.opts.Dedupe.dedupes(item.Kind) {
+        m.dedupe.Lock()
+        defer m.dedupe.Unlock()
+        if res, merged, err := m.mergeDuplicate(ctx, item, opt); err != nil || merged {
+            return res, err
+        }
+    }
+    // 短期记忆计数，达到阈值时触发整理（consolidate.go）
+    if item.Kind == KindShortTerm {
+        defer m.noteShortTerm(item.Tenant)
+    }
+    return SaveResult{ID: item.ID}, m.save(ctx, item, opt)
+}
 
+// save 写入新条目：异步启用时入队（队列满时同步降级），否则同步写入
+func (m *Manager) save(ctx context.Context, item MemoryItem, opt SaveOptions) error {
     if m.opts.Async.Enable {
         // 读取并在发送期间持有读锁，防止与 Close() 竞争引发向已关闭通道发送
         m.mu.RLock()
         ch := m.asyncCh
         closed := m.closed
         if ch != nil && !closed {
-            select {
-            case ch <- saveTask{ctx: ctx, item: item, opt: opt}:
-                m.mu.RUnlock()
+            // 内存作为磁盘缓存时同步写入缓存，保证写后立即可读；仅落盘走队列
+            task := saveTask{ctx: ctx, item: item, opt: opt}
+            if toMem, _, _, _ := m.route(opt); toMem && m.isCache() {
+                if err := m.mem.Save(ctx, item); err != nil {
+                    m.mu.RUnlock()
+                    return err
+                }
+                task.memDone = true
+            }
+            queued, err := m.enqueue(task)
+            m.mu.RUnlock()
+            if err != nil {
+                return err
+            }
+            if queued {
                 return nil
-            default:
-                m.mu.RUnlock()
-                // 队列满，降级为同步，避免丢失
-                return m.saveSync(ctx, item, opt)
             }
+            // 队列满，降级为同步，避免丢失
+            m.stats.syncFallback.Add(1)
+            return m.saveTask(ctx, task)
         }
         m.mu.RUnlock()
     }
     return m.saveSync(ctx, item, opt)
 }
 
+// route 解析写入目标：默认路由跟随全局配置；向量/三元组仅在显式 ToVector/ToTriple 时写入
+func (m *Manager) route(opt SaveOptions) (toMem, toDisk, toVec, toTri bool) {
+    toMem = opt.ToMemory || (!opt.ToDisk && !opt.ToVector && !opt.ToTriple && m.opts.InMemory.Enable)
+    toDisk = opt.ToDisk || (!opt.ToMemory && !opt.ToVector && !opt.ToTriple && m.disk != nil)
+    // 内存作为磁盘缓存时写穿，保证缓存与磁盘一致
+    if toDisk && m.disk != nil && m.isCache() {
+        toMem = true
+    }
+    return toMem, toDisk, opt.ToVector && m.hasVec, opt.ToTriple && m.hasTri
+}
+
+// saveTask 执行异步任务（或队列满时的同步降级）
+func (m *Manager) saveTask(ctx context.Context, task saveTask) error {
+    toMem, toDisk, toVec, toTri := m.route(task.opt)
+    return m.saveTo(ctx, task.item, toMem && !task.memDone, toDisk, toVec, toTri)
+}
+
 func (m *Manager) saveSync(ctx context.Context, item MemoryItem, opt SaveOptions) error {
-    // 默认路由：跟随全局配置
-    toMem := opt.ToMemory || (!opt.ToDisk && !opt.ToVector && !opt.ToTriple && m.opts.InMemory.Enable)
-    toDisk := opt.ToDisk || (!opt.ToMemory && !opt.ToVector && !opt.ToTriple && m.opts.DiskJSON.Enable)
+    toMem, toDisk, toVec, toTri := m.route(opt)
+    return m.saveTo(ctx, item, toMem, toDisk, toVec, toTri)
+}
 
+func (m *Manager) saveTo(ctx context.Context, item MemoryItem, toMem, toDisk, toVec, toTri bool) error {
     var firstErr error
+    // 向量只写入向量后端，本地存储不持久化
+    local := item
+    local.Vector = nil
+    local.Sources = nil
     if toMem && m.mem != nil {
-        if err := m.mem.Save(ctx, item); err != nil { firstErr = err }
+        if err := m.mem.Save(ctx, local); err != nil { firstErr = err }
     }
     if toDisk && m.disk != nil {
-        if err := m.disk.Save(ctx, item); err != nil && firstErr == nil { firstErr = err }
+        if err := m.disk.Save(ctx, local); err != nil && firstErr == nil { firstErr = err }
+    }
+    if toVec {
+        if err := m.saveVector(ctx, item); err != nil && firstErr == nil { firstErr = err }
+    }
+    // 三元组：条目原样交给三元组服务（抽取由服务端负责）
+    if toTri {
+        if err := m.tri.SaveTriples(ctx, []MemoryItem{local}); err != nil && firstErr == nil { firstErr = err }
     }
-    // 预留：向量与三元组保存（通常需要 embedding/抽取，此处不主动调用）
     return firstErr
 }
 
-// Query 检索记忆（本地优先；外部检索预留）
+// saveVector 按需生成向量后写入向量后端
+func (m *Manager) saveVector(ctx context.Context, item MemoryItem) error {
+    item.Sources = nil
+    if len(item.Vector) == 0 && m.embedder != nil {
+        vs, err := m.embedder.Embed(ctx, []string{item.Content})
+        if err != nil {
+            return fmt.Errorf("embed: %w", err)
+        }
+        item.Vector = vs[0]
+    }
+    return m.vec.Save(ctx, item)
+}
+
+// localStores 返回已启用的本地存储
+func (m *Manager) localStores() []Store {
+    var res []Store
+    if m.mem != nil { res = append(res, m.mem) }
+    if m.disk != nil { res = append(res, m.disk) }
+    return res
+}
+
+// Get 按 ID 读取记忆（内存优先，其次磁盘），计入内存中的检索统计
+func (m *Manager) Get(ctx context.Context, t Tenant, id string) (MemoryItem, error) {
+    it, err := m.getStored(ctx, t, id)
+    if err != nil {
+        return MemoryItem{}, err
+    }
+    items := []MemoryItem{it}
+    m.access.overlay(t, items)
+    return items[0], nil
+}
+
+// getStored 按 ID 读取存储中的记忆，不计入内存中的检索统计
+func (m *Manager) getStored(ctx context.Context, t Tenant, id string) (MemoryItem, error) {
+    m.ensureWarm(ctx, t)
+    for _, st := range m.localStores() {
+        it, err := st.Get(ctx, t, id)
+        if err == nil {
+            return it, nil
+        }
+        if !errors.Is(err, ErrNotFound) {
+            return MemoryItem{}, err
+        }
+    }
+    return MemoryItem{}, ErrNotFound
+}
+
+// Update 按 item.ID 替换记忆，作用于所有持有该记忆的本地存储
+// 正文变化时产生新版本，旧版本保留在历史中（见 version.go），启用向量后端时以新正文覆盖向量副本；
+// 其余字段变化原地替换，Version/RevisedAt 沿用当前版本
+// Update/Delete 为同步操作，先等待该条目在异步队列中的写入完成（直到 ctx 截止），保证按调用顺序生效
+func (m *Manager) Update(ctx context.Context, item MemoryItem) error {
+    if item.Tenant.UserID == "" || item.Tenant.ArchiveID == "" {
+        return errors.New("tenant(user_id, archive_id) 不能为空")
+    }
+    if item.ID == "" {
+        return errors.New("id 不能为空")
+    }
+    if err := validImportance(item.Importance); err != nil {
+        return err
+    }
+    _, err := m.modify(ctx, item.Tenant, item.ID, func(it *MemoryItem) error {
+        *it = item
+        return nil
+    })
+    return err
+}
+
+// modify 持有 rewrite 锁读取条目的当前值，交给 fn 修改后写回，语义同 Update
+// 内部的读改写（去重合并、整理后过期）只改动关心的字段，不会以读取时的旧快照覆盖期间的并发修改；
+// fn 返回错误时不写入。fn 收到的 Tags/Meta 为副本，可直接修改
+func (m *Manager) modify(ctx context.Context, t Tenant, id string, fn func(it *MemoryItem) error) (MemoryItem, error) {
+    if err := m.pending.wait(ctx, t, id); err != nil {
+        return MemoryItem{}, err
+    }
+    m.rewrite.Lock()
+    defer m.rewrite.Unlock()
+    cur, err := m.getStored(ctx, t, id)
+    if err != nil {
+        return MemoryItem{}, err
+    }
+    item := cur
+    item.Tags = append([]string(nil), cur.Tags...)
+    item.Meta = maps.Clone(cur.Meta)
+    if err := fn(&item); err != nil {
+        return MemoryItem{}, err
+    }
+    item.Tenant, item.ID = t, id
+    revised := nextVersion(&item, cur, time.Now())
+    keepAccess(&item, cur)
+    if err := m.applyAll(func(st Store) error { return st.Update(ctx, item) }); err != nil {
+        return MemoryItem{}, err
+    }
+    if revised && m.hasVec {
+        return item, m.saveVector(ctx, item)
+    }
+    return item, nil
+}
+
+// Delete 按 ID 删除记忆，作用于所有持有该记忆的本地存储
+func (m *Manager) Delete(ctx context.Context, t Tenant, id string) error {
+    if t.UserID == "" || t.ArchiveID == "" {
+        return errors.New("tenant(user_id, archive_id) 不能为空")
+    }
+    if id == "" {
+        return errors.New("id 不能为空")
+    }
+    if err := m.pending.wait(ctx, t, id); err != nil {
+        return err
+    }
+    m.rewrite.Lock()
+    defer m.rewrite.Unlock()
+    m.ensureWarm(ctx, t)
+    if err := m.applyAll(func(st Store) error { return st.Delete(ctx, t, id) }); err != nil {
+        return err
+    }
+    m.access.forget(t, id)
+    // 向量后端支持删除时同步删除（不存在视为成功）
+    if d, ok := m.vec.(vectorDeleter); ok {
+        if err := d.Delete(ctx, t, id); err != nil && !errors.Is(err, ErrNotFound) {
+            return err
+        }
+    }
+    return nil
+}
+
+// applyAll 在全部本地存储上执行操作；全部返回 ErrNotFound 时返回 ErrNotFound
+func (m *Manager) applyAll(fn func(st Store) error) error {
+    found := false
+    for _, st := range m.localStores() {
+        err := fn(st)
+        if errors.Is(err, ErrNotFound) {
+            continue
+        }
+        if err != nil {
+            return err
+        }
+        found = true
+    }
+    if !found {
+        return ErrNotFound
+    }
+    return nil
+}
+
+// Query 检索记忆
+// - 本地（内存/磁盘）、向量（UseVector）、三元组（UseTriple）后端并发检索，融合与重排见 hybrid.go
+// - 过滤、排序与游标见 query.go：recency 时游标下推到本地存储，融合结果按时间排序；
+//   score 时各后端取 offset+TopK+1 条（启用 Ranking 时至少 Candidates 条），融合、按重要性与时间衰减排序（ranking.go）、重排后截取当前页
+// - 启用 Ranking 时返回的条目计入检索统计（AccessCount/LastAccessedAt）
+// - 指定 AsOf/AsOfChapter 时按历史版本检索（version.go），只查本地存储
+// 内存与磁盘同时启用时，内存作为磁盘的缓存：
+// - 首次访问租户时以磁盘数据预热
+// - 缓存持有租户全部数据时只查内存，否则合并两者结果
+// - 本地结果按 ID 去重后按排序方式排列
 func (m *Manager) Query(ctx context.Context, req QueryRequest) (QueryResult, error) {
     // TopK 默认
     topK := req.TopK
     if topK <= 0 { topK = 10 }
+    req.TopK = topK
 
-    var merged []MemoryItem
+    plan, err := planQuery(req)
+    if err != nil {
+        return QueryResult{}, err
+    }
+    if req.AsOf != nil || req.AsOfChapter > 0 {
+        return m.queryAsOf(ctx, req)
+    }
+    m.ensureWarm(ctx, req.Tenant)
 
-    // 1) 内存
-    if m.mem != nil {
-        r, err := m.mem.Query(ctx, req)
-        if err == nil && len(r.Items) > 0 {
-            merged = append(merged, r.Items...)
+    ranking := m.opts.Ranking.Enable && plan.sort == SortScore
+    sub := req
+    sub.TopK = plan.need()
+    if plan.sort == SortScore {
+        sub.Cursor = ""
+        if ranking && sub.TopK > 0 && sub.TopK < m.opts.Ranking.Candidates {
+            sub.TopK = m.opts.Ranking.Candidates
         }
     }
-
-    // 2) 磁盘
-    if len(merged) < topK && m.disk != nil {
-        r, err := m.disk.Query(ctx, req)
-        if err == nil && len(r.Items) > 0 {
-            merged = append(merged, r.Items...)
+    merged := fuseLists(m.fanOut(ctx, sub), m.opts.Retrieval)
+    m.access.overlay(req.Tenant, merged)
+    now := time.Now()
+    var res QueryResult
+    if plan.sort == SortScore {
+        if ranking {
+            m.rank(merged, now)
+        }
+        merged = m.rerank(ctx, req.Query, merged)
+        plan.limit = topK
+        lo, hi, more := plan.window(len(merged))
+        res.Items = merged[lo:hi]
+        if more && hi > lo {
+            res.NextCursor = plan.next(merged[hi-1], hi)
         }
+    } else {
+        // recency：外部后端不支持游标，统一按游标过滤后排序
+        res = plan.page(merged)
+    }
+    if m.opts.Ranking.Enable {
+        m.access.record(req.Tenant, res.Items, now)
+    }
+    return res, nil
+}
+
+// validImportance 重要性取值 [0,1]
+func validImportance(v float64) error {
+    if v < 0 || v > 1 {
+        return fmt.Errorf("importance 必须在 0 到 1 之间: %g", v)
     }
+    return nil
+}
 
-    // 3) 预留：外部向量/三元组（按需启用并去重合并）
-    // 若未来启用，可在此处调用 m.vec.Query / m.tri.QueryTriples，并按 Score 排序去重
+// ensureWarm 内存缓存未预热时，以磁盘数据预热该租户
+// 预热失败不影响查询（回落磁盘），下次访问时重试
+func (m *Manager) ensureWarm(ctx context.Context, t Tenant) {
+    if m.mem == nil || m.disk == nil {
+        return
+    }
+    cs, ok := m.mem.(CacheStore)
+    if !ok || cs.Warmed(t) {
+        return
+    }
+    src, ok := m.disk.(ArchiveStore)
+    if !ok {
+        return
+    }
+    items, err := src.Export(ctx, t)
+    if err != nil {
+        return
+    }
+    cs.Warm(t, items)
+}
 
-    // 截断到 topK
-    if len(merged) > topK { merged = merged[:topK] }
+// isCache 内存与磁盘同时启用且内存实现 CacheStore 时，内存作为磁盘的缓存
+func (m *Manager) isCache() bool {
+    if m.mem == nil || m.disk == nil {
+        return false
+    }
+    _, ok := m.mem.(CacheStore)
+    return ok
+}
+
+// cacheComplete 内存缓存是否持有租户全部数据
+func (m *Manager) cacheComplete(t Tenant) bool {
+    if m.mem == nil {
+        return false
+    }
+    cs, ok := m.mem.(CacheStore)
+    return ok && cs.Complete(t)
+}
 
-    return QueryResult{Items: merged}, nil
+// mergeByID 按 ID 去重（保留先出现者，合并 Sources）并稳定排序：byScore 时先按分数从高到低，再按 (CreatedAt, ID) 从新到旧
+func mergeByID(items []MemoryItem, byScore bool) []MemoryItem {
+    seen := make(map[string]int, len(items))
+    res := items[:0]
+    for _, it := range items {
+        if it.ID != "" {
+            if i, ok := seen[it.ID]; ok {
+                res[i].Sources = unionSources(res[i].Sources, it.Sources)
+                continue
+            }
+            seen[it.ID] = len(res)
+        }
+        res = append(res, it)
+    }
+    sortItems(res, byScore)
+    return res
 }
@@ -6,23 +6,12 @@ import (
     "time"
 )
 
-func newTestManagerAsync(t *testing.T) *Manager {
-    t.Helper()
-    opts := DefaultOptions()
-    opts.InMemory.Enable = true
-    opts.DiskJSON.Enable = false
-    opts.Async.Enable = true
-    opts.Async.QueueSize = 64
-    opts.Async.Workers = 1
-
-    m, err := NewManager(opts, nil, nil)
-    if err != nil { t.Fatalf("new manager: %v", err) }
-    t.Cleanup(func() { _ = m.Close(context.Background()) })
-    return m
-}
-
 func TestManager_AsyncBasic(t *testing.T) {
-    m := newTestManagerAsync(t)
+    m := newTestManager(t, func(o *RAGOptions) {
+        o.DiskJSON.Enable = false
+        o.Async.Enable = true
+        o.Async.QueueSize = 64
+    })
     ctx := context.Background()
     ten := Tenant{UserID: "au", ArchiveID: "aa"}
 
@@ -68,7 +57,11 @@ func TestManager_AsyncDegradeToSync_WhenNoChan(t *testing.T) {
 }
 
 func TestManager_AsyncCloseDrainsAndCancelIgnored(t *testing.T) {
-    m := newTestManagerAsync(t)
+    m := newTestManager(t, func(o *RAGOptions) {
+        o.DiskJSON.Enable = false
+        o.Async.Enable = true
+        o.Async.QueueSize = 64
+    })
     ten := Tenant{UserID: "cu", ArchiveID: "ca"}
 
     // 上游已取消的 ctx
@@ -91,3 +84,26 @@ func TestManager_AsyncCloseDrainsAndCancelIgnored(t *testing.T) {
         t.Fatalf("expect c1 visible after close drain, got %+v", qr.Items)
     }
 }
+
+func TestManager_SaveAssignsID_UpdateDeleteAcrossStores(t *testing.T) {
+    m := newTestManager(t, nil) // 内存 + 磁盘，同步写入
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+
+    if err := m.Save(ctx, MemoryItem{Tenant: ten, Content: "wrong fact"}, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    qr, _ := m.mem.Query(ctx, QueryRequest{Tenant: ten, TopK: 10})
+    if len(qr.Items) != 1 || qr.Items[0].ID == "" { t.Fatalf("expect generated id: %+v", qr.Items) }
+    id := qr.Items[0].ID
+    if it, err := m.disk.Get(ctx, ten, id); err != nil || it.ID != id { t.Fatalf("disk should share id: %v", err) }
+
+    it, _ := m.Get(ctx, ten, id)
+    it.Content = "right fact"
+    if err := m.Update(ctx, it); err != nil { t.Fatalf("update: %v", err) }
+    if got, _ := m.disk.Get(ctx, ten, id); got.Content != "right fact" { t.Fatalf("disk not updated: %+v", got) }
+
+    if err := m.Delete(ctx, ten, id); err != nil { t.Fatalf("delete: %v", err) }
+    for _, st := range []Store{m.mem, m.disk} {
+        if _, err := st.Get(ctx, ten, id); err != ErrNotFound { t.Fatalf("expect ErrNotFound, got %v", err) }
+    }
+    if err := m.Delete(ctx, ten, id); err != ErrNotFound { t.Fatalf("double delete: %v", err) }
+}
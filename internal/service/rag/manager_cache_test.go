@@ -0,0 +1,73 @@
+package rag
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestManager_Cache_NoDuplicates_And_WarmAfterRestart(t *testing.T) {
+    root := t.TempDir()
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    base := time.Now()
+
+    m1 := newTestManager(t, onDisk(root))
+    for i, c := range []string{"c1", "c2", "c3"} {
+        it := MemoryItem{Tenant: ten, Content: c, CreatedAt: base.Add(time.Duration(i) * time.Millisecond)}
+        if err := m1.Save(ctx, it, SaveOptions{ToMemory: true, ToDisk: true}); err != nil { t.Fatalf("save: %v", err) }
+    }
+    qr, _ := m1.Query(ctx, QueryRequest{Tenant: ten, TopK: 10})
+    if len(qr.Items) != 3 { t.Fatalf("expect 3 deduplicated items, got %d", len(qr.Items)) }
+
+    // 重启：内存为空，首次查询以磁盘预热
+    m2 := newTestManager(t, onDisk(root))
+    qr, _ = m2.Query(ctx, QueryRequest{Tenant: ten, TopK: 10})
+    if len(qr.Items) != 3 || qr.Items[0].Content != "c3" || qr.Items[2].Content != "c1" {
+        t.Fatalf("unexpected items after restart: %+v", qr.Items)
+    }
+    if !m2.cacheComplete(ten) { t.Fatalf("cache should be complete after warm") }
+    mq, _ := m2.mem.Query(ctx, QueryRequest{Tenant: ten, TopK: 10})
+    if len(mq.Items) != 3 { t.Fatalf("cache not warmed: %d", len(mq.Items)) }
+
+    // 仅写磁盘也会写穿到缓存
+    _ = m2.Save(ctx, MemoryItem{Tenant: ten, Content: "c4", CreatedAt: base.Add(time.Second)}, SaveOptions{ToDisk: true})
+    qr, _ = m2.Query(ctx, QueryRequest{Tenant: ten, TopK: 10})
+    if len(qr.Items) != 4 || qr.Items[0].Content != "c4" { t.Fatalf("write-through failed: %+v", qr.Items) }
+}
+
+func TestManager_Cache_EvictedFallsBackToDisk(t *testing.T) {
+    m := newTestManager(t, func(o *RAGOptions) { o.InMemory.MaxEntries = 2 })
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    base := time.Now()
+
+    for i := 0; i < 5; i++ {
+        it := MemoryItem{Tenant: ten, Content: string(rune('a' + i)), CreatedAt: base.Add(time.Duration(i) * time.Millisecond)}
+        _ = m.Save(ctx, it, SaveOptions{})
+    }
+    if m.cacheComplete(ten) { t.Fatalf("cache should be incomplete after eviction") }
+
+    // 合并内存与磁盘：去重且统一从新到旧排序
+    qr, _ := m.Query(ctx, QueryRequest{Tenant: ten, TopK: 10})
+    if len(qr.Items) != 5 { t.Fatalf("expect 5 items, got %d", len(qr.Items)) }
+    for i, want := range []string{"e", "d", "c", "b", "a"} {
+        if qr.Items[i].Content != want { t.Fatalf("order mismatch at %d: %+v", i, qr.Items) }
+    }
+}
+
+func TestManager_Cache_AsyncReadYourWrites(t *testing.T) {
+    m := newTestManager(t, func(o *RAGOptions) { o.Async.Enable = true })
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+
+    // 异步落盘，但缓存同步写入：写后立即可读
+    if err := m.Save(ctx, MemoryItem{Tenant: ten, Content: "fresh"}, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    qr, _ := m.Query(ctx, QueryRequest{Tenant: ten, TopK: 10})
+    if len(qr.Items) != 1 { t.Fatalf("expect item visible immediately, got %d", len(qr.Items)) }
+
+    // 关闭后确已落盘且只有一份
+    _ = m.Close(ctx)
+    all, _ := m.disk.(ArchiveStore).Export(ctx, ten)
+    if len(all) != 1 || all[0].ID != qr.Items[0].ID { t.Fatalf("unexpected disk items: %+v", all) }
+}
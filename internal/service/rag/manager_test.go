@@ -0,0 +1,36 @@
+package rag
+
+import (
+    "context"
+    "path/filepath"
+    "testing"
+)
+
+// newTestManager 创建测试用 Manager，测试结束时自动 Close（显式 Close 亦可，Close 幂等）
+// - 全部数据目录（DiskJSON、Bolt、向量、三元组、预写日志）位于 t.TempDir()，默认同步写入
+// - edit 在创建前调整配置；同一测试内重启 Manager 时由 edit 指定相同目录（见 onDisk）
+// - 未指定 Ranking.AccessPath 时检索统计随 DiskJSON 数据存放，重启同一目录时一并恢复
+func newTestManager(t *testing.T, edit func(*RAGOptions)) *Manager {
+    t.Helper()
+    opts := DefaultOptions()
+    opts.DiskJSON.RootPath = t.TempDir()
+    opts.Bolt.RootPath = t.TempDir()
+    opts.Vector.RootPath = t.TempDir()
+    opts.Triple.RootPath = t.TempDir()
+    opts.Async.Enable = false
+    opts.Async.SpoolPath = t.TempDir()
+    opts.Ranking.AccessPath = ""
+    if edit != nil { edit(&opts) }
+    if opts.Ranking.AccessPath == "" {
+        opts.Ranking.AccessPath = filepath.Join(opts.DiskJSON.RootPath, "access")
+    }
+    m, err := NewManager(opts, nil, nil)
+    if err != nil { t.Fatalf("new manager: %v", err) }
+    t.Cleanup(func() { _ = m.Close(context.Background()) })
+    return m
+}
+
+// onDisk 使用指定的 DiskJSON 目录，用于同一测试内重启 Manager
+func onDisk(disk string) func(*RAGOptions) {
+    return func(o *RAGOptions) { o.DiskJSON.RootPath = disk }
+}
@@ -2,18 +2,25 @@ package rag
 
 import (
     "context"
+    "sort"
     "strings"
     "sync"
     "time"
 )
 
 // memoryStore 进程内存存储（按租户隔离）
-// - 简单子串匹配与标签/类型过滤
+// - 文本查询使用 BM25 打分（每租户倒排索引，随写入/删除增量维护），标签/类型过滤
 // - 基于配置的容量上限与可选 TTL 过滤
+// - 同一 ID 重复保存时原地替换，不产生重复条目；Version 更大时当前版本转入历史（VersionStore）
+// - 实现 CacheStore：可由 Manager 以磁盘数据按租户预热，作为磁盘的缓存
 
 type memoryStore struct {
     mu         sync.RWMutex
-    itemsByKey map[string][]MemoryItem // tenantKey -> items (按时间追加)
+    itemsByKey map[string][]MemoryItem            // tenantKey -> items (按时间追加)
+    warmed     map[string]bool                    // 已用权威数据预热的租户
+    evicted    map[string]bool                    // 因容量上限淘汰过条目的租户
+    index      map[string]*bm25Index              // tenantKey -> BM25 索引
+    history    map[string]map[string][]MemoryItem // tenantKey -> id -> 历史版本（从旧到新）
 
     maxEntries int
     ttl        time.Duration
@@ -22,6 +29,10 @@ type memoryStore struct {
 func NewMemoryStore(opts InMemoryOptions) Store {
     return &memoryStore{
         itemsByKey: make(map[string][]MemoryItem),
+        warmed:     make(map[string]bool),
+        evicted:    make(map[string]bool),
+        index:      make(map[string]*bm25Index),
+        history:    make(map[string]map[string][]MemoryItem),
         maxEntries: opts.MaxEntries,
         ttl:        opts.TTL,
     }
@@ -36,17 +47,112 @@ func (m *memoryStore) Save(ctx context.Context, item MemoryItem) error {
     defer m.mu.Unlock()
 
     key := m.tenantKey(item.Tenant)
-    lst := append(m.itemsByKey[key], item)
+    m.indexFor(key).Add(item)
+    if i := m.indexOf(item.Tenant, item.ID); i >= 0 {
+        m.replace(key, i, item)
+        return nil
+    }
+    m.setList(key, append(m.itemsByKey[key], item))
+    return nil
+}
+
+// indexFor 返回租户的 BM25 索引，不存在时创建（调用方持有写锁）
+func (m *memoryStore) indexFor(key string) *bm25Index {
+    idx := m.index[key]
+    if idx == nil {
+        idx = newBM25Index()
+        m.index[key] = idx
+    }
+    return idx
+}
 
-    // 容量控制：超过上限时丢弃最旧的
+// replace 替换第 i 个条目；新条目版本更大时保留当前版本（调用方持有写锁）
+func (m *memoryStore) replace(key string, i int, item MemoryItem) {
+    old := m.itemsByKey[key][i]
+    if item.Version > old.Version {
+        h := m.history[key]
+        if h == nil {
+            h = make(map[string][]MemoryItem)
+            m.history[key] = h
+        }
+        h[item.ID] = append(h[item.ID], old)
+    }
+    m.itemsByKey[key][i] = item
+}
+
+// setList 写入租户列表并执行容量控制：超过上限时丢弃最旧的，并从索引中移除（调用方持有锁）
+func (m *memoryStore) setList(key string, lst []MemoryItem) {
     if m.maxEntries > 0 && len(lst) > m.maxEntries {
-        lst = lst[len(lst)-m.maxEntries:]
+        drop := len(lst) - m.maxEntries
+        if idx := m.index[key]; idx != nil {
+            for _, it := range lst[:drop] {
+                idx.Remove(docKey(it))
+            }
+        }
+        for _, it := range lst[:drop] {
+            delete(m.history[key], it.ID)
+        }
+        lst = lst[drop:]
+        m.evicted[key] = true
     }
     m.itemsByKey[key] = lst
-    return nil
+}
+
+func (m *memoryStore) Warmed(t Tenant) bool {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    return m.warmed[m.tenantKey(t)]
+}
+
+// Warm 以权威数据（按写入顺序）重建租户缓存
+// 缓存中已有但 items 中没有的条目（如尚未落盘的写入）保留在末尾
+func (m *memoryStore) Warm(t Tenant, items []MemoryItem) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    key := m.tenantKey(t)
+    seen := make(map[string]bool, len(items))
+    lst := make([]MemoryItem, 0, len(items))
+    for _, it := range items {
+        if it.ID != "" {
+            if seen[it.ID] {
+                continue
+            }
+            seen[it.ID] = true
+        }
+        lst = append(lst, it)
+    }
+    existing, ok := m.itemsByKey[key]
+    for _, it := range existing {
+        if it.ID == "" || !seen[it.ID] {
+            lst = append(lst, it)
+        }
+    }
+    // 预热不存在的租户不应使其出现在归档列表中
+    if ok || len(lst) > 0 {
+        idx := newBM25Index()
+        for _, it := range lst {
+            idx.Add(it)
+        }
+        m.index[key] = idx
+        m.setList(key, lst)
+    }
+    m.warmed[key] = true
+}
+
+func (m *memoryStore) Complete(t Tenant) bool {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    key := m.tenantKey(t)
+    return m.warmed[key] && !m.evicted[key]
 }
 
 func (m *memoryStore) Query(ctx context.Context, req QueryRequest) (QueryResult, error) {
+    plan, err := planQuery(req)
+    if err != nil {
+        return QueryResult{}, err
+    }
+
     m.mu.RLock()
     defer m.mu.RUnlock()
 
@@ -55,63 +161,162 @@ func (m *memoryStore) Query(ctx context.Context, req QueryRequest) (QueryResult,
 
     now := time.Now()
     res := make([]MemoryItem, 0, len(lst))
-
-    for i := len(lst) - 1; i >= 0; i-- { // 从新到旧扫描，利于 TopK
-        it := lst[i]
-
+    for _, it := range lst {
         // TTL 过滤（若启用）
         if m.ttl > 0 && it.CreatedAt.Add(m.ttl).Before(now) {
             continue
         }
-        // 显式过期时间
-        if it.ExpiresAt != nil && it.ExpiresAt.Before(now) {
+        if !matchItem(it, req, now) || !plan.before(it.CreatedAt, it.ID) {
             continue
         }
-        // 类型过滤
-        if len(req.Kinds) > 0 {
-            ok := false
-            for _, k := range req.Kinds {
-                if it.Kind == k {
-                    ok = true
-                    break
-                }
-            }
-            if !ok {
-                continue
-            }
+        res = append(res, it)
+    }
+
+    if req.Query != "" {
+        idx := m.index[key]
+        if idx == nil {
+            idx = newBM25Index()
         }
-        // 标签过滤
-        if len(req.Tags) > 0 {
-            tagOK := true
-            for _, want := range req.Tags {
-                found := false
-                for _, t := range it.Tags {
-                    if t == want {
-                        found = true
-                        break
-                    }
-                }
-                if !found {
-                    tagOK = false
-                    break
-                }
-            }
-            if !tagOK {
-                continue
-            }
+        res = scoreByQuery(idx, res, req.Query)
+    }
+    return plan.page(res), nil
+}
+
+// indexOf 返回 ID 在租户列表中的位置（调用方持有锁）
+func (m *memoryStore) indexOf(t Tenant, id string) int {
+    if id == "" {
+        return -1
+    }
+    for i, it := range m.itemsByKey[m.tenantKey(t)] {
+        if it.ID == id {
+            return i
         }
-        // 文本匹配（简单包含）
-        if req.Query != "" && !strings.Contains(strings.ToLower(it.Content), strings.ToLower(req.Query)) {
-            continue
+    }
+    return -1
+}
+
+func (m *memoryStore) Get(ctx context.Context, t Tenant, id string) (MemoryItem, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    i := m.indexOf(t, id)
+    if i < 0 {
+        return MemoryItem{}, ErrNotFound
+    }
+    return m.itemsByKey[m.tenantKey(t)][i], nil
+}
+
+func (m *memoryStore) Update(ctx context.Context, item MemoryItem) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    i := m.indexOf(item.Tenant, item.ID)
+    if i < 0 {
+        return ErrNotFound
+    }
+    key := m.tenantKey(item.Tenant)
+    m.replace(key, i, item)
+    m.indexFor(key).Add(item)
+    return nil
+}
+
+// Versions 返回条目的历史版本与当前版本（从旧到新）
+func (m *memoryStore) Versions(ctx context.Context, t Tenant, id string) ([]MemoryItem, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    i := m.indexOf(t, id)
+    if i < 0 {
+        return nil, ErrNotFound
+    }
+    key := m.tenantKey(t)
+    res := append([]MemoryItem(nil), m.history[key][id]...)
+    return append(res, m.itemsByKey[key][i]), nil
+}
+
+func (m *memoryStore) Delete(ctx context.Context, t Tenant, id string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    i := m.indexOf(t, id)
+    if i < 0 {
+        return ErrNotFound
+    }
+    key := m.tenantKey(t)
+    lst := m.itemsByKey[key]
+    m.itemsByKey[key] = append(lst[:i:i], lst[i+1:]...)
+    if idx := m.index[key]; idx != nil {
+        idx.Remove(id)
+    }
+    delete(m.history[key], id)
+    return nil
+}
+
+func (m *memoryStore) Close(ctx context.Context) error { return nil }
+
+func (m *memoryStore) ListArchives(ctx context.Context, userID string) ([]string, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    prefix := userID + "::"
+    var res []string
+    for key := range m.itemsByKey {
+        if strings.HasPrefix(key, prefix) {
+            res = append(res, strings.TrimPrefix(key, prefix))
         }
+    }
+    sort.Strings(res)
+    return res, nil
+}
 
-        res = append(res, it)
-        if req.TopK > 0 && len(res) >= req.TopK {
-            break
+// Tenants 列出缓存中的全部租户
+func (m *memoryStore) Tenants(ctx context.Context) ([]Tenant, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    var res []Tenant
+    for key := range m.itemsByKey {
+        if user, archive, ok := strings.Cut(key, "::"); ok {
+            res = append(res, Tenant{UserID: user, ArchiveID: archive})
         }
     }
+    sort.Slice(res, func(i, j int) bool {
+        if res[i].UserID != res[j].UserID {
+            return res[i].UserID < res[j].UserID
+        }
+        return res[i].ArchiveID < res[j].ArchiveID
+    })
+    return res, nil
+}
+
+func (m *memoryStore) Create(ctx context.Context, t Tenant) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    key := m.tenantKey(t)
+    if _, ok := m.itemsByKey[key]; !ok {
+        m.itemsByKey[key] = []MemoryItem{}
+    }
+    return nil
+}
+
+func (m *memoryStore) Export(ctx context.Context, t Tenant) ([]MemoryItem, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
 
-    return QueryResult{Items: res}, nil
+    lst := m.itemsByKey[m.tenantKey(t)]
+    return append([]MemoryItem(nil), lst...), nil
 }
 
-func (m *memoryStore) Close(ctx context.Context) error { return nil }
+func (m *memoryStore) Purge(ctx context.Context, t Tenant) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    key := m.tenantKey(t)
+    delete(m.itemsByKey, key)
+    delete(m.warmed, key)
+    delete(m.evicted, key)
+    delete(m.index, key)
+    delete(m.history, key)
+    return nil
+}
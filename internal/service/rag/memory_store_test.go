@@ -80,3 +80,20 @@ func TestMemoryStore_TopK_Order(t *testing.T) {
         t.Fatalf("topk order mismatch: %+v", qr.Items)
     }
 }
+
+func TestMemoryStore_GetUpdateDelete(t *testing.T) {
+    st := NewMemoryStore(InMemoryOptions{Enable: true})
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    for _, id := range []string{"1", "2"} {
+        _ = st.Save(ctx, MemoryItem{ID: id, Tenant: ten, Content: "v" + id, CreatedAt: time.Now()})
+    }
+
+    if err := st.Update(ctx, MemoryItem{ID: "1", Tenant: ten, Content: "new"}); err != nil { t.Fatalf("update: %v", err) }
+    if it, _ := st.Get(ctx, ten, "1"); it.Content != "new" { t.Fatalf("get: %+v", it) }
+    if err := st.Delete(ctx, ten, "1"); err != nil { t.Fatalf("delete: %v", err) }
+    if _, err := st.Get(ctx, ten, "1"); err != ErrNotFound { t.Fatalf("expect ErrNotFound, got %v", err) }
+    if err := st.Delete(ctx, Tenant{UserID: "u", ArchiveID: "other"}, "2"); err != ErrNotFound { t.Fatalf("cross-tenant delete: %v", err) }
+    qr, _ := st.Query(ctx, QueryRequest{Tenant: ten, TopK: 10})
+    if len(qr.Items) != 1 || qr.Items[0].ID != "2" { t.Fatalf("unexpected items: %+v", qr.Items) }
+}
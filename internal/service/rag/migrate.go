@@ -0,0 +1,108 @@
+package rag
+
+import (
+    "context"
+    "errors"
+    "fmt"
+)
+
+// JSONL -> bbolt 迁移（cmd/ragmigrate 使用）
+// 通过 diskJSONStore 回放读取（含封存段、更新与墓碑），按写入顺序分批写入 bbolt；
+// 已存在的 ID 被覆盖并保留原位置，因此可以重复执行
+
+const migrateBatch = 1000
+
+// MigrateTenant 单个租户的迁移结果
+type MigrateTenant struct {
+    Tenant Tenant `json:"tenant"`
+    Items  int    `json:"items"`
+}
+
+// MigrateReport 迁移汇总
+type MigrateReport struct {
+    Tenants []MigrateTenant `json:"tenants"`
+    Errors  []string        `json:"errors,omitempty"`
+}
+
+// Items 迁移的条目总数
+func (r MigrateReport) Items() int {
+    n := 0
+    for _, t := range r.Tenants {
+        n += t.Items
+    }
+    return n
+}
+
+// MigrateDiskToBolt 将 DiskJSON 数据导入 bbolt；dryRun 时只统计不写入
+func MigrateDiskToBolt(ctx context.Context, ns string, from DiskJSONOptions, to BoltOptions, dryRun bool) (MigrateReport, error) {
+    var rep MigrateReport
+    src, err := NewDiskJSONStore(ns, from)
+    if err != nil {
+        return rep, err
+    }
+    defer src.Close(ctx)
+    ds := src.(*diskJSONStore)
+    tenants, err := ds.Tenants(ctx)
+    if err != nil {
+        return rep, err
+    }
+
+    var dst *boltStore
+    if !dryRun {
+        st, err := NewBoltStore(ns, to)
+        if err != nil {
+            return rep, err
+        }
+        defer st.Close(ctx)
+        dst = st.(*boltStore)
+    }
+
+    for _, t := range tenants {
+        if ctx.Err() != nil {
+            return rep, ctx.Err()
+        }
+        items, err := ds.Export(ctx, t)
+        if err == nil && dst != nil {
+            err = migrateTenant(ctx, dst, t, items)
+        }
+        if err != nil {
+            rep.Errors = append(rep.Errors, fmt.Sprintf("%s/%s: %v", t.UserID, t.ArchiveID, err))
+            continue
+        }
+        rep.Tenants = append(rep.Tenants, MigrateTenant{Tenant: t, Items: len(items)})
+    }
+    if len(rep.Errors) > 0 {
+        return rep, errors.New("部分租户迁移失败")
+    }
+    return rep, nil
+}
+
+// migrateTenant 分批写入并校验每个带 ID 的条目均可读取
+func migrateTenant(ctx context.Context, dst *boltStore, t Tenant, items []MemoryItem) error {
+    if err := dst.Create(ctx, t); err != nil {
+        return err
+    }
+    for start := 0; start < len(items); start += migrateBatch {
+        end := start + migrateBatch
+        if end > len(items) {
+            end = len(items)
+        }
+        batch := make([]MemoryItem, end-start)
+        copy(batch, items[start:end])
+        for i := range batch {
+            batch[i].Tenant = t
+        }
+        if err := dst.SaveBatch(ctx, batch); err != nil {
+            return err
+        }
+    }
+    for _, it := range items {
+        if it.ID == "" {
+            continue
+        }
+        if _, err := dst.Get(ctx, t, it.ID); err != nil {
+            return fmt.Errorf("verify %s: %w", it.ID, err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,191 @@
+package rag
+
+import (
+    "context"
+    "errors"
+    "sort"
+
+    actx "ahs/internal/context"
+
+    "go.uber.org/zap"
+)
+
+// 记忆来源（provenance）
+// - Save 时从 context（actx.WithProvenance，由工作流服务、agent 与工具逐层补充）读取来源，
+//   写入 Meta 的保留键；context 中的非空字段覆盖调用方在这些键上的取值，未携带来源时 Meta 保持原样（如导入、整理）
+// - 近重复合并不改写已有条目的来源（见 dedupe.go）
+// - 本地三元组库同样记录来源（见 triple_store.go），重复保存已有三元组不改写来源
+// - RunItems/RollbackRun 按运行 ID 列出或删除该次运行写入的全部记忆（含已过期），用于追查与批量回滚错误写入；
+//   RunTriples 列出该次运行新增的三元组，RollbackRun 一并删除
+
+// 来源写入 Meta 的保留键
+const (
+    MetaRunID      = "run_id"
+    MetaSessionID  = "session_id"
+    MetaWorkflow   = "workflow"
+    MetaToolCallID = "tool_call_id"
+    MetaModel      = "model"
+)
+
+// RollbackReport 回滚结果
+type RollbackReport struct {
+    RunID   string   `json:"run_id"`
+    Deleted []string `json:"deleted"`
+    Triples []string `json:"triples"` // 删除的三元组 ID
+}
+
+// provenanceMeta 来源字段与保留键的对应
+func provenanceMeta(p actx.Provenance) map[string]string {
+    return map[string]string{
+        MetaRunID:      p.RunID,
+        MetaSessionID:  p.SessionID,
+        MetaWorkflow:   p.Workflow,
+        MetaToolCallID: p.ToolCallID,
+        MetaModel:      p.Model,
+    }
+}
+
+// isProvenanceKey 是否为来源保留键
+func isProvenanceKey(k string) bool {
+    switch k {
+    case MetaRunID, MetaSessionID, MetaWorkflow, MetaToolCallID, MetaModel:
+        return true
+    }
+    return false
+}
+
+// stampProvenance 返回写入来源后的 Meta（复制，不修改调用方的 map）
+func stampProvenance(ctx context.Context, meta map[string]any) map[string]any {
+    p, ok := actx.GetProvenance(ctx)
+    if !ok {
+        return meta
+    }
+    out := make(map[string]any, len(meta)+5)
+    for k, v := range meta {
+        out[k] = v
+    }
+    for k, v := range provenanceMeta(p) {
+        if v != "" {
+            out[k] = v
+        }
+    }
+    return out
+}
+
+// stampTripleProvenance 返回写入来源后的三元组 Meta（复制，不修改调用方的 map）
+func stampTripleProvenance(ctx context.Context, meta map[string]string) map[string]string {
+    p, ok := actx.GetProvenance(ctx)
+    if !ok {
+        return meta
+    }
+    out := make(map[string]string, len(meta)+5)
+    for k, v := range meta {
+        out[k] = v
+    }
+    for k, v := range provenanceMeta(p) {
+        if v != "" {
+            out[k] = v
+        }
+    }
+    return out
+}
+
+// RunItems 返回运行 runID 写入的全部记忆（含已过期），按创建时间从旧到新
+// 合并各本地存储（内存缓存中可能有尚未落盘的异步写入），按 ID 去重
+func (m *Manager) RunItems(ctx context.Context, t Tenant, runID string) ([]MemoryItem, error) {
+    if t.UserID == "" || t.ArchiveID == "" {
+        return nil, errors.New("tenant(user_id, archive_id) 不能为空")
+    }
+    if runID == "" {
+        return nil, errors.New("run_id 不能为空")
+    }
+    stores := m.archiveStores()
+    if len(stores) == 0 {
+        return nil, errors.New("本地存储未启用或不支持导出")
+    }
+    m.ensureWarm(ctx, t)
+    seen := make(map[string]bool)
+    var res []MemoryItem
+    for _, st := range stores {
+        all, err := st.Export(ctx, t)
+        if err != nil {
+            return nil, err
+        }
+        for _, it := range all {
+            if seen[it.ID] || it.Meta[MetaRunID] != runID {
+                continue
+            }
+            seen[it.ID] = true
+            res = append(res, it)
+        }
+    }
+    sort.SliceStable(res, func(i, j int) bool {
+        return recencyLess(res[j].CreatedAt, res[j].ID, res[i].CreatedAt, res[i].ID)
+    })
+    return res, nil
+}
+
+// RunTriples 返回运行 runID 新增的三元组（含已失效），按创建时间从旧到新；未使用本地三元组库时返回空
+func (m *Manager) RunTriples(ctx context.Context, t Tenant, runID string) ([]Triple, error) {
+    if t.UserID == "" || t.ArchiveID == "" {
+        return nil, errors.New("tenant(user_id, archive_id) 不能为空")
+    }
+    if runID == "" {
+        return nil, errors.New("run_id 不能为空")
+    }
+    ts, ok := m.Triples()
+    if !ok {
+        return nil, nil
+    }
+    all, err := ts.MatchTriples(ctx, t, TriplePattern{AllTime: true})
+    if err != nil {
+        return nil, err
+    }
+    var res []Triple
+    for _, tr := range all {
+        if tr.Meta[MetaRunID] == runID {
+            res = append(res, tr)
+        }
+    }
+    sort.SliceStable(res, func(i, j int) bool {
+        return recencyLess(res[j].CreatedAt, res[j].ID, res[i].CreatedAt, res[i].ID)
+    })
+    return res, nil
+}
+
+// RollbackRun 删除运行 runID 写入的全部记忆（本地存储与向量后端）及新增的三元组
+// 先等待该租户在异步队列中的写入全部落盘（直到 ctx 截止），避免排队的写入在删除后重新写回；
+// 回滚开始后才入队的写入不在回滚范围内
+func (m *Manager) RollbackRun(ctx context.Context, t Tenant, runID string) (RollbackReport, error) {
+    rep := RollbackReport{RunID: runID, Deleted: []string{}, Triples: []string{}}
+    if err := m.pending.waitTenant(ctx, t); err != nil {
+        return rep, err
+    }
+    items, err := m.RunItems(ctx, t, runID)
+    if err != nil {
+        return rep, err
+    }
+    for _, it := range items {
+        if err := m.Delete(ctx, t, it.ID); err != nil && !errors.Is(err, ErrNotFound) {
+            return rep, err
+        }
+        rep.Deleted = append(rep.Deleted, it.ID)
+    }
+    triples, err := m.RunTriples(ctx, t, runID)
+    if err != nil {
+        return rep, err
+    }
+    ts, _ := m.Triples()
+    for _, tr := range triples {
+        if err := ts.DeleteTriple(ctx, t, tr.ID); err != nil && !errors.Is(err, ErrNotFound) {
+            return rep, err
+        }
+        rep.Triples = append(rep.Triples, tr.ID)
+    }
+    if len(rep.Deleted) > 0 || len(rep.Triples) > 0 {
+        m.log().Info("运行写入的记忆已回滚",
+            zap.String("用户", t.UserID), zap.String("归档", t.ArchiveID),
+            zap.String("运行", runID), zap.Int("条数", len(rep.Deleted)), zap.Int("三元组", len(rep.Triples)))
+    }
+    return rep, nil
+}
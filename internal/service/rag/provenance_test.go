@@ -0,0 +1,124 @@
+package rag
+
+import (
+    "context"
+    "testing"
+
+    actx "ahs/internal/context"
+)
+
+func TestProvenance_StampedFromContext(t *testing.T) {
+    m := newTestManager(t, nil)
+    defer m.Close(context.Background())
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+
+    ctx := actx.WithProvenance(context.Background(), actx.Provenance{RunID: "run-1", SessionID: "s-1", Workflow: "agent"})
+    ctx = actx.WithProvenance(ctx, actx.Provenance{Model: "glm-4.5"})
+    ctx = actx.WithProvenance(ctx, actx.Provenance{ToolCallID: "call-1"})
+    meta := map[string]any{"chapter": 3, MetaRunID: "forged"}
+    if err := m.Save(ctx, MemoryItem{ID: "x", Tenant: ten, Content: "林夏", Meta: meta}, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    if meta[MetaRunID] != "forged" || len(meta) != 2 { t.Fatalf("caller meta mutated: %v", meta) }
+
+    it, _ := m.Get(context.Background(), ten, "x")
+    want := map[string]string{MetaRunID: "run-1", MetaSessionID: "s-1", MetaWorkflow: "agent", MetaModel: "glm-4.5", MetaToolCallID: "call-1"}
+    for k, v := range want {
+        if it.Meta[k] != v { t.Fatalf("%s: %v", k, it.Meta) }
+    }
+    if n, _ := metaInt(it.Meta["chapter"]); n != 3 { t.Fatalf("meta lost: %v", it.Meta) }
+
+    // 未携带来源时 Meta 保持原样；可按运行 ID 过滤查询
+    _ = m.Save(context.Background(), MemoryItem{ID: "y", Tenant: ten, Content: "林夏", Meta: map[string]any{MetaRunID: "imported"}}, SaveOptions{})
+    r, _ := m.Query(context.Background(), QueryRequest{Tenant: ten, Meta: []MetaFilter{{Key: MetaRunID, Value: "imported"}}})
+    if got := ids(r.Items); got != "y" { t.Fatalf("filter by run: %s", got) }
+}
+
+func TestRollbackRun(t *testing.T) {
+    disk, spool, vec := t.TempDir(), t.TempDir(), t.TempDir()
+    open := func() *Manager {
+        return newTestManager(t, func(o *RAGOptions) {
+            o.DiskJSON.RootPath = disk
+            o.Dedupe.Enable = true
+            o.Async.Enable = true
+            o.Async.SpoolPath = spool
+            o.Vector.Enable = true
+            o.Vector.RootPath = vec
+        })
+    }
+    m := open()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    bg := context.Background()
+    run1 := actx.WithProvenance(bg, actx.Provenance{RunID: "run-1"})
+    run2 := actx.WithProvenance(bg, actx.Provenance{RunID: "run-2"})
+    opt := SaveOptions{ToMemory: true, ToDisk: true, ToVector: true}
+
+    for _, c := range []struct {
+        ctx context.Context
+        id, content string
+    }{
+        {bg, "keep", "灯塔在北岸"},
+        {run1, "bad-1", "主角叫林晓"},
+        {run1, "bad-2", "反派是主角的哥哥"},
+        {run2, "good", "妹妹离家出走"},
+    } {
+        if err := m.Save(c.ctx, MemoryItem{ID: c.id, Tenant: ten, Content: c.content}, opt); err != nil { t.Fatalf("save: %v", err) }
+    }
+    // run-1 重复保存已有记忆：合并不改写来源，回滚时保留
+    res, err := m.SaveWithResult(run1, MemoryItem{Tenant: ten, Content: "灯塔在北岸。", Tags: []string{"地点"}}, opt)
+    if err != nil || !res.Merged || res.ID != "keep" { t.Fatalf("merge: %+v %v", res, err) }
+
+    items, err := m.RunItems(bg, ten, "run-1")
+    if err != nil || ids(items) != "bad-1,bad-2" { t.Fatalf("run items: %s %v", ids(items), err) }
+
+    rep, err := m.RollbackRun(bg, ten, "run-1")
+    if err != nil || len(rep.Deleted) != 2 { t.Fatalf("rollback: %+v %v", rep, err) }
+    r, _ := m.Query(bg, QueryRequest{Tenant: ten, TopK: 10})
+    if got := ids(r.Items); got != "good,keep" { t.Fatalf("after rollback: %s", got) }
+    vr, _ := m.vec.Query(bg, QueryRequest{Tenant: ten, Query: "主角", TopK: 10})
+    for _, it := range vr.Items {
+        if it.ID == "bad-1" || it.ID == "bad-2" { t.Fatalf("vector not rolled back: %s", ids(vr.Items)) }
+    }
+
+    // 重复回滚无事可做
+    if rep, err := m.RollbackRun(bg, ten, "run-1"); err != nil || len(rep.Deleted) != 0 { t.Fatalf("second rollback: %+v %v", rep, err) }
+    if _, err := m.RunItems(bg, ten, ""); err == nil { t.Fatalf("empty run id accepted") }
+
+    // 回滚前已排队的写入全部落盘后才删除，重启后不会复活
+    if err := m.Close(bg); err != nil { t.Fatalf("close: %v", err) }
+    m = open()
+    defer m.Close(bg)
+    if items, err := m.RunItems(bg, ten, "run-1"); err != nil || len(items) != 0 { t.Fatalf("rolled back items came back: %s %v", ids(items), err) }
+    vr, _ = m.vec.Query(bg, QueryRequest{Tenant: ten, Query: "主角", TopK: 10})
+    for _, it := range vr.Items {
+        if it.ID == "bad-1" || it.ID == "bad-2" { t.Fatalf("vector came back: %s", ids(vr.Items)) }
+    }
+}
+
+func TestRollbackRun_Triples(t *testing.T) {
+    m := newTestManager(t, func(o *RAGOptions) { o.Triple.Enable = true })
+    ts, ok := m.Triples()
+    if !ok { t.Fatalf("local triple store not enabled") }
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    bg := context.Background()
+    run1 := actx.WithProvenance(bg, actx.Provenance{RunID: "run-1", ToolCallID: "call-1"})
+
+    kept, err := ts.SaveTriple(bg, Triple{Tenant: ten, Subject: "王城", Predicate: "located_in", Object: "北境"})
+    if err != nil { t.Fatalf("save: %v", err) }
+    bad, err := ts.SaveTriple(run1, Triple{Tenant: ten, Subject: "林夏", Predicate: "sister_of", Object: "林晓"})
+    if err != nil || bad.Meta[MetaRunID] != "run-1" || bad.Meta[MetaToolCallID] != "call-1" { t.Fatalf("provenance not stamped: %+v %v", bad, err) }
+    // 重复保存已有三元组：不改写来源，回滚时保留
+    if again, err := ts.SaveTriple(run1, Triple{Tenant: ten, Subject: "王城", Predicate: "located_in", Object: "北境", Provenance: "第3章"}); err != nil || again.ID != kept.ID || again.Meta != nil { t.Fatalf("resave: %+v %v", again, err) }
+    // 由记忆条目抽取的三元组沿用条目的来源
+    item := MemoryItem{ID: "m1", Tenant: ten, Content: "林夏住在灯塔", Meta: map[string]any{"subject": "林夏", "predicate": "lives_in", "object": "灯塔"}}
+    if err := m.Save(run1, item, SaveOptions{ToMemory: true, ToDisk: true, ToTriple: true}); err != nil { t.Fatalf("save item: %v", err) }
+
+    trs, err := m.RunTriples(bg, ten, "run-1")
+    if err != nil || len(trs) != 2 || trs[0].ID != bad.ID || trs[1].SourceID != "m1" { t.Fatalf("run triples: %+v %v", trs, err) }
+    r, _ := m.Query(bg, QueryRequest{Tenant: ten, Query: "林夏", UseTriple: true, Kinds: []MemoryKind{KindTriple}, Meta: []MetaFilter{{Key: MetaRunID, Value: "run-1"}}})
+    if len(r.Items) != 2 { t.Fatalf("filter triples by run: %s", ids(r.Items)) }
+
+    rep, err := m.RollbackRun(bg, ten, "run-1")
+    if err != nil || len(rep.Deleted) != 1 || len(rep.Triples) != 2 { t.Fatalf("rollback: %+v %v", rep, err) }
+    left, _ := ts.MatchTriples(bg, ten, TriplePattern{AllTime: true})
+    if len(left) != 1 || left[0].ID != kept.ID { t.Fatalf("after rollback: %+v", left) }
+    if trs, err := m.RunTriples(bg, ten, "run-1"); err != nil || len(trs) != 0 { t.Fatalf("second list: %+v %v", trs, err) }
+}
@@ -0,0 +1,292 @@
+package rag
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "hash/fnv"
+    "sort"
+    "time"
+)
+
+// 检索过滤、排序与分页（各本地存储共用）
+// - 时间范围：CreatedAfter <= CreatedAt < CreatedBefore
+// - 标签：TagMode all（默认，包含全部 Tags）/ any（包含任一）；ExcludeTags 排除包含其中任一标签的条目
+// - Meta 谓词：eq（默认，按 JSON 值相等）/ in（等于 Values 之一）/ exists（Value 为 false 时要求不存在）
+// - 排序：recency 按 (CreatedAt, ID) 从新到旧；score 按分数从高到低，同分按 recency；缺省时有文本查询为 score，否则为 recency
+// - 游标：不透明字符串，绑定查询条件（不含 TopK）
+//   recency 记录上一页最后一条的 (CreatedAt, ID)，各存储只返回其后的条目，翻页期间的新写入不影响后续页；
+//   score 记录偏移量，由 Manager 在融合结果中截取。QueryResult.NextCursor 为空表示没有更多结果
+
+// 排序方式（QueryRequest.Sort）
+const (
+    SortRecency = "recency"
+    SortScore   = "score"
+)
+
+// 标签匹配方式（QueryRequest.TagMode）
+const (
+    TagModeAll = "all"
+    TagModeAny = "any"
+)
+
+// Meta 谓词（MetaFilter.Op）
+const (
+    MetaEq     = "eq"
+    MetaIn     = "in"
+    MetaExists = "exists"
+)
+
+// ErrInvalidCursor 游标无法解析或与查询条件不匹配
+var ErrInvalidCursor = errors.New("无效的分页游标")
+
+// cursorState 游标内容（base64url 编码的 JSON）
+type cursorState struct {
+    S  string `json:"s"`            // 排序方式
+    F  string `json:"f"`            // 查询条件指纹
+    T  int64  `json:"t,omitempty"`  // recency：上一页最后一条的 CreatedAt（UnixNano）
+    ID string `json:"id,omitempty"` // recency：上一页最后一条的 ID
+    O  int    `json:"o,omitempty"`  // score：偏移量
+}
+
+// queryPlan 由请求解析出的排序与分页
+type queryPlan struct {
+    sort   string
+    fp     string
+    after  *cursorState // recency 游标
+    offset int          // score 游标
+    limit  int          // TopK，<=0 不限
+}
+
+// planQuery 校验请求并解析排序与游标
+func planQuery(req QueryRequest) (queryPlan, error) {
+    if err := validateQuery(req); err != nil {
+        return queryPlan{}, err
+    }
+    p := queryPlan{sort: sortOf(req), fp: queryFingerprint(req), limit: req.TopK}
+    if req.Cursor == "" {
+        return p, nil
+    }
+    raw, err := base64.RawURLEncoding.DecodeString(req.Cursor)
+    if err != nil {
+        return queryPlan{}, ErrInvalidCursor
+    }
+    var c cursorState
+    if err := json.Unmarshal(raw, &c); err != nil || c.S != p.sort || c.F != p.fp || c.O < 0 {
+        return queryPlan{}, ErrInvalidCursor
+    }
+    if p.sort == SortRecency {
+        p.after = &c
+    } else {
+        p.offset = c.O
+    }
+    return p, nil
+}
+
+func validateQuery(req QueryRequest) error {
+    switch req.Sort {
+    case "", SortRecency, SortScore:
+    default:
+        return fmt.Errorf("不支持的排序方式: %s", req.Sort)
+    }
+    switch req.TagMode {
+    case "", TagModeAll, TagModeAny:
+    default:
+        return fmt.Errorf("不支持的标签匹配方式: %s", req.TagMode)
+    }
+    for _, f := range req.Meta {
+        if f.Key == "" {
+            return errors.New("meta 过滤的 key 不能为空")
+        }
+        switch f.Op {
+        case "", MetaEq, MetaIn, MetaExists:
+        default:
+            return fmt.Errorf("不支持的 meta 谓词: %s", f.Op)
+        }
+    }
+    if req.CreatedAfter != nil && req.CreatedBefore != nil && !req.CreatedAfter.Before(*req.CreatedBefore) {
+        return errors.New("created_after 必须早于 created_before")
+    }
+    return nil
+}
+
+// sortOf 解析排序方式：缺省时有文本查询按分数，否则按时间
+func sortOf(req QueryRequest) string {
+    if req.Sort != "" {
+        return req.Sort
+    }
+    if req.Query != "" {
+        return SortScore
+    }
+    return SortRecency
+}
+
+// queryFingerprint 查询条件指纹（不含 TopK、游标与查询向量）
+func queryFingerprint(req QueryRequest) string {
+    req.TopK, req.Cursor, req.Vector = 0, "", nil
+    b, _ := json.Marshal(req)
+    h := fnv.New64a()
+    _, _ = h.Write(b)
+    return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// before 条目 (t, id) 在 recency 顺序中是否位于游标之后
+func (p queryPlan) before(t time.Time, id string) bool {
+    if p.after == nil {
+        return true
+    }
+    n := t.UnixNano()
+    return n < p.after.T || n == p.after.T && id < p.after.ID
+}
+
+// need 需要从排序结果开头取出的条数（含用于判断是否有下一页的一条），0 表示全部
+func (p queryPlan) need() int {
+    if p.limit <= 0 {
+        return 0
+    }
+    return p.offset + p.limit + 1
+}
+
+// window 在长度为 n 的有序结果中截取当前页，more 表示还有下一页
+func (p queryPlan) window(n int) (lo, hi int, more bool) {
+    lo = p.offset
+    if lo > n {
+        lo = n
+    }
+    hi = n
+    if p.limit > 0 && lo+p.limit < n {
+        hi = lo + p.limit
+    }
+    return lo, hi, hi < n
+}
+
+// next 生成下一页游标；last 为当前页最后一条，hi 为其在有序结果中的下一位置
+func (p queryPlan) next(last MemoryItem, hi int) string {
+    c := cursorState{S: p.sort, F: p.fp}
+    if p.sort == SortRecency {
+        c.T, c.ID = last.CreatedAt.UnixNano(), last.ID
+    } else {
+        c.O = hi
+    }
+    b, _ := json.Marshal(c)
+    return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// page 过滤游标之前的条目，按排序方式排序并截取当前页
+func (p queryPlan) page(items []MemoryItem) QueryResult {
+    kept := items[:0]
+    for _, it := range items {
+        if p.before(it.CreatedAt, it.ID) {
+            kept = append(kept, it)
+        }
+    }
+    sortItems(kept, p.sort == SortScore)
+    lo, hi, more := p.window(len(kept))
+    res := QueryResult{Items: kept[lo:hi]}
+    if more && hi > lo {
+        res.NextCursor = p.next(kept[hi-1], hi)
+    }
+    return res
+}
+
+// recencyLess (CreatedAt, ID) 从新到旧
+func recencyLess(t1 time.Time, id1 string, t2 time.Time, id2 string) bool {
+    if n1, n2 := t1.UnixNano(), t2.UnixNano(); n1 != n2 {
+        return n1 > n2
+    }
+    return id1 > id2
+}
+
+// sortItems 稳定排序：byScore 时先按分数从高到低，再按 recency
+func sortItems(items []MemoryItem, byScore bool) {
+    sort.SliceStable(items, func(i, j int) bool {
+        if byScore && items[i].Score != items[j].Score {
+            return items[i].Score > items[j].Score
+        }
+        return recencyLess(items[i].CreatedAt, items[i].ID, items[j].CreatedAt, items[j].ID)
+    })
+}
+
+// matchFilters 过期、时间范围、类型与标签过滤（不含文本与 Meta）
+func matchFilters(it MemoryItem, req QueryRequest, now time.Time) bool {
+    if it.ExpiresAt != nil && it.ExpiresAt.Before(now) {
+        return false
+    }
+    if req.CreatedAfter != nil && it.CreatedAt.Before(*req.CreatedAfter) {
+        return false
+    }
+    if req.CreatedBefore != nil && !it.CreatedAt.Before(*req.CreatedBefore) {
+        return false
+    }
+    if len(req.Kinds) > 0 {
+        ok := false
+        for _, k := range req.Kinds {
+            if it.Kind == k { ok = true; break }
+        }
+        if !ok { return false }
+    }
+    if len(req.Tags) > 0 {
+        anyMode := req.TagMode == TagModeAny
+        hit := 0
+        for _, want := range req.Tags {
+            if hasTag(it.Tags, want) { hit++ }
+        }
+        if anyMode && hit == 0 || !anyMode && hit < len(req.Tags) {
+            return false
+        }
+    }
+    for _, ex := range req.ExcludeTags {
+        if hasTag(it.Tags, ex) { return false }
+    }
+    return true
+}
+
+// matchItem 完整条目的过滤（matchFilters + Meta 谓词）
+func matchItem(it MemoryItem, req QueryRequest, now time.Time) bool {
+    return matchFilters(it, req, now) && matchMeta(it.Meta, req.Meta)
+}
+
+func hasTag(tags []string, want string) bool {
+    for _, t := range tags {
+        if t == want { return true }
+    }
+    return false
+}
+
+// matchMeta 全部谓词成立时返回 true
+func matchMeta(meta map[string]any, filters []MetaFilter) bool {
+    for _, f := range filters {
+        v, ok := meta[f.Key]
+        switch f.Op {
+        case MetaExists:
+            want := true
+            if b, isBool := f.Value.(bool); isBool {
+                want = b
+            }
+            if ok != want { return false }
+        case MetaIn:
+            if !ok { return false }
+            found := false
+            for _, x := range f.Values {
+                if metaEqual(v, x) { found = true; break }
+            }
+            if !found { return false }
+        default:
+            if !ok || !metaEqual(v, f.Value) { return false }
+        }
+    }
+    return true
+}
+
+// metaEqual 按 JSON 编码比较，使内存中的 int 与磁盘读回的 float64 等值
+func metaEqual(a, b any) bool {
+    if sa, ok := a.(string); ok {
+        sb, ok := b.(string)
+        return ok && sa == sb
+    }
+    ja, err1 := json.Marshal(a)
+    jb, err2 := json.Marshal(b)
+    return err1 == nil && err2 == nil && bytes.Equal(ja, jb)
+}
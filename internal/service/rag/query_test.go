@@ -0,0 +1,161 @@
+package rag
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+)
+
+// queryStores 同一批数据写入内存、JSONL 与 bbolt 存储
+func queryStores(t *testing.T, items []MemoryItem) map[string]Store {
+    t.Helper()
+    ds, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: t.TempDir()})
+    if err != nil { t.Fatalf("new disk store: %v", err) }
+    stores := map[string]Store{
+        "memory": NewMemoryStore(InMemoryOptions{Enable: true, MaxEntries: 10000}),
+        "disk":   ds,
+        "bolt":   newTestBoltStore(t, t.TempDir()),
+    }
+    for name, st := range stores {
+        for _, it := range items {
+            if err := st.Save(context.Background(), it); err != nil { t.Fatalf("%s save: %v", name, err) }
+        }
+        t.Cleanup(func() { _ = st.Close(context.Background()) })
+    }
+    return stores
+}
+
+// pageAll 按游标翻页直到结束，返回拼接后的 ID
+func pageAll(t *testing.T, query func(cursor string) (QueryResult, error)) string {
+    t.Helper()
+    var res []MemoryItem
+    cursor := ""
+    for i := 0; ; i++ {
+        if i > 100 { t.Fatalf("too many pages") }
+        r, err := query(cursor)
+        if err != nil { t.Fatalf("query page %d: %v", i, err) }
+        res = append(res, r.Items...)
+        if r.NextCursor == "" { break }
+        cursor = r.NextCursor
+    }
+    return ids(res)
+}
+
+func TestQuery_FiltersConsistentAcrossStores(t *testing.T) {
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+    var items []MemoryItem
+    for i := 0; i < 6; i++ {
+        items = append(items, MemoryItem{
+            ID: fmt.Sprintf("m%d", i), Tenant: ten, Content: fmt.Sprintf("王城记事 %d", i),
+            Tags: [][]string{{"x"}, {"y"}, {"x", "y"}, {"z"}, {"x", "z"}, nil}[i],
+            Meta: map[string]any{"chapter": i, "pov": []string{"林夏", "沈舟"}[i%2]},
+            CreatedAt: base.Add(time.Duration(i) * time.Hour),
+        })
+    }
+    items[5].Meta = nil
+    after, before := base.Add(time.Hour), base.Add(4*time.Hour)
+
+    cases := []struct {
+        name string
+        req  QueryRequest
+        want string
+    }{
+        {"time range", QueryRequest{CreatedAfter: &after, CreatedBefore: &before}, "m3,m2,m1"},
+        {"tags all", QueryRequest{Tags: []string{"x", "y"}}, "m2"},
+        {"tags any", QueryRequest{Tags: []string{"y", "z"}, TagMode: TagModeAny}, "m4,m3,m2,m1"},
+        {"exclude", QueryRequest{ExcludeTags: []string{"x"}}, "m5,m3,m1"},
+        {"meta eq", QueryRequest{Meta: []MetaFilter{{Key: "pov", Value: "沈舟"}}}, "m3,m1"},
+        {"meta eq number", QueryRequest{Meta: []MetaFilter{{Key: "chapter", Value: 2}}}, "m2"},
+        {"meta in", QueryRequest{Meta: []MetaFilter{{Key: "chapter", Op: MetaIn, Values: []any{0, 4.0, "5"}}}}, "m4,m0"},
+        {"meta missing", QueryRequest{Meta: []MetaFilter{{Key: "pov", Op: MetaExists, Value: false}}}, "m5"},
+        {"text recency", QueryRequest{Query: "王城", Sort: SortRecency, Tags: []string{"z"}}, "m4,m3"},
+    }
+    for name, st := range queryStores(t, items) {
+        for _, tc := range cases {
+            req := tc.req
+            req.Tenant = ten
+            r, err := st.Query(context.Background(), req)
+            if err != nil { t.Fatalf("%s/%s: %v", name, tc.name, err) }
+            if got := ids(r.Items); got != tc.want { t.Fatalf("%s/%s: got %s want %s", name, tc.name, got, tc.want) }
+        }
+    }
+}
+
+func TestQuery_CursorPagingAcrossStores(t *testing.T) {
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+    var items []MemoryItem
+    var want []string
+    for i := 0; i < 23; i++ {
+        // 每两条同一时刻，验证同一时刻的条目按 ID 分页不重不漏
+        items = append(items, MemoryItem{ID: fmt.Sprintf("m%02d", i), Tenant: ten, Content: "章节", Tags: []string{"t"}, CreatedAt: base.Add(time.Duration(i/2) * time.Minute)})
+    }
+    for i := 22; i >= 0; i-- { want = append(want, fmt.Sprintf("m%02d", i)) }
+
+    for name, st := range queryStores(t, items) {
+        ctx := context.Background()
+        for _, topK := range []int{1, 2, 5, 50} {
+            got := pageAll(t, func(cursor string) (QueryResult, error) {
+                return st.Query(ctx, QueryRequest{Tenant: ten, Tags: []string{"t"}, TopK: topK, Cursor: cursor})
+            })
+            if got != strings.Join(want, ",") { t.Fatalf("%s topK=%d: %s", name, topK, got) }
+        }
+
+        // 翻页期间的新写入不影响后续页
+        r, _ := st.Query(ctx, QueryRequest{Tenant: ten, TopK: 5})
+        _ = st.Save(ctx, MemoryItem{ID: "new", Tenant: ten, Content: "新", CreatedAt: base.Add(time.Hour)})
+        r, _ = st.Query(ctx, QueryRequest{Tenant: ten, TopK: 5, Cursor: r.NextCursor})
+        if ids(r.Items) != strings.Join(want[5:10], ",") { t.Fatalf("%s after write: %s", name, ids(r.Items)) }
+
+        // 游标绑定查询条件
+        if _, err := st.Query(ctx, QueryRequest{Tenant: ten, Tags: []string{"other"}, Cursor: r.NextCursor}); !errors.Is(err, ErrInvalidCursor) { t.Fatalf("%s mismatched cursor: %v", name, err) }
+        if _, err := st.Query(ctx, QueryRequest{Tenant: ten, Cursor: "!!"}); !errors.Is(err, ErrInvalidCursor) { t.Fatalf("%s garbage cursor: %v", name, err) }
+        if _, err := st.Query(ctx, QueryRequest{Tenant: ten, Sort: "random"}); err == nil { t.Fatalf("%s invalid sort accepted", name) }
+    }
+}
+
+func TestQuery_DiskUnorderedEntries(t *testing.T) {
+    // 导入的历史数据与更新使写入顺序与时间顺序不一致：按时间排序仍然正确
+    s := newTestDiskStore(t, 0)
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+    for i, off := range []int{5, 1, 4, 2, 3} {
+        _ = s.Save(ctx, MemoryItem{ID: fmt.Sprintf("m%d", i), Tenant: ten, Content: "x", CreatedAt: base.Add(time.Duration(off) * time.Minute)})
+    }
+    got := pageAll(t, func(cursor string) (QueryResult, error) {
+        return s.Query(ctx, QueryRequest{Tenant: ten, TopK: 2, Cursor: cursor})
+    })
+    if got != "m0,m2,m4,m3,m1" { t.Fatalf("recency order: %s", got) }
+}
+
+func TestManager_QueryPaging(t *testing.T) {
+    // 只验证融合分数下的游标，重要性与时间衰减排序见 ranking_test.go
+    m := newTestManager(t, func(o *RAGOptions) { o.Ranking.Enable = false })
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    base := time.Now().Add(-time.Hour)
+    for i := 0; i < 12; i++ {
+        content := "雪夜"
+        if i%3 == 0 { content = "雪夜 雪夜 雪夜" }
+        _ = m.Save(ctx, MemoryItem{ID: fmt.Sprintf("m%02d", i), Tenant: ten, Content: content, CreatedAt: base.Add(time.Duration(i) * time.Minute)}, SaveOptions{})
+    }
+
+    // recency：缓存与磁盘合并后分页
+    got := pageAll(t, func(cursor string) (QueryResult, error) {
+        return m.Query(ctx, QueryRequest{Tenant: ten, TopK: 5, Cursor: cursor})
+    })
+    if got != "m11,m10,m09,m08,m07,m06,m05,m04,m03,m02,m01,m00" { t.Fatalf("recency pages: %s", got) }
+
+    // score：按分数分页，同分按时间
+    got = pageAll(t, func(cursor string) (QueryResult, error) {
+        return m.Query(ctx, QueryRequest{Tenant: ten, Query: "雪夜", TopK: 5, Cursor: cursor})
+    })
+    if got != "m09,m06,m03,m00,m11,m10,m08,m07,m05,m04,m02,m01" { t.Fatalf("score pages: %s", got) }
+
+    if _, err := m.Query(ctx, QueryRequest{Tenant: ten, TagMode: "some"}); err == nil { t.Fatalf("invalid tag mode accepted") }
+}
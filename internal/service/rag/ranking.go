@@ -0,0 +1,316 @@
+package rag
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// 重要性与时间衰减排序（RankingOptions，score 排序时生效）
+// - 最终分数 = Relevance*相关度 + Importance*重要性 + Recency*时间衰减，权重按 MemoryKind 配置
+// - 相关度：融合分数除以候选中的最高分，归一化到 [0,1]；无文本查询时为 0
+// - 重要性：MemoryItem.Importance，未设置时按 DefaultImportance
+// - 时间衰减：2^(-age/HalfLife)，age 自 CreatedAt、LastAccessedAt 与 UpdatedAt（近重复合并）中最晚者起算；HalfLife<=0 不衰减
+// - 配置了 Reranker 时在排序之后重排，以重排结果为准
+// 检索统计（仅 Ranking.Enable 时）：Query 返回的条目累加 AccessCount 并刷新 LastAccessedAt；
+// 统计不写入记忆条目（JSONL 日志不随读取增长），在内存中累积，Get/Query 返回时叠加到条目上；
+// 按 AccessFlushInterval 周期或 Close 时整体快照到 Ranking.AccessPath/{Namespace}/access.jsonl，启动时载入。
+// Update 保留存储中的统计字段，不被调用方手中叠加后的值覆盖；Delete、清除归档与压缩丢弃条目时一并删除统计
+
+// accessFlushThreshold 上次快照后变化的条目达到该数量时提前快照
+const accessFlushThreshold = 1024
+
+// accessFileName 检索统计快照文件
+const accessFileName = "access.jsonl"
+
+// weightsFor 返回类型对应的权重
+func (o RankingOptions) weightsFor(k MemoryKind) RankWeights {
+    if w, ok := o.Kinds[k]; ok {
+        return w
+    }
+    return o.Default
+}
+
+// rank 按最终分数重排候选（改写 Score）
+func (m *Manager) rank(items []MemoryItem, now time.Time) {
+    ro := m.opts.Ranking
+    maxScore := 0.0
+    for _, it := range items {
+        if it.Score > maxScore {
+            maxScore = it.Score
+        }
+    }
+    for i := range items {
+        it := &items[i]
+        w := ro.weightsFor(it.Kind)
+        rel := 0.0
+        if maxScore > 0 && it.Score > 0 {
+            rel = it.Score / maxScore
+        }
+        imp := it.Importance
+        if imp <= 0 {
+            imp = ro.DefaultImportance
+        }
+        it.Score = w.Relevance*rel + w.Importance*imp + w.Recency*recencyDecay(*it, w.HalfLife, now)
+    }
+    sortItems(items, true)
+}
+
+// recencyDecay 时间衰减因子 (0,1]
+func recencyDecay(it MemoryItem, halfLife time.Duration, now time.Time) float64 {
+    if halfLife <= 0 {
+        return 1
+    }
+    ref := it.CreatedAt
+    if it.LastAccessedAt != nil && it.LastAccessedAt.After(ref) {
+        ref = *it.LastAccessedAt
+    }
+    if it.UpdatedAt != nil && it.UpdatedAt.After(ref) {
+        ref = *it.UpdatedAt
+    }
+    age := now.Sub(ref)
+    if age < 0 {
+        age = 0
+    }
+    return math.Exp2(-float64(age) / float64(halfLife))
+}
+
+// accessKey 检索统计所属的条目
+type accessKey struct {
+    t  Tenant
+    id string
+}
+
+// accessDelta 条目累积的检索统计（叠加在存储中的值之上）
+type accessDelta struct {
+    count int
+    last  time.Time
+}
+
+// accessRecord 快照文件中的一行
+type accessRecord struct {
+    UserID    string    `json:"user_id"`
+    ArchiveID string    `json:"archive_id"`
+    ID        string    `json:"id"`
+    Count     int       `json:"count"`
+    Last      time.Time `json:"last"`
+}
+
+// accessTracker 检索统计累积与后台快照
+type accessTracker struct {
+    mu    sync.Mutex
+    stats map[accessKey]accessDelta
+    dirty int    // 上次快照后变化的条目数
+    path  string // 快照文件，为空时只保存在内存
+    kick  chan struct{}
+    stop  chan struct{}
+    done  chan struct{}
+}
+
+// record 记录一次检索命中
+func (a *accessTracker) record(t Tenant, items []MemoryItem, now time.Time) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if a.stats == nil {
+        a.stats = make(map[accessKey]accessDelta)
+    }
+    for _, it := range items {
+        if it.ID == "" {
+            continue
+        }
+        k := accessKey{t, it.ID}
+        d := a.stats[k]
+        d.count++
+        d.last = now
+        a.stats[k] = d
+        a.dirty++
+    }
+    if a.dirty >= accessFlushThreshold && a.kick != nil {
+        select {
+        case a.kick <- struct{}{}:
+        default:
+        }
+    }
+}
+
+// overlay 将累积的统计计入条目
+func (a *accessTracker) overlay(t Tenant, items []MemoryItem) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    for i := range items {
+        if d, ok := a.stats[accessKey{t, items[i].ID}]; ok {
+            applyAccess(&items[i], d)
+        }
+    }
+}
+
+// forget 删除条目的统计
+func (a *accessTracker) forget(t Tenant, ids ...string) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    for _, id := range ids {
+        if _, ok := a.stats[accessKey{t, id}]; ok {
+            delete(a.stats, accessKey{t, id})
+            a.dirty++
+        }
+    }
+}
+
+// purge 删除租户的全部统计
+func (a *accessTracker) purge(t Tenant) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    for k := range a.stats {
+        if k.t == t {
+            delete(a.stats, k)
+            a.dirty++
+        }
+    }
+}
+
+// load 载入快照；文件不存在时为空，残缺或损坏的行跳过
+func (a *accessTracker) load(path string) error {
+    a.path = path
+    a.stats = make(map[accessKey]accessDelta)
+    f, err := os.Open(path)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("open access stats: %w", err)
+    }
+    defer f.Close()
+    return scanJSONL(f, func(line []byte) error {
+        var rec accessRecord
+        if json.Unmarshal(line, &rec) != nil || rec.ID == "" {
+            return nil
+        }
+        a.stats[accessKey{Tenant{UserID: rec.UserID, ArchiveID: rec.ArchiveID}, rec.ID}] = accessDelta{count: rec.Count, last: rec.Last}
+        return nil
+    })
+}
+
+// snapshot 有变化时以 tmp + fsync + rename 整体重写快照；失败时保留变化计数，下次重试
+func (a *accessTracker) snapshot() error {
+    a.mu.Lock()
+    if a.path == "" || a.dirty == 0 {
+        a.mu.Unlock()
+        return nil
+    }
+    dirty := a.dirty
+    a.dirty = 0
+    buf := make([]byte, 0, len(a.stats)*96)
+    for k, d := range a.stats {
+        b, _ := json.Marshal(accessRecord{UserID: k.t.UserID, ArchiveID: k.t.ArchiveID, ID: k.id, Count: d.count, Last: d.last})
+        buf = append(append(buf, b...), '\n')
+    }
+    a.mu.Unlock()
+
+    if err := writeFileAtomic(a.path, buf); err != nil {
+        a.mu.Lock()
+        a.dirty += dirty
+        a.mu.Unlock()
+        return fmt.Errorf("write access stats: %w", err)
+    }
+    return nil
+}
+
+// writeFileAtomic 写入 tmp 并 fsync 后改名替换目标文件
+func writeFileAtomic(path string, data []byte) error {
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+        return err
+    }
+    tmp := path + ".tmp"
+    f, err := os.Create(tmp)
+    if err != nil {
+        return err
+    }
+    if _, err = f.Write(data); err == nil {
+        err = f.Sync()
+    }
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        _ = os.Remove(tmp)
+        return err
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        return err
+    }
+    syncDir(filepath.Dir(path))
+    return nil
+}
+
+// keepAccess Update 时沿用存储中的统计：调用方手中的值可能已叠加了内存中的统计，写入会重复计数
+func keepAccess(item *MemoryItem, cur MemoryItem) {
+    item.AccessCount = cur.AccessCount
+    item.LastAccessedAt = cur.LastAccessedAt
+}
+
+func applyAccess(it *MemoryItem, d accessDelta) {
+    it.AccessCount += d.count
+    if it.LastAccessedAt == nil || d.last.After(*it.LastAccessedAt) {
+        last := d.last
+        it.LastAccessedAt = &last
+    }
+}
+
+// flushAccess 将检索统计快照到 Ranking.AccessPath
+func (m *Manager) flushAccess() error {
+    return m.access.snapshot()
+}
+
+// initAccess 载入检索统计快照；未启用 Ranking 或没有磁盘存储时不持久化
+func (m *Manager) initAccess() error {
+    if !m.opts.Ranking.Enable || m.opts.Ranking.AccessPath == "" || m.disk == nil {
+        return nil
+    }
+    return m.access.load(filepath.Join(m.opts.Ranking.AccessPath, m.opts.Namespace, accessFileName))
+}
+
+// startAccessFlusher 后台周期快照检索统计；未启用 Ranking 或未配置 AccessPath 时不快照
+func (m *Manager) startAccessFlusher() {
+    if !m.opts.Ranking.Enable || m.access.path == "" {
+        return
+    }
+    interval := m.opts.Ranking.AccessFlushInterval
+    if interval <= 0 {
+        interval = time.Minute
+    }
+    a := &m.access
+    a.kick = make(chan struct{}, 1)
+    a.stop = make(chan struct{})
+    a.done = make(chan struct{})
+    go func() {
+        defer close(a.done)
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-a.stop:
+                return
+            case <-ticker.C:
+            case <-a.kick:
+            }
+            if err := m.flushAccess(); err != nil {
+                m.log().Warn("检索统计快照失败，稍后重试", zap.Error(err))
+            }
+        }
+    }()
+}
+
+// stopAccessFlusher 停止后台快照并写出剩余统计
+func (m *Manager) stopAccessFlusher() error {
+    if m.access.stop != nil {
+        close(m.access.stop)
+        <-m.access.done
+    }
+    return m.flushAccess()
+}
@@ -0,0 +1,155 @@
+package rag
+
+import (
+    "bytes"
+    "context"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+// logLines 统计 DiskJSON 数据段的总行数
+func logLines(t *testing.T, disk string) int {
+    t.Helper()
+    n := 0
+    err := filepath.WalkDir(disk, func(path string, d os.DirEntry, err error) error {
+        if err != nil || d.IsDir() || !strings.HasPrefix(d.Name(), "data") { return err }
+        b, err := os.ReadFile(path)
+        n += bytes.Count(b, []byte("\n"))
+        return err
+    })
+    if err != nil { t.Fatalf("walk: %v", err) }
+    return n
+}
+
+func TestRank_ImportanceAndKindDecay(t *testing.T) {
+    m := newTestManager(t, nil)
+    defer m.Close(context.Background())
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    now := time.Now()
+    month := now.Add(-30 * 24 * time.Hour)
+    for _, it := range []MemoryItem{
+        {ID: "old-short", Kind: KindShortTerm, Content: "码头 走私", CreatedAt: month},
+        {ID: "old-fact", Kind: KindFact, Content: "码头 走私", CreatedAt: month},
+        {ID: "new-short", Kind: KindShortTerm, Content: "码头 走私", CreatedAt: now},
+        {ID: "minor", Kind: KindNote, Content: "码头 走私", CreatedAt: now, Importance: 0.1},
+        {ID: "major", Kind: KindNote, Content: "码头 走私", CreatedAt: now, Importance: 0.9},
+    } {
+        it.Tenant = ten
+        if err := m.Save(ctx, it, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    }
+
+    // 相关度相同：重要性高者优先；事实不衰减，短期记忆一个月后几乎不再加分
+    r, err := m.Query(ctx, QueryRequest{Tenant: ten, Query: "码头"})
+    if err != nil { t.Fatalf("query: %v", err) }
+    if got := ids(r.Items); got != "new-short,major,minor,old-fact,old-short" { t.Fatalf("ranked: %s", got) }
+    for i := 1; i < len(r.Items); i++ {
+        if r.Items[i].Score > r.Items[i-1].Score { t.Fatalf("scores not descending: %+v", r.Items) }
+    }
+
+    // 无文本查询时按重要性与时间衰减排序
+    r, _ = m.Query(ctx, QueryRequest{Tenant: ten, Sort: SortScore, Kinds: []MemoryKind{KindNote}})
+    if got := ids(r.Items); got != "major,minor" { t.Fatalf("importance only: %s", got) }
+
+    if err := m.Save(ctx, MemoryItem{Tenant: ten, Content: "x", Importance: 1.5}, SaveOptions{}); err == nil { t.Fatalf("importance out of range accepted") }
+}
+
+func TestRank_AccessRefreshesRecency(t *testing.T) {
+    m := newTestManager(t, nil)
+    defer m.Close(context.Background())
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    week := time.Now().Add(-7 * 24 * time.Hour)
+    for _, id := range []string{"a", "b"} {
+        it := MemoryItem{ID: id, Tenant: ten, Kind: KindShortTerm, Content: "灯塔", Tags: []string{"tag-" + id}, CreatedAt: week}
+        if err := m.Save(ctx, it, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    }
+
+    // 按标签检索 a 后，其时间衰减从最近一次检索起算，排到 b 之前
+    if _, err := m.Query(ctx, QueryRequest{Tenant: ten, Tags: []string{"tag-a"}}); err != nil { t.Fatalf("query: %v", err) }
+    r, _ := m.Query(ctx, QueryRequest{Tenant: ten, Query: "灯塔"})
+    if got := ids(r.Items); got != "a,b" { t.Fatalf("ranked: %s", got) }
+    if r.Items[0].AccessCount != 1 || r.Items[0].LastAccessedAt == nil || r.Items[1].AccessCount != 0 { t.Fatalf("stats: %+v", r.Items) }
+}
+
+func TestAccess_PersistedOutsideLog(t *testing.T) {
+    disk := t.TempDir()
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    m := newTestManager(t, onDisk(disk))
+    for _, id := range []string{"a", "b"} {
+        if err := m.Save(ctx, MemoryItem{ID: id, Tenant: ten, Content: "钟楼 " + id}, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    }
+    for i := 0; i < 3; i++ {
+        if _, err := m.Query(ctx, QueryRequest{Tenant: ten, Query: "钟楼 a", TopK: 1}); err != nil { t.Fatalf("query: %v", err) }
+    }
+    // 统计不写入条目，查询与 Get 结果已计入
+    if it, _ := m.disk.Get(ctx, ten, "a"); it.AccessCount != 0 { t.Fatalf("stats written to item: %+v", it) }
+    r, _ := m.Query(ctx, QueryRequest{Tenant: ten, Query: "钟楼 a", TopK: 1})
+    if r.Items[0].ID != "a" || r.Items[0].AccessCount != 3 { t.Fatalf("overlay: %+v", r.Items) }
+    if a, _ := m.Get(ctx, ten, "a"); a.AccessCount != 4 || a.LastAccessedAt == nil { t.Fatalf("get overlay: %+v", a) }
+    if err := m.flushAccess(); err != nil { t.Fatalf("flush: %v", err) }
+    if err := m.Close(ctx); err != nil { t.Fatalf("close: %v", err) }
+
+    // 读取不追加日志：只有两条 put
+    if n := logLines(t, disk); n != 2 { t.Fatalf("log grew with reads: %d lines", n) }
+
+    // 快照在重启后载入
+    m2 := newTestManager(t, onDisk(disk))
+    defer m2.Close(ctx)
+    a, err := m2.Get(ctx, ten, "a")
+    if err != nil || a.AccessCount != 4 || a.LastAccessedAt == nil { t.Fatalf("a after restart: %+v %v", a, err) }
+    if b, _ := m2.Get(ctx, ten, "b"); b.AccessCount != 0 || b.LastAccessedAt != nil { t.Fatalf("b touched: %+v", b) }
+}
+
+func TestAccess_UpdateAndDelete(t *testing.T) {
+    disk := t.TempDir()
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    m := newTestManager(t, onDisk(disk))
+    for _, id := range []string{"a", "b"} {
+        if err := m.Save(ctx, MemoryItem{ID: id, Tenant: ten, Content: "钟楼旧址 " + id}, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    }
+    if _, err := m.Query(ctx, QueryRequest{Tenant: ten, Query: "钟楼"}); err != nil { t.Fatalf("query: %v", err) }
+
+    // 调用方手中叠加了统计的条目（或改动了统计字段）写回时，统计不重复计数也不被改写
+    it, _ := m.Get(ctx, ten, "a")
+    if it.AccessCount != 1 { t.Fatalf("get: %+v", it) }
+    it.Content = "钟楼新址"
+    if err := m.Update(ctx, it); err != nil { t.Fatalf("update: %v", err) }
+    it, _ = m.Get(ctx, ten, "a")
+    it.AccessCount, it.LastAccessedAt = 0, nil
+    if err := m.Update(ctx, it); err != nil { t.Fatalf("update: %v", err) }
+    if got, _ := m.Get(ctx, ten, "a"); got.AccessCount != 1 || got.LastAccessedAt == nil || got.Content != "钟楼新址" { t.Fatalf("after update: %+v", got) }
+
+    // 删除后统计一并删除，同 ID 重新写入从零开始
+    if err := m.Delete(ctx, ten, "b"); err != nil { t.Fatalf("delete: %v", err) }
+    if err := m.Save(ctx, MemoryItem{ID: "b", Tenant: ten, Content: "新条目"}, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    if b, _ := m.Get(ctx, ten, "b"); b.AccessCount != 0 { t.Fatalf("stats survived delete: %+v", b) }
+    if err := m.Close(ctx); err != nil { t.Fatalf("close: %v", err) }
+
+    m2 := newTestManager(t, onDisk(disk))
+    defer m2.Close(ctx)
+    a, err := m2.Get(ctx, ten, "a")
+    if err != nil || a.Content != "钟楼新址" || a.Version != 2 || a.RevisedAt == nil { t.Fatalf("update reverted: %+v %v", a, err) }
+    if a.AccessCount != 1 || a.LastAccessedAt == nil { t.Fatalf("access lost: %+v", a) }
+}
+
+func TestAccess_DisabledWithoutRanking(t *testing.T) {
+    disk := t.TempDir()
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    m := newTestManager(t, func(o *RAGOptions) { o.DiskJSON.RootPath, o.Ranking.Enable = disk, false })
+    if m.access.stop != nil { t.Fatalf("flusher started without ranking") }
+    _ = m.Save(ctx, MemoryItem{ID: "a", Tenant: ten, Content: "钟楼"}, SaveOptions{})
+    r, err := m.Query(ctx, QueryRequest{Tenant: ten, Query: "钟楼"})
+    if err != nil || len(r.Items) != 1 { t.Fatalf("query: %+v %v", r.Items, err) }
+    if err := m.Close(ctx); err != nil { t.Fatalf("close: %v", err) }
+
+    m2 := newTestManager(t, onDisk(disk))
+    defer m2.Close(ctx)
+    if a, _ := m2.Get(ctx, ten, "a"); a.AccessCount != 0 || a.LastAccessedAt != nil { t.Fatalf("access recorded: %+v", a) }
+}
@@ -2,31 +2,77 @@ package rag
 
 import (
     "context"
+    "errors"
 )
 
+// ErrNotFound 记忆不存在（或已删除）
+var ErrNotFound = errors.New("记忆不存在")
+
 // Store 本地存储抽象
 // 说明：实现需具备多租户隔离能力
 // - Save: 保存/追加记忆
 // - Query: 基于简单文本/标签/类型的过滤检索
+// - Get: 按 ID 读取，不存在时返回 ErrNotFound
+// - Update: 按 item.ID 整体替换，保留原有位置；不存在时返回 ErrNotFound
+// - Delete: 按 ID 删除，不存在时返回 ErrNotFound
 // - Close: 释放资源
 // 注意：打分由上游检索器或外部服务提供，本地存储可不负责评分
 
 type Store interface {
     Save(ctx context.Context, item MemoryItem) error
     Query(ctx context.Context, req QueryRequest) (QueryResult, error)
+    Get(ctx context.Context, t Tenant, id string) (MemoryItem, error)
+    Update(ctx context.Context, item MemoryItem) error
+    Delete(ctx context.Context, t Tenant, id string) error
     Close(ctx context.Context) error
 }
 
-// VectorClient 外部向量检索服务接口（HTTP 对接占位）
+// ArchiveStore 归档（租户）级管理能力，本地存储可选实现
+// - ListArchives: 列出用户下已有的 archive_id
+// - Create: 创建空归档（已存在时为空操作）
+// - Export: 按写入顺序导出归档内全部记忆（含已过期条目）
+// - Purge: 删除归档内全部数据
+type ArchiveStore interface {
+    ListArchives(ctx context.Context, userID string) ([]string, error)
+    Create(ctx context.Context, t Tenant) error
+    Export(ctx context.Context, t Tenant) ([]MemoryItem, error)
+    Purge(ctx context.Context, t Tenant) error
+}
+
+// CacheStore 可作为磁盘缓存的存储（memoryStore 实现）
+// - Warmed: 租户是否已预热
+// - Warm: 以权威数据（按写入顺序）重建租户缓存，按 ID 去重
+// - Complete: 缓存是否持有租户的全部数据（已预热且未因容量淘汰），为 true 时查询可不回落磁盘
+type CacheStore interface {
+    Warmed(t Tenant) bool
+    Warm(t Tenant, items []MemoryItem)
+    Complete(t Tenant) bool
+}
+
+// VersionStore 保留历史版本的存储（三种本地存储均实现）
+// - Update 写入的 Version 大于当前版本时，当前版本转为历史版本保留；否则原地替换
+// - Versions: 按版本从旧到新返回全部版本（含当前版本），不存在时返回 ErrNotFound
+// - Delete/压缩丢弃条目时历史版本一并删除
+type VersionStore interface {
+    Versions(ctx context.Context, t Tenant, id string) ([]MemoryItem, error)
+}
+
+// VectorClient 向量检索服务接口（本地实现见 vector_store.go，HTTP 实现见 http_client.go）
 // - Query: 根据 QueryRequest 进行语义检索，返回打分的 MemoryItem 列表
-// - Save: 可选能力，保存入库（通常需要 embedding），此处仅预留
+// - Save: 保存入库（向量缺失时由 Manager 的 Embedder 或服务端生成）
 
 type VectorClient interface {
     Query(ctx context.Context, req QueryRequest) (QueryResult, error)
     Save(ctx context.Context, item MemoryItem) error
 }
 
-// TripleClient 外部三元组检索/存储接口（HTTP 对接占位）
+// vectorDeleter 向量后端的可选删除能力（本地向量库与 HTTP 客户端实现）
+type vectorDeleter interface {
+    Delete(ctx context.Context, t Tenant, id string) error
+    Purge(ctx context.Context, t Tenant) error
+}
+
+// TripleClient 外部三元组检索/存储接口（HTTP 实现见 http_client.go）
 // - QueryTriples: 基于查询与过滤返回匹配条目
 // - SaveTriples: 保存三元组（此处沿用 MemoryItem，后续可定义专用结构）
 
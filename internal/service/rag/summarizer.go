@@ -0,0 +1,65 @@
+package rag
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/cloudwego/eino/components/model"
+    "github.com/cloudwego/eino/schema"
+)
+
+// ChatSummarizer 基于对话模型的 Summarizer：要求模型以 JSON 返回整理结果
+type ChatSummarizer struct {
+    model model.BaseChatModel
+}
+
+// NewChatSummarizer 创建基于对话模型的摘要器
+func NewChatSummarizer(cm model.BaseChatModel) *ChatSummarizer {
+    return &ChatSummarizer{model: cm}
+}
+
+const summarizerPrompt = `你负责整理小说创作助手的短期记忆。
+阅读用户给出的若干条短期记忆（每条以 [ID] 开头），把其中值得长期保留的信息概括为尽量少的条目：
+- 稳定、可核对的设定（人物关系、身份、地点、规则等）作为 fact，每条只陈述一个事实；
+- 情节进展、人物状态变化等概括为 long_term；
+- 琐碎、重复或已被后续记忆推翻的内容直接舍弃。
+只输出 JSON，不要输出其他内容，格式：
+{"memories":[{"content":"概括内容","kind":"long_term 或 fact","importance":0到1之间的重要性,"tags":["标签"],"sources":["依据的记忆ID"]}]}`
+
+// Summarize 实现 Summarizer
+func (s *ChatSummarizer) Summarize(ctx context.Context, items []MemoryItem) ([]Consolidated, error) {
+    var b strings.Builder
+    for _, it := range items {
+        fmt.Fprintf(&b, "[%s] %s %s", it.ID, it.CreatedAt.Format("2006-01-02 15:04"), it.Content)
+        if len(it.Tags) > 0 {
+            fmt.Fprintf(&b, "（标签：%s）", strings.Join(it.Tags, "、"))
+        }
+        b.WriteByte('\n')
+    }
+    out, err := s.model.Generate(ctx, []*schema.Message{
+        schema.SystemMessage(summarizerPrompt),
+        schema.UserMessage(b.String()),
+    })
+    if err != nil {
+        return nil, fmt.Errorf("调用模型: %w", err)
+    }
+    return parseConsolidated(out.Content)
+}
+
+// parseConsolidated 解析模型输出；容忍代码块与前后说明文字
+func parseConsolidated(content string) ([]Consolidated, error) {
+    lo, hi := strings.Index(content, "{"), strings.LastIndex(content, "}")
+    if lo < 0 || hi < lo {
+        return nil, errors.New("模型输出不是 JSON")
+    }
+    var resp struct {
+        Memories []Consolidated `json:"memories"`
+    }
+    if err := json.Unmarshal([]byte(content[lo:hi+1]), &resp); err != nil {
+        return nil, fmt.Errorf("解析模型输出: %w", err)
+    }
+    return resp.Memories, nil
+}
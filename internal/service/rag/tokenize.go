@@ -0,0 +1,77 @@
+package rag
+
+import (
+    "strings"
+    "unicode"
+)
+
+// 分词：面向中英混排的轻量实现，无需词典
+// - 拉丁字母/数字：按连续片段切分为单词，统一小写
+// - CJK（汉字、假名、谚文音节）：按连续片段切分为重叠二元组（bigram）
+// - 文档侧额外索引 CJK 单字，使单字查询也能命中；查询侧片段长度 >= 2 时只用二元组
+// 例："主角的妹妹" -> 主角 角的 的妹 妹妹
+
+// Tokenize 文档侧分词（二元组 + 单字）
+func Tokenize(text string) []string {
+    return tokenize(text, true)
+}
+
+// TokenizeQuery 查询侧分词（仅在单字片段时使用单字）
+func TokenizeQuery(text string) []string {
+    return tokenize(text, false)
+}
+
+func tokenize(text string, withUnigrams bool) []string {
+    var (
+        tokens []string
+        word   strings.Builder
+        run    []rune
+    )
+    flushWord := func() {
+        if word.Len() > 0 {
+            tokens = append(tokens, word.String())
+            word.Reset()
+        }
+    }
+    flushRun := func() {
+        switch {
+        case len(run) == 0:
+        case len(run) == 1:
+            tokens = append(tokens, string(run))
+        default:
+            for i := 0; i+1 < len(run); i++ {
+                tokens = append(tokens, string(run[i:i+2]))
+            }
+            if withUnigrams {
+                for _, r := range run {
+                    tokens = append(tokens, string(r))
+                }
+            }
+        }
+        run = run[:0]
+    }
+
+    for _, r := range text {
+        switch {
+        case isCJK(r):
+            flushWord()
+            run = append(run, r)
+        case unicode.IsLetter(r) || unicode.IsDigit(r):
+            flushRun()
+            word.WriteRune(unicode.ToLower(r))
+        default:
+            flushWord()
+            flushRun()
+        }
+    }
+    flushWord()
+    flushRun()
+    return tokens
+}
+
+func isCJK(r rune) bool {
+    return unicode.Is(unicode.Han, r) ||
+        unicode.Is(unicode.Hiragana, r) ||
+        unicode.Is(unicode.Katakana, r) ||
+        unicode.Is(unicode.Hangul, r)
+}
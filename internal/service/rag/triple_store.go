@@ -0,0 +1,506 @@
+package rag
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "ahs/internal/tenantpath"
+)
+
+// 本地知识图谱（三元组）存储，实现 TripleClient 与 TripleStore
+// - 三元组：主语 —谓词→ 宾语，例："林夏 —sister_of→ 林秋"、"王城 —located_in→ 北境"
+// - 同一租户内 (主语, 谓词, 宾语) 唯一，重复保存视为更新出处与有效期
+// - 有效期：ValidFrom/ValidTo 为空表示不限；查询默认取当前有效的三元组
+// - 来源：新三元组从 context 读取运行来源写入 Meta（与记忆相同的保留键，见 provenance.go），重复保存不改写来源
+// - 持久化：{RootPath}/{Namespace}/{enc(user_id)}/{enc(archive_id)}/triples.jsonl，
+//   只追加（op=delete 为墓碑），每行记录写入时的格式版本，首次访问租户时加载
+
+// KindTriple 三元组转换为 MemoryItem 时的类型
+const KindTriple MemoryKind = "triple"
+
+// TripleSchemaVersion 当前三元组存储格式版本（TripleOptions.SchemaVersion 为空时使用）
+const TripleSchemaVersion = "1"
+
+// supportedTripleSchemas 可读写的格式版本
+var supportedTripleSchemas = map[string]bool{"1": true}
+
+// Triple 结构化事实
+type Triple struct {
+    ID         string            `json:"id"`
+    Tenant     Tenant            `json:"tenant"`
+    Subject    string            `json:"subject"`
+    Predicate  string            `json:"predicate"`
+    Object     string            `json:"object"`
+    Provenance string            `json:"provenance,omitempty"` // 出处，如章节或原文摘录
+    SourceID   string            `json:"source_id,omitempty"`  // 来源记忆 ID
+    ValidFrom  *time.Time        `json:"valid_from,omitempty"`
+    ValidTo    *time.Time        `json:"valid_to,omitempty"`
+    Meta       map[string]string `json:"meta,omitempty"`       // 运行来源（run_id 等保留键，见 provenance.go）
+    CreatedAt  time.Time         `json:"created_at"`
+    UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// ValidAt 三元组在时刻 at 是否有效
+func (tr Triple) ValidAt(at time.Time) bool {
+    if tr.ValidFrom != nil && at.Before(*tr.ValidFrom) {
+        return false
+    }
+    if tr.ValidTo != nil && !at.Before(*tr.ValidTo) {
+        return false
+    }
+    return true
+}
+
+// String 形如 "林夏 —sister_of→ 林秋"
+func (tr Triple) String() string {
+    return tr.Subject + " —" + tr.Predicate + "→ " + tr.Object
+}
+
+// TriplePattern 模式查询：空字段为通配
+// AsOf 为空时取当前时刻；AllTime 为真时忽略有效期
+type TriplePattern struct {
+    Subject   string
+    Predicate string
+    Object    string
+    AsOf      *time.Time
+    AllTime   bool
+    Limit     int
+}
+
+// TripleStore 本地三元组存储：在 TripleClient 基础上提供结构化读写，Manager.Triples 返回
+type TripleStore interface {
+    TripleClient
+    // SaveTriple 按 (主语, 谓词, 宾语) 新增或更新，返回保存后的三元组
+    SaveTriple(ctx context.Context, tr Triple) (Triple, error)
+    // DeleteTriple 按 ID 删除，不存在时返回 ErrNotFound
+    DeleteTriple(ctx context.Context, t Tenant, id string) error
+    // MatchTriples 模式查询，按 UpdatedAt 从新到旧
+    MatchTriples(ctx context.Context, t Tenant, p TriplePattern) ([]Triple, error)
+    // Neighborhood 以实体为起点展开 hops（1~2）跳邻域，按跳数由近到远
+    Neighborhood(ctx context.Context, t Tenant, entity string, hops int, asOf *time.Time) ([]Triple, error)
+    // Purge 删除租户的全部三元组
+    Purge(ctx context.Context, t Tenant) error
+}
+
+// MaxTripleHops 邻域展开的最大跳数
+const MaxTripleHops = 2
+
+type localTripleStore struct {
+    root          string
+    schemaVersion string
+
+    mu     sync.Mutex
+    spaces map[string]*tripleSpace // 租户文件路径 -> 三元组集合
+}
+
+type tripleSpace struct {
+    triples []Triple
+    pos     map[string]int    // id -> 下标
+    spo     map[string]string // spoKey -> id
+}
+
+// tripleRecord triples.jsonl 中的一行
+type tripleRecord struct {
+    V      string `json:"v"`
+    Op     string `json:"op,omitempty"`
+    Triple Triple `json:"triple"`
+}
+
+// NewLocalTripleStore 创建本地三元组存储；root 为持久化根目录（含命名空间）
+func NewLocalTripleStore(root, schemaVersion string) (TripleStore, error) {
+    if root == "" {
+        return nil, errors.New("Triple.RootPath 不能为空")
+    }
+    if schemaVersion == "" {
+        schemaVersion = TripleSchemaVersion
+    }
+    if !supportedTripleSchemas[schemaVersion] {
+        return nil, fmt.Errorf("不支持的三元组格式版本: %s", schemaVersion)
+    }
+    return &localTripleStore{
+        root:          root,
+        schemaVersion: schemaVersion,
+        spaces:        make(map[string]*tripleSpace),
+    }, nil
+}
+
+func spoKey(s, p, o string) string { return s + "\x00" + p + "\x00" + o }
+
+func (s *localTripleStore) pathOf(t Tenant) string {
+    return filepath.Join(tenantpath.Dir(s.root, t.UserID, t.ArchiveID), "triples.jsonl")
+}
+
+// space 返回租户三元组集合，首次访问时从磁盘加载（调用方持有锁）
+func (s *localTripleStore) space(t Tenant) (*tripleSpace, error) {
+    fp := s.pathOf(t)
+    if sp, ok := s.spaces[fp]; ok {
+        return sp, nil
+    }
+    sp := &tripleSpace{pos: make(map[string]int), spo: make(map[string]string)}
+    f, err := os.Open(fp)
+    if err != nil && !os.IsNotExist(err) {
+        return nil, fmt.Errorf("open file: %w", err)
+    }
+    if err == nil {
+        defer f.Close()
+        sc := bufio.NewScanner(f)
+        sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+        for sc.Scan() {
+            var rec tripleRecord
+            if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+                continue
+            }
+            if rec.V != "" && !supportedTripleSchemas[rec.V] {
+                return nil, fmt.Errorf("三元组数据格式版本 %s 不受支持", rec.V)
+            }
+            if rec.Op == opDelete {
+                sp.remove(rec.Triple.ID)
+            } else {
+                sp.put(rec.Triple)
+            }
+        }
+        if err := sc.Err(); err != nil {
+            return nil, fmt.Errorf("scan jsonl: %w", err)
+        }
+    }
+    s.spaces[fp] = sp
+    return sp, nil
+}
+
+func (sp *tripleSpace) put(tr Triple) {
+    if i, ok := sp.pos[tr.ID]; ok {
+        old := sp.triples[i]
+        delete(sp.spo, spoKey(old.Subject, old.Predicate, old.Object))
+        sp.triples[i] = tr
+    } else {
+        sp.pos[tr.ID] = len(sp.triples)
+        sp.triples = append(sp.triples, tr)
+    }
+    sp.spo[spoKey(tr.Subject, tr.Predicate, tr.Object)] = tr.ID
+}
+
+func (sp *tripleSpace) remove(id string) bool {
+    i, ok := sp.pos[id]
+    if !ok {
+        return false
+    }
+    old := sp.triples[i]
+    delete(sp.spo, spoKey(old.Subject, old.Predicate, old.Object))
+    last := len(sp.triples) - 1
+    if i != last {
+        sp.triples[i] = sp.triples[last]
+        sp.pos[sp.triples[i].ID] = i
+    }
+    sp.triples = sp.triples[:last]
+    delete(sp.pos, id)
+    return true
+}
+
+func (s *localTripleStore) appendRecord(t Tenant, rec tripleRecord) error {
+    fp := s.pathOf(t)
+    if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+        return fmt.Errorf("ensure dir: %w", err)
+    }
+    f, err := os.OpenFile(fp, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+    if err != nil {
+        return fmt.Errorf("open file: %w", err)
+    }
+    defer f.Close()
+    rec.V = s.schemaVersion
+    if err := json.NewEncoder(f).Encode(&rec); err != nil {
+        return fmt.Errorf("encode json: %w", err)
+    }
+    return nil
+}
+
+func (s *localTripleStore) SaveTriple(ctx context.Context, tr Triple) (Triple, error) {
+    if tr.Tenant.UserID == "" || tr.Tenant.ArchiveID == "" {
+        return Triple{}, errors.New("tenant(user_id, archive_id) 不能为空")
+    }
+    tr.Subject = strings.TrimSpace(tr.Subject)
+    tr.Predicate = strings.TrimSpace(tr.Predicate)
+    tr.Object = strings.TrimSpace(tr.Object)
+    if tr.Subject == "" || tr.Predicate == "" || tr.Object == "" {
+        return Triple{}, errors.New("subject、predicate、object 不能为空")
+    }
+    if tr.ValidFrom != nil && tr.ValidTo != nil && !tr.ValidTo.After(*tr.ValidFrom) {
+        return Triple{}, errors.New("valid_to 必须晚于 valid_from")
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    sp, err := s.space(tr.Tenant)
+    if err != nil {
+        return Triple{}, err
+    }
+    now := time.Now()
+    if id, ok := sp.spo[spoKey(tr.Subject, tr.Predicate, tr.Object)]; ok {
+        // 已存在：保留 ID、创建时间与来源，更新出处与有效期
+        old := sp.triples[sp.pos[id]]
+        tr.ID = id
+        tr.CreatedAt = old.CreatedAt
+        tr.Meta = old.Meta
+    } else {
+        // 新三元组：ID 缺失或已被其他三元组占用时重新分配
+        if _, used := sp.pos[tr.ID]; used || tr.ID == "" {
+            tr.ID = NewID()
+        }
+        tr.Meta = stampTripleProvenance(ctx, tr.Meta)
+    }
+    if tr.CreatedAt.IsZero() {
+        tr.CreatedAt = now
+    }
+    tr.UpdatedAt = now
+    if err := s.appendRecord(tr.Tenant, tripleRecord{Triple: tr}); err != nil {
+        return Triple{}, err
+    }
+    sp.put(tr)
+    return tr, nil
+}
+
+func (s *localTripleStore) DeleteTriple(ctx context.Context, t Tenant, id string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    sp, err := s.space(t)
+    if err != nil {
+        return err
+    }
+    if _, ok := sp.pos[id]; !ok {
+        return ErrNotFound
+    }
+    if err := s.appendRecord(t, tripleRecord{Op: opDelete, Triple: Triple{ID: id, Tenant: t}}); err != nil {
+        return err
+    }
+    sp.remove(id)
+    return nil
+}
+
+func (s *localTripleStore) MatchTriples(ctx context.Context, t Tenant, p TriplePattern) ([]Triple, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    sp, err := s.space(t)
+    if err != nil {
+        return nil, err
+    }
+    at := asOfTime(p.AsOf)
+    res := make([]Triple, 0)
+    for _, tr := range sp.triples {
+        if p.Subject != "" && tr.Subject != p.Subject { continue }
+        if p.Predicate != "" && tr.Predicate != p.Predicate { continue }
+        if p.Object != "" && tr.Object != p.Object { continue }
+        if !p.AllTime && !tr.ValidAt(at) { continue }
+        res = append(res, tr)
+    }
+    sortTriples(res)
+    if p.Limit > 0 && len(res) > p.Limit {
+        res = res[:p.Limit]
+    }
+    return res, nil
+}
+
+func (s *localTripleStore) Neighborhood(ctx context.Context, t Tenant, entity string, hops int, asOf *time.Time) ([]Triple, error) {
+    entity = strings.TrimSpace(entity)
+    if entity == "" {
+        return nil, errors.New("entity 不能为空")
+    }
+    if hops <= 0 {
+        hops = 1
+    }
+    if hops > MaxTripleHops {
+        hops = MaxTripleHops
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    sp, err := s.space(t)
+    if err != nil {
+        return nil, err
+    }
+    at := asOfTime(asOf)
+    var (
+        res      []Triple
+        seen     = make(map[string]bool)
+        visited  = map[string]bool{entity: true}
+        frontier = map[string]bool{entity: true}
+    )
+    for hop := 0; hop < hops && len(frontier) > 0; hop++ {
+        var layer []Triple
+        next := make(map[string]bool)
+        for _, tr := range sp.triples {
+            if seen[tr.ID] || !tr.ValidAt(at) {
+                continue
+            }
+            if !frontier[tr.Subject] && !frontier[tr.Object] {
+                continue
+            }
+            seen[tr.ID] = true
+            layer = append(layer, tr)
+            for _, e := range []string{tr.Subject, tr.Object} {
+                if !visited[e] {
+                    visited[e] = true
+                    next[e] = true
+                }
+            }
+        }
+        sortTriples(layer)
+        res = append(res, layer...)
+        frontier = next
+    }
+    return res, nil
+}
+
+func (s *localTripleStore) Purge(ctx context.Context, t Tenant) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    fp := s.pathOf(t)
+    delete(s.spaces, fp)
+    if err := os.Remove(fp); err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("remove triples: %w", err)
+    }
+    return nil
+}
+
+// SaveTriples 实现 TripleClient：保存 Meta 中带有 subject/predicate/object 的条目
+// 本地不做抽取，缺少结构化字段的条目被忽略
+func (s *localTripleStore) SaveTriples(ctx context.Context, items []MemoryItem) error {
+    for _, it := range items {
+        tr, ok := TripleFromItem(it)
+        if !ok {
+            continue
+        }
+        if _, err := s.SaveTriple(ctx, tr); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// QueryTriples 实现 TripleClient：返回查询文本中提及的实体的一跳邻域
+// 得分为三元组两端被提及的实体数；无查询文本时不返回结果，避免纯过滤查询混入整张图
+func (s *localTripleStore) QueryTriples(ctx context.Context, req QueryRequest) (QueryResult, error) {
+    if strings.TrimSpace(req.Query) == "" {
+        return QueryResult{}, nil
+    }
+    if len(req.Kinds) > 0 {
+        ok := false
+        for _, k := range req.Kinds {
+            if k == KindTriple { ok = true; break }
+        }
+        if !ok {
+            return QueryResult{}, nil
+        }
+    }
+    if len(req.Tags) > 0 {
+        return QueryResult{}, nil
+    }
+    all, err := s.MatchTriples(ctx, req.Tenant, TriplePattern{})
+    if err != nil {
+        return QueryResult{}, err
+    }
+
+    res := make([]MemoryItem, 0)
+    for _, tr := range all {
+        score := 0.0
+        if strings.Contains(req.Query, tr.Subject) { score++ }
+        if strings.Contains(req.Query, tr.Object) { score++ }
+        if score == 0 {
+            continue
+        }
+        it := tr.ToMemoryItem()
+        it.Score = score
+        res = append(res, it)
+    }
+    sort.SliceStable(res, func(i, j int) bool { return res[i].Score > res[j].Score })
+    topK := req.TopK
+    if topK <= 0 {
+        topK = 10
+    }
+    if len(res) > topK {
+        res = res[:topK]
+    }
+    return QueryResult{Items: res}, nil
+}
+
+// ToMemoryItem 将三元组转换为检索结果条目（Meta 保留结构化字段）
+func (tr Triple) ToMemoryItem() MemoryItem {
+    meta := map[string]any{
+        "subject":   tr.Subject,
+        "predicate": tr.Predicate,
+        "object":    tr.Object,
+    }
+    if tr.Provenance != "" { meta["provenance"] = tr.Provenance }
+    if tr.SourceID != "" { meta["source_id"] = tr.SourceID }
+    if tr.ValidFrom != nil { meta["valid_from"] = tr.ValidFrom.Format(time.RFC3339) }
+    if tr.ValidTo != nil { meta["valid_to"] = tr.ValidTo.Format(time.RFC3339) }
+    for k, v := range tr.Meta { meta[k] = v }
+    return MemoryItem{
+        ID:        tr.ID,
+        Tenant:    tr.Tenant,
+        Content:   tr.String(),
+        Kind:      KindTriple,
+        CreatedAt: tr.CreatedAt,
+        Meta:      meta,
+    }
+}
+
+// TripleFromItem 从 Meta 的 subject/predicate/object（及可选 provenance、source_id、运行来源）还原三元组
+// Kind 为 KindTriple 时沿用条目 ID；否则视为普通记忆，条目 ID 作为来源
+func TripleFromItem(it MemoryItem) (Triple, bool) {
+    str := func(k string) string {
+        v, _ := it.Meta[k].(string)
+        return v
+    }
+    tr := Triple{
+        ID:         it.ID,
+        Tenant:     it.Tenant,
+        Subject:    str("subject"),
+        Predicate:  str("predicate"),
+        Object:     str("object"),
+        Provenance: str("provenance"),
+        SourceID:   str("source_id"),
+        CreatedAt:  it.CreatedAt,
+    }
+    if tr.Subject == "" || tr.Predicate == "" || tr.Object == "" {
+        return Triple{}, false
+    }
+    for k := range it.Meta {
+        if v := str(k); v != "" && isProvenanceKey(k) {
+            if tr.Meta == nil { tr.Meta = make(map[string]string) }
+            tr.Meta[k] = v
+        }
+    }
+    if it.Kind != KindTriple {
+        if tr.SourceID == "" {
+            tr.SourceID = it.ID
+        }
+        tr.ID = ""
+    }
+    return tr, true
+}
+
+// Triples 返回本地三元组存储；未启用或使用外部服务时返回 false
+func (m *Manager) Triples() (TripleStore, bool) {
+    ts, ok := m.tri.(TripleStore)
+    return ts, ok
+}
+
+func asOfTime(p *time.Time) time.Time {
+    if p != nil {
+        return *p
+    }
+    return time.Now()
+}
+
+// sortTriples 按 UpdatedAt 从新到旧
+func sortTriples(ts []Triple) {
+    sort.SliceStable(ts, func(i, j int) bool { return ts[i].UpdatedAt.After(ts[j].UpdatedAt) })
+}
@@ -0,0 +1,115 @@
+package rag
+
+import (
+    "context"
+    "errors"
+    "os"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestLocalTripleStore_PatternNeighborhoodValidity(t *testing.T) {
+    root := t.TempDir()
+    ts, err := NewLocalTripleStore(root, "")
+    if err != nil { t.Fatalf("new triple store: %v", err) }
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+
+    past := time.Now().Add(-time.Hour)
+    facts := []Triple{
+        {Subject: "林夏", Predicate: "sister_of", Object: "林秋", Provenance: "第1章"},
+        {Subject: "林秋", Predicate: "lives_in", Object: "王城"},
+        {Subject: "王城", Predicate: "located_in", Object: "北境"},
+        {Subject: "林夏", Predicate: "lives_in", Object: "南港", ValidTo: &past},
+    }
+    for _, f := range facts {
+        f.Tenant = ten
+        if _, err := ts.SaveTriple(ctx, f); err != nil { t.Fatalf("save: %v", err) }
+    }
+
+    // 重复保存同一三元组：ID 不变，出处更新
+    first, _ := ts.MatchTriples(ctx, ten, TriplePattern{Subject: "林夏", Predicate: "sister_of"})
+    again, err := ts.SaveTriple(ctx, Triple{Tenant: ten, Subject: "林夏", Predicate: "sister_of", Object: "林秋", Provenance: "第3章"})
+    if err != nil || again.ID != first[0].ID || again.Provenance != "第3章" { t.Fatalf("upsert: %+v %v", again, err) }
+
+    // 模式查询默认排除已失效的三元组
+    got, _ := ts.MatchTriples(ctx, ten, TriplePattern{Predicate: "lives_in"})
+    if len(got) != 1 || got[0].Object != "王城" { t.Fatalf("pattern: %+v", got) }
+    got, _ = ts.MatchTriples(ctx, ten, TriplePattern{Predicate: "lives_in", AllTime: true})
+    if len(got) != 2 { t.Fatalf("all time: %+v", got) }
+    old := past.Add(-time.Minute)
+    got, _ = ts.MatchTriples(ctx, ten, TriplePattern{Subject: "林夏", Predicate: "lives_in", AsOf: &old})
+    if len(got) != 1 || got[0].Object != "南港" { t.Fatalf("as of: %+v", got) }
+
+    // 邻域：1 跳到林秋，2 跳到王城
+    n1, _ := ts.Neighborhood(ctx, ten, "林夏", 1, nil)
+    if len(n1) != 1 || n1[0].Object != "林秋" { t.Fatalf("1 hop: %+v", n1) }
+    n2, _ := ts.Neighborhood(ctx, ten, "林夏", 2, nil)
+    if len(n2) != 2 || n2[1].Object != "王城" { t.Fatalf("2 hop: %+v", n2) }
+    n3, _ := ts.Neighborhood(ctx, ten, "林夏", 5, nil)
+    if len(n3) != 2 { t.Fatalf("hops should be capped at %d: %+v", MaxTripleHops, n3) }
+
+    // 文本查询：提及实体的一跳邻域
+    qr, _ := ts.QueryTriples(ctx, QueryRequest{Tenant: ten, Query: "王城在哪里"})
+    if len(qr.Items) != 2 || qr.Items[0].Kind != KindTriple { t.Fatalf("query: %+v", qr.Items) }
+    // 无查询文本（仅按过滤条件查询）时不返回三元组
+    qr, _ = ts.QueryTriples(ctx, QueryRequest{Tenant: ten})
+    if len(qr.Items) != 0 { t.Fatalf("empty query should return nothing: %+v", qr.Items) }
+
+    // 删除与重新加载
+    if err := ts.DeleteTriple(ctx, ten, first[0].ID); err != nil { t.Fatalf("delete: %v", err) }
+    if err := ts.DeleteTriple(ctx, ten, first[0].ID); !errors.Is(err, ErrNotFound) { t.Fatalf("expect ErrNotFound, got %v", err) }
+    ts2, _ := NewLocalTripleStore(root, TripleSchemaVersion)
+    got, _ = ts2.MatchTriples(ctx, ten, TriplePattern{AllTime: true})
+    if len(got) != 3 { t.Fatalf("reload: %+v", got) }
+    got, _ = ts2.MatchTriples(ctx, Tenant{UserID: "u", ArchiveID: "b"}, TriplePattern{})
+    if len(got) != 0 { t.Fatalf("tenant leak: %+v", got) }
+}
+
+func TestLocalTripleStore_SchemaVersion(t *testing.T) {
+    if _, err := NewLocalTripleStore(t.TempDir(), "99"); err == nil { t.Fatalf("expect unsupported schema error") }
+
+    root := t.TempDir()
+    ts, _ := NewLocalTripleStore(root, "")
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    if _, err := ts.SaveTriple(ctx, Triple{Tenant: ten, Subject: "a", Predicate: "p", Object: "b"}); err != nil { t.Fatalf("save: %v", err) }
+
+    // 写入的记录携带格式版本；更高版本的数据拒绝读取
+    fp := ts.(*localTripleStore).pathOf(ten)
+    data, _ := os.ReadFile(fp)
+    if !strings.Contains(string(data), `"v":"1"`) { t.Fatalf("schema version not recorded: %s", data) }
+    f, _ := os.OpenFile(fp, os.O_APPEND|os.O_WRONLY, 0o644)
+    _, _ = f.WriteString(`{"v":"2","triple":{"id":"x","subject":"a","predicate":"p","object":"c"}}` + "\n")
+    _ = f.Close()
+    ts2, _ := NewLocalTripleStore(root, "")
+    if _, err := ts2.MatchTriples(ctx, ten, TriplePattern{}); err == nil { t.Fatalf("expect newer schema rejected") }
+}
+
+func TestManager_LocalTriplesFromOptions(t *testing.T) {
+    if DefaultOptions().Triple.Enable { t.Fatalf("triple store should be disabled by default") }
+    m := newTestManager(t, func(o *RAGOptions) { o.DiskJSON.Enable, o.Triple.Enable = false, true })
+
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    // ToTriple：带结构化字段的记忆写入三元组库，来源为记忆 ID
+    item := MemoryItem{ID: NewID(), Tenant: ten, Content: "林夏是林秋的妹妹",
+        Meta: map[string]any{"subject": "林夏", "predicate": "sister_of", "object": "林秋"}}
+    if err := m.Save(ctx, item, SaveOptions{ToMemory: true, ToTriple: true}); err != nil { t.Fatalf("save: %v", err) }
+    ts, ok := m.Triples()
+    if !ok { t.Fatalf("local triple store expected") }
+    got, _ := ts.MatchTriples(ctx, ten, TriplePattern{Subject: "林夏"})
+    if len(got) != 1 || got[0].SourceID != item.ID { t.Fatalf("triple from item: %+v", got) }
+
+    // UseTriple：与本地结果融合并标注来源
+    r, _ := m.Query(ctx, QueryRequest{Tenant: ten, Query: "林夏", UseTriple: true})
+    srcs := map[string]bool{}
+    for _, it := range r.Items { for _, s := range it.Sources { srcs[s] = true } }
+    if !srcs[SourceMemory] || !srcs[SourceTriple] { t.Fatalf("expect memory and triple sources: %+v", r.Items) }
+
+    // 清除归档同时清除三元组
+    if err := m.PurgeArchive(ctx, ten); err != nil { t.Fatalf("purge: %v", err) }
+    got, _ = ts.MatchTriples(ctx, ten, TriplePattern{AllTime: true})
+    if len(got) != 0 { t.Fatalf("triples not purged: %+v", got) }
+}
@@ -30,29 +30,68 @@ type MemoryItem struct {
     ExpiresAt *time.Time             `json:"expires_at,omitempty"`
     Meta      map[string]any         `json:"meta,omitempty"`
     Score     float64                `json:"score,omitempty"`
+    // Importance 重要性 [0,1]，0 表示未设置（排序时按 Ranking.DefaultImportance）
+    Importance float64               `json:"importance,omitempty"`
+    // AccessCount/LastAccessedAt 检索命中统计，由 Manager.Query 维护（见 ranking.go）
+    AccessCount    int               `json:"access_count,omitempty"`
+    LastAccessedAt *time.Time        `json:"last_accessed_at,omitempty"`
+    // HitCount/UpdatedAt 近重复保存被合并进本条目的次数与最近一次合并时间（见 dedupe.go）
+    HitCount  int                    `json:"hit_count,omitempty"`
+    UpdatedAt *time.Time             `json:"updated_at,omitempty"`
+    // Version/RevisedAt 版本号（从 1 开始，0 视为 1）与本版本的生效时间；Update 修改正文时产生新版本（见 version.go）
+    Version   int                    `json:"version,omitempty"`
+    RevisedAt *time.Time             `json:"revised_at,omitempty"`
+    // Vector 写入向量后端时携带的向量（可选，缺失时由 Embedder 生成）；本地存储不持久化
+    Vector    []float32              `json:"vector,omitempty"`
+    // Sources 检索结果的来源后端（memory/disk/vector/triple），仅出现在 Query 结果中
+    Sources   []string               `json:"sources,omitempty"`
 }
 
 // QueryRequest 记忆检索请求
 // Query: 文本查询（可为空，表示仅按标签/类型过滤）
 // TopK: 期望返回条数，<=0 使用默认值
-// Tags/Kinds: 过滤条件
+// Tags/Kinds: 过滤条件；TagMode/ExcludeTags、CreatedAfter/CreatedBefore、Meta 见 query.go
+// Sort/Cursor: 排序方式与分页游标（上一页的 QueryResult.NextCursor）
+// AsOf/AsOfChapter: 按历史版本检索（见 version.go）
 // UseVector/UseTriple: 是否启用外部高级检索（由 Manager 决策）
+// Vector: 查询向量（可选，缺失时由 Embedder 根据 Query 生成）
 type QueryRequest struct {
-    Tenant    Tenant       `json:"tenant"`
-    Query     string       `json:"query,omitempty"`
-    TopK      int          `json:"top_k,omitempty"`
-    Tags      []string     `json:"tags,omitempty"`
-    Kinds     []MemoryKind `json:"kinds,omitempty"`
+    Tenant        Tenant       `json:"tenant"`
+    Query         string       `json:"query,omitempty"`
+    TopK          int          `json:"top_k,omitempty"`
+    Tags          []string     `json:"tags,omitempty"`
+    TagMode       string       `json:"tag_mode,omitempty"`     // all（默认）| any
+    ExcludeTags   []string     `json:"exclude_tags,omitempty"` // 排除包含任一标签的条目
+    Kinds         []MemoryKind `json:"kinds,omitempty"`
+    CreatedAfter  *time.Time   `json:"created_after,omitempty"`  // 含
+    CreatedBefore *time.Time   `json:"created_before,omitempty"` // 不含
+    Meta          []MetaFilter `json:"meta,omitempty"`
+    Sort          string       `json:"sort,omitempty"` // recency | score，缺省时有文本查询为 score
+    Cursor        string       `json:"cursor,omitempty"`
+    UseVector     bool         `json:"use_vector,omitempty"`
+    UseTriple     bool         `json:"use_triple,omitempty"`
+    Vector        []float32    `json:"vector,omitempty"`
+    AsOf          *time.Time   `json:"as_of,omitempty"`         // 返回该时刻有效的版本
+    AsOfChapter   int          `json:"as_of_chapter,omitempty"` // 返回该章节有效的版本（Meta.chapter），<=0 不限
+}
+
+// MetaFilter MemoryItem.Meta 上的谓词
+type MetaFilter struct {
+    Key    string `json:"key"`
+    Op     string `json:"op,omitempty"`     // eq（默认）| in | exists
+    Value  any    `json:"value,omitempty"`  // eq 的比较值；exists 时为 false 表示要求不存在
+    Values []any  `json:"values,omitempty"` // in 的候选值
 }
 
 type QueryResult struct {
-    Items []MemoryItem `json:"items"`
+    Items      []MemoryItem `json:"items"`
+    NextCursor string       `json:"next_cursor,omitempty"` // 为空表示没有更多结果
 }
 
 // SaveOptions 保存策略开关（由 Manager 解释并路由到底层后端）
 type SaveOptions struct {
     ToMemory bool
     ToDisk   bool
-    ToVector bool // 预留，向量后端
-    ToTriple bool // 预留，三元组后端
+    ToVector bool // 向量后端（写入前按需生成向量）
+    ToTriple bool // 三元组后端
 }
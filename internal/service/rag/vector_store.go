@@ -0,0 +1,253 @@
+package rag
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+
+    "ahs/internal/tenantpath"
+)
+
+// localVectorStore 进程内向量库，实现 VectorClient
+// - 每租户一个命名空间，扁平余弦检索（向量已归一化，相似度即点积）
+// - 持久化：{RootPath}/{Namespace}/{enc(user_id)}/{enc(archive_id)}/vectors.jsonl，
+//   只追加（同 ID 重复写入视为更新，op=delete 为墓碑），首次访问租户时加载
+// - 向量缺失时使用 Embedder 生成
+type localVectorStore struct {
+    root     string
+    embedder Embedder
+
+    mu     sync.Mutex
+    spaces map[string]*vectorSpace // 租户文件路径 -> 命名空间
+}
+
+type vectorSpace struct {
+    items []MemoryItem
+    vecs  [][]float32
+    pos   map[string]int // id -> 下标
+}
+
+// vectorRecord vectors.jsonl 中的一行
+type vectorRecord struct {
+    Item   MemoryItem `json:"item"`
+    Vector []float32  `json:"vector,omitempty"`
+    Op     string     `json:"op,omitempty"`
+}
+
+// NewLocalVectorStore 创建本地向量库；root 为持久化根目录（含命名空间）
+func NewLocalVectorStore(root string, embedder Embedder) (VectorClient, error) {
+    if root == "" {
+        return nil, errors.New("Vector.RootPath 不能为空")
+    }
+    if embedder == nil {
+        return nil, errors.New("embedder 不能为空")
+    }
+    return &localVectorStore{
+        root:     root,
+        embedder: embedder,
+        spaces:   make(map[string]*vectorSpace),
+    }, nil
+}
+
+func (s *localVectorStore) pathOf(t Tenant) string {
+    return filepath.Join(tenantpath.Dir(s.root, t.UserID, t.ArchiveID), "vectors.jsonl")
+}
+
+// space 返回租户命名空间，首次访问时从磁盘加载（调用方持有锁）
+func (s *localVectorStore) space(t Tenant) (*vectorSpace, error) {
+    fp := s.pathOf(t)
+    if sp, ok := s.spaces[fp]; ok {
+        return sp, nil
+    }
+    sp := &vectorSpace{pos: make(map[string]int)}
+    f, err := os.Open(fp)
+    if err != nil && !os.IsNotExist(err) {
+        return nil, fmt.Errorf("open file: %w", err)
+    }
+    if err == nil {
+        defer f.Close()
+        sc := bufio.NewScanner(f)
+        sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+        for sc.Scan() {
+            var rec vectorRecord
+            if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+                continue
+            }
+            if rec.Op == opDelete {
+                sp.remove(rec.Item.ID)
+            } else {
+                sp.put(rec.Item, rec.Vector)
+            }
+        }
+        if err := sc.Err(); err != nil {
+            return nil, fmt.Errorf("scan jsonl: %w", err)
+        }
+    }
+    s.spaces[fp] = sp
+    return sp, nil
+}
+
+func (sp *vectorSpace) put(it MemoryItem, vec []float32) {
+    if i, ok := sp.pos[it.ID]; ok && it.ID != "" {
+        sp.items[i], sp.vecs[i] = it, vec
+        return
+    }
+    if it.ID != "" {
+        sp.pos[it.ID] = len(sp.items)
+    }
+    sp.items = append(sp.items, it)
+    sp.vecs = append(sp.vecs, vec)
+}
+
+func (sp *vectorSpace) remove(id string) bool {
+    i, ok := sp.pos[id]
+    if !ok {
+        return false
+    }
+    last := len(sp.items) - 1
+    if i != last {
+        sp.items[i], sp.vecs[i] = sp.items[last], sp.vecs[last]
+        if moved := sp.items[i].ID; moved != "" {
+            sp.pos[moved] = i
+        }
+    }
+    sp.items, sp.vecs = sp.items[:last], sp.vecs[:last]
+    delete(sp.pos, id)
+    return true
+}
+
+func (s *localVectorStore) appendRecord(t Tenant, rec vectorRecord) error {
+    fp := s.pathOf(t)
+    if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+        return fmt.Errorf("ensure dir: %w", err)
+    }
+    f, err := os.OpenFile(fp, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+    if err != nil {
+        return fmt.Errorf("open file: %w", err)
+    }
+    defer f.Close()
+    if err := json.NewEncoder(f).Encode(&rec); err != nil {
+        return fmt.Errorf("encode json: %w", err)
+    }
+    return nil
+}
+
+// vectorOf 返回条目向量：已携带则校验维度，否则生成
+func (s *localVectorStore) vectorOf(ctx context.Context, vec []float32, text string) ([]float32, error) {
+    if len(vec) == 0 {
+        vs, err := s.embedder.Embed(ctx, []string{text})
+        if err != nil {
+            return nil, err
+        }
+        vec = vs[0]
+    }
+    if len(vec) != s.embedder.Dim() {
+        return nil, fmt.Errorf("向量维度不匹配: 期望 %d，实际 %d", s.embedder.Dim(), len(vec))
+    }
+    return vec, nil
+}
+
+func (s *localVectorStore) Save(ctx context.Context, item MemoryItem) error {
+    if item.ID == "" {
+        return errors.New("id 不能为空")
+    }
+    vec, err := s.vectorOf(ctx, item.Vector, item.Content)
+    if err != nil {
+        return err
+    }
+    item.Vector = nil
+    item.Score = 0
+    item.Sources = nil
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    sp, err := s.space(item.Tenant)
+    if err != nil {
+        return err
+    }
+    if err := s.appendRecord(item.Tenant, vectorRecord{Item: item, Vector: vec}); err != nil {
+        return err
+    }
+    sp.put(item, vec)
+    return nil
+}
+
+func (s *localVectorStore) Query(ctx context.Context, req QueryRequest) (QueryResult, error) {
+    if req.Query == "" && len(req.Vector) == 0 {
+        return QueryResult{}, nil
+    }
+    qv, err := s.vectorOf(ctx, req.Vector, req.Query)
+    if err != nil {
+        return QueryResult{}, err
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    sp, err := s.space(req.Tenant)
+    if err != nil {
+        return QueryResult{}, err
+    }
+
+    now := time.Now()
+    res := make([]MemoryItem, 0)
+    for i, it := range sp.items {
+        if !matchItem(it, req, now) {
+            continue
+        }
+        var dot float32
+        for j, x := range sp.vecs[i] {
+            dot += x * qv[j]
+        }
+        if dot <= 0 {
+            continue
+        }
+        it.Score = float64(dot)
+        res = append(res, it)
+    }
+    sort.SliceStable(res, func(i, j int) bool { return res[i].Score > res[j].Score })
+    topK := req.TopK
+    if topK <= 0 {
+        topK = 10
+    }
+    if len(res) > topK {
+        res = res[:topK]
+    }
+    return QueryResult{Items: res}, nil
+}
+
+// Delete 删除向量，不存在时返回 ErrNotFound
+func (s *localVectorStore) Delete(ctx context.Context, t Tenant, id string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    sp, err := s.space(t)
+    if err != nil {
+        return err
+    }
+    if _, ok := sp.pos[id]; !ok {
+        return ErrNotFound
+    }
+    if err := s.appendRecord(t, vectorRecord{Item: MemoryItem{ID: id, Tenant: t}, Op: opDelete}); err != nil {
+        return err
+    }
+    sp.remove(id)
+    return nil
+}
+
+// Purge 删除租户的全部向量
+func (s *localVectorStore) Purge(ctx context.Context, t Tenant) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    fp := s.pathOf(t)
+    delete(s.spaces, fp)
+    if err := os.Remove(fp); err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("remove vectors: %w", err)
+    }
+    return nil
+}
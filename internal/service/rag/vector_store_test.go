@@ -0,0 +1,69 @@
+package rag
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestLocalVectorStore_QueryPersistDelete(t *testing.T) {
+    root := t.TempDir()
+    emb := NewHashingEmbedder(128)
+    vc, err := NewLocalVectorStore(root, emb)
+    if err != nil { t.Fatalf("new vector store: %v", err) }
+
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    other := Tenant{UserID: "u", ArchiveID: "b"}
+    docs := []MemoryItem{
+        {ID: "1", Tenant: ten, Content: "主角的妹妹喜欢画画", Kind: KindFact},
+        {ID: "2", Tenant: ten, Content: "北方的城堡里住着反派", Kind: KindFact},
+        {ID: "3", Tenant: other, Content: "主角的妹妹喜欢画画", Kind: KindFact},
+    }
+    for _, d := range docs {
+        d.CreatedAt = time.Now()
+        if err := vc.Save(ctx, d); err != nil { t.Fatalf("save: %v", err) }
+    }
+
+    qr, err := vc.Query(ctx, QueryRequest{Tenant: ten, Query: "妹妹画画", TopK: 5})
+    if err != nil { t.Fatalf("query: %v", err) }
+    if len(qr.Items) == 0 || qr.Items[0].ID != "1" || qr.Items[0].Score <= 0 { t.Fatalf("unexpected result: %+v", qr.Items) }
+    for _, it := range qr.Items {
+        if it.Tenant != ten { t.Fatalf("tenant leak: %+v", it) }
+    }
+
+    // 重新打开后从磁盘恢复；删除写墓碑
+    if err := vc.(vectorDeleter).Delete(ctx, ten, "1"); err != nil { t.Fatalf("delete: %v", err) }
+    vc2, _ := NewLocalVectorStore(root, emb)
+    qr, _ = vc2.Query(ctx, QueryRequest{Tenant: ten, Query: "妹妹画画", TopK: 5})
+    for _, it := range qr.Items {
+        if it.ID == "1" { t.Fatalf("deleted vector resurrected") }
+    }
+    qr, _ = vc2.Query(ctx, QueryRequest{Tenant: other, Query: "妹妹画画", TopK: 5})
+    if len(qr.Items) != 1 || qr.Items[0].ID != "3" { t.Fatalf("reload failed: %+v", qr.Items) }
+
+    // 维度不一致的向量被拒绝
+    if err := vc.Save(ctx, MemoryItem{ID: "x", Tenant: ten, Vector: []float32{1, 2}}); err == nil { t.Fatalf("expect dim error") }
+}
+
+func TestManager_SaveToVector_EmbedsAndQueries(t *testing.T) {
+    m := newTestManager(t, func(o *RAGOptions) { o.Vector.Enable = true })
+
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    if err := m.Save(ctx, MemoryItem{Tenant: ten, Content: "妹妹在雨夜离家出走"}, SaveOptions{ToDisk: true, ToVector: true}); err != nil { t.Fatalf("save: %v", err) }
+
+    // 本地存储不持久化向量
+    all, _ := m.disk.(ArchiveStore).Export(ctx, ten)
+    if len(all) != 1 || all[0].Vector != nil { t.Fatalf("vector leaked into disk: %+v", all) }
+
+    vr, err := m.vec.Query(ctx, QueryRequest{Tenant: ten, Query: "离家出走", TopK: 3})
+    if err != nil || len(vr.Items) != 1 || vr.Items[0].ID != all[0].ID { t.Fatalf("vector query: %+v %v", vr.Items, err) }
+
+    // UseVector 时 Manager 合并向量结果；删除同步到向量库
+    qr, _ := m.Query(ctx, QueryRequest{Tenant: ten, Query: "离家", TopK: 3, UseVector: true})
+    if len(qr.Items) != 1 { t.Fatalf("merged query should dedupe: %+v", qr.Items) }
+    if err := m.Delete(ctx, ten, all[0].ID); err != nil { t.Fatalf("delete: %v", err) }
+    vr, _ = m.vec.Query(ctx, QueryRequest{Tenant: ten, Query: "离家出走", TopK: 3})
+    if len(vr.Items) != 0 { t.Fatalf("vector not deleted: %+v", vr.Items) }
+}
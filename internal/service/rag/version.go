@@ -0,0 +1,139 @@
+package rag
+
+import (
+    "context"
+    "errors"
+    "time"
+)
+
+// 记忆版本历史
+// - 逻辑 ID（MemoryItem.ID）在各版本间保持不变；Version 从 1 开始，旧数据的 0 视为 1
+// - Manager.Update 修改正文时产生新版本：Version 加一、RevisedAt 记为生效时间，旧版本由存储保留（VersionStore）；
+//   只改标签、Meta、过期时间或统计字段（检索统计、近重复合并、整理过期）时原地替换，不产生新版本
+// - 章节标记：版本 Meta 的 chapter（MetaChapter）表示该值自哪一章起生效，未标记的版本沿用上一版本的章节
+// - 磁盘 JSONL：每个版本即一条 op=update 记录，回放时 Version 更大的记录把当前版本转入历史；
+//   压缩时历史版本按版本顺序写入新段（首个版本为普通记录，其余为 op=update），回放结果不变
+// - Versions 列出全部版本；GetAsOf 与 QueryRequest.AsOf/AsOfChapter 返回某一时刻或章节有效的版本
+// - 删除（含回滚）与压缩丢弃条目时历史一并删除；归档分叉与快照（archive.go）连同历史版本一起复制，
+//   ExportItems 只包含当前版本
+
+// MetaChapter 版本生效章节写入 Meta 的键
+const MetaChapter = "chapter"
+
+// itemVersion 版本号（0 视为 1）
+func itemVersion(it MemoryItem) int {
+    if it.Version <= 0 {
+        return 1
+    }
+    return it.Version
+}
+
+// effectiveAt 版本的生效时间：首个版本为创建时间
+func effectiveAt(it MemoryItem) time.Time {
+    if it.RevisedAt != nil {
+        return *it.RevisedAt
+    }
+    return it.CreatedAt
+}
+
+// nextVersion 按当前版本设置 item 的版本字段；正文变化时产生新版本并返回 true
+func nextVersion(item *MemoryItem, cur MemoryItem, now time.Time) bool {
+    if item.Content == cur.Content {
+        item.Version, item.RevisedAt = cur.Version, cur.RevisedAt
+        return false
+    }
+    item.Version = itemVersion(cur) + 1
+    item.RevisedAt = &now
+    return true
+}
+
+// pickVersion 返回 at 时刻且 chapter 章节（<=0 不限）有效的版本；versions 按版本从旧到新
+// 首个版本尚未生效（创建于 at 之后，或标记的章节晚于 chapter）时返回 false
+func pickVersion(versions []MemoryItem, at *time.Time, chapter int) (MemoryItem, bool) {
+    var (
+        res   MemoryItem
+        found bool
+        chap  int
+    )
+    for _, v := range versions {
+        if c, ok := metaInt(v.Meta[MetaChapter]); ok {
+            chap = c
+        }
+        if at != nil && effectiveAt(v).After(*at) || chapter > 0 && chap > chapter {
+            break
+        }
+        res, found = v, true
+    }
+    return res, found
+}
+
+// Versions 返回记忆的全部版本（按版本从旧到新，最后一个为当前版本；旧数据的版本号补为 1）
+func (m *Manager) Versions(ctx context.Context, t Tenant, id string) ([]MemoryItem, error) {
+    if t.UserID == "" || t.ArchiveID == "" {
+        return nil, errors.New("tenant(user_id, archive_id) 不能为空")
+    }
+    if id == "" {
+        return nil, errors.New("id 不能为空")
+    }
+    m.ensureWarm(ctx, t)
+    // 磁盘持有完整历史；内存缓存只含预热后的更新，排在其后
+    for _, st := range []Store{m.disk, m.mem} {
+        vs, ok := st.(VersionStore)
+        if !ok {
+            continue
+        }
+        res, err := vs.Versions(ctx, t, id)
+        if errors.Is(err, ErrNotFound) {
+            continue
+        }
+        for i := range res {
+            res[i].Version = itemVersion(res[i])
+        }
+        return res, err
+    }
+    return nil, ErrNotFound
+}
+
+// GetAsOf 返回 at 时刻（nil 不限）且 chapter 章节（<=0 不限）有效的版本；彼时尚不存在时返回 ErrNotFound
+func (m *Manager) GetAsOf(ctx context.Context, t Tenant, id string, at *time.Time, chapter int) (MemoryItem, error) {
+    versions, err := m.Versions(ctx, t, id)
+    if err != nil {
+        return MemoryItem{}, err
+    }
+    it, ok := pickVersion(versions, at, chapter)
+    if !ok {
+        return MemoryItem{}, ErrNotFound
+    }
+    return it, nil
+}
+
+// queryAsOf 按历史版本检索：权威存储的每个条目替换为指定时刻或章节有效的版本后，
+// 在临时内存存储中过滤、打分与分页；不查询向量与三元组后端，不计入检索统计
+func (m *Manager) queryAsOf(ctx context.Context, req QueryRequest) (QueryResult, error) {
+    st := m.authoritative()
+    as, ok := st.(ArchiveStore)
+    vs, vok := st.(VersionStore)
+    if !ok || !vok {
+        return QueryResult{}, errors.New("本地存储未启用或不支持版本历史")
+    }
+    m.ensureWarm(ctx, req.Tenant)
+    items, err := as.Export(ctx, req.Tenant)
+    if err != nil {
+        return QueryResult{}, err
+    }
+    tmp := NewMemoryStore(InMemoryOptions{})
+    for _, it := range items {
+        versions := []MemoryItem{it}
+        if itemVersion(it) > 1 {
+            if versions, err = vs.Versions(ctx, req.Tenant, it.ID); err != nil {
+                return QueryResult{}, err
+            }
+        }
+        if v, ok := pickVersion(versions, req.AsOf, req.AsOfChapter); ok {
+            _ = tmp.Save(ctx, v)
+        }
+    }
+    sub := req
+    sub.AsOf, sub.AsOfChapter = nil, 0
+    return tmp.Query(ctx, sub)
+}
@@ -0,0 +1,121 @@
+package rag
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+    "time"
+)
+
+func contents(items []MemoryItem) string {
+    var res []string
+    for _, it := range items { res = append(res, it.Content) }
+    return strings.Join(res, ",")
+}
+
+func TestVersionStores_KeepSupersededVersions(t *testing.T) {
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    disk, err := NewDiskJSONStore("ns", DiskJSONOptions{Enable: true, RootPath: t.TempDir()})
+    if err != nil { t.Fatalf("disk: %v", err) }
+    bolt := newTestBoltStore(t, t.TempDir())
+    defer bolt.Close(ctx)
+
+    for name, st := range map[string]Store{"memory": NewMemoryStore(InMemoryOptions{}), "disk": disk, "bolt": bolt} {
+        vs := st.(VersionStore)
+        _ = st.Save(ctx, MemoryItem{ID: "x", Tenant: ten, Content: "v1", CreatedAt: time.Now()})
+        // 版本号不变时原地替换，更大时保留旧版本
+        for _, it := range []MemoryItem{
+            {ID: "x", Tenant: ten, Content: "v1", Tags: []string{"t"}},
+            {ID: "x", Tenant: ten, Content: "v2", Version: 2},
+            {ID: "x", Tenant: ten, Content: "v3", Version: 3},
+        } {
+            if err := st.Update(ctx, it); err != nil { t.Fatalf("%s update: %v", name, err) }
+        }
+        got, err := vs.Versions(ctx, ten, "x")
+        if err != nil || contents(got) != "v1,v2,v3" || len(got[0].Tags) != 1 { t.Fatalf("%s versions: %+v %v", name, got, err) }
+
+        // 删除后历史一并删除，同 ID 重新保存从头开始
+        _ = st.Delete(ctx, ten, "x")
+        if _, err := vs.Versions(ctx, ten, "x"); !errors.Is(err, ErrNotFound) { t.Fatalf("%s deleted: %v", name, err) }
+        _ = st.Save(ctx, MemoryItem{ID: "x", Tenant: ten, Content: "new", CreatedAt: time.Now()})
+        if got, _ := vs.Versions(ctx, ten, "x"); contents(got) != "new" { t.Fatalf("%s resaved: %s", name, contents(got)) }
+    }
+}
+
+func TestVersions_UpdateAsOfAndCompaction(t *testing.T) {
+    ctx := context.Background()
+    ten := Tenant{UserID: "u", ArchiveID: "a"}
+    disk := t.TempDir()
+    m := newTestManager(t, onDisk(disk))
+
+    if err := m.Save(ctx, MemoryItem{ID: "age", Tenant: ten, Content: "林夏十六岁", Meta: map[string]any{MetaChapter: 1}}, SaveOptions{}); err != nil { t.Fatalf("save: %v", err) }
+    _ = m.Save(ctx, MemoryItem{ID: "town", Tenant: ten, Content: "林夏住在海边小镇"}, SaveOptions{})
+    before := time.Now()
+    revise := func(content string, chapter int) {
+        it, _ := m.Get(ctx, ten, "age")
+        it.Content = content
+        it.Meta = map[string]any{MetaChapter: chapter}
+        if err := m.Update(ctx, it); err != nil { t.Fatalf("update: %v", err) }
+    }
+    revise("林夏十七岁", 5)
+    mid := time.Now()
+    // 只改标签不产生新版本
+    it, _ := m.Get(ctx, ten, "age")
+    it.Tags = []string{"人物"}
+    _ = m.Update(ctx, it)
+    revise("林夏十八岁", 9)
+
+    check := func(m *Manager) {
+        t.Helper()
+        vs, err := m.Versions(ctx, ten, "age")
+        if err != nil || contents(vs) != "林夏十六岁,林夏十七岁,林夏十八岁" { t.Fatalf("versions: %s %v", contents(vs), err) }
+        for i, v := range vs {
+            if v.ID != "age" || v.Version != i+1 { t.Fatalf("version %d: %+v", i, v) }
+        }
+        if !hasTag(vs[1].Tags, "人物") || vs[2].RevisedAt == nil { t.Fatalf("in-place update lost: %+v", vs[1]) }
+
+        for _, c := range []struct {
+            at      *time.Time
+            chapter int
+            want    string
+        }{
+            {nil, 1, "林夏十六岁"},
+            {nil, 6, "林夏十七岁"},
+            {nil, 100, "林夏十八岁"},
+            {&before, 0, "林夏十六岁"},
+            {&mid, 0, "林夏十七岁"},
+            {&mid, 3, "林夏十六岁"},
+        } {
+            got, err := m.GetAsOf(ctx, ten, "age", c.at, c.chapter)
+            if err != nil || got.Content != c.want { t.Fatalf("as of %v/%d: %q %v", c.at, c.chapter, got.Content, err) }
+        }
+        early := vs[0].CreatedAt.Add(-time.Second)
+        if _, err := m.GetAsOf(ctx, ten, "age", &early, 0); !errors.Is(err, ErrNotFound) { t.Fatalf("before creation: %v", err) }
+
+        r, err := m.Query(ctx, QueryRequest{Tenant: ten, Query: "林夏", AsOfChapter: 6, TopK: 10})
+        if err != nil || len(r.Items) != 2 { t.Fatalf("query as of: %s %v", ids(r.Items), err) }
+        for _, it := range r.Items {
+            if it.ID == "age" && it.Content != "林夏十七岁" { t.Fatalf("query as of chapter: %q", it.Content) }
+        }
+        if r, _ := m.Query(ctx, QueryRequest{Tenant: ten, AsOf: &early}); len(r.Items) != 0 { t.Fatalf("query before creation: %s", ids(r.Items)) }
+        if it, _ := m.Get(ctx, ten, "age"); it.Content != "林夏十八岁" || it.Version != 3 { t.Fatalf("current: %+v", it) }
+    }
+    check(m)
+    if err := m.Close(ctx); err != nil { t.Fatalf("close: %v", err) }
+
+    // 重启后由 JSONL 回放恢复历史；压缩保留历史版本
+    m = newTestManager(t, onDisk(disk))
+    check(m)
+    sum, err := m.Compact(ctx)
+    if err != nil || len(sum.Tenants) != 1 || sum.Tenants[0].Versions != 2 || sum.Tenants[0].Kept != 2 { t.Fatalf("compact: %+v %v", sum, err) }
+    check(m)
+    _ = m.Close(ctx)
+    m = newTestManager(t, onDisk(disk))
+    defer m.Close(ctx)
+    check(m)
+
+    if err := m.Delete(ctx, ten, "age"); err != nil { t.Fatalf("delete: %v", err) }
+    if _, err := m.Versions(ctx, ten, "age"); !errors.Is(err, ErrNotFound) { t.Fatalf("versions after delete: %v", err) }
+}
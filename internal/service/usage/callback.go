@@ -0,0 +1,66 @@
+package usage
+
+import (
+	"context"
+	"io"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+	ucb "github.com/cloudwego/eino/utils/callbacks"
+)
+
+// NewCallbackHandler 创建 eino 回调处理器，将 ChatModel 的 TokenUsage 累加到 context 中的 Collector
+// 用法：runnable.Invoke(ctx, input, compose.WithCallbacks(usage.NewCallbackHandler()))
+func NewCallbackHandler() callbacks.Handler {
+	return ucb.NewHandlerHelper().ChatModel(&ucb.ModelCallbackHandler{
+		OnEnd: func(ctx context.Context, info *callbacks.RunInfo, output *model.CallbackOutput) context.Context {
+			if c, ok := CollectorFrom(ctx); ok && output != nil && output.TokenUsage != nil {
+				c.Add(fromTokenUsage(output.TokenUsage))
+			}
+			return ctx
+		},
+		OnEndWithStreamOutput: func(ctx context.Context, info *callbacks.RunInfo, output *schema.StreamReader[*model.CallbackOutput]) context.Context {
+			c, ok := CollectorFrom(ctx)
+			if !ok {
+				output.Close()
+				return ctx
+			}
+			// 流式输出中用量通常只出现在最后一个分片，取最后一次非空值
+			c.pending.Add(1)
+			go func() {
+				defer c.pending.Done()
+				defer output.Close()
+				var last *model.TokenUsage
+				for {
+					chunk, err := output.Recv()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						return
+					}
+					if chunk != nil && chunk.TokenUsage != nil {
+						last = chunk.TokenUsage
+					}
+				}
+				if last != nil {
+					c.Add(fromTokenUsage(last))
+				}
+			}()
+			return ctx
+		},
+	}).Handler()
+}
+
+func fromTokenUsage(tu *model.TokenUsage) Usage {
+	u := Usage{
+		PromptTokens:     int64(tu.PromptTokens),
+		CompletionTokens: int64(tu.CompletionTokens),
+		TotalTokens:      int64(tu.TotalTokens),
+	}
+	if u.TotalTokens == 0 {
+		u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	}
+	return u
+}
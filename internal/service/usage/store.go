@@ -0,0 +1,172 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	actx "ahs/internal/context"
+	"ahs/internal/tenantpath"
+)
+
+// Store 用量持久化抽象（按租户 + 日聚合）
+type Store interface {
+	Add(ctx context.Context, t actx.Tenant, day string, u Usage) error
+	Get(ctx context.Context, t actx.Tenant, day string) (DailyUsage, error)
+	// Purge 删除租户的全部用量记录
+	Purge(ctx context.Context, t actx.Tenant) error
+}
+
+// memoryStore 进程内实现，主要用于测试与关闭持久化时
+type memoryStore struct {
+	mu   sync.Mutex
+	data map[string]map[string]DailyUsage // tenantKey -> day -> usage
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{data: make(map[string]map[string]DailyUsage)}
+}
+
+func tenantKey(t actx.Tenant) string {
+	return t.UserID + "::" + t.ArchiveID
+}
+
+func (s *memoryStore) Add(ctx context.Context, t actx.Tenant, day string, u Usage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	days := s.data[tenantKey(t)]
+	if days == nil {
+		days = make(map[string]DailyUsage)
+		s.data[tenantKey(t)] = days
+	}
+	d := days[day]
+	d.Day = day
+	d.Runs++
+	d.Usage.Add(u)
+	days[day] = d
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, t actx.Tenant, day string) (DailyUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.data[tenantKey(t)][day]
+	if !ok {
+		return DailyUsage{Day: day}, nil
+	}
+	return d, nil
+}
+
+func (s *memoryStore) Purge(ctx context.Context, t actx.Tenant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, tenantKey(t))
+	return nil
+}
+
+// fileStore 基于 JSON 文件的持久化实现
+// 每个租户一个文件：{RootPath}/{enc(user_id)}/{enc(archive_id)}/usage.json，内容为 day -> DailyUsage
+// 目录名编码见 tenantpath
+// 写入时整体重写（临时文件 + rename），数据量为每日一条，足够小
+type fileStore struct {
+	root string
+
+	mu    sync.Mutex
+	cache map[string]map[string]DailyUsage
+}
+
+func NewFileStore(root string) (Store, error) {
+	if root == "" {
+		return nil, errors.New("usage.root_path 不能为空")
+	}
+	if _, err := tenantpath.MigrateLegacy(root); err != nil {
+		return nil, fmt.Errorf("migrate legacy layout: %w", err)
+	}
+	return &fileStore{root: root, cache: make(map[string]map[string]DailyUsage)}, nil
+}
+
+func (s *fileStore) pathOf(t actx.Tenant) string {
+	return filepath.Join(tenantpath.Dir(s.root, t.UserID, t.ArchiveID), "usage.json")
+}
+
+// load 读取租户文件（调用方持有锁）
+func (s *fileStore) load(t actx.Tenant) (map[string]DailyUsage, error) {
+	key := tenantKey(t)
+	if days, ok := s.cache[key]; ok {
+		return days, nil
+	}
+	days := make(map[string]DailyUsage)
+	b, err := os.ReadFile(s.pathOf(t))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read usage file: %w", err)
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &days); err != nil {
+			return nil, fmt.Errorf("decode usage file: %w", err)
+		}
+	}
+	s.cache[key] = days
+	return days, nil
+}
+
+func (s *fileStore) Add(ctx context.Context, t actx.Tenant, day string, u Usage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	days, err := s.load(t)
+	if err != nil {
+		return err
+	}
+	d := days[day]
+	d.Day = day
+	d.Runs++
+	d.Usage.Add(u)
+	days[day] = d
+
+	fp := s.pathOf(t)
+	if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+		return fmt.Errorf("ensure dir: %w", err)
+	}
+	b, err := json.Marshal(days)
+	if err != nil {
+		return fmt.Errorf("encode usage: %w", err)
+	}
+	tmp := fp + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("write usage file: %w", err)
+	}
+	if err := os.Rename(tmp, fp); err != nil {
+		return fmt.Errorf("rename usage file: %w", err)
+	}
+	return nil
+}
+
+func (s *fileStore) Get(ctx context.Context, t actx.Tenant, day string) (DailyUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	days, err := s.load(t)
+	if err != nil {
+		return DailyUsage{}, err
+	}
+	d, ok := days[day]
+	if !ok {
+		return DailyUsage{Day: day}, nil
+	}
+	return d, nil
+}
+
+func (s *fileStore) Purge(ctx context.Context, t actx.Tenant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.cache, tenantKey(t))
+	if err := os.Remove(s.pathOf(t)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove usage file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package usage
+
+import (
+	"context"
+	"time"
+
+	"ahs/internal/config"
+	actx "ahs/internal/context"
+)
+
+const (
+	// QuotaModeReject 超额后拒绝请求
+	QuotaModeReject = "reject"
+	// QuotaModeDegrade 超额后降级执行（限制单次生成 token 数）
+	QuotaModeDegrade = "degrade"
+)
+
+// Decision 额度检查结果
+type Decision struct {
+	Used      int64 // 当日已用 token
+	Limit     int64 // 当日额度，0 表示不限
+	Degraded  bool  // 是否以降级模式放行
+	MaxTokens int   // 降级模式下建议的单次生成上限
+}
+
+// Tracker 用量记录与额度校验
+type Tracker struct {
+	cfg   config.UsageConfig
+	store Store
+	now   func() time.Time
+}
+
+// NewTracker 根据配置创建 Tracker；未启用时返回 nil
+func NewTracker(cfg config.UsageConfig) (*Tracker, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	var st Store
+	if cfg.RootPath == "" {
+		st = NewMemoryStore()
+	} else {
+		fs, err := NewFileStore(cfg.RootPath)
+		if err != nil {
+			return nil, err
+		}
+		st = fs
+	}
+	return NewTrackerWithStore(cfg, st), nil
+}
+
+// NewTrackerWithStore 使用指定存储创建 Tracker
+func NewTrackerWithStore(cfg config.UsageConfig, st Store) *Tracker {
+	return &Tracker{cfg: cfg, store: st, now: time.Now}
+}
+
+// limitOf 返回用户的每日额度，用户级覆盖优先
+func (t *Tracker) limitOf(tenant actx.Tenant) int64 {
+	if n, ok := t.cfg.UserLimits[tenant.UserID]; ok {
+		return n
+	}
+	return t.cfg.DailyTokenLimit
+}
+
+// Enforced 是否配置了任何额度；此时运行必须能确定租户才能计量
+func (t *Tracker) Enforced() bool {
+	if t.cfg.DailyTokenLimit > 0 {
+		return true
+	}
+	for _, n := range t.cfg.UserLimits {
+		if n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// userTotal 用户级汇总记录的键：archive_id 为空（合法租户的 archive_id 不能为空，不会与真实存档冲突）
+// 额度按用户计，汇总该用户全部存档，换用新的 archive_id 或清除存档都不会重置当日额度
+func userTotal(tenant actx.Tenant) actx.Tenant {
+	return actx.Tenant{UserID: tenant.UserID}
+}
+
+// Check 在运行前校验用户当日额度（汇总该用户全部存档的用量）
+// 超额时：reject 模式返回 ErrQuotaExceeded；degrade 模式返回 Degraded=true
+func (t *Tracker) Check(ctx context.Context, tenant actx.Tenant) (Decision, error) {
+	limit := t.limitOf(tenant)
+	if limit <= 0 {
+		return Decision{}, nil
+	}
+	d, err := t.store.Get(ctx, userTotal(tenant), DayOf(t.now()))
+	if err != nil {
+		return Decision{}, err
+	}
+	dec := Decision{Used: d.TotalTokens, Limit: limit}
+	if d.TotalTokens < limit {
+		return dec, nil
+	}
+	if t.cfg.Mode == QuotaModeDegrade {
+		dec.Degraded = true
+		dec.MaxTokens = t.cfg.DegradedMaxTokens
+		return dec, nil
+	}
+	return dec, ErrQuotaExceeded
+}
+
+// Record 记录一次运行的用量（同时计入存档与用户级汇总）
+func (t *Tracker) Record(ctx context.Context, tenant actx.Tenant, u Usage) error {
+	day := DayOf(t.now())
+	if err := t.store.Add(ctx, tenant, day, u); err != nil {
+		return err
+	}
+	return t.store.Add(ctx, userTotal(tenant), day, u)
+}
+
+// Today 返回租户当日累计用量
+func (t *Tracker) Today(ctx context.Context, tenant actx.Tenant) (DailyUsage, error) {
+	return t.store.Get(ctx, tenant, DayOf(t.now()))
+}
+
+// Purge 删除租户的全部用量记录（归档清除时调用）；用户级汇总保留，额度不因清除而重置
+func (t *Tracker) Purge(ctx context.Context, tenant actx.Tenant) error {
+	return t.store.Purge(ctx, tenant)
+}
+
+// RetryAfter 返回距下一次额度重置（UTC 零点）的时长
+func (t *Tracker) RetryAfter() time.Duration {
+	now := t.now().UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return next.Sub(now)
+}
@@ -0,0 +1,108 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQuotaExceeded 租户当日 token 额度已用尽
+	ErrQuotaExceeded = errors.New("租户额度已用尽")
+)
+
+// Usage 一次运行（或一段时间）内的 token 消耗
+type Usage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+// Add 累加另一份用量
+func (u *Usage) Add(o Usage) {
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.TotalTokens += o.TotalTokens
+}
+
+// IsZero 是否没有任何消耗
+func (u Usage) IsZero() bool {
+	return u.PromptTokens == 0 && u.CompletionTokens == 0 && u.TotalTokens == 0
+}
+
+// DailyUsage 按租户+自然日（UTC）聚合的用量
+type DailyUsage struct {
+	Day  string `json:"day"`
+	Runs int64  `json:"runs"`
+	Usage
+}
+
+// DayOf 返回用量聚合使用的日期键（UTC，YYYY-MM-DD）
+func DayOf(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// Collector 单次运行内的用量收集器，并发安全
+// 由服务层在运行开始时放入 context，模型回调向其中累加
+type Collector struct {
+	mu    sync.Mutex
+	usage Usage
+	calls int
+
+	pending sync.WaitGroup // 尚未读完的流式回调
+}
+
+// Add 累加一次模型调用的用量
+func (c *Collector) Add(u Usage) {
+	c.mu.Lock()
+	c.usage.Add(u)
+	c.calls++
+	c.mu.Unlock()
+}
+
+// Snapshot 返回当前累计用量
+func (c *Collector) Snapshot() Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usage
+}
+
+// Wait 等待流式回调读完输出，再读取最终用量
+func (c *Collector) Wait() {
+	c.pending.Wait()
+}
+
+// Calls 返回已记录的模型调用次数
+func (c *Collector) Calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+type collectorKey struct{}
+
+type degradeKey struct{}
+
+// WithCollector 在 context 中放入新的用量收集器
+func WithCollector(ctx context.Context) (context.Context, *Collector) {
+	c := &Collector{}
+	return context.WithValue(ctx, collectorKey{}, c), c
+}
+
+// CollectorFrom 从 context 获取用量收集器
+func CollectorFrom(ctx context.Context) (*Collector, bool) {
+	c, ok := ctx.Value(collectorKey{}).(*Collector)
+	return c, ok && c != nil
+}
+
+// WithDegraded 标记本次运行处于降级模式，maxTokens 为建议的单次生成上限
+func WithDegraded(ctx context.Context, maxTokens int) context.Context {
+	return context.WithValue(ctx, degradeKey{}, maxTokens)
+}
+
+// DegradedMaxTokens 若处于降级模式，返回建议的单次生成上限
+func DegradedMaxTokens(ctx context.Context) (int, bool) {
+	n, ok := ctx.Value(degradeKey{}).(int)
+	return n, ok
+}
@@ -0,0 +1,102 @@
+package usage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ahs/internal/config"
+	actx "ahs/internal/context"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore_PersistAcrossInstances(t *testing.T) {
+	root := t.TempDir()
+	ctx := context.Background()
+	ten := actx.Tenant{UserID: "u1", ArchiveID: "a1"}
+
+	st, err := NewFileStore(root)
+	require.NoError(t, err)
+	require.NoError(t, st.Add(ctx, ten, "2025-01-01", Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}))
+	require.NoError(t, st.Add(ctx, ten, "2025-01-01", Usage{PromptTokens: 1, CompletionTokens: 1, TotalTokens: 2}))
+
+	// 新实例从磁盘读取
+	st2, err := NewFileStore(root)
+	require.NoError(t, err)
+	d, err := st2.Get(ctx, ten, "2025-01-01")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), d.Runs)
+	assert.Equal(t, int64(17), d.TotalTokens)
+
+	// 其他租户互不影响
+	d, err = st2.Get(ctx, actx.Tenant{UserID: "u1", ArchiveID: "a2"}, "2025-01-01")
+	require.NoError(t, err)
+	assert.True(t, d.Usage.IsZero())
+}
+
+func TestTracker_RejectAndDegrade(t *testing.T) {
+	ctx := context.Background()
+	ten := actx.Tenant{UserID: "u1", ArchiveID: "a1"}
+	fixed := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tr := NewTrackerWithStore(config.UsageConfig{Enabled: true, DailyTokenLimit: 100, Mode: QuotaModeReject}, NewMemoryStore())
+	tr.now = func() time.Time { return fixed }
+
+	_, err := tr.Check(ctx, ten)
+	require.NoError(t, err)
+	require.NoError(t, tr.Record(ctx, ten, Usage{TotalTokens: 100}))
+	_, err = tr.Check(ctx, ten)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.Equal(t, 12*time.Hour, tr.RetryAfter())
+
+	// 额度按用户汇总：换用新存档或清除存档不重置
+	other := actx.Tenant{UserID: "u1", ArchiveID: "a2"}
+	_, err = tr.Check(ctx, other)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	require.NoError(t, tr.Purge(ctx, ten))
+	_, err = tr.Check(ctx, ten)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	_, err = tr.Check(ctx, actx.Tenant{UserID: "u2", ArchiveID: "a1"})
+	assert.NoError(t, err)
+	assert.True(t, tr.Enforced())
+
+	// 用户级覆盖额度
+	tr.cfg.UserLimits = map[string]int64{"u1": 1000}
+	_, err = tr.Check(ctx, ten)
+	assert.NoError(t, err)
+
+	// 降级模式放行并给出生成上限
+	tr.cfg.UserLimits = nil
+	tr.cfg.Mode = QuotaModeDegrade
+	tr.cfg.DegradedMaxTokens = 64
+	dec, err := tr.Check(ctx, ten)
+	require.NoError(t, err)
+	assert.True(t, dec.Degraded)
+	assert.Equal(t, 64, dec.MaxTokens)
+
+	// 次日额度重置
+	tr.now = func() time.Time { return fixed.Add(24 * time.Hour) }
+	dec, err = tr.Check(ctx, ten)
+	require.NoError(t, err)
+	assert.False(t, dec.Degraded)
+}
+
+func TestCallbackHandler_CollectsTokenUsage(t *testing.T) {
+	ctx, c := WithCollector(context.Background())
+	h := NewCallbackHandler()
+	info := &callbacks.RunInfo{Component: "ChatModel"}
+
+	h.OnEnd(ctx, info, &model.CallbackOutput{TokenUsage: &model.TokenUsage{PromptTokens: 7, CompletionTokens: 3}})
+	h.OnEnd(ctx, info, &model.CallbackOutput{TokenUsage: &model.TokenUsage{PromptTokens: 1, CompletionTokens: 1, TotalTokens: 2}})
+
+	c.Wait()
+	u := c.Snapshot()
+	assert.Equal(t, int64(8), u.PromptTokens)
+	assert.Equal(t, int64(4), u.CompletionTokens)
+	assert.Equal(t, int64(12), u.TotalTokens)
+	assert.Equal(t, 2, c.Calls())
+}
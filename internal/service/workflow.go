@@ -2,7 +2,13 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"time"
+
+	actx "ahs/internal/context"
+	"ahs/internal/service/usage"
 )
 
 var (
@@ -10,6 +16,16 @@ var (
 	ErrInvalidRequest   = errors.New("无效的请求")
 )
 
+// maxSessionIDLen 请求体 session_id 最大长度（字节）
+const maxSessionIDLen = 128
+
+// NewRunID 生成运行 ID：UTC 时间前缀（按时间排序）+ 随机后缀
+func NewRunID() string {
+	var b [6]byte
+	_, _ = rand.Read(b[:])
+	return "run-" + time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b[:])
+}
+
 // WorkflowRequest 工作流请求结构
 type WorkflowRequest struct {
 	Workflow  string `json:"workflow"`
@@ -17,13 +33,18 @@ type WorkflowRequest struct {
 	UserID    string `json:"user_id,omitempty"`
 	ArchiveID string `json:"archive_id,omitempty"`
 	Timeout   int    `json:"timeout,omitempty"`
+	SessionID string `json:"session_id,omitempty"` // 客户端会话，记入本次运行写入的记忆
+	RunID     string `json:"-"`                    // 运行 ID，由服务端分配（为空时生成）
 }
 
 // WorkflowResponse 工作流响应结构
 type WorkflowResponse struct {
-	Status string `json:"status"`
-	Result string `json:"result"`
-	Error  string `json:"error,omitempty"`
+	Status   string       `json:"status"`
+	Result   string       `json:"result"`
+	Error    string       `json:"error,omitempty"`
+	Usage    *usage.Usage `json:"usage,omitempty"`
+	Degraded bool         `json:"degraded,omitempty"`
+	RunID    string       `json:"run_id,omitempty"` // 本次运行写入的记忆可按该 ID 查询与回滚
 }
 
 // WorkflowInfo 工作流信息结构
@@ -53,6 +74,7 @@ type WorkflowProcessor interface {
 // WorkflowService 工作流服务
 type WorkflowService struct {
 	manager WorkflowManager
+	usage   *usage.Tracker // 可选：用量记录与额度校验
 }
 
 // NewWorkflowService 创建工作流服务
@@ -62,6 +84,16 @@ func NewWorkflowService(manager WorkflowManager) *WorkflowService {
 	}
 }
 
+// SetUsageTracker 设置用量记录器（nil 表示关闭）
+func (s *WorkflowService) SetUsageTracker(t *usage.Tracker) {
+	s.usage = t
+}
+
+// UsageTracker 返回当前用量记录器
+func (s *WorkflowService) UsageTracker() *usage.Tracker {
+	return s.usage
+}
+
 // ListWorkflows 列出所有工作流
 func (s *WorkflowService) ListWorkflows() []string {
 	return s.manager.List()
@@ -85,34 +117,131 @@ func (s *WorkflowService) Execute(ctx context.Context, req WorkflowRequest) (*Wo
 		return nil, ErrWorkflowNotFound
 	}
 
+	// 额度校验并挂载用量收集器
+	ctx, run, err := s.beginRun(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
 	// 处理请求
 	result, err := processor.Process(ctx, req.Input)
+	u := s.endRun(ctx, run)
 	if err != nil {
 		return &WorkflowResponse{
-			Status: "error",
-			Error:  err.Error(),
+			Status:   "error",
+			Error:    err.Error(),
+			Usage:    u,
+			Degraded: run.degraded,
+			RunID:    run.runID,
 		}, nil
 	}
 
 	return &WorkflowResponse{
-		Status: "success",
-		Result: result,
+		Status:   "success",
+		Result:   result,
+		Usage:    u,
+		Degraded: run.degraded,
+		RunID:    run.runID,
 	}, nil
 }
 
 // ExecuteStream 执行流式工作流
-func (s *WorkflowService) ExecuteStream(ctx context.Context, req WorkflowRequest, callback StreamCallback) error {
+// 返回本次运行的 token 用量（未启用用量记录时为 nil）
+func (s *WorkflowService) ExecuteStream(ctx context.Context, req WorkflowRequest, callback StreamCallback) (*usage.Usage, error) {
+	run, err := s.StartStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return run.Run(callback)
+}
+
+// StreamRun 已通过校验与额度检查、尚未开始处理的流式运行
+type StreamRun struct {
+	s         *WorkflowService
+	ctx       context.Context
+	processor WorkflowProcessor
+	input     string
+	run       *runState
+}
+
+// StartStream 校验请求、分配运行 ID 并检查额度，不执行处理
+// 调用方可在发送流式响应头之前据返回的错误（含 usage.ErrQuotaExceeded）返回相应状态码
+func (s *WorkflowService) StartStream(ctx context.Context, req WorkflowRequest) (*StreamRun, error) {
 	// 验证请求
 	if req.Workflow == "" {
-		return ErrInvalidRequest
+		return nil, ErrInvalidRequest
 	}
 
 	// 获取工作流处理器
 	processor, ok := s.manager.Get(req.Workflow)
 	if !ok {
-		return ErrWorkflowNotFound
+		return nil, ErrWorkflowNotFound
 	}
 
-	// 执行流式处理
-	return processor.ProcessStream(ctx, req.Input, callback)
+	// 额度校验并挂载用量收集器
+	ctx, run, err := s.beginRun(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamRun{s: s, ctx: ctx, processor: processor, input: req.Input, run: run}, nil
+}
+
+// RunID 本次运行 ID
+func (r *StreamRun) RunID() string { return r.run.runID }
+
+// Degraded 是否以降级模式放行
+func (r *StreamRun) Degraded() bool { return r.run.degraded }
+
+// Run 执行流式处理，返回本次运行的 token 用量（未启用用量记录时为 nil）；处理失败时同样返回已产生的用量
+func (r *StreamRun) Run(callback StreamCallback) (*usage.Usage, error) {
+	err := r.processor.ProcessStream(r.ctx, r.input, callback)
+	return r.s.endRun(r.ctx, r.run), err
+}
+
+// runState 单次运行的用量上下文
+type runState struct {
+	runID     string
+	tenant    actx.Tenant
+	collector *usage.Collector
+	degraded  bool
+}
+
+// beginRun 分配运行 ID 并写入来源信息，校验额度并在 context 中放入用量收集器
+func (s *WorkflowService) beginRun(ctx context.Context, req WorkflowRequest) (context.Context, *runState, error) {
+	tenant, err := actx.ResolveTenant(ctx, actx.Tenant{UserID: req.UserID, ArchiveID: req.ArchiveID})
+	// 租户不一致或不合法时拒绝；无法确定租户时仅在未配置额度时放行（不计量）
+	if err != nil && (!errors.Is(err, actx.ErrTenantMissing) || s.usage != nil && s.usage.Enforced()) ||
+		len(req.SessionID) > maxSessionIDLen {
+		return ctx, nil, ErrInvalidRequest
+	}
+	run := &runState{runID: req.RunID, tenant: tenant}
+	if run.runID == "" {
+		run.runID = NewRunID()
+	}
+	ctx = actx.WithProvenance(ctx, actx.Provenance{RunID: run.runID, SessionID: req.SessionID, Workflow: req.Workflow})
+	if s.usage == nil || err != nil {
+		return ctx, run, nil
+	}
+	dec, err := s.usage.Check(ctx, run.tenant)
+	if err != nil {
+		return ctx, nil, err
+	}
+	if dec.Degraded {
+		run.degraded = true
+		ctx = usage.WithDegraded(ctx, dec.MaxTokens)
+	}
+	ctx, run.collector = usage.WithCollector(ctx)
+	return ctx, run, nil
+}
+
+// endRun 汇总并持久化本次运行的用量
+func (s *WorkflowService) endRun(ctx context.Context, run *runState) *usage.Usage {
+	if run == nil || run.collector == nil {
+		return nil
+	}
+	run.collector.Wait()
+	u := run.collector.Snapshot()
+	// 记录失败不影响本次结果，仅丢失统计
+	_ = s.usage.Record(context.WithoutCancel(ctx), run.tenant, u)
+	return &u
 }
@@ -0,0 +1,325 @@
+// Package tenantpath 负责租户 ID 与磁盘目录名之间的映射
+//
+// 编码规则（可逆、无碰撞）：
+//   - 小写字母、数字与 '-' 原样保留；
+//   - 其余每个字节（含大写字母、'_'、'.'、'/' 等）编码为 '_' + 两位小写十六进制；
+//   - 空字符串编码为 "_"。
+//
+// 大写字母也被转义，因此在大小写不敏感的文件系统上同样不会碰撞；
+// 编码结果不含 '.'，不会出现 "."、".." 或隐藏目录。
+// 超过 MaxSegmentLen 的编码结果退化为 "~" + sha256 十六进制（不可逆，仅为兜底）。
+package tenantpath
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxSegmentLen 单个目录名的最大长度，留出余量以适配常见文件系统的 255 字节限制
+const MaxSegmentLen = 200
+
+// LayoutMarker 标记目录已使用新编码布局
+const LayoutMarker = ".layout-v2"
+
+const hexDigits = "0123456789abcdef"
+
+// ErrNotEncoded 目录名不是合法的编码结果
+var ErrNotEncoded = errors.New("不是合法的租户目录名")
+
+// Encode 将租户 ID 编码为目录名
+func Encode(id string) string {
+	if id == "" {
+		return "_"
+	}
+	var b strings.Builder
+	b.Grow(len(id))
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('_')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&0x0f])
+	}
+	if b.Len() > MaxSegmentLen {
+		sum := sha256.Sum256([]byte(id))
+		return "~" + hex.EncodeToString(sum[:])
+	}
+	return b.String()
+}
+
+// Decode 将目录名还原为租户 ID
+func Decode(name string) (string, error) {
+	if name == "_" {
+		return "", nil
+	}
+	if name == "" || strings.HasPrefix(name, "~") {
+		return "", ErrNotEncoded
+	}
+	var b strings.Builder
+	b.Grow(len(name))
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-':
+			b.WriteByte(c)
+		case c == '_' && i+2 < len(name):
+			v, err := hex.DecodeString(name[i+1 : i+3])
+			if err != nil {
+				return "", fmt.Errorf("%w: %q", ErrNotEncoded, name)
+			}
+			b.WriteByte(v[0])
+			i += 2
+		default:
+			return "", fmt.Errorf("%w: %q", ErrNotEncoded, name)
+		}
+	}
+	// 仅接受规范编码，保证一一对应
+	id := b.String()
+	if Encode(id) != name {
+		return "", fmt.Errorf("%w: %q", ErrNotEncoded, name)
+	}
+	return id, nil
+}
+
+// Dir 返回租户在 base 下的目录：{base}/{Encode(user_id)}/{Encode(archive_id)}
+func Dir(base, userID, archiveID string) string {
+	return filepath.Join(base, Encode(userID), Encode(archiveID))
+}
+
+// MigrationReport 旧布局迁移结果
+type MigrationReport struct {
+	Moved     []string // 整体重命名的目录（旧路径）
+	Merged    []string // 合并进已有目录的文件（旧路径）
+	Conflicts []string // 目标已存在且无法合并、保留在原处的文件
+}
+
+// MigrateLegacy 将 base 下旧布局（目录名即原始 ID，经过简单替换）迁移到新编码布局
+// 迁移完成后写入 LayoutMarker，重复调用为空操作。
+// 没有 LayoutMarker 时 base 下的目录一律视为旧布局（即使名称恰好是合法编码，如 "a_5fb"）；
+// 迁移计划先写入日志文件（LayoutMarker + ".journal"），每完成一步追加记录，
+// 中途失败后重跑按日志继续，不会重新扫描或重复编码已迁移的目录。
+// 同名冲突时：.jsonl 文件追加合并，其余文件保留在原处并记入 Conflicts。
+func MigrateLegacy(base string) (MigrationReport, error) {
+	var rep MigrationReport
+	marker := filepath.Join(base, LayoutMarker)
+	if _, err := os.Stat(marker); err == nil {
+		return rep, nil
+	}
+	journal := marker + ".journal"
+	steps, done, err := readJournal(journal)
+	if os.IsNotExist(err) {
+		if steps, err = planMigration(base); err != nil {
+			return rep, err
+		}
+		if len(steps) == 0 {
+			return rep, writeMarker(marker)
+		}
+		err = writeJournal(journal, steps)
+	}
+	if err != nil {
+		return rep, err
+	}
+
+	f, err := os.OpenFile(journal, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return rep, fmt.Errorf("open journal: %w", err)
+	}
+	defer f.Close()
+	for i, st := range steps {
+		if done[i] {
+			continue
+		}
+		if err := runStep(base, st, &rep); err != nil {
+			return rep, err
+		}
+		if _, err := fmt.Fprintf(f, "%d\n", i); err != nil {
+			return rep, fmt.Errorf("write journal: %w", err)
+		}
+	}
+	if err := writeMarker(marker); err != nil {
+		return rep, err
+	}
+	_ = os.Remove(journal)
+	return rep, nil
+}
+
+// migrationStep 迁移计划中的一步（路径相对 base）；To 为空表示移除已清空的旧用户目录
+type migrationStep struct {
+	From string `json:"from"`
+	To   string `json:"to,omitempty"`
+}
+
+// planMigration 扫描旧布局生成迁移计划
+// 编码结果只会比原名更长，因此按名称从长到短处理：若某目录的新名称恰好是另一个旧目录的名称，
+// 后者总是先被移走，不会被误合并
+func planMigration(base string) ([]migrationStep, error) {
+	users, err := readDirsByLength(base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var steps []migrationStep
+	for _, u := range users {
+		archives, err := readDirsByLength(filepath.Join(base, u))
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range archives {
+			from := filepath.Join(u, a)
+			to := filepath.Join(Encode(u), Encode(a))
+			if from != to {
+				steps = append(steps, migrationStep{From: from, To: to})
+			}
+		}
+		if Encode(u) != u {
+			steps = append(steps, migrationStep{From: u})
+		}
+	}
+	return steps, nil
+}
+
+// readDirsByLength 返回 dir 下的子目录名，按长度从长到短（同长按名称）
+func readDirsByLength(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	return names, nil
+}
+
+func runStep(base string, st migrationStep, rep *MigrationReport) error {
+	from := filepath.Join(base, st.From)
+	if st.To == "" {
+		// 旧用户目录清空后移除
+		_ = os.Remove(from)
+		return nil
+	}
+	// 上次已执行完但未记入日志的步骤：源目录已不存在
+	if _, err := os.Stat(from); os.IsNotExist(err) {
+		return nil
+	}
+	return moveDir(from, filepath.Join(base, st.To), rep)
+}
+
+// writeJournal 写入迁移计划（首行），先写临时文件再重命名，避免留下半截计划
+func writeJournal(journal string, steps []migrationStep) error {
+	b, err := json.Marshal(steps)
+	if err != nil {
+		return fmt.Errorf("marshal journal: %w", err)
+	}
+	tmp := journal + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := os.Rename(tmp, journal); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	return nil
+}
+
+// readJournal 读取迁移计划与已完成的步骤序号；日志不存在时返回 os.IsNotExist 错误
+func readJournal(journal string) ([]migrationStep, map[int]bool, error) {
+	b, err := os.ReadFile(journal)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	var steps []migrationStep
+	if err := json.Unmarshal([]byte(lines[0]), &steps); err != nil {
+		return nil, nil, fmt.Errorf("parse journal: %w", err)
+	}
+	done := map[int]bool{}
+	for _, l := range lines[1:] {
+		// 末行可能因中断而不完整，忽略无法解析的行（对应步骤会重跑）
+		if i, err := strconv.Atoi(l); err == nil {
+			done[i] = true
+		}
+	}
+	return steps, done, nil
+}
+
+func writeMarker(marker string) error {
+	if err := os.MkdirAll(filepath.Dir(marker), 0o755); err != nil {
+		return fmt.Errorf("ensure dir: %w", err)
+	}
+	return os.WriteFile(marker, []byte("tenant dir names: tenantpath.Encode\n"), 0o644)
+}
+
+// moveDir 将旧目录移动到新位置，目标存在时逐文件合并
+func moveDir(from, to string, rep *MigrationReport) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return fmt.Errorf("ensure dir: %w", err)
+	}
+	if _, err := os.Stat(to); os.IsNotExist(err) {
+		if err := os.Rename(from, to); err != nil {
+			return fmt.Errorf("rename %s: %w", from, err)
+		}
+		rep.Moved = append(rep.Moved, from)
+		return nil
+	}
+
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return fmt.Errorf("read dir: %w", err)
+	}
+	for _, e := range entries {
+		src := filepath.Join(from, e.Name())
+		dst := filepath.Join(to, e.Name())
+		if _, err := os.Stat(dst); os.IsNotExist(err) {
+			if err := os.Rename(src, dst); err != nil {
+				return fmt.Errorf("rename %s: %w", src, err)
+			}
+			rep.Merged = append(rep.Merged, src)
+			continue
+		}
+		if e.IsDir() || filepath.Ext(e.Name()) != ".jsonl" {
+			rep.Conflicts = append(rep.Conflicts, src)
+			continue
+		}
+		if err := appendFile(src, dst); err != nil {
+			return err
+		}
+		_ = os.Remove(src)
+		rep.Merged = append(rep.Merged, src)
+	}
+	_ = os.Remove(from)
+	return nil
+}
+
+func appendFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("append %s: %w", src, err)
+	}
+	return nil
+}
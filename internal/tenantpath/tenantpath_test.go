@@ -0,0 +1,109 @@
+package tenantpath
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode_RoundTripAndNoCollision(t *testing.T) {
+	ids := []string{"", "abc", "ABC", "a/b", "a_b", "a_2fb", ".hidden", "..", "用户", "a b", "x\x00y", "Abc"}
+	seen := map[string]string{}
+	for _, id := range ids {
+		enc := Encode(id)
+		assert.NotContains(t, enc, "/")
+		assert.NotContains(t, enc, ".")
+		if prev, ok := seen[strings.ToLower(enc)]; ok {
+			t.Fatalf("collision (case-insensitive) between %q and %q: %s", prev, id, enc)
+		}
+		seen[strings.ToLower(enc)] = id
+
+		dec, err := Decode(enc)
+		require.NoError(t, err)
+		assert.Equal(t, id, dec)
+	}
+
+	// 非规范编码被拒绝
+	for _, bad := range []string{"_61", "A", "_zz", "a.b", "~abc", "_4"} {
+		_, err := Decode(bad)
+		assert.ErrorIs(t, err, ErrNotEncoded, bad)
+	}
+
+	// 超长 ID 退化为哈希
+	long := strings.Repeat("X", 100)
+	assert.True(t, strings.HasPrefix(Encode(long), "~"))
+	assert.LessOrEqual(t, len(Encode(long)), MaxSegmentLen)
+}
+
+func writeFile(t *testing.T, p, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+	require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+}
+
+func readFile(t *testing.T, p string) string {
+	t.Helper()
+	b, err := os.ReadFile(p)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestMigrateLegacy_MovesLegacyDirs(t *testing.T) {
+	base := t.TempDir()
+	writeFile(t, filepath.Join(base, "User1", "a1", "data.jsonl"), "user1\n")
+	// 旧租户名恰好是合法编码（"a_5fb" 解码为 "a_b"），且与另一旧租户 "a_b" 的新名称相同
+	writeFile(t, filepath.Join(base, "u", "a_5fb", "data.jsonl"), "a_5fb\n")
+	writeFile(t, filepath.Join(base, "u", "a_b", "data.jsonl"), "a_b\n")
+
+	rep, err := MigrateLegacy(base)
+	require.NoError(t, err)
+	assert.Len(t, rep.Moved, 3)
+	assert.Empty(t, rep.Merged)
+
+	assert.Equal(t, "user1\n", readFile(t, filepath.Join(Dir(base, "User1", "a1"), "data.jsonl")))
+	assert.Equal(t, "a_5fb\n", readFile(t, filepath.Join(Dir(base, "u", "a_5fb"), "data.jsonl")))
+	assert.Equal(t, "a_b\n", readFile(t, filepath.Join(Dir(base, "u", "a_b"), "data.jsonl")))
+	_, err = os.Stat(filepath.Join(base, "User1"))
+	assert.True(t, os.IsNotExist(err))
+
+	// 已写入标记并清理日志，再次调用为空操作
+	_, err = os.Stat(filepath.Join(base, LayoutMarker))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(base, LayoutMarker+".journal"))
+	assert.True(t, os.IsNotExist(err))
+	rep, err = MigrateLegacy(base)
+	require.NoError(t, err)
+	assert.Empty(t, rep.Moved)
+}
+
+func TestMigrateLegacy_ResumesFromJournal(t *testing.T) {
+	base := t.TempDir()
+	writeFile(t, filepath.Join(base, "u", "a_5fb", "data.jsonl"), "a_5fb\n")
+	writeFile(t, filepath.Join(base, "u", "a_b", "data.jsonl"), "a_b\n")
+
+	// 模拟中断：计划已写入，第一步（移走 a_5fb）已执行并记录，第二步（a_b -> a_5fb）已执行但未记录
+	steps, err := planMigration(base)
+	require.NoError(t, err)
+	require.Len(t, steps, 2)
+	journal := filepath.Join(base, LayoutMarker+".journal")
+	require.NoError(t, writeJournal(journal, steps))
+	var rep MigrationReport
+	for _, st := range steps {
+		require.NoError(t, runStep(base, st, &rep))
+	}
+	f, err := os.OpenFile(journal, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, _ = f.WriteString("0\n")
+	require.NoError(t, f.Close())
+
+	// 此时 u/a_5fb 已是新布局目录（属于 a_b），重跑不能再次编码
+	rep, err = MigrateLegacy(base)
+	require.NoError(t, err)
+	assert.Empty(t, rep.Moved)
+	assert.Equal(t, "a_5fb\n", readFile(t, filepath.Join(Dir(base, "u", "a_5fb"), "data.jsonl")))
+	assert.Equal(t, "a_b\n", readFile(t, filepath.Join(Dir(base, "u", "a_b"), "data.jsonl")))
+}
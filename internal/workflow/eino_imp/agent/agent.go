@@ -6,8 +6,12 @@ import (
 	"io"
 
 	"ahs/internal/config"
+	actx "ahs/internal/context"
 	"ahs/internal/handler"
 	"ahs/internal/service"
+	"ahs/internal/service/usage"
+
+	"github.com/cloudwego/eino/compose"
 )
 
 // agentConfig 配置结构体
@@ -44,6 +48,8 @@ func (p *AgentProcessor) Process(ctx context.Context, input string) (string, err
 	if p.config == nil {
 		p.config = loadAgentConfig()
 	}
+	// 工具写入的记忆记录发起调用的模型
+	ctx = actx.WithProvenance(ctx, actx.Provenance{Model: p.config.Model})
 
 	// 构建图
 	graph, err := p.buildGraph(ctx)
@@ -60,7 +66,7 @@ func (p *AgentProcessor) Process(ctx context.Context, input string) (string, err
 	// 执行图
 	result, err := runnable.Invoke(ctx, map[string]any{
 		"input": input,
-	})
+	}, compose.WithCallbacks(usage.NewCallbackHandler()))
 	if err != nil {
 		return "", fmt.Errorf("invoke graph failed: %w", err)
 	}
@@ -85,6 +91,8 @@ func (p *AgentProcessor) ProcessStream(
 	if p.config == nil {
 		p.config = loadAgentConfig()
 	}
+	// 工具写入的记忆记录发起调用的模型
+	ctx = actx.WithProvenance(ctx, actx.Provenance{Model: p.config.Model})
 
 	// 构建图
 	graph, err := p.buildGraph(ctx)
@@ -101,7 +109,7 @@ func (p *AgentProcessor) ProcessStream(
 	// 流式执行
 	stream, err := runnable.Stream(ctx, map[string]any{
 		"input": input,
-	})
+	}, compose.WithCallbacks(usage.NewCallbackHandler()))
 	if err != nil {
 		return fmt.Errorf("stream graph failed: %w", err)
 	}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	pb "ahs/internal/service/prompt_builder"
+	"ahs/internal/service/usage"
 	rt "ahs/internal/workflow/tools/rag_tool"
 
 	"github.com/cloudwego/eino-ext/components/model/openai"
@@ -52,9 +53,21 @@ func (p *AgentProcessor) buildGraph(ctx context.Context) (*compose.Graph[map[str
 	if err != nil {
 		return nil, fmt.Errorf("create memory query tool failed: %w", err)
 	}
+	mft, err := rt.GetMemoryForgetTool()
+	if err != nil {
+		return nil, fmt.Errorf("create memory forget tool failed: %w", err)
+	}
+	tst, err := rt.GetTripleSaveTool()
+	if err != nil {
+		return nil, fmt.Errorf("create triple save tool failed: %w", err)
+	}
+	tqt, err := rt.GetTripleQueryTool()
+	if err != nil {
+		return nil, fmt.Errorf("create triple query tool failed: %w", err)
+	}
 
 	// 绑定工具到 ChatModel
-	toolsList := []tool.BaseTool{mst, mqt}
+	toolsList := []tool.BaseTool{mst, mqt, mft, tst, tqt}
 	infos := make([]*schema.ToolInfo, 0, len(toolsList))
 	for _, t := range toolsList {
 		info, err := t.Info(ctx)
@@ -148,12 +161,17 @@ func (p *AgentProcessor) newChatTemplate() prompt.ChatTemplate {
 
 func (p *AgentProcessor) newChatModel(ctx context.Context) (model.ToolCallingChatModel, error) {
 	var temp float32 = 0
-	cm, err := openai.NewChatModel(ctx, &openai.ChatModelConfig{
+	cfg := &openai.ChatModelConfig{
 		APIKey:      p.config.APIKey,
 		BaseURL:     p.config.BaseURL,
 		Model:       p.config.Model,
 		Temperature: &temp,
-	})
+	}
+	// 租户超额降级：限制单次生成长度
+	if n, ok := usage.DegradedMaxTokens(ctx); ok && n > 0 {
+		cfg.MaxTokens = &n
+	}
+	cm, err := openai.NewChatModel(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("create chat model failed: %w", err)
 	}
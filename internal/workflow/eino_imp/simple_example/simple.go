@@ -2,15 +2,18 @@ package simpleexample
 
 import (
 	"context"
-	"log"
+	"fmt"
 	"sync"
 
 	"github.com/cloudwego/eino-ext/components/model/openai"
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components"
 	"github.com/cloudwego/eino/components/model"
 	"github.com/cloudwego/eino/schema"
 
 	"ahs/internal/config"
 	"ahs/internal/service"
+	"ahs/internal/service/usage"
 )
 
 var (
@@ -22,7 +25,7 @@ var (
 type SimpleProcessor struct{}
 
 func (p *SimpleProcessor) Process(ctx context.Context, input string) (string, error) {
-	s, err := gen()
+	s, err := gen(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -75,31 +78,34 @@ func initConfig() {
 	})
 }
 
-func newChatModel(ctx context.Context) model.ToolCallingChatModel {
+func newChatModel(ctx context.Context) (model.ToolCallingChatModel, error) {
 	// 确保配置已初始化
 	initConfig()
-	
-	var cm model.ToolCallingChatModel
-	var err error
 
-	cm, err = openai.NewChatModel(ctx, &openai.ChatModelConfig{
+	mc := &openai.ChatModelConfig{
 		APIKey:  cfg.APIKey,
 		BaseURL: cfg.APIBaseURL,
 		Model:   cfg.Model,
-	})
-	if err != nil {
-		log.Fatal(err)
 	}
-	return cm
+	// 租户超额降级：限制单次生成长度
+	if n, ok := usage.DegradedMaxTokens(ctx); ok && n > 0 {
+		mc.MaxTokens = &n
+	}
+	return openai.NewChatModel(ctx, mc)
 }
 
-func gen() (*schema.Message, error) {
-	ctx := context.Background()
+// gen 使用请求 context 调用模型，并挂载用量回调以便计入租户额度
+func gen(ctx context.Context) (*schema.Message, error) {
 	messages := []*schema.Message{{Role: schema.User, Content: input}}
-	cm := newChatModel(ctx)
+	cm, err := newChatModel(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("create chat model failed: %w", err)
+	}
+	ctx = callbacks.InitCallbacks(ctx, &callbacks.RunInfo{Name: "simple_example", Component: components.ComponentOfChatModel},
+		usage.NewCallbackHandler())
 	ret, err := cm.Generate(ctx, messages)
 	if err != nil {
-		log.Fatalf("Generate failed, err=%v", err)
+		return nil, fmt.Errorf("generate failed: %w", err)
 	}
-	return ret, err
+	return ret, nil
 }
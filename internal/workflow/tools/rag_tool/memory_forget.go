@@ -0,0 +1,88 @@
+package ragtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	actx "ahs/internal/context"
+	"ahs/internal/service/rag"
+
+	"github.com/bytedance/sonic"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/components/tool/utils"
+	"go.uber.org/zap"
+)
+
+// GetMemoryForgetTool 创建记忆遗忘工具，供 agent 撤回错误或已被推翻的记忆
+func GetMemoryForgetTool() (tool.InvokableTool, error) {
+	t, err := utils.InferTool(
+		"memory_forget",
+		"按ID删除一条记忆，用于撤回错误或已被推翻的事实",
+		memoryForgetFunc,
+		utils.WithUnmarshalArguments(func(ctx context.Context, arguments string) (interface{}, error) {
+			// 解析 agent 输入的参数
+			var agentInput MemoryForgetInput
+			if err := sonic.UnmarshalString(arguments, &agentInput); err != nil {
+				return nil, fmt.Errorf("参数解析失败: %w", err)
+			}
+
+			// 从上下文注入租户信息，agent 只能删除当前租户的记忆
+			userID, archiveID, err := parseTenantFromContext(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("租户信息解析失败: %w", err)
+			}
+			agentInput.UserID = userID
+			agentInput.ArchiveID = archiveID
+
+			return &agentInput, nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.WrapToolWithErrorHandler(t, func(ctx context.Context, err error) string {
+		return fmt.Sprintf("记忆删除失败: %v", err)
+	}).(tool.InvokableTool), nil
+}
+
+func memoryForgetFunc(ctx context.Context, input *MemoryForgetInput) (*MemoryForgetOutput, error) {
+	if input.ID == "" {
+		return &MemoryForgetOutput{Success: false, Message: "缺少记忆ID"}, nil
+	}
+
+	// 获取 RAG Manager 单例
+	mgr := rag.Default()
+
+	tenant := rag.Tenant{UserID: input.UserID, ArchiveID: input.ArchiveID}
+	if err := mgr.Delete(ctx, tenant, input.ID); err != nil {
+		msg := fmt.Sprintf("删除失败: %v", err)
+		if errors.Is(err, rag.ErrNotFound) {
+			msg = "记忆不存在或已删除"
+		}
+		return &MemoryForgetOutput{
+			Success: false,
+			ID:      input.ID,
+			Message: msg,
+		}, nil // 错误已转为消息，不再向上抛
+	}
+
+	// 删除不可撤销，记录原因便于事后追查
+	fields := []zap.Field{
+		zap.String("用户", input.UserID),
+		zap.String("归档", input.ArchiveID),
+		zap.String("记忆ID", input.ID),
+		zap.String("原因", input.Reason),
+	}
+	if p, ok := actx.GetProvenance(ctx); ok {
+		fields = append(fields, zap.String("运行", p.RunID), zap.String("工具调用", p.ToolCallID))
+	}
+	mgr.Logger().Info("记忆已按 agent 请求删除", fields...)
+
+	return &MemoryForgetOutput{
+		Success: true,
+		ID:      input.ID,
+		Message: "记忆已删除",
+	}, nil
+}
@@ -4,6 +4,7 @@ import (
 	"ahs/internal/service/rag"
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/bytedance/sonic"
 	"github.com/cloudwego/eino/components/tool"
@@ -14,7 +15,7 @@ import (
 func GetMemoryQueryTool() (tool.InvokableTool, error) {
 	t, err := utils.InferTool(
 		"memory_query",
-		"查询记忆系统，支持文本搜索、标签过滤、TopK等",
+		"查询记忆系统，支持文本搜索、标签/类型/时间范围/元数据过滤、排序与分页，以及按时间或章节查询记忆的历史版本",
 		memoryQueryFunc,
 		/*
 			WithUnmarshalArguments 中的匿名函数会在工具运行时被调用，
@@ -66,9 +67,16 @@ func memoryQueryFunc(ctx context.Context, input *MemoryQueryInput) (*MemoryQuery
 			UserID:    input.UserID,
 			ArchiveID: input.ArchiveID,
 		},
-		Query: input.Query,
-		TopK:  input.TopK,
-		Tags:  input.Tags,
+		Query:       input.Query,
+		TopK:        input.TopK,
+		Tags:        input.Tags,
+		TagMode:     input.TagMode,
+		ExcludeTags: input.ExcludeTags,
+		Sort:        input.Sort,
+		Cursor:      input.Cursor,
+		// 混合检索：未配置向量/三元组后端时由 Manager 忽略
+		UseVector: true,
+		UseTriple: true,
 	}
 
 	// 转换 Kinds
@@ -79,6 +87,24 @@ func memoryQueryFunc(ctx context.Context, input *MemoryQueryInput) (*MemoryQuery
 		}
 	}
 
+	// 解析时间范围
+	var err error
+	if req.CreatedAfter, err = parseTimeArg(input.CreatedAfter); err != nil {
+		return &MemoryQueryOutput{Success: false, Message: fmt.Sprintf("created_after 无效: %v", err)}, nil
+	}
+	if req.CreatedBefore, err = parseTimeArg(input.CreatedBefore); err != nil {
+		return &MemoryQueryOutput{Success: false, Message: fmt.Sprintf("created_before 无效: %v", err)}, nil
+	}
+	if req.AsOf, err = parseTimeArg(input.AsOf); err != nil {
+		return &MemoryQueryOutput{Success: false, Message: fmt.Sprintf("as_of 无效: %v", err)}, nil
+	}
+	req.AsOfChapter = input.AsOfChapter
+
+	// 转换元数据过滤
+	for _, f := range input.Meta {
+		req.Meta = append(req.Meta, rag.MetaFilter{Key: f.Key, Op: f.Op, Value: f.Value, Values: f.Values})
+	}
+
 	// 执行查询
 	result, err := mgr.Query(ctx, req)
 	if err != nil {
@@ -96,15 +122,49 @@ func memoryQueryFunc(ctx context.Context, input *MemoryQueryInput) (*MemoryQuery
 			Content:   item.Content,
 			Tags:      item.Tags,
 			Kind:      string(item.Kind),
-			CreatedAt: item.CreatedAt.Format("2006-01-02 15:04:05"),
+			CreatedAt: formatTimeArg(&item.CreatedAt),
 			Score:     item.Score,
+			Sources:   item.Sources,
+
+			Importance:  item.Importance,
+			AccessCount: item.AccessCount,
+			Version:     item.Version,
 		}
 	}
 
+	msg := fmt.Sprintf("查询成功，返回 %d 条记忆", len(items))
+	if result.NextCursor != "" {
+		msg += "，还有更多结果，可用 next_cursor 翻页"
+	}
 	return &MemoryQueryOutput{
-		Success: true,
-		Items:   items,
-		Count:   len(items),
-		Message: fmt.Sprintf("查询成功，返回 %d 条记忆", len(items)),
+		Success:    true,
+		Items:      items,
+		Count:      len(items),
+		NextCursor: result.NextCursor,
+		Message:    msg,
 	}, nil
 }
+
+// timeArgLayout 工具输出时间使用的格式，parseTimeArg 可原样解析
+const timeArgLayout = "2006-01-02 15:04:05"
+
+// parseTimeArg 解析工具的时间参数（RFC3339、2006-01-02 15:04:05 或 2006-01-02）：空串返回 nil；无时区的格式按本地时间
+func parseTimeArg(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, timeArgLayout, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("无法解析时间 %q", s)
+}
+
+// formatTimeArg 按 timeArgLayout 输出时间，nil 返回空串
+func formatTimeArg(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.In(time.Local).Format(timeArgLayout)
+}
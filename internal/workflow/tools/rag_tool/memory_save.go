@@ -5,19 +5,21 @@ import (
 	"fmt"
 	"time"
 
+	actx "ahs/internal/context"
 	"ahs/internal/handler"
 	"ahs/internal/service/rag"
 
 	"github.com/bytedance/sonic"
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/components/tool/utils"
+	"github.com/cloudwego/eino/compose"
 )
 
 // 创建工具
 func GetMemorySaveTool() (tool.InvokableTool, error) {
 	t, err := utils.InferTool(
 		"memory_save",
-		"保存记忆到系统，支持标签、类型、TTL等",
+		"保存记忆到系统，支持标签、类型、重要性、TTL等",
 		memorySaveFunc,
 		utils.WithUnmarshalArguments(func(ctx context.Context, arguments string) (interface{}, error) {
 			// 解析 agent 输入的参数
@@ -46,36 +48,32 @@ func GetMemorySaveTool() (tool.InvokableTool, error) {
 	}).(tool.InvokableTool), nil
 }
 
+// parseTenantFromContext 通过统一解析器获取当前请求的租户
+// 以认证后的请求头租户为准，请求体与请求头不一致时拒绝
 func parseTenantFromContext(ctx context.Context) (string, string, error) {
-	// 从上下文中获取原始请求体并解析 user_id / archive_id
-	b, ok := handler.GetRequestBody(ctx)
-	if !ok || len(b) == 0 {
-		return "", "", fmt.Errorf("上下文中没有原始请求体")
-	}
-	var payload struct {
-		UserID    string `json:"user_id"`
-		ArchiveID string `json:"archive_id"`
-	}
-	if err := sonic.Unmarshal(b, &payload); err != nil {
-		return "", "", fmt.Errorf("解析上下文请求体失败: %w", err)
+	t, err := handler.ResolveTenant(ctx)
+	if err != nil {
+		return "", "", err
 	}
-	return payload.UserID, payload.ArchiveID, nil
+	return t.UserID, t.ArchiveID, nil
 }
 
 func memorySaveFunc(ctx context.Context, input *MemorySaveInput) (*MemorySaveOutput, error) {
 	// 获取 RAG Manager 单例
 	mgr := rag.Default()
 
-	// 构建记忆项
+	// 构建记忆项（预先分配 ID，便于回传给 agent 后续引用）
 	item := rag.MemoryItem{
+		ID: rag.NewID(),
 		Tenant: rag.Tenant{
 			UserID:    input.UserID,
 			ArchiveID: input.ArchiveID,
 		},
-		Content:   input.Content,
-		Tags:      input.Tags,
-		Kind:      rag.MemoryKind(input.Kind),
-		CreatedAt: time.Now(),
+		Content:    input.Content,
+		Tags:       input.Tags,
+		Kind:       rag.MemoryKind(input.Kind),
+		CreatedAt:  time.Now(),
+		Importance: input.Importance,
 	}
 
 	// 处理 TTL
@@ -84,10 +82,16 @@ func memorySaveFunc(ctx context.Context, input *MemorySaveInput) (*MemorySaveOut
 		item.ExpiresAt = &expireAt
 	}
 
-	// 保存（异步/同步由 Manager 内部决定）
-	err := mgr.Save(ctx, item, rag.SaveOptions{
+	// 记录触发本次写入的工具调用（运行、会话、模型等来源由上游写入 context）
+	if id := compose.GetToolCallID(ctx); id != "" {
+		ctx = actx.WithProvenance(ctx, actx.Provenance{ToolCallID: id})
+	}
+
+	// 保存（异步/同步由 Manager 内部决定；启用近重复检测时可能合并进已有记忆）
+	res, err := mgr.SaveWithResult(ctx, item, rag.SaveOptions{
 		ToMemory: true,
 		ToDisk:   true,
+		ToVector: true, // 未启用向量后端时忽略
 	})
 
 	if err != nil {
@@ -97,9 +101,17 @@ func memorySaveFunc(ctx context.Context, input *MemorySaveInput) (*MemorySaveOut
 		}, nil // 错误已转为消息，不再向上抛
 	}
 
+	if res.Merged {
+		return &MemorySaveOutput{
+			Success: true,
+			ID:      res.ID,
+			Merged:  true,
+			Message: fmt.Sprintf("已存在相似记忆（相似度 %.2f），已合并到记忆 %s，未新增条目", res.Similarity, res.ID),
+		}, nil
+	}
 	return &MemorySaveOutput{
 		Success: true,
-		ID:      item.ID,
+		ID:      res.ID,
 		Message: "记忆保存成功",
 	}, nil
 }
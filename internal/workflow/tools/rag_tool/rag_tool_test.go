@@ -1,9 +1,12 @@
 package ragtool
 
 import (
+	actx "ahs/internal/context"
 	"ahs/internal/handler"
 	"ahs/internal/service/rag"
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -11,6 +14,8 @@ import (
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 // __测试目标__
@@ -18,6 +23,28 @@ import (
 // 2) 工具接口测试：验证工具符合 Eino InvokableTool 接口规范（Info(ctx)、InvokableRun）。
 // 3) 错误处理测试：验证 WrapToolWithErrorHandler 将错误转换为字符串结果并不返回 error。
 
+// TestMain 将默认 Manager 的全部数据目录指向临时目录，测试数据不落在包目录下，也不在多次运行间残留
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "rag_tool_test")
+	if err != nil {
+		panic(err)
+	}
+	opts := rag.DefaultOptions()
+	opts.DiskJSON.RootPath = filepath.Join(dir, "rag")
+	opts.Bolt.RootPath = filepath.Join(dir, "rag_bolt")
+	opts.Vector.RootPath = filepath.Join(dir, "rag_vector")
+	opts.Triple.Enable = true
+	opts.Triple.RootPath = filepath.Join(dir, "rag_triple")
+	opts.Async.SpoolPath = filepath.Join(dir, "rag_spool")
+	opts.Ranking.AccessPath = filepath.Join(dir, "rag_access")
+	if err := rag.InitDefault(opts, nil, nil); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestMemoryQuery_Interface_Info(t *testing.T) {
 	// 获取工具并断言接口
 	qTool, err := GetMemoryQueryTool()
@@ -168,3 +195,303 @@ func TestMemorySave_WithUnmarshalArguments_Injection(t *testing.T) {
 	assert.True(t, out.Success)
 	assert.Contains(t, out.Message, "记忆保存成功")
 }
+
+func TestMemoryTools_TenantResolution_HeaderWinsAndMismatchRejected(t *testing.T) {
+	sTool, err := GetMemorySaveTool()
+	require.NoError(t, err)
+	qTool, err := GetMemoryQueryTool()
+	require.NoError(t, err)
+
+	// 仅有中间件注入的租户（无请求体）也可工作
+	hdrCtx := actx.WithTenant(context.Background(), "u_hdr", "a_hdr")
+	saveArgs, _ := sonic.MarshalString(map[string]any{"content": "来自请求头租户", "kind": "note"})
+	result, err := sTool.InvokableRun(hdrCtx, saveArgs)
+	require.NoError(t, err)
+	var sOut MemorySaveOutput
+	require.NoError(t, sonic.UnmarshalString(result, &sOut))
+	assert.True(t, sOut.Success)
+
+	// 请求体试图写入其他租户：拒绝
+	bodyBytes, _ := sonic.Marshal(map[string]any{"user_id": "victim", "archive_id": "a_hdr"})
+	forged := handler.WithRequestBody(hdrCtx, bodyBytes)
+	result, err = sTool.InvokableRun(forged, saveArgs)
+	require.NoError(t, err)
+	assert.Contains(t, result, "记忆保存失败")
+
+	result, err = qTool.InvokableRun(forged, `{"query":""}`)
+	require.NoError(t, err)
+	assert.Contains(t, result, "记忆查询失败")
+
+	// 请求体与请求头一致：正常
+	bodyBytes, _ = sonic.Marshal(map[string]any{"user_id": "u_hdr", "archive_id": "a_hdr"})
+	result, err = qTool.InvokableRun(handler.WithRequestBody(hdrCtx, bodyBytes), `{"query":""}`)
+	require.NoError(t, err)
+	var qOut MemoryQueryOutput
+	require.NoError(t, sonic.UnmarshalString(result, &qOut))
+	assert.True(t, qOut.Success)
+}
+
+func TestMemoryForget_SaveThenForget(t *testing.T) {
+	ctx := actx.WithTenant(context.Background(), "u_forget", "a_forget")
+	tenant := rag.Tenant{UserID: "u_forget", ArchiveID: "a_forget"}
+
+	sTool, err := GetMemorySaveTool()
+	require.NoError(t, err)
+	fTool, err := GetMemoryForgetTool()
+	require.NoError(t, err)
+	ti, err := fTool.Info(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "memory_forget", ti.Name)
+
+	// 保存后返回可引用的 ID
+	saveArgs, _ := sonic.MarshalString(map[string]any{"content": "主角是左撇子", "kind": "fact"})
+	result, err := sTool.InvokableRun(ctx, saveArgs)
+	require.NoError(t, err)
+	var sOut MemorySaveOutput
+	require.NoError(t, sonic.UnmarshalString(result, &sOut))
+	require.True(t, sOut.Success)
+	require.NotEmpty(t, sOut.ID)
+
+	// 默认异步写入，等待落地
+	require.Eventually(t, func() bool {
+		_, err := rag.Default().Get(context.Background(), tenant, sOut.ID)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// 其他租户无法删除
+	otherCtx := actx.WithTenant(context.Background(), "u_other", "a_other")
+	forgetArgs, _ := sonic.MarshalString(map[string]any{"id": sOut.ID, "reason": "事实错误"})
+	result, err = fTool.InvokableRun(otherCtx, forgetArgs)
+	require.NoError(t, err)
+	var fOut MemoryForgetOutput
+	require.NoError(t, sonic.UnmarshalString(result, &fOut))
+	assert.False(t, fOut.Success)
+
+	// 删除时记录 agent 给出的原因
+	core, logs := observer.New(zap.InfoLevel)
+	rag.Default().SetLogger(zap.New(core))
+	t.Cleanup(func() { rag.Default().SetLogger(nil) })
+
+	result, err = fTool.InvokableRun(ctx, forgetArgs)
+	require.NoError(t, err)
+	require.NoError(t, sonic.UnmarshalString(result, &fOut))
+	assert.True(t, fOut.Success)
+	_, err = rag.Default().Get(context.Background(), tenant, sOut.ID)
+	assert.ErrorIs(t, err, rag.ErrNotFound)
+
+	entries := logs.FilterField(zap.String("记忆ID", sOut.ID)).All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "事实错误", entries[0].ContextMap()["原因"])
+}
+
+func TestTripleTools_SaveThenQuery(t *testing.T) {
+	ctx := actx.WithTenant(context.Background(), "u_triple", "a_triple")
+
+	sTool, err := GetTripleSaveTool()
+	require.NoError(t, err)
+	qTool, err := GetTripleQueryTool()
+	require.NoError(t, err)
+	ti, err := qTool.Info(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "triple_query", ti.Name)
+
+	for _, args := range []map[string]any{
+		{"subject": "林夏", "predicate": "sister_of", "object": "林秋", "provenance": "第1章"},
+		{"subject": "林秋", "predicate": "lives_in", "object": "王城"},
+	} {
+		s, _ := sonic.MarshalString(args)
+		result, err := sTool.InvokableRun(ctx, s)
+		require.NoError(t, err)
+		var out TripleSaveOutput
+		require.NoError(t, sonic.UnmarshalString(result, &out))
+		require.True(t, out.Success, out.Message)
+		require.NotEmpty(t, out.ID)
+	}
+
+	// 时间格式错误转为消息
+	result, err := sTool.InvokableRun(ctx, `{"subject":"a","predicate":"p","object":"b","valid_from":"昨天"}`)
+	require.NoError(t, err)
+	var bad TripleSaveOutput
+	require.NoError(t, sonic.UnmarshalString(result, &bad))
+	assert.False(t, bad.Success)
+
+	// 两跳邻域
+	result, err = qTool.InvokableRun(ctx, `{"entity":"林夏","hops":2}`)
+	require.NoError(t, err)
+	var qOut TripleQueryOutput
+	require.NoError(t, sonic.UnmarshalString(result, &qOut))
+	require.True(t, qOut.Success)
+	assert.Equal(t, 2, qOut.Count)
+
+	// 查询输出的时间可原样作为参数传回
+	result, err = sTool.InvokableRun(ctx, `{"subject":"林夏","predicate":"works_at","object":"书院","valid_from":"2020-01-02T08:00:00+08:00"}`)
+	require.NoError(t, err)
+	result, err = qTool.InvokableRun(ctx, `{"subject":"林夏","predicate":"works_at"}`)
+	require.NoError(t, err)
+	require.NoError(t, sonic.UnmarshalString(result, &qOut))
+	require.Equal(t, 1, qOut.Count)
+	validFrom := qOut.Triples[0].ValidFrom
+	require.NotEmpty(t, validFrom)
+	asOf, _ := sonic.MarshalString(map[string]any{"subject": "林夏", "predicate": "works_at", "as_of": validFrom})
+	result, err = qTool.InvokableRun(ctx, asOf)
+	require.NoError(t, err)
+	require.NoError(t, sonic.UnmarshalString(result, &qOut))
+	require.True(t, qOut.Success, qOut.Message)
+	assert.Equal(t, 1, qOut.Count)
+
+	// 其他租户看不到
+	otherCtx := actx.WithTenant(context.Background(), "u_other", "a_other")
+	result, err = qTool.InvokableRun(otherCtx, `{"subject":"林夏"}`)
+	require.NoError(t, err)
+	require.NoError(t, sonic.UnmarshalString(result, &qOut))
+	assert.Equal(t, 0, qOut.Count)
+}
+
+func TestMemoryQuery_FiltersAndCursor(t *testing.T) {
+	ctx := actx.WithTenant(context.Background(), "u_filter", "a_filter")
+	tenant := rag.Tenant{UserID: "u_filter", ArchiveID: "a_filter"}
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	for i, tags := range [][]string{{"林夏"}, {"沈舟"}, {"林夏", "回忆"}, {"沈舟"}, {"旁白"}} {
+		it := rag.MemoryItem{
+			ID: "f" + string(rune('0'+i)), Tenant: tenant, Content: "第一卷", Tags: tags,
+			Meta: map[string]any{"chapter": i + 1}, CreatedAt: base.Add(time.Duration(i) * time.Hour),
+		}
+		require.NoError(t, rag.Default().Save(context.Background(), it, rag.SaveOptions{ToMemory: true}))
+	}
+
+	qTool, err := GetMemoryQueryTool()
+	require.NoError(t, err)
+	run := func(args map[string]any) MemoryQueryOutput {
+		s, _ := sonic.MarshalString(args)
+		result, err := qTool.InvokableRun(ctx, s)
+		require.NoError(t, err)
+		var out MemoryQueryOutput
+		require.NoError(t, sonic.UnmarshalString(result, &out))
+		return out
+	}
+	idsOf := func(out MemoryQueryOutput) []string {
+		var res []string
+		for _, it := range out.Items {
+			res = append(res, it.ID)
+		}
+		return res
+	}
+
+	// 任一标签 + 排除标签 + 时间范围 + 元数据，按时间分页
+	args := map[string]any{
+		"tags": []string{"林夏", "沈舟"}, "tag_mode": "any", "exclude_tags": []string{"回忆"},
+		"created_after": "2026-03-01 12:00:00", "created_before": base.Add(4 * time.Hour).Format(time.RFC3339),
+		"meta":  []map[string]any{{"key": "chapter", "op": "in", "values": []int{1, 2, 4}}},
+		"top_k": 2,
+	}
+	out := run(args)
+	require.True(t, out.Success, out.Message)
+	assert.Equal(t, []string{"f3", "f1"}, idsOf(out))
+	require.NotEmpty(t, out.NextCursor)
+
+	args["cursor"] = out.NextCursor
+	out = run(args)
+	require.True(t, out.Success, out.Message)
+	assert.Equal(t, []string{"f0"}, idsOf(out))
+	assert.Empty(t, out.NextCursor)
+
+	// 游标与查询条件不一致时拒绝
+	args["tag_mode"] = "all"
+	out = run(args)
+	assert.False(t, out.Success)
+	assert.Contains(t, out.Message, "游标")
+
+	out = run(map[string]any{"created_after": "昨天"})
+	assert.False(t, out.Success)
+	assert.Contains(t, out.Message, "created_after")
+}
+
+func TestMemorySave_Importance(t *testing.T) {
+	ctx := actx.WithTenant(context.Background(), "u_importance", "a_importance")
+	tenant := rag.Tenant{UserID: "u_importance", ArchiveID: "a_importance"}
+	sTool, err := GetMemorySaveTool()
+	require.NoError(t, err)
+	save := func(args map[string]any) MemorySaveOutput {
+		s, _ := sonic.MarshalString(args)
+		result, err := sTool.InvokableRun(ctx, s)
+		require.NoError(t, err)
+		var out MemorySaveOutput
+		require.NoError(t, sonic.UnmarshalString(result, &out))
+		return out
+	}
+
+	out := save(map[string]any{"content": "女主的真实身份是前朝公主", "kind": "fact", "importance": 0.9})
+	require.True(t, out.Success, out.Message)
+	require.Eventually(t, func() bool {
+		it, err := rag.Default().Get(context.Background(), tenant, out.ID)
+		return err == nil && it.Importance == 0.9
+	}, time.Second, 10*time.Millisecond)
+
+	out = save(map[string]any{"content": "超出范围", "importance": 2})
+	assert.False(t, out.Success)
+	assert.Contains(t, out.Message, "importance")
+}
+
+func TestMemorySave_RecordsProvenance(t *testing.T) {
+	ctx := actx.WithTenant(context.Background(), "u_prov", "a_prov")
+	runID := "run-tool-" + rag.NewID()
+	ctx = actx.WithProvenance(ctx, actx.Provenance{RunID: runID, Workflow: "agent", Model: "glm-4.5"})
+	tenant := rag.Tenant{UserID: "u_prov", ArchiveID: "a_prov"}
+	sTool, err := GetMemorySaveTool()
+	require.NoError(t, err)
+
+	result, err := sTool.InvokableRun(ctx, `{"content":"主角叫林夏","kind":"fact"}`)
+	require.NoError(t, err)
+	var out MemorySaveOutput
+	require.NoError(t, sonic.UnmarshalString(result, &out))
+	require.True(t, out.Success, out.Message)
+
+	// 按运行 ID 可找回本次写入
+	require.Eventually(t, func() bool {
+		items, err := rag.Default().RunItems(context.Background(), tenant, runID)
+		return err == nil && len(items) == 1 && items[0].ID == out.ID
+	}, time.Second, 10*time.Millisecond)
+	it, err := rag.Default().Get(context.Background(), tenant, out.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "agent", it.Meta[rag.MetaWorkflow])
+	assert.Equal(t, "glm-4.5", it.Meta[rag.MetaModel])
+}
+
+func TestMemoryQuery_AsOfChapter(t *testing.T) {
+	ctx := actx.WithTenant(context.Background(), "u_ver", "a_ver")
+	tenant := rag.Tenant{UserID: "u_ver", ArchiveID: "a_ver"}
+	mgr := rag.Default()
+	id := "ver-" + rag.NewID()
+	require.NoError(t, mgr.Save(context.Background(), rag.MemoryItem{ID: id, Tenant: tenant, Kind: rag.KindFact, Content: id + " 十六岁", Meta: map[string]any{rag.MetaChapter: 1}}, rag.SaveOptions{ToMemory: true, ToDisk: true}))
+	require.Eventually(t, func() bool {
+		_, err := mgr.Get(context.Background(), tenant, id)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	it, err := mgr.Get(context.Background(), tenant, id)
+	require.NoError(t, err)
+	it.Content = id + " 十七岁"
+	it.Meta = map[string]any{rag.MetaChapter: 5}
+	require.NoError(t, mgr.Update(context.Background(), it))
+
+	qTool, err := GetMemoryQueryTool()
+	require.NoError(t, err)
+	// 归档中可能有此前运行留下的数据，按 ID 取本次写入的记忆
+	query := func(args string) MemoryItemView {
+		result, err := qTool.InvokableRun(ctx, args)
+		require.NoError(t, err)
+		var out MemoryQueryOutput
+		require.NoError(t, sonic.UnmarshalString(result, &out))
+		require.True(t, out.Success, out.Message)
+		for _, it := range out.Items {
+			if it.ID == id {
+				return it
+			}
+		}
+		t.Fatalf("memory %s not found: %+v", id, out.Items)
+		return MemoryItemView{}
+	}
+	assert.Equal(t, id+" 十六岁", query(`{"query":"`+id+`","as_of_chapter":3}`).Content)
+	cur := query(`{"query":"` + id + `"}`)
+	assert.Equal(t, id+" 十七岁", cur.Content)
+	assert.Equal(t, 2, cur.Version)
+}
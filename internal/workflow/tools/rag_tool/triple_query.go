@@ -0,0 +1,105 @@
+package ragtool
+
+import (
+	"context"
+	"fmt"
+
+	"ahs/internal/service/rag"
+
+	"github.com/bytedance/sonic"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/components/tool/utils"
+)
+
+// GetTripleQueryTool 创建三元组查询工具，支持模式匹配与实体邻域展开
+func GetTripleQueryTool() (tool.InvokableTool, error) {
+	t, err := utils.InferTool(
+		"triple_query",
+		"查询结构化事实：按主语/谓词/宾语模式匹配，或以某个实体为中心展开1~2跳关系",
+		tripleQueryFunc,
+		utils.WithUnmarshalArguments(func(ctx context.Context, arguments string) (interface{}, error) {
+			// 解析 agent 输入的参数
+			var agentInput TripleQueryInput
+			if err := sonic.UnmarshalString(arguments, &agentInput); err != nil {
+				return nil, fmt.Errorf("参数解析失败: %w", err)
+			}
+
+			// 注入系统级参数（不需要 agent 处理的）
+			userID, archiveID, err := parseTenantFromContext(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("租户信息解析失败: %w", err)
+			}
+			agentInput.UserID = userID
+			agentInput.ArchiveID = archiveID
+
+			return &agentInput, nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.WrapToolWithErrorHandler(t, func(ctx context.Context, err error) string {
+		return fmt.Sprintf("三元组查询失败: %v", err)
+	}).(tool.InvokableTool), nil
+}
+
+func tripleQueryFunc(ctx context.Context, input *TripleQueryInput) (*TripleQueryOutput, error) {
+	ts, ok := rag.Default().Triples()
+	if !ok {
+		return &TripleQueryOutput{Success: false, Message: "三元组存储未启用"}, nil
+	}
+
+	asOf, err := parseTimeArg(input.AsOf)
+	if err != nil {
+		return &TripleQueryOutput{Success: false, Message: fmt.Sprintf("as_of 格式错误: %v", err)}, nil
+	}
+	limit := input.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+
+	tenant := rag.Tenant{UserID: input.UserID, ArchiveID: input.ArchiveID}
+	var triples []rag.Triple
+	if input.Entity != "" {
+		triples, err = ts.Neighborhood(ctx, tenant, input.Entity, input.Hops, asOf)
+		if len(triples) > limit {
+			triples = triples[:limit]
+		}
+	} else {
+		triples, err = ts.MatchTriples(ctx, tenant, rag.TriplePattern{
+			Subject:   input.Subject,
+			Predicate: input.Predicate,
+			Object:    input.Object,
+			AsOf:      asOf,
+			Limit:     limit,
+		})
+	}
+	if err != nil {
+		return &TripleQueryOutput{
+			Success: false,
+			Message: fmt.Sprintf("查询失败: %v", err),
+		}, nil // 错误已转为消息，不再向上抛
+	}
+
+	views := make([]TripleView, len(triples))
+	for i, tr := range triples {
+		views[i] = TripleView{
+			ID:         tr.ID,
+			Subject:    tr.Subject,
+			Predicate:  tr.Predicate,
+			Object:     tr.Object,
+			Provenance: tr.Provenance,
+			SourceID:   tr.SourceID,
+			ValidFrom:  formatTimeArg(tr.ValidFrom),
+			ValidTo:    formatTimeArg(tr.ValidTo),
+		}
+	}
+
+	return &TripleQueryOutput{
+		Success: true,
+		Triples: views,
+		Count:   len(views),
+		Message: fmt.Sprintf("查询成功，返回 %d 条三元组", len(views)),
+	}, nil
+}
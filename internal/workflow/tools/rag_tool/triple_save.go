@@ -0,0 +1,92 @@
+package ragtool
+
+import (
+	"context"
+	"fmt"
+
+	actx "ahs/internal/context"
+	"ahs/internal/service/rag"
+
+	"github.com/bytedance/sonic"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/components/tool/utils"
+	"github.com/cloudwego/eino/compose"
+)
+
+// GetTripleSaveTool 创建三元组保存工具，供 agent 记录人物、地点之间的结构化关系
+func GetTripleSaveTool() (tool.InvokableTool, error) {
+	t, err := utils.InferTool(
+		"triple_save",
+		"保存一条结构化事实（主语-谓词-宾语），如 林夏 sister_of 林秋；相同三元组重复保存会更新出处与有效期",
+		tripleSaveFunc,
+		utils.WithUnmarshalArguments(func(ctx context.Context, arguments string) (interface{}, error) {
+			// 解析 agent 输入的参数
+			var agentInput TripleSaveInput
+			if err := sonic.UnmarshalString(arguments, &agentInput); err != nil {
+				return nil, fmt.Errorf("参数解析失败: %w", err)
+			}
+
+			// 注入系统级参数（不需要 agent 处理的）
+			userID, archiveID, err := parseTenantFromContext(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("租户信息解析失败: %w", err)
+			}
+			agentInput.UserID = userID
+			agentInput.ArchiveID = archiveID
+
+			return &agentInput, nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.WrapToolWithErrorHandler(t, func(ctx context.Context, err error) string {
+		return fmt.Sprintf("三元组保存失败: %v", err)
+	}).(tool.InvokableTool), nil
+}
+
+func tripleSaveFunc(ctx context.Context, input *TripleSaveInput) (*TripleSaveOutput, error) {
+	ts, ok := rag.Default().Triples()
+	if !ok {
+		return &TripleSaveOutput{Success: false, Message: "三元组存储未启用"}, nil
+	}
+
+	tr := rag.Triple{
+		Tenant: rag.Tenant{
+			UserID:    input.UserID,
+			ArchiveID: input.ArchiveID,
+		},
+		Subject:    input.Subject,
+		Predicate:  input.Predicate,
+		Object:     input.Object,
+		Provenance: input.Provenance,
+		SourceID:   input.SourceID,
+	}
+	var err error
+	if tr.ValidFrom, err = parseTimeArg(input.ValidFrom); err != nil {
+		return &TripleSaveOutput{Success: false, Message: fmt.Sprintf("valid_from 格式错误: %v", err)}, nil
+	}
+	if tr.ValidTo, err = parseTimeArg(input.ValidTo); err != nil {
+		return &TripleSaveOutput{Success: false, Message: fmt.Sprintf("valid_to 格式错误: %v", err)}, nil
+	}
+
+	// 记录触发本次写入的工具调用（运行、会话、模型等来源由上游写入 context）
+	if id := compose.GetToolCallID(ctx); id != "" {
+		ctx = actx.WithProvenance(ctx, actx.Provenance{ToolCallID: id})
+	}
+
+	saved, err := ts.SaveTriple(ctx, tr)
+	if err != nil {
+		return &TripleSaveOutput{
+			Success: false,
+			Message: fmt.Sprintf("保存失败: %v", err),
+		}, nil // 错误已转为消息，不再向上抛
+	}
+
+	return &TripleSaveOutput{
+		Success: true,
+		ID:      saved.ID,
+		Message: fmt.Sprintf("已保存: %s", saved),
+	}, nil
+}
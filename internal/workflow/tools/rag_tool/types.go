@@ -6,6 +6,8 @@ type MemorySaveInput struct {
 	Tags    []string `json:"tags,omitempty" jsonschema:"description=标签列表"`
 	Kind    string   `json:"kind,omitempty" jsonschema:"description=记忆类型,enum=short_term|long_term|fact|note"`
 	TTL     int      `json:"ttl_seconds,omitempty" jsonschema:"description=过期时间(秒)"`
+	// Importance 0 表示未设置，由检索排序按默认重要性计
+	Importance float64 `json:"importance,omitempty" jsonschema:"description=重要性(0~1)，越高越优先被检索到，如主线设定 0.9、闲聊细节 0.2；缺省为 0.5"`
 
 	// 这些字段不会出现在工具的 schema 中，agent 无法直接设置
 	UserID    string `json:"user_id,omitempty"`
@@ -16,6 +18,7 @@ type MemorySaveInput struct {
 type MemorySaveOutput struct {
 	Success bool   `json:"success"`
 	ID      string `json:"id,omitempty"`
+	Merged  bool   `json:"merged,omitempty"` // 与已有记忆近似重复，已合并进 ID 对应的记忆
 	Message string `json:"message"`
 }
 
@@ -27,17 +30,36 @@ type MemoryQueryInput struct {
 	Tags  []string `json:"tags,omitempty" jsonschema:"description=标签过滤"`
 	Kinds []string `json:"kinds,omitempty" jsonschema:"description=类型过滤"`
 
+	TagMode       string            `json:"tag_mode,omitempty" jsonschema:"description=标签匹配方式：all 包含全部标签（默认），any 包含任一标签,enum=all|any"`
+	ExcludeTags   []string          `json:"exclude_tags,omitempty" jsonschema:"description=排除包含其中任一标签的记忆"`
+	CreatedAfter  string            `json:"created_after,omitempty" jsonschema:"description=仅返回此时间及之后创建的记忆，格式 2006-01-02 15:04:05 或 RFC3339"`
+	CreatedBefore string            `json:"created_before,omitempty" jsonschema:"description=仅返回此时间之前创建的记忆，格式同 created_after"`
+	Meta          []MetaFilterInput `json:"meta,omitempty" jsonschema:"description=元数据过滤，全部条件同时满足"`
+	Sort          string            `json:"sort,omitempty" jsonschema:"description=排序：recency 从新到旧，score 按相关度；缺省时有 query 按相关度，否则按时间,enum=recency|score"`
+	Cursor        string            `json:"cursor,omitempty" jsonschema:"description=分页游标，取上次结果的 next_cursor；其余参数需与上次一致"`
+	AsOf          string            `json:"as_of,omitempty" jsonschema:"description=返回该时间有效的历史版本（记忆被修改过时），格式同 created_after"`
+	AsOfChapter   int               `json:"as_of_chapter,omitempty" jsonschema:"description=返回该章节有效的历史版本（按记忆 meta.chapter 标记），如回顾第 3 章时人物的年龄"`
+
 	// 这些字段不会出现在工具的 schema 中，agent 无法直接设置
 	UserID    string `json:"user_id,omitempty"`
 	ArchiveID string `json:"archive_id,omitempty"`
 }
 
+// MetaFilterInput 元数据过滤条件
+type MetaFilterInput struct {
+	Key    string `json:"key" jsonschema:"required,description=元数据键"`
+	Op     string `json:"op,omitempty" jsonschema:"description=谓词：eq 等于 value（默认），in 等于 values 之一，exists 键存在（value 为 false 时要求不存在）,enum=eq|in|exists"`
+	Value  any    `json:"value,omitempty" jsonschema:"description=eq 的比较值；exists 时为 true/false"`
+	Values []any  `json:"values,omitempty" jsonschema:"description=in 的候选值"`
+}
+
 // MemoryQueryOutput 查询结果
 type MemoryQueryOutput struct {
-	Success bool             `json:"success"`
-	Items   []MemoryItemView `json:"items"`
-	Count   int              `json:"count"`
-	Message string           `json:"message"`
+	Success    bool             `json:"success"`
+	Items      []MemoryItemView `json:"items"`
+	Count      int              `json:"count"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	Message    string           `json:"message"`
 }
 
 // MemoryItemView 记忆项视图（简化版）
@@ -48,4 +70,84 @@ type MemoryItemView struct {
 	Kind      string   `json:"kind,omitempty"`
 	CreatedAt string   `json:"created_at"`
 	Score     float64  `json:"score,omitempty"`
+	Sources   []string `json:"sources,omitempty"`
+
+	Importance  float64 `json:"importance,omitempty"`
+	AccessCount int     `json:"access_count,omitempty"`
+	Version     int     `json:"version,omitempty"` // 大于 1 表示记忆被修改过
+}
+
+// MemoryForgetInput 遗忘（删除）记忆输入参数
+type MemoryForgetInput struct {
+	ID     string `json:"id" jsonschema:"required,description=要删除的记忆ID（来自 memory_save 或 memory_query 的结果）"`
+	Reason string `json:"reason,omitempty" jsonschema:"description=删除原因，如事实错误或已被推翻"`
+
+	// 这些字段不会出现在工具的 schema 中，agent 无法直接设置
+	UserID    string `json:"user_id,omitempty"`
+	ArchiveID string `json:"archive_id,omitempty"`
+}
+
+// MemoryForgetOutput 遗忘结果
+type MemoryForgetOutput struct {
+	Success bool   `json:"success"`
+	ID      string `json:"id,omitempty"`
+	Message string `json:"message"`
+}
+
+// TripleSaveInput 保存三元组输入参数
+type TripleSaveInput struct {
+	Subject    string `json:"subject" jsonschema:"required,description=主语实体，如人物或地点名称"`
+	Predicate  string `json:"predicate" jsonschema:"required,description=关系谓词，建议使用英文蛇形命名，如 sister_of、located_in"`
+	Object     string `json:"object" jsonschema:"required,description=宾语实体"`
+	Provenance string `json:"provenance,omitempty" jsonschema:"description=出处，如章节号或原文摘录"`
+	SourceID   string `json:"source_id,omitempty" jsonschema:"description=来源记忆ID（可选）"`
+	ValidFrom  string `json:"valid_from,omitempty" jsonschema:"description=生效时间，格式 2006-01-02 15:04:05、2006-01-02 或 RFC3339"`
+	ValidTo    string `json:"valid_to,omitempty" jsonschema:"description=失效时间，格式同 valid_from"`
+
+	// 这些字段不会出现在工具的 schema 中，agent 无法直接设置
+	UserID    string `json:"user_id,omitempty"`
+	ArchiveID string `json:"archive_id,omitempty"`
+}
+
+// TripleSaveOutput 保存三元组结果
+type TripleSaveOutput struct {
+	Success bool   `json:"success"`
+	ID      string `json:"id,omitempty"`
+	Message string `json:"message"`
+}
+
+// TripleQueryInput 查询三元组输入参数
+// 指定 entity 时返回其邻域，否则按 subject/predicate/object 模式匹配（空为通配）
+type TripleQueryInput struct {
+	Subject   string `json:"subject,omitempty" jsonschema:"description=主语过滤"`
+	Predicate string `json:"predicate,omitempty" jsonschema:"description=谓词过滤"`
+	Object    string `json:"object,omitempty" jsonschema:"description=宾语过滤"`
+	Entity    string `json:"entity,omitempty" jsonschema:"description=以该实体为中心展开邻域（优先于模式匹配）"`
+	Hops      int    `json:"hops,omitempty" jsonschema:"description=邻域跳数,1或2,默认1"`
+	AsOf      string `json:"as_of,omitempty" jsonschema:"description=按该时间判断有效期，默认当前时间，格式同 triple_save 的 valid_from"`
+	Limit     int    `json:"limit,omitempty" jsonschema:"description=最多返回条数,默认20"`
+
+	// 这些字段不会出现在工具的 schema 中，agent 无法直接设置
+	UserID    string `json:"user_id,omitempty"`
+	ArchiveID string `json:"archive_id,omitempty"`
+}
+
+// TripleQueryOutput 查询三元组结果
+type TripleQueryOutput struct {
+	Success bool         `json:"success"`
+	Triples []TripleView `json:"triples"`
+	Count   int          `json:"count"`
+	Message string       `json:"message"`
+}
+
+// TripleView 三元组视图
+type TripleView struct {
+	ID         string `json:"id"`
+	Subject    string `json:"subject"`
+	Predicate  string `json:"predicate"`
+	Object     string `json:"object"`
+	Provenance string `json:"provenance,omitempty"`
+	SourceID   string `json:"source_id,omitempty"`
+	ValidFrom  string `json:"valid_from,omitempty"`
+	ValidTo    string `json:"valid_to,omitempty"`
 }
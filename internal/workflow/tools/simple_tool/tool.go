@@ -8,7 +8,6 @@ import (
 
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/components/tool/utils"
-	"github.com/bytedance/sonic"  
 )
 
 const (
@@ -35,36 +34,16 @@ func GetTool() (tool.InvokableTool, error) {
 
 // SimpleTool 实际的工具函数
 func SimpleTool(ctx context.Context, _ *SimpleToolInput) (*ResponseData, error) {
-	// 从context获取原始请求body（可选，用于调试或额外验证）
-	body, ok := handler.GetRequestBody(ctx)
-	if !ok {
-		return nil, fmt.Errorf("无法从context获取请求body")
-	}
-
-	// 记录原始请求用于调试
-	fmt.Printf("原始请求: %s\n", string(body))
-
-	var req map[string]interface{}
-	if err := sonic.UnmarshalString(string(body), &req); err != nil {
-		return nil, fmt.Errorf("unmarshal request body failed: %w", err)
-	}
-
-	if req[userId] == "" || req[archiveId] == "" {
-		return nil, fmt.Errorf("user_id和archive_id不能为空")
-	}
-	
-	// 类型断言：将interface{}转换为string
-	userID, ok1 := req[userId].(string)
-	archiveID, ok2 := req[archiveId].(string)
-	
-	if !ok1 || !ok2 {
-		return nil, fmt.Errorf("user_id或archive_id类型错误，期望string类型")
+	// 统一租户解析：以中间件注入的租户为准，请求体仅作回退
+	t, err := handler.ResolveTenant(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s和%s解析失败: %w", userId, archiveId, err)
 	}
 
 	// 构建响应
 	return &ResponseData{
-		UserID:    userID,
-		ArchiveID: archiveID,
-		Message:   fmt.Sprintf("成功提取数据 - 用户ID: %s, 档案ID: %s", userID, archiveID),
+		UserID:    t.UserID,
+		ArchiveID: t.ArchiveID,
+		Message:   fmt.Sprintf("成功提取数据 - 用户ID: %s, 档案ID: %s", t.UserID, t.ArchiveID),
 	}, nil
 }